@@ -0,0 +1,110 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeRenewJitter is the maximum jitter autocert applies to renewals, a RenewBefore not exceeding it is ignored
+// by autocert in favour of its 30 day default.
+const acmeRenewJitter = time.Hour
+
+// WithACMERenewBefore configures how long before expiry certificates are renewed. It must exceed autocert's
+// renewal jitter, else autocert silently uses its 30 day default. Use WithACMECertLifetime after it to check it
+// against the lifetime of issued certificates.
+func WithACMERenewBefore(d time.Duration) ACMEOption {
+	return func(mgr *autocert.Manager) error {
+		if d <= acmeRenewJitter {
+			return errors.Errorf("ACME renew before %s must exceed %s", d, acmeRenewJitter)
+		}
+		mgr.RenewBefore = d
+		return nil
+	}
+}
+
+// WithACMECertLifetime declares the expected lifetime of issued certificates, for CAs issuing other than Let's
+// Encrypt's 90 days. Any renew before configured by a preceding WithACMERenewBefore must be less than lifetime,
+// otherwise renew before defaults to a third of lifetime.
+func WithACMECertLifetime(lifetime time.Duration) ACMEOption {
+	return func(mgr *autocert.Manager) error {
+		if mgr.RenewBefore == 0 {
+			if lifetime/3 <= acmeRenewJitter {
+				return errors.Errorf("ACME certificate lifetime %s too short to renew", lifetime)
+			}
+			mgr.RenewBefore = lifetime / 3
+			return nil
+		}
+		if mgr.RenewBefore >= lifetime {
+			return errors.Errorf("ACME renew before %s must be less than certificate lifetime %s", mgr.RenewBefore, lifetime)
+		}
+		return nil
+	}
+}
+
+// WithACMEHostPolicyFunc sets the ACME host policy to policy, consulted for each host before a certificate is requested.
+func WithACMEHostPolicyFunc(policy autocert.HostPolicy) ACMEOption {
+	return func(mgr *autocert.Manager) error {
+		mgr.HostPolicy = policy
+		return nil
+	}
+}
+
+// WithACMEHostRegexp sets the ACME host policy to only permit hosts entirely matching the regular expression expr.
+func WithACMEHostRegexp(expr string) ACMEOption {
+	return func(mgr *autocert.Manager) error {
+		re, err := regexp.Compile(`^(?:` + expr + `)$`)
+		if err != nil {
+			return errors.Wrap(err, "invalid ACME host regexp")
+		}
+		mgr.HostPolicy = func(_ context.Context, host string) error {
+			if !re.MatchString(host) {
+				return errors.Errorf("acme/autocert: host %q not permitted by HostPolicy", host)
+			}
+			return nil
+		}
+		return nil
+	}
+}
+
+// NewACMEHTTPServer returns an http.Server for port 80 that answers ACME http-01 challenges and redirects other
+// GET and HEAD requests to https, along with the Option configuring TLS to use the same ACME manager. A positive
+// hstsMaxAge adds a Strict-Transport-Security header to the redirects, browsers only honour it over https so the
+// TLS server's handler should also be wrapped with HSTS.
+func NewACMEHTTPServer(hstsMaxAge time.Duration, opts ...ACMEOption) (*http.Server, Option, error) {
+	mgr, err := newACMEManager(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return acmeHTTPServer(mgr, mgr.HTTPHandler(nil), hstsMaxAge)
+}
+
+// NewACMERedirectServer returns an http.Server for port 80 that answers ACME http-01 challenges and redirects all
+// other requests to https with status, see RedirectToHTTPS, along with the Option configuring TLS to use the same
+// ACME manager. hstsMaxAge is as NewACMEHTTPServer.
+func NewACMERedirectServer(status int, hstsMaxAge time.Duration, opts ...ACMEOption) (*http.Server, Option, error) {
+	if !validRedirectStatus(status) {
+		return nil, nil, errors.Errorf("invalid redirect status %d", status)
+	}
+	mgr, err := newACMEManager(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return acmeHTTPServer(mgr, mgr.HTTPHandler(RedirectToHTTPS(status)), hstsMaxAge)
+}
+
+// acmeHTTPServer returns the port 80 server serving h, and the Option configuring TLS to use mgr.
+func acmeHTTPServer(mgr *autocert.Manager, h http.Handler, hstsMaxAge time.Duration) (*http.Server, Option, error) {
+	if hstsMaxAge > 0 {
+		h = HSTS(h, hstsMaxAge, false)
+	}
+	opt := func(cfg *tls.Config) error {
+		return setGetCertificate(cfg, mgr.GetCertificate)
+	}
+	return newPlainHTTPServer(h), opt, nil
+}
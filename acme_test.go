@@ -0,0 +1,117 @@
+package tlsutil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestACMERenewBefore(t *testing.T) {
+	mgr, err := newACMEManager(WithACMERenewBefore(7 * 24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mgr.RenewBefore != 7*24*time.Hour {
+		t.Fatalf("expected renew before of 7 days, got %s", mgr.RenewBefore)
+	}
+	if _, err := newACMEManager(WithACMERenewBefore(acmeRenewJitter)); err == nil {
+		t.Fatal("expected error for renew before within autocert's jitter")
+	}
+
+	// Short lived certificates renew a third of the way from expiry by default.
+	if mgr, err = newACMEManager(WithACMECertLifetime(6 * 24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if mgr.RenewBefore != 2*24*time.Hour {
+		t.Fatalf("expected renew before of 2 days, got %s", mgr.RenewBefore)
+	}
+	if _, err := newACMEManager(WithACMERenewBefore(30*24*time.Hour), WithACMECertLifetime(6*24*time.Hour)); err == nil {
+		t.Fatal("expected error for renew before exceeding the lifetime")
+	}
+	if _, err := newACMEManager(WithACMECertLifetime(2 * time.Hour)); err == nil {
+		t.Fatal("expected error for lifetime too short to renew")
+	}
+}
+
+func TestACMEHostRegexp(t *testing.T) {
+	mgr, err := newACMEManager(WithACMEHostRegexp(`[a-z]+\.example\.com`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for host, ok := range map[string]bool{
+		"shop.example.com":          true,
+		"example.com":               false,
+		"shop.example.com.evil.org": false,
+		"evil.org/shop.example.com": false,
+	} {
+		if err := mgr.HostPolicy(ctx, host); (err == nil) != ok {
+			t.Errorf("%s: expected permitted %v, got %v", host, ok, err)
+		}
+	}
+	if _, err := newACMEManager(WithACMEHostRegexp(`(`)); err == nil {
+		t.Fatal("expected error for invalid regexp")
+	}
+
+	deny := errors.New("denied")
+	if mgr, err = newACMEManager(WithACMEHostPolicyFunc(func(context.Context, string) error { return deny })); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.HostPolicy(ctx, "example.com"); err != deny {
+		t.Fatalf("expected policy's error, got %v", err)
+	}
+}
+
+func TestACMEHTTPServer(t *testing.T) {
+	srv, opt, err := NewACMEHTTPServer(time.Hour, WithACMEHosts([]string{"example.com"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/a?b=c", nil)
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, r)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/a?b=c" {
+		t.Fatalf("expected redirect to https, got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=3600" {
+		t.Fatalf("expected HSTS header, got %q", got)
+	}
+	// Challenges are answered, not redirected, an unknown token not found.
+	r = httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/token", nil)
+	w = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected challenge path answered, got %d", w.Code)
+	}
+
+	cfg, err := NewTLSConfig(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GetCertificate == nil {
+		t.Fatal("expected GetCertificate of the ACME manager")
+	}
+	if _, err := NewTLSConfig(opt, WithACME()); err != ErrGetCertificateConflict {
+		t.Fatalf("expected ErrGetCertificateConflict, got %v", err)
+	}
+
+	if srv, _, err = NewACMERedirectServer(http.StatusPermanentRedirect, 0); err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "http://example.com/form", nil)
+	w = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, r)
+	if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != "https://example.com/form" {
+		t.Fatalf("expected POST redirected with 308, got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+	if w.Header().Get("Strict-Transport-Security") != "" {
+		t.Fatal("expected no HSTS header without max age")
+	}
+	if _, _, err := NewACMERedirectServer(http.StatusOK, 0); err == nil {
+		t.Fatal("expected error for non redirect status")
+	}
+}
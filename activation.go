@@ -0,0 +1,121 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// activationFirstFD is the first file descriptor systemd passes sockets from, SD_LISTEN_FDS_START.
+const activationFirstFD = 3
+
+// activatedListener is a socket passed by systemd socket activation, and its FileDescriptorName.
+type activatedListener struct {
+	name string
+	ln   net.Listener
+}
+
+// activation holds the sockets passed by systemd, collected once as the environment describing them is then cleared.
+var activation struct {
+	once      sync.Once
+	mu        sync.Mutex
+	listeners []activatedListener
+	err       error
+}
+
+// parseActivation returns the number of sockets, and their names, passed to the process pid by systemd, from the
+// LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES environment variables. Zero if none were passed to pid.
+func parseActivation(pid int, listenPID, listenFDs, listenFDNames string) (int, []string, error) {
+	if listenPID == "" || listenFDs == "" {
+		return 0, nil, nil
+	}
+	if p, err := strconv.Atoi(listenPID); err != nil || p != pid {
+		return 0, nil, nil
+	}
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n < 0 {
+		return 0, nil, errors.Errorf("invalid LISTEN_FDS %q", listenFDs)
+	}
+	names := make([]string, n)
+	if listenFDNames != "" {
+		given := strings.Split(listenFDNames, ":")
+		if len(given) != n {
+			return 0, nil, errors.Errorf("LISTEN_FDNAMES names %d sockets, LISTEN_FDS %d", len(given), n)
+		}
+		copy(names, given)
+	}
+	return n, names, nil
+}
+
+// loadActivation collects the sockets passed by systemd, clearing the environment describing them so child
+// processes don't mistake them for their own.
+func loadActivation() {
+	n, names, err := parseActivation(os.Getpid(), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil {
+		activation.err = err
+		return
+	}
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(activationFirstFD+i), names[i])
+		ln, err := net.FileListener(f)
+		// FileListener duplicates the descriptor, with close on exec set.
+		f.Close()
+		if err != nil {
+			activation.err = errors.Wrapf(err, "inherited socket %d is not a listener", activationFirstFD+i)
+			return
+		}
+		activation.listeners = append(activation.listeners, activatedListener{name: names[i], ln: ln})
+	}
+}
+
+// takeActivated returns, once, the socket passed by systemd named name, or if name is empty the first not yet taken.
+// Returns nil if there is no such socket.
+func takeActivated(name string) (net.Listener, error) {
+	activation.once.Do(loadActivation)
+	activation.mu.Lock()
+	defer activation.mu.Unlock()
+	if activation.err != nil {
+		return nil, activation.err
+	}
+	for i, l := range activation.listeners {
+		if name == "" || l.name == name {
+			activation.listeners = append(activation.listeners[:i], activation.listeners[i+1:]...)
+			return l.ln, nil
+		}
+	}
+	return nil, nil
+}
+
+// listenActivated returns the socket passed by systemd named name, see Listen, falling back to binding network addr.
+func listenActivated(name, network, addr string) (net.Listener, error) {
+	ln, err := takeActivated(name)
+	if err != nil {
+		return nil, err
+	}
+	if ln != nil {
+		return ln, nil
+	}
+	if ln, err = net.Listen(network, addr); err != nil {
+		return nil, errors.Wrap(err, "failed to listen")
+	}
+	return ln, nil
+}
+
+// Listen returns a TLS listener of cfg, on the socket passed by systemd socket activation with FileDescriptorName
+// name, or if name is empty the first socket passed not already taken. Without such a socket it falls back to
+// binding network addr, so the same binary runs with or without activation.
+func Listen(name, network, addr string, cfg *tls.Config) (net.Listener, error) {
+	ln, err := listenActivated(name, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(ln, cfg), nil
+}
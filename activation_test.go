@@ -0,0 +1,55 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestParseActivation(t *testing.T) {
+	for _, tt := range []struct {
+		pid, fds, names string
+		n               int
+		want            []string
+		ok              bool
+	}{
+		{"", "", "", 0, nil, true},
+		{"41", "2", "", 0, nil, true},
+		{"42", "2", "", 2, []string{"", ""}, true},
+		{"42", "2", "https:http", 2, []string{"https", "http"}, true},
+		{"42", "x", "", 0, nil, false},
+		{"42", "2", "https", 0, nil, false},
+	} {
+		n, names, err := parseActivation(42, tt.pid, tt.fds, tt.names)
+		if (err == nil) != tt.ok || n != tt.n || len(names) != len(tt.want) {
+			t.Errorf("%q %q %q: got %d %q %v", tt.pid, tt.fds, tt.names, n, names, err)
+			continue
+		}
+		for i := range names {
+			if names[i] != tt.want[i] {
+				t.Errorf("%q %q %q: got names %q", tt.pid, tt.fds, tt.names, names)
+			}
+		}
+	}
+}
+
+func TestListenFallback(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	ln, err := Listen("https", "tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,254 @@
+package tlsutil
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPeerNotAllowed is returned by peer verification when the peer's certificate matches no allowlist rule.
+var ErrPeerNotAllowed = errors.New("tlsutil: peer certificate not allowed")
+
+// AllowlistOption configures a PeerAllowlist loaded from a file.
+type AllowlistOption func(*PeerAllowlist) error
+
+// WithAllowlistErrorHandler sets a function called with every failed reload. The previous rules continue to be used.
+func WithAllowlistErrorHandler(fn func(error)) AllowlistOption {
+	return func(a *PeerAllowlist) error {
+		a.watcher.onError = fn
+		return nil
+	}
+}
+
+// WithAllowlistPolling checks the file for changes every interval, by content hash, instead of relying on file
+// system notifications.
+func WithAllowlistPolling(interval time.Duration) AllowlistOption {
+	return func(a *PeerAllowlist) error {
+		if interval <= 0 {
+			return errors.New("reload polling interval must be positive")
+		}
+		a.watcher.poll = interval
+		return nil
+	}
+}
+
+// allowRule matches one attribute of a certificate against a path.Match pattern.
+type allowRule struct {
+	kind    string
+	pattern string
+}
+
+// PeerAllowlist restricts peers, beyond chaining to a trusted CA, to those whose leaf certificate matches at least one
+// rule. Rules are of the form kind:pattern, where kind is one of dns, email, uri, cn or subject, and pattern is as
+// path.Match. For example "dns:*.billing.internal", "uri:spiffe://example.org/billing/*" or "cn:backup-??". DNS names
+// compare case insensitively. An empty allowlist allows no peers.
+type PeerAllowlist struct {
+	file string
+
+	mu    sync.RWMutex
+	rules []allowRule
+	sum   [sha256.Size]byte
+
+	watcher fileWatcher
+}
+
+// NewPeerAllowlist returns a PeerAllowlist of rules.
+func NewPeerAllowlist(rules ...string) (*PeerAllowlist, error) {
+	r, err := parseAllowRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	return &PeerAllowlist{rules: r}, nil
+}
+
+// LoadPeerAllowlist returns a PeerAllowlist of the rules in file, one per line, ignoring blank lines and those
+// starting with #. An empty file is an error, as it's indistinguishable from one being written. Call Watch to reload
+// as it changes.
+func LoadPeerAllowlist(file string, opts ...AllowlistOption) (*PeerAllowlist, error) {
+	a := &PeerAllowlist{file: file}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+	a.watcher.files = []string{file}
+	a.watcher.reload, a.watcher.changed = a.Reload, a.changed
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// WithPeerAllowlist configures TLS to reject peers whose certificates are not allowed by a.
+func WithPeerAllowlist(a *PeerAllowlist) Option {
+	return func(cfg *tls.Config) error {
+		appendVerifyConnection(cfg, a.VerifyConnection)
+		return nil
+	}
+}
+
+// WithPeerAllowlistFile configures TLS to reject peers whose certificates are not allowed by the rules in file,
+// reloading them whenever it changes for the life of the process, watching from the first handshake. To stop
+// watching, use WithPeerAllowlist and close the PeerAllowlist.
+func WithPeerAllowlistFile(file string, opts ...AllowlistOption) Option {
+	return func(cfg *tls.Config) error {
+		a, err := LoadPeerAllowlist(file, opts...)
+		if err != nil {
+			return err
+		}
+		watch := a.watcher.startOnFirstUse()
+		appendVerifyConnection(cfg, func(cs tls.ConnectionState) error {
+			watch()
+			return a.VerifyConnection(cs)
+		})
+		return nil
+	}
+}
+
+func parseAllowRules(rules []string) ([]allowRule, error) {
+	r := make([]allowRule, 0, len(rules))
+	for _, rule := range rules {
+		i := strings.IndexByte(rule, ':')
+		if i < 0 {
+			return nil, errors.Errorf("allowlist rule %q has no kind", rule)
+		}
+		kind, pattern := strings.ToLower(rule[:i]), rule[i+1:]
+		switch kind {
+		case "dns":
+			pattern = strings.ToLower(pattern)
+		case "email", "uri", "cn", "subject":
+		default:
+			return nil, errors.Errorf("allowlist rule %q has unknown kind %q", rule, kind)
+		}
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, errors.Errorf("allowlist rule %q has invalid pattern", rule)
+		}
+		r = append(r, allowRule{kind: kind, pattern: pattern})
+	}
+	return r, nil
+}
+
+// readFile returns the rules of the file, and the hash of its contents.
+func (a *PeerAllowlist) readFile() ([]allowRule, [sha256.Size]byte, error) {
+	b, err := ioutil.ReadFile(a.file)
+	if err != nil {
+		return nil, [sha256.Size]byte{}, errors.Wrap(err, "failed to read allowlist")
+	}
+	if len(b) == 0 {
+		// Most likely truncated mid write, a deliberately empty allowlist can say so in a comment.
+		return nil, [sha256.Size]byte{}, errors.Errorf("allowlist %s is empty", a.file)
+	}
+	var lines []string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	rules, err := parseAllowRules(lines)
+	if err != nil {
+		return nil, [sha256.Size]byte{}, errors.Wrapf(err, "failed to load allowlist %s", a.file)
+	}
+	return rules, sha256.Sum256(b), nil
+}
+
+// Reload loads the allowlist file, replacing the current rules only if every rule is valid.
+func (a *PeerAllowlist) Reload() error {
+	if a.file == "" {
+		return errors.New("allowlist has no file to reload")
+	}
+	rules, sum, err := a.readFile()
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.rules, a.sum = rules, sum
+	a.mu.Unlock()
+	return nil
+}
+
+// changed reports whether the file's contents differ from those last loaded.
+func (a *PeerAllowlist) changed() (bool, error) {
+	_, sum, err := a.readFile()
+	if err != nil {
+		return false, err
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return sum != a.sum, nil
+}
+
+// Watch reloads the rules in the background whenever the file changes, until Close.
+func (a *PeerAllowlist) Watch() error {
+	if a.file == "" {
+		return errors.New("allowlist has no file to watch")
+	}
+	return a.watcher.start()
+}
+
+// Close stops watching.
+func (a *PeerAllowlist) Close() error {
+	return a.watcher.close()
+}
+
+// Allowed reports whether cert matches any rule.
+func (a *PeerAllowlist) Allowed(cert *x509.Certificate) bool {
+	a.mu.RLock()
+	rules := a.rules
+	a.mu.RUnlock()
+	for _, r := range rules {
+		if r.match(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyConnection returns ErrPeerNotAllowed unless the peer's leaf certificate is allowed, for use as tls.Config's
+// VerifyConnection.
+func (a *PeerAllowlist) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("peer presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	if !a.Allowed(leaf) {
+		return errors.Wrapf(ErrPeerNotAllowed, "%q", leaf.Subject)
+	}
+	return nil
+}
+
+func (r allowRule) match(cert *x509.Certificate) bool {
+	var values []string
+	switch r.kind {
+	case "dns":
+		for _, name := range cert.DNSNames {
+			values = append(values, strings.ToLower(name))
+		}
+	case "email":
+		values = cert.EmailAddresses
+	case "uri":
+		for _, u := range cert.URIs {
+			values = append(values, u.String())
+		}
+	case "cn":
+		values = []string{cert.Subject.CommonName}
+	case "subject":
+		values = []string{cert.Subject.String()}
+	}
+	for _, v := range values {
+		if ok, _ := path.Match(r.pattern, v); ok && v != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestPeerAllowlist(t *testing.T) {
+	a, err := NewPeerAllowlist("dns:*.billing.internal", "email:ops@example.com", "uri:spiffe://example.org/web/*", "cn:backup-??")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("spiffe://example.org/web/frontend")
+	for _, tt := range []struct {
+		cert    x509.Certificate
+		allowed bool
+	}{
+		{x509.Certificate{DNSNames: []string{"API.Billing.Internal"}}, true},
+		{x509.Certificate{DNSNames: []string{"api.payments.internal"}}, false},
+		{x509.Certificate{EmailAddresses: []string{"ops@example.com"}}, true},
+		{x509.Certificate{URIs: []*url.URL{u}}, true},
+		{x509.Certificate{Subject: pkix.Name{CommonName: "backup-01"}}, true},
+		{x509.Certificate{Subject: pkix.Name{CommonName: "backup-001"}}, false},
+		{x509.Certificate{}, false},
+	} {
+		if got := a.Allowed(&tt.cert); got != tt.allowed {
+			t.Errorf("%v %v %v %q: expected %v", tt.cert.DNSNames, tt.cert.EmailAddresses, tt.cert.URIs, tt.cert.Subject.CommonName, tt.allowed)
+		}
+	}
+
+	cfg, err := NewTLSConfig(WithPeerAllowlist(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "other"}}}}
+	if err := cfg.VerifyConnection(cs); errors.Cause(err) != ErrPeerNotAllowed {
+		t.Fatalf("expected ErrPeerNotAllowed, got %v", err)
+	}
+
+	for _, rule := range []string{"billing.internal", "ip:10.0.0.1", "dns:[", "cn:"} {
+		if _, err := NewPeerAllowlist(rule); err == nil {
+			t.Errorf("%q: expected error", rule)
+		}
+	}
+}
+
+func TestPeerAllowlistReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "allow")
+	if err := ioutil.WriteFile(file, []byte("# billing\ncn:billing\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadPeerAllowlist(file, WithAllowlistPolling(5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	billing := &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}}
+	web := &x509.Certificate{Subject: pkix.Name{CommonName: "web"}}
+	if !a.Allowed(billing) || a.Allowed(web) {
+		t.Fatal("initial rules not applied")
+	}
+
+	if err := ioutil.WriteFile(file, []byte("cn:web\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !a.Allowed(web) {
+		if time.Now().After(deadline) {
+			t.Fatal("polling did not pick up the new rules")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if a.Allowed(billing) {
+		t.Fatal("removed rule still applied")
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalid and empty files keep the previous rules.
+	for _, data := range []string{"bogus\n", ""} {
+		if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Reload(); err == nil {
+			t.Fatalf("%q: expected reload to fail", data)
+		}
+		if !a.Allowed(web) {
+			t.Fatalf("%q: failed reload replaced rules", data)
+		}
+	}
+}
+
+func TestPeerAllowlistFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "allow")
+	if err := ioutil.WriteFile(file, []byte("cn:billing\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(WithPeerAllowlistFile(file, WithAllowlistPolling(5*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "web"}}}}
+
+	// Changes before the first handshake, with nothing yet watching, are picked up by it.
+	if err := ioutil.WriteFile(file, []byte("cn:web\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.VerifyConnection(web); err != nil {
+		t.Fatalf("first handshake did not pick up the new rules: %v", err)
+	}
+
+	// Then watched.
+	if err := ioutil.WriteFile(file, []byte("cn:billing\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for cfg.VerifyConnection(web) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("rules not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,178 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// ariDefaultRetryAfter is how long to wait between renewal information polls if the CA does not say.
+	ariDefaultRetryAfter = 6 * time.Hour
+	// ariMinRetryAfter and ariMaxRetryAfter bound the CA's Retry-After, ARI should be polled at least daily.
+	ariMinRetryAfter = time.Hour
+	ariMaxRetryAfter = 24 * time.Hour
+)
+
+// renewalInfo is an ACME Renewal Information response, draft-ietf-acme-ari.
+type renewalInfo struct {
+	SuggestedWindow struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"suggestedWindow"`
+	ExplanationURL string `json:"explanationURL"`
+}
+
+// renewAt returns a uniformly random time within the suggested window.
+func (ri *renewalInfo) renewAt() time.Time {
+	start, end := ri.SuggestedWindow.Start, ri.SuggestedWindow.End
+	if !end.After(start) {
+		return start
+	}
+	return start.Add(time.Duration(mrand.Int63n(int64(end.Sub(start)))))
+}
+
+// ariCertID returns the ARI unique identifier of leaf, the base64url encoded authority key identifier and serial.
+func ariCertID(leaf *x509.Certificate) (string, error) {
+	if len(leaf.AuthorityKeyId) == 0 {
+		return "", errors.New("certificate has no authority key identifier")
+	}
+	// Serial as the content octets of its DER INTEGER encoding.
+	serial := leaf.SerialNumber.Bytes()
+	if len(serial) == 0 || serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+	return base64.RawURLEncoding.EncodeToString(leaf.AuthorityKeyId) + "." + base64.RawURLEncoding.EncodeToString(serial), nil
+}
+
+// ariRetryAfter parses a Retry-After header, clamped to sensible polling bounds.
+func ariRetryAfter(h string, now time.Time) time.Duration {
+	d := ariDefaultRetryAfter
+	if secs, err := strconv.Atoi(h); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(h); err == nil {
+		d = t.Sub(now)
+	}
+	if d < ariMinRetryAfter {
+		return ariMinRetryAfter
+	}
+	if d > ariMaxRetryAfter {
+		return ariMaxRetryAfter
+	}
+	return d
+}
+
+func (m *DNSManager) httpClient() *http.Client {
+	m.clientMu.Lock()
+	defer m.clientMu.Unlock()
+	if m.Client != nil && m.Client.HTTPClient != nil {
+		return m.Client.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// renewalInfoURL returns the CA's renewalInfo endpoint, or "" if the CA does not support ARI. The directory is
+// fetched once, as x/crypto/acme does not expose the renewalInfo field. Only the primary CA is consulted, certificates
+// issued by a fallback are unknown to it and renew by RenewBefore.
+func (m *DNSManager) renewalInfoURL(ctx context.Context) (string, error) {
+	m.clientMu.Lock()
+	if m.ariLoaded {
+		defer m.clientMu.Unlock()
+		return m.ariURL, nil
+	}
+	dirURL := acme.LetsEncryptURL
+	if m.Client != nil && m.Client.DirectoryURL != "" {
+		dirURL = m.Client.DirectoryURL
+	}
+	m.clientMu.Unlock()
+
+	var dir struct {
+		RenewalInfo string `json:"renewalInfo"`
+	}
+	if _, err := m.getJSON(ctx, dirURL, &dir); err != nil {
+		return "", errors.Wrap(err, "failed to fetch ACME directory")
+	}
+	m.clientMu.Lock()
+	defer m.clientMu.Unlock()
+	m.ariURL, m.ariLoaded = dir.RenewalInfo, true
+	return m.ariURL, nil
+}
+
+// getJSON decodes the JSON response of a GET request to url into v, returning the response headers.
+func (m *DNSManager) getJSON(ctx context.Context, url string, v interface{}) (http.Header, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp.Header, json.Unmarshal(body, v)
+}
+
+// fetchRenewalInfo returns the CA's suggested renewal window for leaf, and when to next ask. A nil renewalInfo
+// means the CA does not support ARI.
+func (m *DNSManager) fetchRenewalInfo(ctx context.Context, leaf *x509.Certificate) (*renewalInfo, time.Duration, error) {
+	base, err := m.renewalInfoURL(ctx)
+	if err != nil || base == "" {
+		return nil, 0, err
+	}
+	id, err := ariCertID(leaf)
+	if err != nil {
+		return nil, 0, err
+	}
+	var ri renewalInfo
+	h, err := m.getJSON(ctx, strings.TrimSuffix(base, "/")+"/"+id, &ri)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to fetch ACME renewal information")
+	}
+	if ri.SuggestedWindow.Start.IsZero() || ri.SuggestedWindow.End.Before(ri.SuggestedWindow.Start) {
+		return nil, 0, errors.New("invalid ACME renewal window")
+	}
+	return &ri, ariRetryAfter(h.Get("Retry-After"), time.Now()), nil
+}
+
+// checkRenewalInfo polls the CA's renewal information for the certificate held in s, scheduling renewal within the
+// suggested window. A window in the past, as published after revocation, renews at once on the next handshake.
+func (m *DNSManager) checkRenewalInfo(s *dnsCertState) {
+	s.mu.Lock()
+	leaf := s.cert.Leaf
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ri, retry, err := m.fetchRenewalInfo(ctx, leaf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkingARI = false
+	switch {
+	case err != nil:
+		// Renewal falls back to RenewBefore.
+		s.nextARI = time.Now().Add(dnsRetryAfter)
+	case ri == nil:
+		s.nextARI = time.Now().Add(ariMaxRetryAfter)
+	case s.cert.Leaf == leaf:
+		s.renewAt = ri.renewAt()
+		s.nextARI = time.Now().Add(retry)
+	}
+}
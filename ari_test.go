@@ -0,0 +1,101 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestARICertID(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	leaf := newTestCertificate(t, "example.com", ca, now.Add(-time.Hour), now.Add(time.Hour)).Leaf
+
+	// Example from draft-ietf-acme-ari.
+	leaf.AuthorityKeyId = []byte{0x69, 0x88, 0x5b, 0x6b, 0x87, 0x46, 0x40, 0x41, 0xe1, 0xb3, 0x7b, 0x84, 0x7b, 0xa0, 0xae, 0x2c, 0xde, 0x01, 0xc8, 0xd4}
+	leaf.SerialNumber = new(big.Int).SetBytes([]byte{0x00, 0x87, 0x65, 0x43, 0x21})
+	id, err := ariCertID(leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "aYhba4dGQEHhs3uEe6CuLN4ByNQ.AIdlQyE"; id != want {
+		t.Fatalf("got %q, want %q", id, want)
+	}
+
+	leaf.AuthorityKeyId = nil
+	if _, err := ariCertID(leaf); err == nil {
+		t.Fatal("expected error without authority key identifier")
+	}
+}
+
+func TestARIRetryAfter(t *testing.T) {
+	now := time.Now()
+	for h, want := range map[string]time.Duration{
+		"":        ariDefaultRetryAfter,
+		"10":      ariMinRetryAfter,
+		"7200":    2 * time.Hour,
+		"9999999": ariMaxRetryAfter,
+		now.Add(3 * time.Hour).UTC().Format(http.TimeFormat): 3 * time.Hour,
+	} {
+		if got := ariRetryAfter(h, now); got.Round(time.Minute) != want {
+			t.Errorf("%q: got %v, want %v", h, got, want)
+		}
+	}
+}
+
+func TestDNSManagerRenewalInfo(t *testing.T) {
+	now := time.Now()
+	start, end := now.Add(-2*time.Hour), now.Add(-time.Hour)
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/dir", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"renewalInfo": srv.URL + "/ari/"})
+	})
+	mux.HandleFunc("/ari/", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path[len("/ari/"):], ".") {
+			http.Error(w, "bad certID", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Retry-After", "21600")
+		var ri renewalInfo
+		ri.SuggestedWindow.Start, ri.SuggestedWindow.End = start, end
+		json.NewEncoder(w).Encode(ri)
+	})
+
+	m, err := NewDNSManager(nopProvider{}, WithDNSDirectoryURL(srv.URL+"/dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(90*24*time.Hour))
+	cert := newTestCertificate(t, "example.com", ca, now.Add(-time.Hour), now.Add(90*24*time.Hour))
+	s := &dnsCertState{cert: cert, checkingARI: true}
+
+	m.checkRenewalInfo(s)
+	if s.checkingARI {
+		t.Fatal("checkingARI not cleared")
+	}
+	if s.renewAt.Before(start) || !s.renewAt.Before(end) {
+		t.Fatalf("renewAt %v outside window", s.renewAt)
+	}
+	if d := s.nextARI.Sub(now); d < 5*time.Hour || d > 7*time.Hour {
+		t.Fatalf("unexpected next poll in %v", d)
+	}
+
+	// No renewalInfo in the directory disables ARI.
+	m2, err := NewDNSManager(nopProvider{}, WithDNSDirectoryURL(srv.URL+"/ari/x.y"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := &dnsCertState{cert: &tls.Certificate{Leaf: cert.Leaf}}
+	m2.checkRenewalInfo(s2)
+	if !s2.renewAt.IsZero() {
+		t.Fatal("unexpected renewAt without ARI support")
+	}
+}
@@ -0,0 +1,237 @@
+package tlsutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultAuthorizerCacheSize = 1024
+	defaultAuthorizerAllowTTL  = 5 * time.Minute
+	defaultAuthorizerDenyTTL   = time.Minute
+	// authorizeTimeout bounds an authorization, as VerifyConnection holds up the handshake.
+	authorizeTimeout = 10 * time.Second
+)
+
+// ErrPeerDenied is returned by peer verification when an authorizer denies the peer.
+var ErrPeerDenied = errors.New("tlsutil: peer denied by authorizer")
+
+// PeerAuthorizer decides whether to accept a peer whose certificate chain is otherwise valid, such as by consulting
+// a device registry.
+type PeerAuthorizer interface {
+	// AuthorizePeer reports whether the peer presenting chain, leaf first, is allowed. Errors are treated as denial,
+	// but not cached.
+	AuthorizePeer(ctx context.Context, chain []*x509.Certificate) (bool, error)
+}
+
+// PeerAuthorizerFunc adapts a function to a PeerAuthorizer.
+type PeerAuthorizerFunc func(ctx context.Context, chain []*x509.Certificate) (bool, error)
+
+// AuthorizePeer calls f.
+func (f PeerAuthorizerFunc) AuthorizePeer(ctx context.Context, chain []*x509.Certificate) (bool, error) {
+	return f(ctx, chain)
+}
+
+// AuthorizerOption configures an Authorizer.
+type AuthorizerOption func(*Authorizer) error
+
+// WithAuthorizerCacheSize sets the maximum number of decisions cached, 1024 by default.
+func WithAuthorizerCacheSize(n int) AuthorizerOption {
+	return func(a *Authorizer) error {
+		if n <= 0 {
+			return errors.New("authorizer cache size must be positive")
+		}
+		a.size = n
+		return nil
+	}
+}
+
+// WithAuthorizerCacheTTL sets how long allow and deny decisions are cached, five minutes and one minute by default.
+// Zero disables caching of that decision.
+func WithAuthorizerCacheTTL(allow, deny time.Duration) AuthorizerOption {
+	return func(a *Authorizer) error {
+		if allow < 0 || deny < 0 {
+			return errors.New("authorizer cache TTL must not be negative")
+		}
+		a.allowTTL, a.denyTTL = allow, deny
+		return nil
+	}
+}
+
+// WithAuthorizerClock sets the clock cache entries are expired by.
+func WithAuthorizerClock(clock Clock) AuthorizerOption {
+	return func(a *Authorizer) error {
+		a.clock = clock
+		return nil
+	}
+}
+
+// authorizerEntry is a cached decision.
+type authorizerEntry struct {
+	allow   bool
+	expires time.Time
+}
+
+// Authorizer is a Verifier consulting a PeerAuthorizer, caching its decisions by the leaf's fingerprint.
+type Authorizer struct {
+	authorizer PeerAuthorizer
+	size       int
+	allowTTL   time.Duration
+	denyTTL    time.Duration
+	clock      Clock
+
+	mu    sync.Mutex
+	cache *lruCache
+}
+
+// NewAuthorizer returns an Authorizer consulting authorizer.
+func NewAuthorizer(authorizer PeerAuthorizer, opts ...AuthorizerOption) (*Authorizer, error) {
+	if fn, ok := authorizer.(PeerAuthorizerFunc); authorizer == nil || (ok && fn == nil) {
+		return nil, errors.New("peer authorizer must not be nil")
+	}
+	a := &Authorizer{
+		authorizer: authorizer,
+		size:       defaultAuthorizerCacheSize,
+		allowTTL:   defaultAuthorizerAllowTTL,
+		denyTTL:    defaultAuthorizerDenyTTL,
+		clock:      systemClock{},
+	}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+	a.cache = newLRUCache(a.size)
+	return a, nil
+}
+
+// WithPeerAuthorizer configures TLS to also require authorizer allow peers, after any other verification.
+func WithPeerAuthorizer(authorizer PeerAuthorizer, opts ...AuthorizerOption) Option {
+	return func(cfg *tls.Config) error {
+		a, err := NewAuthorizer(authorizer, opts...)
+		if err != nil {
+			return err
+		}
+		return WithVerifiers(a)(cfg)
+	}
+}
+
+// Invalidate removes any cached decision for cert, so the peer is authorized again on its next handshake.
+func (a *Authorizer) Invalidate(cert *x509.Certificate) {
+	fp := Fingerprint(cert)
+	a.mu.Lock()
+	a.cache.remove(string(fp[:]))
+	a.mu.Unlock()
+}
+
+// cached returns the unexpired cached decision of key.
+func (a *Authorizer) cached(key string, now time.Time) (authorizerEntry, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	v, ok := a.cache.get(key)
+	if !ok {
+		return authorizerEntry{}, false
+	}
+	e := v.(authorizerEntry)
+	if !now.Before(e.expires) {
+		a.cache.remove(key)
+		return authorizerEntry{}, false
+	}
+	return e, true
+}
+
+// VerifyConnection returns ErrPeerDenied unless the authorizer allows the peer, given its verified chain if crypto/tls
+// verified it, otherwise the certificates presented.
+func (a *Authorizer) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("peer presented no certificate")
+	}
+	chain := cs.PeerCertificates
+	if len(cs.VerifiedChains) > 0 {
+		chain = cs.VerifiedChains[0]
+	}
+	fp := Fingerprint(chain[0])
+	key := string(fp[:])
+	now := a.clock.Now()
+	e, ok := a.cached(key, now)
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), authorizeTimeout)
+		defer cancel()
+		allow, err := a.authorizer.AuthorizePeer(ctx, chain)
+		if err != nil {
+			return errors.Wrap(err, "failed to authorize peer")
+		}
+		e = authorizerEntry{allow: allow, expires: now.Add(a.allowTTL)}
+		if !allow {
+			e.expires = now.Add(a.denyTTL)
+		}
+		if e.expires.After(now) {
+			a.mu.Lock()
+			a.cache.add(key, e)
+			a.mu.Unlock()
+		}
+	}
+	if !e.allow {
+		return errors.Wrapf(ErrPeerDenied, "%q", chain[0].Subject)
+	}
+	return nil
+}
+
+// webhookRequest is the body POSTed by a webhook authorizer.
+type webhookRequest struct {
+	// Certificates is the chain, leaf first, DER encoded.
+	Certificates [][]byte `json:"certificates"`
+}
+
+// webhookResponse is the body expected in reply.
+type webhookResponse struct {
+	Allow bool `json:"allow"`
+}
+
+// NewWebhookAuthorizer returns a PeerAuthorizer POSTing the chain to url as JSON, {"certificates": [base64 DER, ...]}
+// leaf first, expecting a 200 response of {"allow": true} or {"allow": false}. Any other response is an error. If
+// client is nil http.DefaultClient is used.
+func NewWebhookAuthorizer(url string, client *http.Client) PeerAuthorizer {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return PeerAuthorizerFunc(func(ctx context.Context, chain []*x509.Certificate) (bool, error) {
+		var body webhookRequest
+		for _, cert := range chain {
+			body.Certificates = append(body.Certificates, cert.Raw)
+		}
+		b, err := json.Marshal(body)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to encode authorization request")
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+		if err != nil {
+			return false, errors.Wrap(err, "failed to create authorization request")
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return false, errors.Wrap(err, "failed to send authorization request")
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(ioutil.Discard, resp.Body)
+			return false, errors.Errorf("authorization webhook returned %s", resp.Status)
+		}
+		var decision webhookResponse
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&decision); err != nil {
+			return false, errors.Wrap(err, "failed to decode authorization response")
+		}
+		return decision.Allow, nil
+	})
+}
@@ -0,0 +1,99 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestAuthorizer(t *testing.T) {
+	now := time.Now()
+	allowed := newTestCertificate(t, "allowed", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	denied := newTestCertificate(t, "denied", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	calls := 0
+	var fail error
+	clock := newFakeClock(now)
+	a, err := NewAuthorizer(PeerAuthorizerFunc(func(ctx context.Context, chain []*x509.Certificate) (bool, error) {
+		calls++
+		return chain[0].Subject.CommonName == "allowed", fail
+	}), WithAuthorizerClock(clock), WithAuthorizerCacheTTL(time.Hour, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := func(cert *tls.Certificate) tls.ConnectionState {
+		return tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := a.VerifyConnection(state(allowed)); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.VerifyConnection(state(denied)); errors.Cause(err) != ErrPeerDenied {
+			t.Fatalf("expected ErrPeerDenied, got %v", err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected decisions cached, got %d calls", calls)
+	}
+
+	// Denials expire sooner, and errors deny without being cached.
+	clock.Advance(2 * time.Minute)
+	fail = errors.New("registry unavailable")
+	if err := a.VerifyConnection(state(allowed)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyConnection(state(denied)); err == nil || errors.Cause(err) == ErrPeerDenied {
+		t.Fatalf("expected authorizer error, got %v", err)
+	}
+	fail = nil
+	a.Invalidate(allowed.Leaf)
+	if err := a.VerifyConnection(state(allowed)); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 4 {
+		t.Fatalf("expected 4 calls, got %d", calls)
+	}
+
+	if _, err := NewAuthorizer(nil); err == nil {
+		t.Fatal("expected error for nil authorizer")
+	}
+	if _, err := NewAuthorizer(PeerAuthorizerFunc(nil)); err == nil {
+		t.Fatal("expected error for nil authorizer func")
+	}
+}
+
+func TestWebhookAuthorizer(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "device", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Certificates) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(webhookResponse{Allow: string(req.Certificates[0]) == string(cert.Leaf.Raw)})
+	}))
+	defer srv.Close()
+
+	cfg, err := NewTLSConfig(WithPeerAuthorizer(NewWebhookAuthorizer(srv.URL, srv.Client()), WithAuthorizerCacheTTL(0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}}
+	if err := cfg.VerifyConnection(cs); err != nil {
+		t.Fatal(err)
+	}
+	status = http.StatusInternalServerError
+	if err := cfg.VerifyConnection(cs); err == nil {
+		t.Fatal("expected error for failed webhook")
+	}
+}
@@ -0,0 +1,104 @@
+package tlsutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// encryptedCacheMagic prefixes every encrypted entry, distinguishing them from plaintext entries written before
+// encryption was enabled.
+var encryptedCacheMagic = []byte("tlsutil-enc-v1:")
+
+type encryptedCache struct {
+	inner   autocert.Cache
+	aead    cipher.AEAD
+	migrate bool
+}
+
+// NewEncryptedCache returns an autocert.Cache that encrypts entries with aead before storing them in inner. Each
+// entry is bound to its key, so ciphertexts can not be swapped between keys undetected. Tampered entries are an
+// error. Plaintext entries already in inner are treated as cache misses, so autocert requests new certificates
+// (mind CA rate limits), use NewMigratingEncryptedCache to adopt them instead.
+func NewEncryptedCache(inner autocert.Cache, aead cipher.AEAD) (autocert.Cache, error) {
+	return newEncryptedCache(inner, aead, false)
+}
+
+// NewMigratingEncryptedCache is NewEncryptedCache, except plaintext entries already in inner are returned as is,
+// and rewritten encrypted. Intended for enabling encryption over an existing cache, switch to NewEncryptedCache
+// once every entry has been rewritten.
+func NewMigratingEncryptedCache(inner autocert.Cache, aead cipher.AEAD) (autocert.Cache, error) {
+	return newEncryptedCache(inner, aead, true)
+}
+
+func newEncryptedCache(inner autocert.Cache, aead cipher.AEAD, migrate bool) (autocert.Cache, error) {
+	if inner == nil {
+		return nil, errors.New("encrypted cache requires an inner cache")
+	}
+	if aead == nil {
+		return nil, errors.New("encrypted cache requires an AEAD")
+	}
+	return &encryptedCache{inner: inner, aead: aead, migrate: migrate}, nil
+}
+
+// WithACMEEncryptedCache wraps the previously configured ACME cache with NewEncryptedCache, or
+// NewMigratingEncryptedCache if migrate is true.
+func WithACMEEncryptedCache(aead cipher.AEAD, migrate bool) ACMEOption {
+	return func(mgr *autocert.Manager) error {
+		if mgr.Cache == nil {
+			return errors.New("no ACME cache configured to encrypt")
+		}
+		cache, err := newEncryptedCache(mgr.Cache, aead, migrate)
+		if err != nil {
+			return err
+		}
+		mgr.Cache = cache
+		return nil
+	}
+}
+
+func (c *encryptedCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.inner.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, encryptedCacheMagic) {
+		if !c.migrate {
+			return nil, autocert.ErrCacheMiss
+		}
+		if err := c.Put(ctx, key, data); err != nil {
+			return nil, errors.Wrapf(err, "failed to encrypt cache entry %q", key)
+		}
+		return data, nil
+	}
+	data = data[len(encryptedCacheMagic):]
+	n := c.aead.NonceSize()
+	if len(data) < n {
+		return nil, errors.Errorf("encrypted cache entry %q truncated", key)
+	}
+	plain, err := c.aead.Open(nil, data[:n], data[n:], []byte(key))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt cache entry %q", key)
+	}
+	return plain, nil
+}
+
+func (c *encryptedCache) Put(ctx context.Context, key string, data []byte) error {
+	m, n := len(encryptedCacheMagic), c.aead.NonceSize()
+	out := make([]byte, m+n, m+n+len(data)+c.aead.Overhead())
+	copy(out, encryptedCacheMagic)
+	nonce := out[m:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	return c.inner.Put(ctx, key, c.aead.Seal(out, nonce, data, []byte(key)))
+}
+
+func (c *encryptedCache) Delete(ctx context.Context, key string) error {
+	return c.inner.Delete(ctx, key)
+}
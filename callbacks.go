@@ -0,0 +1,106 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrGetCertificateConflict is returned when more than one option attempts to set tls.Config's GetCertificate.
+	ErrGetCertificateConflict = errors.New("GetCertificate already configured")
+	// ErrGetConfigForClientConflict is returned when more than one option attempts to set tls.Config's GetConfigForClient.
+	ErrGetConfigForClientConflict = errors.New("GetConfigForClient already configured")
+	// ErrGetClientCertificateConflict is returned when more than one option attempts to set tls.Config's
+	// GetClientCertificate.
+	ErrGetClientCertificateConflict = errors.New("GetClientCertificate already configured")
+)
+
+// setGetCertificate sets tls.Config's GetCertificate, failing if another option has already set it.
+func setGetCertificate(cfg *tls.Config, fn func(*tls.ClientHelloInfo) (*tls.Certificate, error)) error {
+	if cfg.GetCertificate != nil {
+		return ErrGetCertificateConflict
+	}
+	cfg.GetCertificate = fn
+	return nil
+}
+
+// setGetConfigForClient sets tls.Config's GetConfigForClient, failing if another option has already set it.
+func setGetConfigForClient(cfg *tls.Config, fn func(*tls.ClientHelloInfo) (*tls.Config, error)) error {
+	if cfg.GetConfigForClient != nil {
+		return ErrGetConfigForClientConflict
+	}
+	cfg.GetConfigForClient = fn
+	return nil
+}
+
+// setGetClientCertificate sets tls.Config's GetClientCertificate, failing if another option has already set it.
+func setGetClientCertificate(cfg *tls.Config, fn func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) error {
+	if cfg.GetClientCertificate != nil {
+		return ErrGetClientCertificateConflict
+	}
+	cfg.GetClientCertificate = fn
+	return nil
+}
+
+// prependVerifyConnection adds fn to tls.Config's VerifyConnection, called before any verification already
+// configured, for chain verification that later verification depends upon.
+func prependVerifyConnection(cfg *tls.Config, fn func(tls.ConnectionState) error) {
+	next := cfg.VerifyConnection
+	if next == nil {
+		cfg.VerifyConnection = fn
+		return
+	}
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if err := fn(cs); err != nil {
+			return err
+		}
+		return next(cs)
+	}
+}
+
+// appendVerifyConnection adds fn to tls.Config's VerifyConnection, called after any verification already configured.
+func appendVerifyConnection(cfg *tls.Config, fn func(tls.ConnectionState) error) {
+	prev := cfg.VerifyConnection
+	if prev == nil {
+		cfg.VerifyConnection = fn
+		return
+	}
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if err := prev(cs); err != nil {
+			return err
+		}
+		return fn(cs)
+	}
+}
+
+// appendVerifyPeerCertificate adds fn to tls.Config's VerifyPeerCertificate, called after any verification already
+// configured.
+func appendVerifyPeerCertificate(cfg *tls.Config, fn func([][]byte, [][]*x509.Certificate) error) {
+	prev := cfg.VerifyPeerCertificate
+	if prev == nil {
+		cfg.VerifyPeerCertificate = fn
+		return
+	}
+	cfg.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if err := prev(rawCerts, verifiedChains); err != nil {
+			return err
+		}
+		return fn(rawCerts, verifiedChains)
+	}
+}
+
+// WithGetCertificate sets tls.Config's GetCertificate callback.
+func WithGetCertificate(fn func(*tls.ClientHelloInfo) (*tls.Certificate, error)) Option {
+	return func(cfg *tls.Config) error {
+		return setGetCertificate(cfg, fn)
+	}
+}
+
+// WithGetConfigForClient sets tls.Config's GetConfigForClient callback.
+func WithGetConfigForClient(fn func(*tls.ClientHelloInfo) (*tls.Config, error)) Option {
+	return func(cfg *tls.Config) error {
+		return setGetConfigForClient(cfg, fn)
+	}
+}
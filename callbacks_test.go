@@ -0,0 +1,28 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestCallbackConflicts(t *testing.T) {
+	getCert := func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return nil, nil }
+	getConfig := func(*tls.ClientHelloInfo) (*tls.Config, error) { return nil, nil }
+
+	cfg, err := NewTLSConfig(WithGetCertificate(getCert), WithGetConfigForClient(getConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GetCertificate == nil || cfg.GetConfigForClient == nil {
+		t.Fatal("expected callbacks set")
+	}
+	if _, err := NewTLSConfig(WithGetCertificate(getCert), WithGetCertificate(getCert)); err != ErrGetCertificateConflict {
+		t.Fatalf("expected ErrGetCertificateConflict, got %v", err)
+	}
+	if _, err := NewTLSConfig(WithACME(), WithGetCertificate(getCert)); err != ErrGetCertificateConflict {
+		t.Fatalf("expected ACME's GetCertificate to conflict, got %v", err)
+	}
+	if _, err := NewTLSConfig(WithGetConfigForClient(getConfig), WithGetConfigForClient(getConfig)); err != ErrGetConfigForClientConflict {
+		t.Fatalf("expected ErrGetConfigForClientConflict, got %v", err)
+	}
+}
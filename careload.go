@@ -0,0 +1,229 @@
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CAReloadOption configures a CAPoolReloader.
+type CAReloadOption func(*CAPoolReloader) error
+
+// WithCAReloadErrorHandler sets a function called with every failed reload. The previous pool continues to be used.
+func WithCAReloadErrorHandler(fn func(error)) CAReloadOption {
+	return func(r *CAPoolReloader) error {
+		r.watcher.onError = fn
+		return nil
+	}
+}
+
+// WithCAReloadPolling checks the files for changes every interval, by content hash, instead of relying on file
+// system notifications.
+func WithCAReloadPolling(interval time.Duration) CAReloadOption {
+	return func(r *CAPoolReloader) error {
+		if interval <= 0 {
+			return errors.New("reload polling interval must be positive")
+		}
+		r.watcher.poll = interval
+		return nil
+	}
+}
+
+// CAPoolReloader maintains a x509.CertPool of the CA certificates in PEM files, rebuilding it when they change. So
+// rotated intermediates and roots are trusted without restarts.
+type CAPoolReloader struct {
+	paths []string
+
+	mu   sync.RWMutex
+	pool *x509.CertPool
+	sum  [sha256.Size]byte
+
+	watcher fileWatcher
+}
+
+// NewCAPoolReloader returns a CAPoolReloader having loaded the CA certificates in paths. Call Watch to reload as
+// they change.
+func NewCAPoolReloader(paths []string, opts ...CAReloadOption) (*CAPoolReloader, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no CA files to reload")
+	}
+	r := &CAPoolReloader{paths: append([]string(nil), paths...)}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	r.watcher.files = r.paths
+	r.watcher.reload, r.watcher.changed = r.Reload, r.changed
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// WithClientCAsReloader configures TLS to verify client certificates with the current pool of r, via
+// GetConfigForClient.
+func WithClientCAsReloader(r *CAPoolReloader) Option {
+	return func(cfg *tls.Config) error {
+		return setClientCAsPool(cfg, r.Pool)
+	}
+}
+
+// setClientCAsPool configures cfg to verify client certificates with the current pool returned by pool, via
+// GetConfigForClient.
+func setClientCAsPool(cfg *tls.Config, pool func() *x509.CertPool) error {
+	if err := setGetConfigForClient(cfg, func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		// Cloned per handshake, as ConfigReloader does, to keep session ticket keys current.
+		c := cfg.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = pool()
+		return c, nil
+	}); err != nil {
+		return err
+	}
+	cfg.ClientCAs = pool()
+	return nil
+}
+
+// WithClientCAsReload configures TLS to verify client certificates with the CA certificates in paths, reloading
+// them whenever they change for the life of the process, watching from the first handshake. Failures to watch are
+// reported to the reload error handler. To stop watching, use WithClientCAsReloader and close the CAPoolReloader,
+// such as by WithRunClosers.
+func WithClientCAsReload(paths []string, opts ...CAReloadOption) Option {
+	return func(cfg *tls.Config) error {
+		r, err := NewCAPoolReloader(paths, opts...)
+		if err != nil {
+			return err
+		}
+		if err := WithClientCAsReloader(r)(cfg); err != nil {
+			return err
+		}
+		watch, get := r.watcher.startOnFirstUse(), cfg.GetConfigForClient
+		cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			watch()
+			return get(hello)
+		}
+		return nil
+	}
+}
+
+// WithRootCAsReloader configures TLS to verify server certificates with the current pool of r. crypto/tls has no
+// per handshake RootCAs, so its verification is disabled with InsecureSkipVerify and replaced by the equivalent
+// verification, including the server name, in VerifyConnection ahead of any other Verifiers.
+func WithRootCAsReloader(r *CAPoolReloader) Option {
+	return func(cfg *tls.Config) error {
+		prependVerifyConnection(cfg, r.verifyServer)
+		cfg.InsecureSkipVerify = true
+		return nil
+	}
+}
+
+// WithRootCAsReload configures TLS to verify server certificates with the CA certificates in paths, reloading them
+// whenever they change for the life of the process, watching from the first handshake as WithClientCAsReload does.
+func WithRootCAsReload(paths []string, opts ...CAReloadOption) Option {
+	return func(cfg *tls.Config) error {
+		r, err := NewCAPoolReloader(paths, opts...)
+		if err != nil {
+			return err
+		}
+		watch := r.watcher.startOnFirstUse()
+		prependVerifyConnection(cfg, func(cs tls.ConnectionState) error {
+			watch()
+			return r.verifyServer(cs)
+		})
+		cfg.InsecureSkipVerify = true
+		return nil
+	}
+}
+
+// Pool returns the current pool. It must not be modified.
+func (r *CAPoolReloader) Pool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// readFiles returns the pool of the CA files, and the hash of their contents.
+func (r *CAPoolReloader) readFiles() (*x509.CertPool, [sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	pool := x509.NewCertPool()
+	h := sha256.New()
+	for _, path := range r.paths {
+		pemCerts, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, sum, errors.Wrap(err, "failed to read CA certificates")
+		}
+		h.Write(pemCerts)
+		if !pool.AppendCertsFromPEM(pemCerts) {
+			return nil, sum, errors.Errorf("failed to load CA certificates: no certificates found in %s", path)
+		}
+	}
+	copy(sum[:], h.Sum(nil))
+	return pool, sum, nil
+}
+
+// Reload loads the CA files, replacing the current pool only if every file contains certificates.
+func (r *CAPoolReloader) Reload() error {
+	pool, sum, err := r.readFiles()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.pool, r.sum = pool, sum
+	r.mu.Unlock()
+	return nil
+}
+
+// changed reports whether the files' contents differ from those last loaded.
+func (r *CAPoolReloader) changed() (bool, error) {
+	_, sum, err := r.readFiles()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return sum != r.sum, nil
+}
+
+// Watch reloads the pool in the background whenever its files change, until Close.
+func (r *CAPoolReloader) Watch() error {
+	return r.watcher.start()
+}
+
+// Close stops watching.
+func (r *CAPoolReloader) Close() error {
+	return r.watcher.close()
+}
+
+// verifyServer verifies the server's certificate chain and name against the current pool, as crypto/tls does when
+// InsecureSkipVerify is false.
+func (r *CAPoolReloader) verifyServer(cs tls.ConnectionState) error {
+	return verifyServerWithRoots(cs, r.Pool())
+}
+
+// verifyServerWithRoots verifies the server's certificate chain and name against roots.
+func verifyServerWithRoots(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificates")
+	}
+	if cs.ServerName == "" {
+		return errors.New("no server name to verify certificate against")
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return errors.Wrap(err, "failed to verify server certificate")
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCA writes the PEM encoded leaf of ca to path.
+func writeCA(t *testing.T, path string, ca *tls.Certificate) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCAPoolReloader(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	path := filepath.Join(dir, "ca.pem")
+	ca1 := newTestCertificate(t, "ca1", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	ca2 := newTestCertificate(t, "ca2", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	writeCA(t, path, ca1)
+
+	r, err := NewCAPoolReloader([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewTLSConfig(WithRootCAsReloader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewTLSConfig(WithClientCAsReloader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf := newTestCertificate(t, "example.com", ca2, now.Add(-time.Hour), now.Add(time.Hour))
+	chain := make([]*x509.Certificate, len(leaf.Certificate))
+	for i, der := range leaf.Certificate {
+		if chain[i], err = x509.ParseCertificate(der); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs := tls.ConnectionState{ServerName: "example.com", PeerCertificates: chain}
+	if err := client.VerifyConnection(cs); err == nil {
+		t.Fatal("expected verification against the old CA to fail")
+	}
+
+	writeCA(t, path, ca2)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.VerifyConnection(cs); err != nil {
+		t.Fatal(err)
+	}
+	cs.ServerName = "other.example"
+	if err := client.VerifyConnection(cs); err == nil {
+		t.Fatal("expected verification of wrong name to fail")
+	}
+	got, err := server.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ClientCAs != r.Pool() {
+		t.Fatal("handshake not using reloaded client CAs")
+	}
+
+	// A file without certificates keeps the previous pool.
+	pool := r.Pool()
+	if err := ioutil.WriteFile(path, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected reload to fail")
+	}
+	if r.Pool() != pool {
+		t.Fatal("failed reload replaced pool")
+	}
+
+	// Chain verification runs ahead of verifiers configured earlier.
+	var verified bool
+	ordered, err := NewTLSConfig(WithVerifiers(VerifierFunc(func(tls.ConnectionState) error {
+		verified = true
+		return nil
+	})), WithRootCAsReloader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.ServerName = "example.com"
+	if err := ordered.VerifyConnection(cs); err != nil || !verified {
+		t.Fatalf("expected chain and verifier to pass, got %v", err)
+	}
+	verified = false
+	cs.ServerName = "other.example"
+	if err := ordered.VerifyConnection(cs); err == nil || verified {
+		t.Fatal("verifier called despite failed chain verification")
+	}
+}
+
+func TestCAPoolReloaderPolling(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	path := filepath.Join(dir, "ca.pem")
+	writeCA(t, path, newTestCertificate(t, "ca1", nil, now.Add(-time.Hour), now.Add(time.Hour)))
+
+	r, err := NewCAPoolReloader([]string{path}, WithCAReloadPolling(5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	pool := r.Pool()
+	writeCA(t, path, newTestCertificate(t, "ca2", nil, now.Add(-time.Hour), now.Add(time.Hour)))
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Pool() == pool {
+		if time.Now().After(deadline) {
+			t.Fatal("polling did not pick up the new CA")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCAsReload(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	path := filepath.Join(dir, "ca.pem")
+	ca1 := newTestCertificate(t, "ca1", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	ca2 := newTestCertificate(t, "ca2", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	writeCA(t, path, ca1)
+
+	client, err := NewTLSConfig(WithRootCAsReload([]string{path}, WithCAReloadPolling(5*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewTLSConfig(WithClientCAsReload([]string{path}, WithCAReloadPolling(5*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := newTestCertificate(t, "example.com", ca2, now.Add(-time.Hour), now.Add(time.Hour))
+	cs := tls.ConnectionState{ServerName: "example.com", PeerCertificates: []*x509.Certificate{leaf.Leaf}}
+
+	// Changes before the first handshake, with nothing yet watching, are picked up by it.
+	writeCA(t, path, ca2)
+	if err := client.VerifyConnection(cs); err != nil {
+		t.Fatalf("first handshake did not pick up the changed CA: %v", err)
+	}
+	got, err := server.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subjects := got.ClientCAs.Subjects(); len(subjects) != 1 || !bytes.Equal(subjects[0], ca2.Leaf.RawSubject) {
+		t.Fatal("first handshake did not pick up the changed client CA")
+	}
+
+	// Then watched.
+	writeCA(t, path, ca1)
+	deadline := time.Now().Add(5 * time.Second)
+	for client.VerifyConnection(cs) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("CA not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := NewTLSConfig(WithRootCAsReload([]string{filepath.Join(dir, "missing.pem")})); err == nil {
+		t.Fatal("expected error for missing CA file")
+	}
+}
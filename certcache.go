@@ -0,0 +1,126 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultCertificateCacheSize is the size of a CachedGetCertificate cache given a size that isn't positive.
+const defaultCertificateCacheSize = 1024
+
+// acmeTLSALPNProto is the ALPN protocol of ACME tls-alpn-01 challenges, RFC 8737.
+const acmeTLSALPNProto = "acme-tls/1"
+
+// isACMEChallenge reports whether hello is of an ACME tls-alpn-01 challenge, to be answered with a challenge
+// certificate.
+func isACMEChallenge(hello *tls.ClientHelloInfo) bool {
+	for _, proto := range hello.SupportedProtos {
+		if proto == acmeTLSALPNProto {
+			return true
+		}
+	}
+	return false
+}
+
+// cachedCertificate is a cached GetCertificate result.
+type cachedCertificate struct {
+	cert    *tls.Certificate
+	expires time.Time
+}
+
+// certificateCall is an in flight GetCertificate call, shared by concurrent handshakes for the same name.
+type certificateCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+// certificateCache caches the results of a GetCertificate function by server name.
+type certificateCache struct {
+	fn    func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	ttl   time.Duration
+	clock Clock
+
+	mu    sync.Mutex
+	cache *lruCache
+	calls map[string]*certificateCall
+}
+
+// CachedGetCertificate returns a GetCertificate function caching the certificates returned by fn by server name, for
+// up to ttl but never past their expiry, holding at most size names. Concurrent handshakes for an uncached name
+// share a single call of fn, so handshake storms don't become backend load. Errors are not cached, and those of a
+// cancelled call are not shared, the handshakes waiting on it retrying.
+//
+// As the cache is keyed by name alone, fn must not choose certificates by other ClientHelloInfo fields, serve both
+// key types from a SNIRouter for instance. ACME tls-alpn-01 challenge handshakes always call fn.
+func CachedGetCertificate(fn func(*tls.ClientHelloInfo) (*tls.Certificate, error), ttl time.Duration, size int) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return newCertificateCache(fn, ttl, size, systemClock{}).getCertificate
+}
+
+func newCertificateCache(fn func(*tls.ClientHelloInfo) (*tls.Certificate, error), ttl time.Duration, size int, clock Clock) *certificateCache {
+	if size <= 0 {
+		size = defaultCertificateCacheSize
+	}
+	return &certificateCache{
+		fn:    fn,
+		ttl:   ttl,
+		clock: clock,
+		cache: newLRUCache(size),
+		calls: make(map[string]*certificateCall),
+	}
+}
+
+func (c *certificateCache) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if isACMEChallenge(hello) {
+		return c.fn(hello)
+	}
+	name := normalizeServerName(hello.ServerName)
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	if v, ok := c.cache.get(name); ok {
+		e := v.(cachedCertificate)
+		if now.Before(e.expires) {
+			c.mu.Unlock()
+			return e.cert, nil
+		}
+		c.cache.remove(name)
+	}
+	if call, ok := c.calls[name]; ok {
+		c.mu.Unlock()
+		<-call.done
+		// The leader's handshake giving up is no reason for the others to, so they retry, one of them leading.
+		if isContextError(call.err) && (hello.Context() == nil || hello.Context().Err() == nil) {
+			return c.getCertificate(hello)
+		}
+		return call.cert, call.err
+	}
+	call := &certificateCall{done: make(chan struct{})}
+	c.calls[name] = call
+	c.mu.Unlock()
+
+	call.cert, call.err = c.fn(hello)
+
+	c.mu.Lock()
+	delete(c.calls, name)
+	if call.err == nil && call.cert != nil {
+		expires := now.Add(c.ttl)
+		if leaf := leafOf(call.cert); leaf != nil && leaf.NotAfter.Before(expires) {
+			expires = leaf.NotAfter
+		}
+		c.cache.add(name, cachedCertificate{cert: call.cert, expires: expires})
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.cert, call.err
+}
+
+// isContextError reports whether err is that of a context being cancelled or its deadline passing.
+func isContextError(err error) bool {
+	cause := errors.Cause(err)
+	return cause == context.Canceled || cause == context.DeadlineExceeded
+}
@@ -0,0 +1,118 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestCertificateCache(t *testing.T) {
+	now := time.Now()
+	clock := newFakeClock(now)
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(30*time.Minute))
+	var calls int32
+	release := make(chan struct{})
+	fail := errors.New("backend unavailable")
+	c := newCertificateCache(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		if hello.ServerName == "fail.example" {
+			return nil, fail
+		}
+		return cert, nil
+	}, time.Hour, 10, clock)
+
+	// Concurrent misses share one call.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := c.getCertificate(&tls.ClientHelloInfo{ServerName: "Example.com"}); err != nil || got != cert {
+				t.Errorf("unexpected result %v, %v", got, err)
+			}
+		}()
+	}
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected 1 call, got %d", n)
+	}
+
+	c.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com."})
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatal("expected cached certificate")
+	}
+	// Entries expire with the certificate, before the TTL.
+	clock.Advance(31 * time.Minute)
+	c.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected entry to expire with certificate, got %d calls", n)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.getCertificate(&tls.ClientHelloInfo{ServerName: "fail.example"}); err != fail {
+			t.Fatalf("expected backend error, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Fatalf("expected errors not to be cached, got %d calls", n)
+	}
+
+	c.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com", SupportedProtos: []string{acmeTLSALPNProto}})
+	if n := atomic.LoadInt32(&calls); n != 5 {
+		t.Fatal("expected challenge handshake to bypass the cache")
+	}
+}
+
+func TestCertificateCacheLeaderCancelled(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	var calls int32
+	release := make(chan struct{})
+	c := newCertificateCache(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+			return nil, errors.Wrap(context.Canceled, "leader gave up")
+		}
+		return cert, nil
+	}, time.Hour, 10, newFakeClock(now))
+
+	leader := make(chan error, 1)
+	go func() {
+		_, err := c.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+		leader <- err
+	}()
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// Waiters retry rather than share the leader's cancellation.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := c.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); err != nil || got != cert {
+				t.Errorf("unexpected result %v, %v", got, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if err := <-leader; errors.Cause(err) != context.Canceled {
+		t.Fatalf("expected leader's own cancellation, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected one retry shared by the waiters, got %d calls", n)
+	}
+}
@@ -0,0 +1,153 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// appendPEM appends the PEM encoded certificates to pool, creating a new pool if nil.
+func appendPEM(pool *x509.CertPool, pemCerts []byte) (*x509.CertPool, error) {
+	if pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, errors.New("no certificates found in PEM")
+	}
+	return pool, nil
+}
+
+// appendPEMFiles appends the certificates from each PEM file to pool.
+func appendPEMFiles(pool *x509.CertPool, paths ...string) (*x509.CertPool, error) {
+	for _, path := range paths {
+		pemCerts, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if pool, err = appendPEM(pool, pemCerts); err != nil {
+			return nil, errors.Wrap(err, path)
+		}
+	}
+	return pool, nil
+}
+
+// pemFilesInDir returns the .pem and .crt files in dir.
+func pemFilesInDir(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(info.Name())) {
+		case ".pem", ".crt":
+			paths = append(paths, filepath.Join(dir, info.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// appendPEMGlob appends the certificates from the PEM files matching patterns to pool, creating a new pool if nil.
+func appendPEMGlob(pool *x509.CertPool, patterns ...string) (*x509.CertPool, error) {
+	certs, err := readPEMGlob(patterns...)
+	if err != nil {
+		return nil, err
+	}
+	if pool == nil {
+		pool = x509.NewCertPool()
+	}
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// readPEMGlob returns the certificates, without duplicates, from the files matching patterns, or the .pem and .crt
+// files of directories matching. Every pattern must match, and every PEM block must be a parsable certificate, errors
+// naming the file and line of the block.
+func readPEMGlob(patterns ...string) ([]*x509.Certificate, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("no patterns")
+	}
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+		}
+		if len(matches) == 0 {
+			return nil, errors.Errorf("no files match %q", pattern)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				paths = append(paths, match)
+				continue
+			}
+			files, err := pemFilesInDir(match)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, files...)
+		}
+	}
+	sort.Strings(paths)
+
+	var certs []*x509.Certificate
+	var problems []string
+	seen := make(map[[sha256.Size]byte]bool)
+	for i, path := range paths {
+		if i > 0 && path == paths[i-1] {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for rest := data; ; {
+			start := len(data) - len(rest) + bytes.Index(rest, []byte("-----BEGIN"))
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			n++
+			line := bytes.Count(data[:start], []byte("\n")) + 1
+			if block.Type != "CERTIFICATE" {
+				problems = append(problems, fmt.Sprintf("%s:%d: unexpected PEM block %q", path, line, block.Type))
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s:%d: %v", path, line, err))
+				continue
+			}
+			if sum := sha256.Sum256(cert.Raw); !seen[sum] {
+				seen[sum] = true
+				certs = append(certs, cert)
+			}
+		}
+		if n == 0 {
+			problems = append(problems, fmt.Sprintf("%s: no certificates found in PEM", path))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return certs, nil
+}
@@ -0,0 +1,59 @@
+package tlsutil
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadPEMGlob(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ca1 := newTestCertificate(t, "ca1", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	ca2 := newTestCertificate(t, "ca2", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	ca3 := newTestCertificate(t, "ca3", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	pem1 := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca1.Leaf.Raw})
+	pem2 := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca2.Leaf.Raw})
+	write := func(name string, data []byte) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.pem", append(append([]byte("# bundle\n"), pem1...), pem2...))
+	write("b.pem", pem2)
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	write("sub/c.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca3.Leaf.Raw}))
+
+	certs, err := readPEMGlob(filepath.Join(dir, "*.pem"), filepath.Join(dir, "a.pem"), filepath.Join(dir, "s*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 3 {
+		t.Fatalf("expected 3 distinct certificates, got %d", len(certs))
+	}
+	cfg, err := NewTLSConfig(WithRootCAsGlob(filepath.Join(dir, "*.pem")), WithClientCAsGlob(filepath.Join(dir, "sub")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RootCAs == nil || cfg.ClientCAs == nil {
+		t.Fatal("expected pools")
+	}
+
+	write("bad.pem", append(append(pem1, "\n"...), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1, 2, 3}})...))
+	lines := strings.Count(string(pem1), "\n")
+	_, err = readPEMGlob(filepath.Join(dir, "*.pem"))
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("%s:%d:", filepath.Join(dir, "bad.pem"), lines+2)) {
+		t.Fatalf("expected error naming bad.pem line %d, got %v", lines+2, err)
+	}
+	if _, err := readPEMGlob(filepath.Join(dir, "*.der")); err == nil {
+		t.Fatal("expected error for pattern matching nothing")
+	}
+}
@@ -0,0 +1,106 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoCertificates is returned by CertStore's GetCertificate when the store is empty.
+var ErrNoCertificates = errors.New("tlsutil: no certificates available")
+
+// CertStore is a concurrency safe set of named certificates, served by GetCertificate. Certificates may be set and
+// removed whilst serving, by reloaders or external certificate managers.
+type CertStore struct {
+	mu    sync.RWMutex
+	names []string
+	certs map[string]*tls.Certificate
+}
+
+// NewCertStore returns an empty CertStore.
+func NewCertStore() *CertStore {
+	return &CertStore{certs: make(map[string]*tls.Certificate)}
+}
+
+// WithCertStore configures TLS to serve certificates from s.
+func WithCertStore(s *CertStore) Option {
+	return func(cfg *tls.Config) error {
+		return setGetCertificate(cfg, s.GetCertificate)
+	}
+}
+
+// SetCertificate adds cert under name, replacing any certificate previously stored under it.
+func (s *CertStore) SetCertificate(name string, cert *tls.Certificate) error {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return errors.New("certificate has no chain")
+	}
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return errors.Wrap(err, "failed to parse certificate")
+		}
+		c := *cert
+		c.Leaf = leaf
+		cert = &c
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.certs[name]; !ok {
+		s.names = append(s.names, name)
+	}
+	s.certs[name] = cert
+	return nil
+}
+
+// Remove removes the certificate stored under name, reporting whether there was one.
+func (s *CertStore) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.certs[name]; !ok {
+		return false
+	}
+	delete(s.certs, name)
+	for i, n := range s.names {
+		if n == name {
+			s.names = append(s.names[:i:i], s.names[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Certificate returns the certificate stored under name.
+func (s *CertStore) Certificate(name string) (*tls.Certificate, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cert, ok := s.certs[name]
+	return cert, ok
+}
+
+// Snapshot returns a copy of the stored certificates by name.
+func (s *CertStore) Snapshot() map[string]*tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := make(map[string]*tls.Certificate, len(s.certs))
+	for name, cert := range s.certs {
+		m[name] = cert
+	}
+	return m
+}
+
+// GetCertificate implements tls.Config's GetCertificate, returning the first certificate, in the order first set,
+// the client supports, or the first certificate if none are. As crypto/tls does for Certificates.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.names) == 0 {
+		return nil, ErrNoCertificates
+	}
+	certs := make([]*tls.Certificate, len(s.names))
+	for i, name := range s.names {
+		certs[i] = s.certs[name]
+	}
+	return selectCertificate(hello, certs), nil
+}
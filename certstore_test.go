@@ -0,0 +1,71 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestCertStore(t *testing.T) {
+	now := time.Now()
+	s := NewCertStore()
+	if _, err := s.GetCertificate(&tls.ClientHelloInfo{}); err != ErrNoCertificates {
+		t.Fatalf("expected ErrNoCertificates, got %v", err)
+	}
+
+	a := newTestCertificate(t, "a.example", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	b := newTestCertificate(t, "b.example", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	if err := s.SetCertificate("a", a); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetCertificate("b", &tls.Certificate{Certificate: b.Certificate, PrivateKey: b.PrivateKey}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Certificate("b"); got.Leaf == nil {
+		t.Fatal("leaf not parsed")
+	}
+
+	hello := &tls.ClientHelloInfo{
+		ServerName:        "b.example",
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+	}
+	got, err := s.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Leaf.Subject.CommonName != "b.example" {
+		t.Fatalf("expected b.example, got %s", got.Leaf.Subject.CommonName)
+	}
+	hello.ServerName = "unknown.example"
+	if got, _ := s.GetCertificate(hello); got.Leaf.Subject.CommonName != "a.example" {
+		t.Fatal("expected first certificate as fallback")
+	}
+
+	if len(s.Snapshot()) != 2 {
+		t.Fatal("snapshot incomplete")
+	}
+	if !s.Remove("a") || s.Remove("a") {
+		t.Fatal("unexpected Remove result")
+	}
+	if got, _ := s.GetCertificate(hello); got.Leaf.Subject.CommonName != "b.example" {
+		t.Fatal("removed certificate still served")
+	}
+	if err := s.SetCertificate("c", &tls.Certificate{}); err == nil {
+		t.Fatal("expected error for empty certificate")
+	}
+}
+
+func TestCertReloaderStore(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, keyFile := writeKeyPair(t, dir, cert)
+	s := NewCertStore()
+	if _, err := NewCertReloader(certFile, keyFile, WithReloadStore(s, "example")); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s.Certificate("example"); !ok || got.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Fatal("reloader did not set certificate in store")
+	}
+}
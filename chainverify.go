@@ -0,0 +1,180 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+)
+
+// ChainOption configures a ChainVerifier.
+type ChainOption func(*ChainVerifier) error
+
+// WithChainRoots sets the trusted roots. By default the tls.Config's RootCAs, or ClientCAs when verifying clients,
+// are used.
+func WithChainRoots(roots *x509.CertPool) ChainOption {
+	return func(v *ChainVerifier) error {
+		v.roots = roots
+		return nil
+	}
+}
+
+// WithChainIntermediates sets a pool of intermediates to build chains with, in addition to those the peer presents,
+// for peers that don't send their full chain.
+func WithChainIntermediates(intermediates *x509.CertPool) ChainOption {
+	return func(v *ChainVerifier) error {
+		v.intermediates = intermediates
+		return nil
+	}
+}
+
+// WithChainKeyUsages sets the extended key usages a chain must permit, any of which suffices. By default server
+// authentication, or client authentication when verifying clients, is required.
+func WithChainKeyUsages(usages ...x509.ExtKeyUsage) ChainOption {
+	return func(v *ChainVerifier) error {
+		if len(usages) == 0 {
+			return errors.New("no extended key usages")
+		}
+		v.usages = append([]x509.ExtKeyUsage(nil), usages...)
+		return nil
+	}
+}
+
+// WithChainClock sets the clock giving the time chains are verified at.
+func WithChainClock(clock Clock) ChainOption {
+	return func(v *ChainVerifier) error {
+		if clock == nil {
+			return errors.New("chain clock must not be nil")
+		}
+		v.clock = clock
+		return nil
+	}
+}
+
+// WithChainMaxDepth limits chains to depth certificates, including the leaf and root.
+func WithChainMaxDepth(depth int) ChainOption {
+	return func(v *ChainVerifier) error {
+		if depth < 1 {
+			return errors.New("chain depth must be positive")
+		}
+		v.maxDepth = depth
+		return nil
+	}
+}
+
+// ChainVerifier verifies peer certificate chains with x509.VerifyOptions crypto/tls doesn't expose, in place of
+// crypto/tls's own verification.
+type ChainVerifier struct {
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+	usages        []x509.ExtKeyUsage
+	clock         Clock
+	maxDepth      int
+}
+
+// NewChainVerifier returns a ChainVerifier configured by opts.
+func NewChainVerifier(opts ...ChainOption) (*ChainVerifier, error) {
+	v := &ChainVerifier{clock: systemClock{}}
+	for _, opt := range opts {
+		if err := opt(v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// WithServerChainVerification configures a client to verify servers' chains, and names, with a ChainVerifier of
+// opts. crypto/tls's verification is disabled with InsecureSkipVerify and replaced in VerifyConnection, ahead of any
+// other Verifiers.
+func WithServerChainVerification(opts ...ChainOption) Option {
+	return func(cfg *tls.Config) error {
+		v, err := NewChainVerifier(opts...)
+		if err != nil {
+			return err
+		}
+		prependServerVerification(cfg, v, "")
+		return nil
+	}
+}
+
+// prependServerVerification disables crypto/tls's verification of servers in favour of v's, against name if given,
+// otherwise the connection's server name.
+func prependServerVerification(cfg *tls.Config, v *ChainVerifier, name string) {
+	prependVerifyConnection(cfg, func(cs tls.ConnectionState) error {
+		dnsName := name
+		if dnsName == "" {
+			dnsName = cs.ServerName
+		}
+		if dnsName == "" {
+			return errors.New("no server name to verify certificate against")
+		}
+		return v.verify(cs, cfg.RootCAs, dnsName, x509.ExtKeyUsageServerAuth)
+	})
+	cfg.InsecureSkipVerify = true
+}
+
+// WithClientChainVerification configures a server to verify clients' chains with a ChainVerifier of opts, in place
+// of crypto/tls's verification against ClientCAs. Apply after WithClientAuth, as verifying ClientAuth modes are
+// replaced by their non verifying equivalents.
+func WithClientChainVerification(opts ...ChainOption) Option {
+	return func(cfg *tls.Config) error {
+		v, err := NewChainVerifier(opts...)
+		if err != nil {
+			return err
+		}
+		optional := cfg.ClientAuth == tls.VerifyClientCertIfGiven || cfg.ClientAuth == tls.RequestClientCert
+		if optional {
+			cfg.ClientAuth = tls.RequestClientCert
+		} else {
+			cfg.ClientAuth = tls.RequireAnyClientCert
+		}
+		prependVerifyConnection(cfg, func(cs tls.ConnectionState) error {
+			if optional && len(cs.PeerCertificates) == 0 {
+				return nil
+			}
+			return v.verify(cs, cfg.ClientCAs, "", x509.ExtKeyUsageClientAuth)
+		})
+		return nil
+	}
+}
+
+// verify verifies the peer's chain, with roots and usage unless overridden.
+func (v *ChainVerifier) verify(cs tls.ConnectionState, roots *x509.CertPool, dnsName string, usage x509.ExtKeyUsage) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("peer presented no certificates")
+	}
+	if v.roots != nil {
+		roots = v.roots
+	}
+	intermediates := x509.NewCertPool()
+	if v.intermediates != nil {
+		intermediates = v.intermediates.Clone()
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       dnsName,
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   v.clock.Now(),
+		KeyUsages:     v.usages,
+	}
+	if opts.KeyUsages == nil {
+		opts.KeyUsages = []x509.ExtKeyUsage{usage}
+	}
+	leaf := cs.PeerCertificates[0]
+	chains, err := leaf.Verify(opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify peer certificate")
+	}
+	if v.maxDepth == 0 {
+		return nil
+	}
+	for _, chain := range chains {
+		if len(chain) <= v.maxDepth {
+			return nil
+		}
+	}
+	return errors.Errorf("peer certificate %q has no chain within depth %d", leaf.Subject, v.maxDepth)
+}
@@ -0,0 +1,99 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func TestChainVerification(t *testing.T) {
+	now := time.Now()
+	root := newTestCertificate(t, "root", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	intermediate := issueTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "intermediate"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root)
+	leaf := newTestCertificate(t, "example.com", intermediate, now.Add(-time.Hour), now.Add(time.Hour))
+	serverOnly := issueTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, intermediate)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Leaf)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate.Leaf)
+	full := tls.ConnectionState{ServerName: "example.com", PeerCertificates: []*x509.Certificate{leaf.Leaf, intermediate.Leaf}}
+	bare := tls.ConnectionState{ServerName: "example.com", PeerCertificates: []*x509.Certificate{leaf.Leaf}}
+	clock := newFakeClock(now)
+
+	for _, tt := range []struct {
+		name string
+		opts []ChainOption
+		cs   tls.ConnectionState
+		ok   bool
+	}{
+		{"full chain", nil, full, true},
+		{"missing intermediate", nil, bare, false},
+		{"intermediates pool", []ChainOption{WithChainIntermediates(intermediates)}, bare, true},
+		{"within depth", []ChainOption{WithChainMaxDepth(3)}, full, true},
+		{"too deep", []ChainOption{WithChainMaxDepth(2)}, full, false},
+		{"expired by clock", []ChainOption{WithChainClock(clock)}, full, false},
+		{"wrong name", nil, tls.ConnectionState{ServerName: "other.example", PeerCertificates: full.PeerCertificates}, false},
+		{"other roots", []ChainOption{WithChainRoots(x509.NewCertPool())}, full, false},
+	} {
+		cfg, err := NewTLSConfig(func(cfg *tls.Config) error {
+			cfg.RootCAs = roots
+			return nil
+		}, WithServerChainVerification(tt.opts...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.name == "expired by clock" {
+			clock.Advance(2 * time.Hour)
+		}
+		if err := cfg.VerifyConnection(tt.cs); (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok %v, got %v", tt.name, tt.ok, err)
+		}
+		if !cfg.InsecureSkipVerify {
+			t.Fatal("crypto/tls verification not replaced")
+		}
+	}
+
+	// Clients need client authentication, unless the usages are overridden.
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{serverOnly.Leaf, intermediate.Leaf}}
+	for _, tt := range []struct {
+		opts []ChainOption
+		ok   bool
+	}{
+		{nil, false},
+		{[]ChainOption{WithChainKeyUsages(x509.ExtKeyUsageServerAuth)}, true},
+	} {
+		cfg, err := NewTLSConfig(WithClientCAPool(roots), WithClientAuth(tls.RequireAndVerifyClientCert),
+			WithClientChainVerification(tt.opts...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ClientAuth != tls.RequireAnyClientCert {
+			t.Fatalf("expected RequireAnyClientCert, got %v", cfg.ClientAuth)
+		}
+		if err := cfg.VerifyConnection(cs); (err == nil) != tt.ok {
+			t.Errorf("%v: expected ok %v, got %v", tt.opts, tt.ok, err)
+		}
+	}
+	if _, err := NewChainVerifier(WithChainMaxDepth(0)); err == nil {
+		t.Fatal("expected error for zero depth")
+	}
+	if _, err := NewChainVerifier(WithChainClock(nil)); err == nil {
+		t.Fatal("expected error for nil clock")
+	}
+}
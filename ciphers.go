@@ -0,0 +1,77 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+)
+
+// WithCipherSuites sets the enabled TLS 1.0-1.2 cipher suites, in order of preference.
+func WithCipherSuites(suites ...uint16) Option {
+	return func(cfg *tls.Config) error {
+		cfg.CipherSuites = append([]uint16(nil), suites...)
+		return nil
+	}
+}
+
+// WithCipherSuiteNames sets the enabled TLS 1.0-1.2 cipher suites by their standard names, eg TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+// Only suites returned by tls.CipherSuites() are accepted, insecure suites are rejected.
+func WithCipherSuiteNames(names ...string) Option {
+	return func(cfg *tls.Config) error {
+		suites := make([]uint16, 0, len(names))
+		for _, name := range names {
+			id, ok := cipherSuiteID(name)
+			if !ok {
+				return errors.Errorf("unknown or insecure cipher suite %q", name)
+			}
+			suites = append(suites, id)
+		}
+		cfg.CipherSuites = suites
+		return nil
+	}
+}
+
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == name {
+			return cs.ID, true
+		}
+	}
+	return 0, false
+}
+
+// WithCurvePreferences sets the elliptic curves used in ECDHE handshakes, in order of preference.
+func WithCurvePreferences(curves ...tls.CurveID) Option {
+	return func(cfg *tls.Config) error {
+		cfg.CurvePreferences = append([]tls.CurveID(nil), curves...)
+		return nil
+	}
+}
+
+// knownCurves are the curves go supports, used for name lookup.
+var knownCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// WithCurvePreferenceNames sets the elliptic curves used in ECDHE handshakes by name, eg X25519, CurveP256.
+func WithCurvePreferenceNames(names ...string) Option {
+	return func(cfg *tls.Config) error {
+		curves := make([]tls.CurveID, 0, len(names))
+		for _, name := range names {
+			id, ok := curveID(name)
+			if !ok {
+				return errors.Errorf("unknown curve %q", name)
+			}
+			curves = append(curves, id)
+		}
+		cfg.CurvePreferences = curves
+		return nil
+	}
+}
+
+func curveID(name string) (tls.CurveID, bool) {
+	for _, c := range knownCurves {
+		if c.String() == name {
+			return c, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,46 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+)
+
+func TestCipherSuiteNames(t *testing.T) {
+	cfg, err := NewTLSConfig(WithCipherSuiteNames("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+	if !reflect.DeepEqual(cfg.CipherSuites, want) {
+		t.Fatalf("expected %v, got %v", want, cfg.CipherSuites)
+	}
+	if _, err := NewTLSConfig(WithCipherSuiteNames("TLS_RSA_WITH_RC4_128_SHA")); err == nil {
+		t.Fatal("expected error for insecure suite")
+	}
+	if _, err := NewTLSConfig(WithCipherSuiteNames("TLS_NOT_A_SUITE")); err == nil {
+		t.Fatal("expected error for unknown suite")
+	}
+}
+
+func TestCurvePreferenceNames(t *testing.T) {
+	cfg, err := NewTLSConfig(WithCurvePreferenceNames("X25519", "CurveP256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []tls.CurveID{tls.X25519, tls.CurveP256}; !reflect.DeepEqual(cfg.CurvePreferences, want) {
+		t.Fatalf("expected %v, got %v", want, cfg.CurvePreferences)
+	}
+	if _, err := NewTLSConfig(WithCurvePreferenceNames("P-256")); err == nil {
+		t.Fatal("expected error for unknown curve")
+	}
+
+	curves := []tls.CurveID{tls.CurveP384}
+	if cfg, err = NewTLSConfig(WithCurvePreferences(curves...)); err != nil {
+		t.Fatal(err)
+	}
+	curves[0] = tls.CurveP521
+	if cfg.CurvePreferences[0] != tls.CurveP384 {
+		t.Fatal("expected CurvePreferences not to alias the caller's slice")
+	}
+}
@@ -0,0 +1,55 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+)
+
+// ClientOption is an Option for configs dialing servers. It's an alias, so any Option applies, such as
+// WithRootCAsFromFile or WithKeyPair for a client certificate, but the options here only make sense for clients.
+type ClientOption = Option
+
+// NewClientTLSConfig returns a new tls.Config for dialing servers, with all options applied over client defaults of
+// TLS 1.2 or later, session resumption by an in memory session cache, and verification against the system roots.
+// Cipher suite and curve preferences are left to crypto/tls, which orders them by the client's hardware.
+func NewClientTLSConfig(opts ...ClientOption) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	if err := Apply(cfg, opts...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// WithServerName sets the name sent in SNI and the server's certificate is verified against. Dialers set it from the
+// address dialed if unset.
+func WithServerName(name string) ClientOption {
+	return func(cfg *tls.Config) error {
+		if name == "" {
+			return errors.New("empty server name")
+		}
+		cfg.ServerName = name
+		return nil
+	}
+}
+
+// WithVerifyServerName verifies the server's certificate against name, which may be an IP address, instead of the
+// name sent in SNI, as when connecting through fronting proxies, or dialing an IP of a server with a certificate
+// only naming hosts. The chain is verified by a ChainVerifier of opts in place of crypto/tls's verification, so use
+// in place of WithServerChainVerification, not with it.
+func WithVerifyServerName(name string, opts ...ChainOption) ClientOption {
+	return func(cfg *tls.Config) error {
+		if name == "" {
+			return errors.New("empty server name")
+		}
+		v, err := NewChainVerifier(opts...)
+		if err != nil {
+			return err
+		}
+		prependServerVerification(cfg, v, name)
+		return nil
+	}
+}
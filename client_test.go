@@ -0,0 +1,88 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewClientTLSConfig(t *testing.T) {
+	cfg, err := NewClientTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.ClientSessionCache == nil || cfg.RootCAs != nil {
+		t.Fatalf("unexpected client defaults %+v", cfg)
+	}
+	if cfg.PreferServerCipherSuites || cfg.CipherSuites != nil {
+		t.Fatal("client config has server shaped defaults")
+	}
+	if _, err := NewClientTLSConfig(WithServerName("")); err == nil {
+		t.Fatal("expected error for empty server name")
+	}
+
+	now := time.Now()
+	serverCert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	pool := x509.NewCertPool()
+	pool.AddCert(serverCert.Leaf)
+	cfg, err = NewClientTLSConfig(WithServerName("example.com"), WithTLS13Only(), func(cfg *tls.Config) error {
+		cfg.RootCAs = pool
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, c2 := net.Pipe()
+	server := tls.Server(c1, &tls.Config{Certificates: []tls.Certificate{*serverCert}})
+	client := tls.Client(c2, cfg)
+	defer server.Close()
+	defer client.Close()
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if v := client.ConnectionState().Version; v != tls.VersionTLS13 {
+		t.Fatalf("expected TLS 1.3, got %#04x", v)
+	}
+}
+
+func TestVerifyServerName(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	serverCert := newTestCertificate(t, "backend.internal", ca, now.Add(-time.Hour), now.Add(time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	server := &tls.Config{Certificates: []tls.Certificate{*serverCert}}
+
+	for _, tt := range []struct {
+		name string
+		ok   bool
+	}{
+		{"backend.internal", true},
+		{"front.example", false},
+	} {
+		cfg, err := NewClientTLSConfig(WithServerName("front.example"), WithVerifyServerName(tt.name), func(cfg *tls.Config) error {
+			cfg.RootCAs = roots
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs, err := clientHandshake(t, server, cfg)
+		if (err == nil) != tt.ok {
+			t.Fatalf("verifying %q: expected ok %v, got %v", tt.name, tt.ok, err)
+		}
+		if tt.ok && cs.ServerName != "front.example" {
+			t.Fatalf("expected SNI front.example, got %q", cs.ServerName)
+		}
+	}
+	if _, err := NewClientTLSConfig(WithVerifyServerName("")); err == nil {
+		t.Fatal("expected error for empty name")
+	}
+}
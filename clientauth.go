@@ -0,0 +1,85 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+)
+
+// WithClientAuth sets the server's policy for TLS client authentication.
+func WithClientAuth(auth tls.ClientAuthType) Option {
+	return func(cfg *tls.Config) error {
+		cfg.ClientAuth = auth
+		return nil
+	}
+}
+
+// WithClientCAPool sets tls.Config's ClientCAs to pool, replacing any CAs previously configured.
+func WithClientCAPool(pool *x509.CertPool) Option {
+	return func(cfg *tls.Config) error {
+		cfg.ClientCAs = pool
+		return nil
+	}
+}
+
+// WithClientCAs appends PEM encoded CA certificates to tls.Config's ClientCAs, used to verify client certificates.
+func WithClientCAs(pemCerts []byte) Option {
+	return func(cfg *tls.Config) error {
+		pool, err := appendPEM(cfg.ClientCAs, pemCerts)
+		if err != nil {
+			return errors.Wrap(err, "failed to load client CAs")
+		}
+		cfg.ClientCAs = pool
+		return nil
+	}
+}
+
+// WithClientCAsFromFile appends the CA certificates from PEM files to tls.Config's ClientCAs.
+func WithClientCAsFromFile(paths ...string) Option {
+	return func(cfg *tls.Config) error {
+		pool, err := appendPEMFiles(cfg.ClientCAs, paths...)
+		if err != nil {
+			return errors.Wrap(err, "failed to load client CAs")
+		}
+		cfg.ClientCAs = pool
+		return nil
+	}
+}
+
+// WithClientCAsFromDir appends the CA certificates from every .pem and .crt file in dir to tls.Config's ClientCAs.
+func WithClientCAsFromDir(dir string) Option {
+	return func(cfg *tls.Config) error {
+		paths, err := pemFilesInDir(dir)
+		if err != nil {
+			return errors.Wrap(err, "failed to load client CAs")
+		}
+		if len(paths) == 0 {
+			return errors.Errorf("failed to load client CAs: no certificates in %s", dir)
+		}
+		return WithClientCAsFromFile(paths...)(cfg)
+	}
+}
+
+// WithClientCAsGlob appends the CA certificates from the PEM files matching glob patterns, or in directories matching,
+// to tls.Config's ClientCAs, as WithRootCAsGlob.
+func WithClientCAsGlob(patterns ...string) Option {
+	return func(cfg *tls.Config) error {
+		pool, err := appendPEMGlob(cfg.ClientCAs, patterns...)
+		if err != nil {
+			return errors.Wrap(err, "failed to load client CAs")
+		}
+		cfg.ClientCAs = pool
+		return nil
+	}
+}
+
+// WithMutualTLS configures a server for mutual TLS, serving the certificate in certFile, keyFile and requiring
+// clients present a certificate issued by a CA in clientCAFile.
+func WithMutualTLS(certFile, keyFile, clientCAFile string) Option {
+	return Wrap(
+		WithKeyPair(certFile, keyFile),
+		WithClientCAsFromFile(clientCAFile),
+		WithClientAuth(tls.RequireAndVerifyClientCert),
+	)
+}
@@ -0,0 +1,66 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientAuth(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	client := newTestCertificate(t, "client", ca, now.Add(-time.Hour), now.Add(time.Hour))
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw})
+
+	cfg, err := NewTLSConfig(WithClientAuth(tls.RequireAndVerifyClientCert), WithClientCAs(caPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("expected RequireAndVerifyClientCert, got %v", cfg.ClientAuth)
+	}
+	opts := x509.VerifyOptions{Roots: cfg.ClientCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err := client.Leaf.Verify(opts); err != nil {
+		t.Fatalf("client not verified by ClientCAs: %v", err)
+	}
+
+	if _, err := NewTLSConfig(WithClientCAs([]byte("not PEM"))); err == nil {
+		t.Fatal("expected error for PEM without certificates")
+	}
+}
+
+func TestClientCAsFromDir(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ca1 := newTestCertificate(t, "ca1", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	ca2 := newTestCertificate(t, "ca2", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	client := newTestCertificate(t, "client", ca2, now.Add(-time.Hour), now.Add(time.Hour))
+	writeCA(t, filepath.Join(dir, "ca1.pem"), ca1)
+	writeCA(t, filepath.Join(dir, "ca2.crt"), ca2)
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := NewTLSConfig(WithClientCAsFromDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := x509.VerifyOptions{Roots: cfg.ClientCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if _, err := client.Leaf.Verify(opts); err != nil {
+		t.Fatalf("client not verified by CA from .crt file: %v", err)
+	}
+
+	if _, err := NewTLSConfig(WithClientCAsFromDir(t.TempDir())); err == nil {
+		t.Fatal("expected error for directory without certificates")
+	}
+	if _, err := NewTLSConfig(WithClientCAsFromFile(filepath.Join(dir, "README"))); err == nil {
+		t.Fatal("expected error for file without certificates")
+	}
+	if _, err := NewTLSConfig(WithClientCAsFromFile(filepath.Join(dir, "missing.pem"))); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
@@ -0,0 +1,37 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+)
+
+// WithClientIdentities configures a client to present, of certs, the first the server will accept, by the CAs and
+// signature schemes of its certificate request. So one config can dial several mutual TLS servers each requiring a
+// certificate from its own CA. If none are acceptable no certificate is sent, leaving the server to decide.
+func WithClientIdentities(certs ...*tls.Certificate) ClientOption {
+	return func(cfg *tls.Config) error {
+		if len(certs) == 0 {
+			return errors.New("no client identities")
+		}
+		for _, cert := range certs {
+			if leafOf(cert) == nil {
+				return errors.New("failed to parse client identity certificate")
+			}
+		}
+		certs = append([]*tls.Certificate(nil), certs...)
+		return setGetClientCertificate(cfg, func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return selectClientCertificate(cri, certs), nil
+		})
+	}
+}
+
+// selectClientCertificate returns the first of certs acceptable to the server, or an empty certificate.
+func selectClientCertificate(cri *tls.CertificateRequestInfo, certs []*tls.Certificate) *tls.Certificate {
+	for _, cert := range certs {
+		if cri.SupportsCertificate(cert) == nil {
+			return cert
+		}
+	}
+	return &tls.Certificate{}
+}
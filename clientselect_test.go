@@ -0,0 +1,85 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+)
+
+// clientHandshake handshakes client with server over a pipe, returning the server's view of the connection.
+func clientHandshake(t *testing.T, server, client *tls.Config) (tls.ConnectionState, error) {
+	t.Helper()
+	c1, c2 := net.Pipe()
+	s, c := tls.Server(c1, server), tls.Client(c2, client)
+	defer s.Close()
+	defer c.Close()
+	errs := make(chan error, 1)
+	go func() { errs <- c.Handshake() }()
+	err := s.Handshake()
+	if err != nil {
+		s.Close()
+	}
+	if cerr := <-errs; err == nil {
+		err = cerr
+	}
+	return s.ConnectionState(), err
+}
+
+func TestClientIdentities(t *testing.T) {
+	now := time.Now()
+	caA := newTestCertificate(t, "ca-a", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	caB := newTestCertificate(t, "ca-b", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	idA := newTestCertificate(t, "client-a", caA, now.Add(-time.Hour), now.Add(time.Hour))
+	idB := newTestCertificate(t, "client-b", caB, now.Add(-time.Hour), now.Add(time.Hour))
+	serverCert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.Leaf)
+
+	client, err := NewClientTLSConfig(WithServerName("example.com"), WithClientIdentities(idA, idB), func(cfg *tls.Config) error {
+		cfg.RootCAs = roots
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		ca   *tls.Certificate
+		want string
+	}{
+		{caA, "client-a"},
+		{caB, "client-b"},
+	} {
+		pool := x509.NewCertPool()
+		pool.AddCert(tt.ca.Leaf)
+		server := &tls.Config{
+			Certificates: []tls.Certificate{*serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+			// Tickets sent after the client's flight would block on the synchronous pipe.
+			SessionTicketsDisabled: true,
+		}
+		cs, err := clientHandshake(t, server, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cs.PeerCertificates[0].Subject.CommonName; got != tt.want {
+			t.Errorf("expected %s presented, got %s", tt.want, got)
+		}
+	}
+
+	// No acceptable identity sends none.
+	caC := newTestCertificate(t, "ca-c", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	got := selectClientCertificate(&tls.CertificateRequestInfo{
+		AcceptableCAs:    [][]byte{caC.Leaf.RawSubject},
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		Version:          tls.VersionTLS13,
+	}, []*tls.Certificate{idA, idB})
+	if len(got.Certificate) != 0 {
+		t.Fatal("expected no certificate")
+	}
+	if err := WithClientIdentities(idA)(client); err != ErrGetClientCertificateConflict {
+		t.Fatalf("expected ErrGetClientCertificateConflict, got %v", err)
+	}
+}
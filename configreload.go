@@ -0,0 +1,155 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// reloadedMaterial is the certificates and client CA pool produced by a ConfigReloader's options.
+type reloadedMaterial struct {
+	certs     []tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// ConfigReloader loads certificates and client CA pools with file based Options, such as WithKeyPair and
+// WithClientCAsFromFile, and can reapply them on demand, typically on SIGHUP. Reloaded material is validated before
+// use, and a failed reload leaves the previous material in service.
+type ConfigReloader struct {
+	// OnError, if set, is called with errors reloading on a signal.
+	OnError func(error)
+
+	opts []Option
+
+	mu       sync.RWMutex
+	material reloadedMaterial
+	base     *tls.Config
+	start    int
+	count    int
+}
+
+// NewConfigReloader returns a ConfigReloader having applied opts.
+func NewConfigReloader(opts ...Option) (*ConfigReloader, error) {
+	r := &ConfigReloader{opts: opts}
+	m, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.material = m
+	return r, nil
+}
+
+// WithConfigReloader configures TLS with the material loaded by r, with server handshakes using the latest material
+// via GetConfigForClient. Certificates from other options are unaffected.
+func WithConfigReloader(r *ConfigReloader) Option {
+	return func(cfg *tls.Config) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.base != nil {
+			return errors.New("config reloader already in use by another tls.Config")
+		}
+		if err := setGetConfigForClient(cfg, r.getConfigForClient); err != nil {
+			return err
+		}
+		r.base, r.start, r.count = cfg, len(cfg.Certificates), len(r.material.certs)
+		cfg.Certificates = append(cfg.Certificates, r.material.certs...)
+		if r.material.clientCAs != nil {
+			cfg.ClientCAs = r.material.clientCAs
+		}
+		return nil
+	}
+}
+
+// WithSIGHUPReload configures TLS with the material loaded by opts, reloading it on SIGHUP for the life of the
+// process. onError, if not nil, is called with reload failures.
+func WithSIGHUPReload(onError func(error), opts ...Option) Option {
+	return func(cfg *tls.Config) error {
+		r, err := NewConfigReloader(opts...)
+		if err != nil {
+			return err
+		}
+		r.OnError = onError
+		if err := WithConfigReloader(r)(cfg); err != nil {
+			return err
+		}
+		r.ReloadOnSignal(syscall.SIGHUP)
+		return nil
+	}
+}
+
+// load applies the options to an empty config, validating the certificates produced.
+func (r *ConfigReloader) load() (reloadedMaterial, error) {
+	var cfg tls.Config
+	if err := Apply(&cfg, r.opts...); err != nil {
+		return reloadedMaterial{}, err
+	}
+	now := time.Now()
+	for i := range cfg.Certificates {
+		if err := ValidateCertificate(&cfg.Certificates[i], now); err != nil {
+			return reloadedMaterial{}, errors.Wrap(err, "invalid certificate")
+		}
+	}
+	return reloadedMaterial{certs: cfg.Certificates, clientCAs: cfg.ClientCAs}, nil
+}
+
+// Reload reapplies the options, replacing the material in use only if all load and validate.
+func (r *ConfigReloader) Reload() error {
+	m, err := r.load()
+	if err != nil {
+		return errors.Wrap(err, "failed to reload")
+	}
+	r.mu.Lock()
+	r.material = m
+	r.mu.Unlock()
+	return nil
+}
+
+// ReloadOnSignal reloads on receipt of any of sigs, until the returned stop function is called.
+func (r *ConfigReloader) ReloadOnSignal(sigs ...os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				if err := r.Reload(); err != nil && r.OnError != nil {
+					r.OnError(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
+
+// getConfigForClient returns a clone of the base config with the current material. Cloning per handshake, rather
+// than on reload, keeps the base config's session ticket keys current as they rotate.
+func (r *ConfigReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cfg := r.base.Clone()
+	cfg.GetConfigForClient = nil
+	certs := make([]tls.Certificate, 0, len(cfg.Certificates)-r.count+len(r.material.certs))
+	certs = append(certs, cfg.Certificates[:r.start]...)
+	certs = append(certs, r.material.certs...)
+	certs = append(certs, cfg.Certificates[r.start+r.count:]...)
+	cfg.Certificates = certs
+	if r.material.clientCAs != nil {
+		cfg.ClientCAs = r.material.clientCAs
+	}
+	return cfg, nil
+}
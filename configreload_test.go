@@ -0,0 +1,73 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestConfigReloader(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, keyFile := writeKeyPair(t, dir, first)
+
+	r, err := NewConfigReloader(WithKeyPair(certFile, keyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	static := newTestCertificate(t, "static.example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	cfg, err := NewTLSConfig(WithConfigReloader(r), func(cfg *tls.Config) error {
+		cfg.Certificates = append(cfg.Certificates, *static)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(cfg.Certificates))
+	}
+
+	second := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	writeKeyPair(t, dir, second)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetConfigForClient != nil {
+		t.Fatal("returned config must not recurse")
+	}
+	if len(got.Certificates) != 2 ||
+		got.Certificates[0].Leaf.SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 ||
+		got.Certificates[1].Leaf.SerialNumber.Cmp(static.Leaf.SerialNumber) != 0 {
+		t.Fatal("reloaded certificate not served alongside static certificate")
+	}
+
+	// An expired replacement fails validation, and the previous certificate stays in service.
+	expired := newTestCertificate(t, "example.com", nil, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	writeKeyPair(t, dir, expired)
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected expired certificate to fail reload")
+	}
+	if err := ioutil.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected unparsable key to fail reload")
+	}
+	got, err = cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Certificates[0].Leaf.SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Fatal("failed reload replaced certificate")
+	}
+
+	if err := WithConfigReloader(r)(&tls.Config{}); err == nil {
+		t.Fatal("expected error attaching to a second config")
+	}
+}
@@ -0,0 +1,119 @@
+package tlsutil
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrWeakCredential is returned by peer verification when the peer's chain fails a CredentialPolicy.
+var ErrWeakCredential = errors.New("tlsutil: weak peer credential")
+
+const defaultMinRSAKeyBits = 2048
+
+// CredentialOption configures a CredentialPolicy.
+type CredentialOption func(*CredentialPolicy) error
+
+// WithMinRSAKeyBits sets the minimum size of RSA keys in the chain, 2048 bits by default.
+func WithMinRSAKeyBits(bits int) CredentialOption {
+	return func(p *CredentialPolicy) error {
+		if bits <= 0 {
+			return errors.New("minimum RSA key size must be positive")
+		}
+		p.minRSABits = bits
+		return nil
+	}
+}
+
+// WithSHA1Signatures permits SHA-1 signatures, rejected by default.
+func WithSHA1Signatures() CredentialOption {
+	return func(p *CredentialPolicy) error {
+		p.allowSHA1 = true
+		return nil
+	}
+}
+
+// WithMaxLifetime sets the maximum validity period of the leaf certificate, such as the CA/Browser Forum's 398 days.
+// Unlimited by default.
+func WithMaxLifetime(d time.Duration) CredentialOption {
+	return func(p *CredentialPolicy) error {
+		if d <= 0 {
+			return errors.New("maximum lifetime must be positive")
+		}
+		p.maxLifetime = d
+		return nil
+	}
+}
+
+// CredentialPolicy is a Verifier rejecting peers whose chains contain weak credentials, crypto/tls accepting any it
+// can verify.
+type CredentialPolicy struct {
+	minRSABits  int
+	allowSHA1   bool
+	maxLifetime time.Duration
+}
+
+// NewCredentialPolicy returns a CredentialPolicy configured by opts.
+func NewCredentialPolicy(opts ...CredentialOption) (*CredentialPolicy, error) {
+	p := &CredentialPolicy{minRSABits: defaultMinRSAKeyBits}
+	for _, opt := range opts {
+		if err := opt(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// WithCredentialPolicy configures TLS to reject peers whose chains fail a CredentialPolicy of opts.
+func WithCredentialPolicy(opts ...CredentialOption) Option {
+	return func(cfg *tls.Config) error {
+		p, err := NewCredentialPolicy(opts...)
+		if err != nil {
+			return err
+		}
+		return WithVerifiers(p)(cfg)
+	}
+}
+
+// VerifyConnection checks the peer's verified chain, or if unverified the certificates presented. The signature of
+// a self signed root isn't checked, as its trust doesn't rest on it.
+func (p *CredentialPolicy) VerifyConnection(cs tls.ConnectionState) error {
+	chain := cs.PeerCertificates
+	if len(cs.VerifiedChains) > 0 {
+		chain = cs.VerifiedChains[0]
+	}
+	if len(chain) == 0 {
+		return errors.New("peer presented no certificates")
+	}
+	for i, cert := range chain {
+		if err := p.check(cert, i == 0); err != nil {
+			return errors.Wrapf(ErrWeakCredential, "certificate %q %s", cert.Subject, err)
+		}
+	}
+	return nil
+}
+
+// check returns the reason cert fails the policy, or nil.
+func (p *CredentialPolicy) check(cert *x509.Certificate, leaf bool) error {
+	if key, ok := cert.PublicKey.(*rsa.PublicKey); ok && key.N.BitLen() < p.minRSABits {
+		return errors.Errorf("has %d bit RSA key, minimum %d", key.N.BitLen(), p.minRSABits)
+	}
+	if !p.allowSHA1 && !isSelfSigned(cert) {
+		switch cert.SignatureAlgorithm {
+		case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+			return errors.New("has SHA-1 signature")
+		}
+	}
+	if leaf && p.maxLifetime > 0 && cert.NotAfter.Sub(cert.NotBefore) > p.maxLifetime {
+		return errors.Errorf("lifetime %s exceeds maximum %s", cert.NotAfter.Sub(cert.NotBefore), p.maxLifetime)
+	}
+	return nil
+}
+
+// isSelfSigned reports whether cert is signed by its own key.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return string(cert.RawIssuer) == string(cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
@@ -0,0 +1,72 @@
+package tlsutil
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestCredentialPolicy(t *testing.T) {
+	now := time.Now()
+	rsaKey := func(bits int) *rsa.PublicKey {
+		return &rsa.PublicKey{N: new(big.Int).Lsh(big.NewInt(1), uint(bits-1)), E: 65537}
+	}
+	cert := func(mod func(*x509.Certificate)) *x509.Certificate {
+		c := &x509.Certificate{
+			PublicKey:          rsaKey(2048),
+			SignatureAlgorithm: x509.SHA256WithRSA,
+			RawIssuer:          []byte("issuer"),
+			RawSubject:         []byte("subject"),
+			NotBefore:          now,
+			NotAfter:           now.Add(90 * 24 * time.Hour),
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+	strict, err := NewCredentialPolicy(WithMaxLifetime(398 * 24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lenient, err := NewCredentialPolicy(WithMinRSAKeyBits(1024), WithSHA1Signatures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := func(c *x509.Certificate) { c.NotAfter = now.Add(3 * 365 * 24 * time.Hour) }
+	for _, tt := range []struct {
+		name            string
+		chain           []*x509.Certificate
+		strict, lenient bool
+	}{
+		{"good", []*x509.Certificate{cert(nil), cert(nil)}, true, true},
+		{"small RSA intermediate", []*x509.Certificate{cert(nil), cert(func(c *x509.Certificate) { c.PublicKey = rsaKey(1024) })}, false, true},
+		{"SHA-1 leaf", []*x509.Certificate{cert(func(c *x509.Certificate) { c.SignatureAlgorithm = x509.SHA1WithRSA })}, false, true},
+		{"long leaf", []*x509.Certificate{cert(long)}, false, true},
+		{"long CA", []*x509.Certificate{cert(nil), cert(long)}, true, true},
+	} {
+		cs := tls.ConnectionState{PeerCertificates: tt.chain}
+		if err := strict.VerifyConnection(cs); (err == nil) != tt.strict {
+			t.Errorf("%s: strict expected ok %v, got %v", tt.name, tt.strict, err)
+		} else if err != nil && errors.Cause(err) != ErrWeakCredential {
+			t.Errorf("%s: expected ErrWeakCredential, got %v", tt.name, err)
+		}
+		if err := lenient.VerifyConnection(cs); (err == nil) != tt.lenient {
+			t.Errorf("%s: lenient expected ok %v, got %v", tt.name, tt.lenient, err)
+		}
+	}
+
+	// Verified chains are checked in preference to what was presented.
+	cs := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert(nil)},
+		VerifiedChains:   [][]*x509.Certificate{{cert(nil), cert(func(c *x509.Certificate) { c.PublicKey = rsaKey(1024) })}},
+	}
+	if err := strict.VerifyConnection(cs); err == nil {
+		t.Fatal("expected weak root in verified chain to be rejected")
+	}
+}
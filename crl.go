@@ -0,0 +1,349 @@
+package tlsutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxCRLSize bounds the size of a downloaded CRL.
+	maxCRLSize = 32 << 20
+	// crlFetchTimeout bounds a CRL download, as verification holds up the handshake.
+	crlFetchTimeout = 10 * time.Second
+	// defaultCRLRefresh is how long a CRL without a next update time is used before being fetched again.
+	defaultCRLRefresh = 24 * time.Hour
+)
+
+// ErrCertificateRevoked is returned by revocation checks of revoked certificates.
+var ErrCertificateRevoked = errors.New("tlsutil: certificate revoked")
+
+// RevocationPolicy decides the fate of certificates whose revocation status can not be determined, such as when a
+// CRL can not be fetched.
+type RevocationPolicy int
+
+const (
+	// RevocationSoftFail accepts certificates whose status can not be determined.
+	RevocationSoftFail RevocationPolicy = iota
+	// RevocationHardFail rejects certificates whose status can not be determined.
+	RevocationHardFail
+)
+
+// CRLOption configures a CRLChecker.
+type CRLOption func(*CRLChecker) error
+
+// WithCRLPolicy sets the policy for certificates whose revocation status can not be determined, soft fail by default.
+func WithCRLPolicy(p RevocationPolicy) CRLOption {
+	return func(c *CRLChecker) error {
+		c.policy = p
+		return nil
+	}
+}
+
+// WithCRLFiles loads CRLs, DER or PEM encoded, from files, checked in addition to those of certificates' CRL
+// distribution points. Call ReloadFiles to load them again.
+func WithCRLFiles(paths ...string) CRLOption {
+	return func(c *CRLChecker) error {
+		c.files = append(c.files, paths...)
+		return nil
+	}
+}
+
+// WithCRLHTTPClient sets the HTTP client CRLs are downloaded with.
+func WithCRLHTTPClient(client *http.Client) CRLOption {
+	return func(c *CRLChecker) error {
+		c.client = client
+		return nil
+	}
+}
+
+// WithCRLClock sets the clock CRL freshness is judged by.
+func WithCRLClock(clock Clock) CRLOption {
+	return func(c *CRLChecker) error {
+		c.clock = clock
+		return nil
+	}
+}
+
+// crlEntry is a parsed CRL.
+type crlEntry struct {
+	list    *x509.RevocationList
+	revoked map[string]bool
+	expires time.Time
+
+	// mu guards issuers, the fingerprints of issuers the CRL's signature has been checked against.
+	mu      sync.Mutex
+	issuers map[[sha256.Size]byte]bool
+}
+
+// crlFetch is a download of a CRL in flight, shared by all waiting on it. done is closed once e or err is set.
+type crlFetch struct {
+	done chan struct{}
+	e    *crlEntry
+	err  error
+}
+
+// CRLChecker checks peer certificates against the CRLs of their distribution points, and any loaded from files.
+// Downloaded CRLs are cached until their next update.
+type CRLChecker struct {
+	policy RevocationPolicy
+	files  []string
+	client *http.Client
+	clock  Clock
+
+	mu       sync.Mutex
+	byURL    map[string]*crlEntry
+	byFile   []*crlEntry
+	fetching map[string]*crlFetch
+}
+
+// NewCRLChecker returns a CRLChecker, having loaded any CRL files.
+func NewCRLChecker(opts ...CRLOption) (*CRLChecker, error) {
+	c := &CRLChecker{
+		client:   http.DefaultClient,
+		clock:    systemClock{},
+		byURL:    make(map[string]*crlEntry),
+		fetching: make(map[string]*crlFetch),
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.ReloadFiles(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// WithCRLChecker configures TLS to reject peer certificates revoked by the CRLs of c, after any other verification.
+func WithCRLChecker(c *CRLChecker) Option {
+	return func(cfg *tls.Config) error {
+		appendVerifyConnection(cfg, c.VerifyConnection)
+		return nil
+	}
+}
+
+// WithCRLChecking configures TLS to reject revoked peer certificates, per a new CRLChecker configured by opts.
+func WithCRLChecking(opts ...CRLOption) Option {
+	return func(cfg *tls.Config) error {
+		c, err := NewCRLChecker(opts...)
+		if err != nil {
+			return err
+		}
+		return WithCRLChecker(c)(cfg)
+	}
+}
+
+// ReloadFiles loads the CRL files again, replacing those in use only if all parse.
+func (c *CRLChecker) ReloadFiles() error {
+	entries := make([]*crlEntry, 0, len(c.files))
+	for _, path := range c.files {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to read CRL")
+		}
+		e, err := parseCRL(data, c.clock.Now())
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+		entries = append(entries, e)
+	}
+	c.mu.Lock()
+	c.byFile = entries
+	c.mu.Unlock()
+	return nil
+}
+
+// parseCRL parses a DER or PEM encoded CRL.
+func parseCRL(data []byte, now time.Time) (*crlEntry, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "X509 CRL" {
+			return nil, errors.New("no X509 CRL PEM block")
+		}
+		data = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse CRL")
+	}
+	e := &crlEntry{
+		list:    list,
+		revoked: make(map[string]bool, len(list.RevokedCertificateEntries)),
+		expires: list.NextUpdate,
+		issuers: make(map[[sha256.Size]byte]bool),
+	}
+	if e.expires.IsZero() {
+		e.expires = now.Add(defaultCRLRefresh)
+	}
+	for _, rc := range list.RevokedCertificateEntries {
+		e.revoked[rc.SerialNumber.String()] = true
+	}
+	return e, nil
+}
+
+// signedBy reports whether e was issued by issuer, caching the result of the signature check.
+func (e *crlEntry) signedBy(issuer *x509.Certificate) bool {
+	sum := sha256.Sum256(issuer.Raw)
+	e.mu.Lock()
+	ok, checked := e.issuers[sum]
+	e.mu.Unlock()
+	if checked {
+		return ok
+	}
+	// Checked without holding mu, a large CRL's signature taking a while. Concurrent checks compute the same result.
+	ok = bytes.Equal(e.list.RawIssuer, issuer.RawSubject) && e.list.CheckSignatureFrom(issuer) == nil
+	e.mu.Lock()
+	e.issuers[sum] = ok
+	e.mu.Unlock()
+	return ok
+}
+
+// VerifyConnection implements tls.Config's VerifyConnection, checking the verified chain, or if verification was
+// skipped the presented chain, of the peer.
+func (c *CRLChecker) VerifyConnection(cs tls.ConnectionState) error {
+	chain := cs.PeerCertificates
+	if len(cs.VerifiedChains) > 0 {
+		chain = cs.VerifiedChains[0]
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), crlFetchTimeout)
+	defer cancel()
+	return c.Check(ctx, chain)
+}
+
+// Check checks each certificate in chain, leaf first, against the CRLs of its issuer, the next in chain. ctx bounds
+// waiting for CRLs to download.
+func (c *CRLChecker) Check(ctx context.Context, chain []*x509.Certificate) error {
+	for i := 0; i+1 < len(chain); i++ {
+		if err := c.check(ctx, chain[i], chain[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check checks cert against the CRLs of issuer.
+func (c *CRLChecker) check(ctx context.Context, cert, issuer *x509.Certificate) error {
+	serial := cert.SerialNumber.String()
+	var checked bool
+	c.mu.Lock()
+	byFile := c.byFile
+	c.mu.Unlock()
+	for _, e := range byFile {
+		if e.signedBy(issuer) {
+			if e.revoked[serial] {
+				return errors.Wrapf(ErrCertificateRevoked, "certificate %q", cert.Subject)
+			}
+			checked = true
+		}
+	}
+
+	var unavailable error
+	for _, url := range cert.CRLDistributionPoints {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue
+		}
+		revoked, err := c.checkURL(ctx, url, serial, issuer)
+		if err != nil {
+			unavailable = err
+			continue
+		}
+		if revoked {
+			return errors.Wrapf(ErrCertificateRevoked, "certificate %q", cert.Subject)
+		}
+		checked = true
+	}
+	if !checked && c.policy == RevocationHardFail {
+		if unavailable == nil {
+			unavailable = errors.New("no CRL available")
+		}
+		return errors.Wrapf(unavailable, "revocation status of certificate %q unknown", cert.Subject)
+	}
+	return nil
+}
+
+// checkURL reports whether serial is revoked by the CRL at url, issued by issuer. Expired CRLs are fetched again,
+// and under soft fail used in the meantime. Concurrent checks share one download per url.
+func (c *CRLChecker) checkURL(ctx context.Context, url, serial string, issuer *x509.Certificate) (bool, error) {
+	now := c.clock.Now()
+	c.mu.Lock()
+	e := c.byURL[url]
+	usable := e != nil && (now.Before(e.expires) || c.policy == RevocationSoftFail)
+	var f *crlFetch
+	if e == nil || !now.Before(e.expires) {
+		f = c.startFetch(url)
+	}
+	c.mu.Unlock()
+	if !usable {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return false, errors.Wrapf(ctx.Err(), "failed to fetch CRL %s", url)
+		}
+		if f.err != nil {
+			return false, f.err
+		}
+		e = f.e
+	}
+	if !e.signedBy(issuer) {
+		return false, errors.Errorf("CRL %s not issued by %q", url, issuer.Subject)
+	}
+	return e.revoked[serial], nil
+}
+
+// startFetch returns the download of the CRL at url in flight, starting one if there's none, which on success
+// replaces the cached CRL. It's bounded by crlFetchTimeout rather than a caller's context, as others may be waiting
+// on it. c.mu must be held.
+func (c *CRLChecker) startFetch(url string) *crlFetch {
+	if f, ok := c.fetching[url]; ok {
+		return f
+	}
+	f := &crlFetch{done: make(chan struct{})}
+	c.fetching[url] = f
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), crlFetchTimeout)
+		defer cancel()
+		f.e, f.err = c.fetch(ctx, url)
+		c.mu.Lock()
+		delete(c.fetching, url)
+		if f.err == nil {
+			c.byURL[url] = f.e
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// fetch downloads and parses the CRL at url.
+func (c *CRLChecker) fetch(ctx context.Context, url string) (*crlEntry, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CRL distribution point")
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch CRL")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to fetch CRL %s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read CRL")
+	}
+	return parseCRL(data, c.clock.Now())
+}
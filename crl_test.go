@@ -0,0 +1,193 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newTestCRL returns a DER CRL issued by ca revoking serials.
+func newTestCRL(t *testing.T, ca *tls.Certificate, nextUpdate time.Time, serials ...*big.Int) []byte {
+	t.Helper()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: nextUpdate,
+	}
+	for _, serial := range serials {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.Leaf, ca.PrivateKey.(crypto.Signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// issueTestLeaf returns a client certificate for cn issued by ca, with a CRL distribution point of crlURL.
+func issueTestLeaf(t *testing.T, cn string, ca *tls.Certificate, crlURL string) *tls.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if crlURL != "" {
+		tmpl.CRLDistributionPoints = []string{crlURL}
+	}
+	return issueTestCertificate(t, tmpl, ca)
+}
+
+func TestCRLChecker(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	var crl atomic.Value
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write(crl.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	good := issueTestLeaf(t, "good", ca, srv.URL)
+	revoked := issueTestLeaf(t, "revoked", ca, srv.URL)
+	crl.Store(newTestCRL(t, ca, now.Add(time.Hour), revoked.Leaf.SerialNumber))
+
+	clock := newFakeClock(now)
+	c, err := NewCRLChecker(WithCRLClock(clock), WithCRLPolicy(RevocationHardFail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(WithCRLChecker(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(cert *tls.Certificate) error {
+		return cfg.VerifyConnection(tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert.Leaf},
+			VerifiedChains:   [][]*x509.Certificate{{cert.Leaf, ca.Leaf}},
+		})
+	}
+	if err := verify(good); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(revoked); errors.Cause(err) != ErrCertificateRevoked {
+		t.Fatalf("expected ErrCertificateRevoked, got %v", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected CRL to be cached, fetched %d times", n)
+	}
+
+	// An expired CRL is fetched again.
+	crl.Store(newTestCRL(t, ca, now.Add(3*time.Hour), revoked.Leaf.SerialNumber, good.Leaf.SerialNumber))
+	clock.Advance(2 * time.Hour)
+	if err := verify(good); errors.Cause(err) != ErrCertificateRevoked {
+		t.Fatalf("expected refreshed CRL to revoke, got %v", err)
+	}
+
+	// A CRL not signed by the issuer is rejected.
+	other := newTestCertificate(t, "other ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	crl.Store(newTestCRL(t, other, now.Add(5*time.Hour)))
+	clock.Advance(2 * time.Hour)
+	if err := verify(good); err == nil || errors.Cause(err) == ErrCertificateRevoked {
+		t.Fatalf("expected unverifiable CRL to fail hard, got %v", err)
+	}
+
+	// Without a distribution point, soft fail accepts and hard fail rejects.
+	none := issueTestLeaf(t, "none", ca, "")
+	if err := verify(none); err == nil {
+		t.Fatal("expected hard fail without CRL")
+	}
+	soft, err := NewCRLChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := soft.Check(context.Background(), []*x509.Certificate{none.Leaf, ca.Leaf}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCRLCheckerConcurrentFetch(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	var crl []byte
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write(crl)
+	}))
+	defer srv.Close()
+	leaf := issueTestLeaf(t, "leaf", ca, srv.URL)
+	crl = newTestCRL(t, ca, now.Add(time.Hour))
+
+	c, err := NewCRLChecker(WithCRLPolicy(RevocationHardFail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- c.Check(context.Background(), []*x509.Certificate{leaf.Leaf, ca.Leaf})
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected checks to share 1 fetch, got %d", n)
+	}
+}
+
+func TestCRLCheckerFiles(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	revoked := issueTestLeaf(t, "revoked", ca, "")
+	good := issueTestLeaf(t, "good", ca, "")
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	der := newTestCRL(t, ca, now.Add(time.Hour), revoked.Leaf.SerialNumber)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCRLChecker(WithCRLFiles(path), WithCRLPolicy(RevocationHardFail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Check(context.Background(), []*x509.Certificate{revoked.Leaf, ca.Leaf}); errors.Cause(err) != ErrCertificateRevoked {
+		t.Fatalf("expected ErrCertificateRevoked, got %v", err)
+	}
+	if err := c.Check(context.Background(), []*x509.Certificate{good.Leaf, ca.Leaf}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, newTestCRL(t, ca, now.Add(time.Hour), good.Leaf.SerialNumber), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReloadFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Check(context.Background(), []*x509.Certificate{good.Leaf, ca.Leaf}); errors.Cause(err) != ErrCertificateRevoked {
+		t.Fatal("reloaded CRL not used")
+	}
+}
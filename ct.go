@@ -0,0 +1,455 @@
+package tlsutil
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	_ "embed"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInsufficientSCTs is returned by peer verification when a certificate lacks enough valid SCTs.
+var ErrInsufficientSCTs = errors.New("tlsutil: insufficient valid SCTs")
+
+// oidSCTList is the X.509v3 extension embedding SCTs in a certificate, RFC 6962 section 3.3.
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// CTLogListURL is Chrome's list of CT logs, in the version 3 JSON format.
+const CTLogListURL = "https://www.gstatic.com/ct/log_list/v3/log_list.json"
+
+//go:generate go run ./internal/ctloglist -o ct_log_list.json
+
+// bundledCTLogList is the snapshot of Chrome's log list bundled with the package, regenerated by go generate.
+//
+//go:embed ct_log_list.json
+var bundledCTLogList []byte
+
+const (
+	defaultSCTMinimum = 2
+	// ctLogListMaxSize bounds the size of a fetched log list.
+	ctLogListMaxSize = 4 << 20
+
+	sctEntryX509    = 0
+	sctEntryPrecert = 1
+)
+
+// CTLog is a Certificate Transparency log whose SCTs are trusted.
+type CTLog struct {
+	// ID is the SHA-256 of the log's DER encoded public key.
+	ID          [sha256.Size]byte
+	Description string
+	Operator    string
+	Key         crypto.PublicKey
+	// Retired, if not zero, is when the log was retired. SCTs it issued later aren't accepted.
+	Retired time.Time
+}
+
+// NewCTLog returns the CTLog of a DER encoded public key.
+func NewCTLog(description, operator string, der []byte) (*CTLog, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse key of CT log %q", description)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, errors.Errorf("CT log %q has unsupported key type %T", description, key)
+	}
+	return &CTLog{ID: sha256.Sum256(der), Description: description, Operator: operator, Key: key}, nil
+}
+
+// operatorKey identifies l's operator, or for a log without one, l itself.
+func (l *CTLog) operatorKey() string {
+	if l.Operator == "" {
+		return string(l.ID[:])
+	}
+	return l.Operator
+}
+
+// CTLogList is a set of trusted CT logs by ID.
+type CTLogList struct {
+	logs map[[sha256.Size]byte]*CTLog
+}
+
+// NewCTLogList returns a CTLogList of logs.
+func NewCTLogList(logs ...*CTLog) *CTLogList {
+	l := &CTLogList{logs: make(map[[sha256.Size]byte]*CTLog, len(logs))}
+	for _, log := range logs {
+		l.logs[log.ID] = log
+	}
+	return l
+}
+
+// ctLogListJSON is the subset of the version 3 log list schema used.
+type ctLogListJSON struct {
+	Operators []struct {
+		Name string `json:"name"`
+		Logs []struct {
+			Description string `json:"description"`
+			LogID       []byte `json:"log_id"`
+			Key         []byte `json:"key"`
+			State       map[string]struct {
+				Timestamp time.Time `json:"timestamp"`
+			} `json:"state"`
+		} `json:"logs"`
+	} `json:"operators"`
+}
+
+// ParseCTLogList parses a log list in the version 3 JSON format, such as Chrome's at CTLogListURL. Pending and
+// rejected logs are omitted.
+func ParseCTLogList(data []byte) (*CTLogList, error) {
+	var list ctLogListJSON
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, errors.Wrap(err, "failed to parse CT log list")
+	}
+	var logs []*CTLog
+	for _, op := range list.Operators {
+		for _, l := range op.Logs {
+			if _, ok := l.State["pending"]; ok {
+				continue
+			}
+			if _, ok := l.State["rejected"]; ok {
+				continue
+			}
+			log, err := NewCTLog(l.Description, op.Name, l.Key)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(log.ID[:], l.LogID) {
+				return nil, errors.Errorf("CT log %q ID does not match its key", l.Description)
+			}
+			if retired, ok := l.State["retired"]; ok {
+				log.Retired = retired.Timestamp
+			}
+			logs = append(logs, log)
+		}
+	}
+	if len(logs) == 0 {
+		return nil, errors.New("CT log list has no usable logs")
+	}
+	return NewCTLogList(logs...), nil
+}
+
+// BundledCTLogList returns the snapshot of Chrome's log list bundled with the package. It goes stale with the binary,
+// as logs are added and retired, so long running clients should refresh it with FetchCTLogList and SetLogList.
+func BundledCTLogList() (*CTLogList, error) {
+	list, err := ParseCTLogList(bundledCTLogList)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid bundled CT log list, regenerate it with go generate")
+	}
+	return list, nil
+}
+
+// FetchCTLogList fetches and parses the log list at url, such as CTLogListURL, with client, or
+// http.DefaultClient if nil.
+func FetchCTLogList(ctx context.Context, client *http.Client, url string) (*CTLogList, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CT log list URL")
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch CT log list")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to fetch CT log list: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, ctLogListMaxSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read CT log list")
+	}
+	if len(data) > ctLogListMaxSize {
+		return nil, errors.New("CT log list too large")
+	}
+	return ParseCTLogList(data)
+}
+
+// SCTOption configures an SCTVerifier.
+type SCTOption func(*SCTVerifier) error
+
+// WithSCTMinimum sets the number of valid SCTs, from logs of distinct operators, a certificate must have, two by
+// default, as CT policies require so no one operator can hide a certificate.
+func WithSCTMinimum(n int) SCTOption {
+	return func(v *SCTVerifier) error {
+		if n <= 0 {
+			return errors.New("SCT minimum must be positive")
+		}
+		v.min = n
+		return nil
+	}
+}
+
+// WithSCTClock sets the clock SCT timestamps are checked against.
+func WithSCTClock(clock Clock) SCTOption {
+	return func(v *SCTVerifier) error {
+		v.clock = clock
+		return nil
+	}
+}
+
+// SCTVerifier verifies servers' certificates have valid SCTs from trusted CT logs, embedded in the certificate or
+// delivered in the TLS extension.
+type SCTVerifier struct {
+	min   int
+	clock Clock
+
+	mu   sync.RWMutex
+	logs *CTLogList
+}
+
+// NewSCTVerifier returns an SCTVerifier trusting the logs of list.
+func NewSCTVerifier(list *CTLogList, opts ...SCTOption) (*SCTVerifier, error) {
+	if list == nil {
+		return nil, errors.New("no CT log list")
+	}
+	v := &SCTVerifier{min: defaultSCTMinimum, clock: systemClock{}, logs: list}
+	for _, opt := range opts {
+		if err := opt(v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// WithSCTVerifier configures a client to reject servers whose certificates lack the SCTs v requires.
+func WithSCTVerifier(v *SCTVerifier) Option {
+	return WithVerifiers(v)
+}
+
+// WithSCTVerification configures a client to reject servers whose certificates lack valid SCTs from the logs of list.
+func WithSCTVerification(list *CTLogList, opts ...SCTOption) Option {
+	return func(cfg *tls.Config) error {
+		v, err := NewSCTVerifier(list, opts...)
+		if err != nil {
+			return err
+		}
+		return WithSCTVerifier(v)(cfg)
+	}
+}
+
+// SetLogList replaces the trusted logs, such as after fetching an updated list.
+func (v *SCTVerifier) SetLogList(list *CTLogList) {
+	v.mu.Lock()
+	v.logs = list
+	v.mu.Unlock()
+}
+
+// VerifyConnection returns ErrInsufficientSCTs unless the server's certificate has enough valid SCTs. Embedded SCTs
+// are verified against the certificate's issuer, so require the chain be verified first.
+func (v *SCTVerifier) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificates")
+	}
+	leaf := cs.PeerCertificates[0]
+	v.mu.RLock()
+	logs := v.logs
+	v.mu.RUnlock()
+	now := v.clock.Now()
+
+	// Counted by operator, as an operator's logs are no more independent than one log.
+	valid := make(map[string]bool)
+	entry := uint24Prefixed(leaf.Raw)
+	for _, raw := range cs.SignedCertificateTimestamps {
+		if log, err := verifySCT(raw, logs, sctEntryX509, entry, now); err == nil {
+			valid[log.operatorKey()] = true
+		}
+	}
+	if embedded, err := embeddedSCTs(leaf); err == nil && len(embedded) > 0 {
+		if issuer := issuerOf(cs); issuer != nil {
+			tbs, err := precertTBS(leaf.RawTBSCertificate)
+			if err != nil {
+				return errors.Wrap(err, "failed to reconstruct precertificate")
+			}
+			keyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+			precert := append(keyHash[:], uint24Prefixed(tbs)...)
+			for _, raw := range embedded {
+				if log, err := verifySCT(raw, logs, sctEntryPrecert, precert, now); err == nil {
+					valid[log.operatorKey()] = true
+				}
+			}
+		}
+	}
+	if len(valid) < v.min {
+		return errors.Wrapf(ErrInsufficientSCTs, "%q has SCTs from %d operators of %d", leaf.Subject, len(valid), v.min)
+	}
+	return nil
+}
+
+// issuerOf returns the verified issuer of the peer's leaf, or nil.
+func issuerOf(cs tls.ConnectionState) *x509.Certificate {
+	if len(cs.VerifiedChains) > 0 {
+		if chain := cs.VerifiedChains[0]; len(chain) > 1 {
+			return chain[1]
+		}
+		return nil
+	}
+	if len(cs.PeerCertificates) > 1 && cs.PeerCertificates[0].CheckSignatureFrom(cs.PeerCertificates[1]) == nil {
+		return cs.PeerCertificates[1]
+	}
+	return nil
+}
+
+// embeddedSCTs returns the SCTs embedded in cert.
+func embeddedSCTs(cert *x509.Certificate) ([][]byte, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSCTList) {
+			continue
+		}
+		var list []byte
+		if _, err := asn1.Unmarshal(ext.Value, &list); err != nil {
+			return nil, errors.Wrap(err, "invalid SCT list extension")
+		}
+		return parseSCTList(list)
+	}
+	return nil, nil
+}
+
+// parseSCTList splits a TLS encoded SignedCertificateTimestampList.
+func parseSCTList(b []byte) ([][]byte, error) {
+	if len(b) < 2 || int(binary.BigEndian.Uint16(b)) != len(b)-2 {
+		return nil, errors.New("invalid SCT list length")
+	}
+	var scts [][]byte
+	for b = b[2:]; len(b) > 0; {
+		if len(b) < 2 {
+			return nil, errors.New("truncated SCT list")
+		}
+		n := int(binary.BigEndian.Uint16(b))
+		if n == 0 || n > len(b)-2 {
+			return nil, errors.New("invalid SCT length")
+		}
+		scts = append(scts, b[2:2+n])
+		b = b[2+n:]
+	}
+	return scts, nil
+}
+
+// precertTBS returns the TBSCertificate the log signed, that of the certificate without the SCT list extension.
+func precertTBS(tbs []byte) ([]byte, error) {
+	var seq asn1.RawValue
+	if rest, err := asn1.Unmarshal(tbs, &seq); err != nil || len(rest) > 0 {
+		return nil, errors.New("invalid TBSCertificate")
+	}
+	var out []byte
+	for b := seq.Bytes; len(b) > 0; {
+		var field asn1.RawValue
+		rest, err := asn1.Unmarshal(b, &field)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid TBSCertificate field")
+		}
+		b = rest
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 3 {
+			out = append(out, field.FullBytes...)
+			continue
+		}
+		var exts []asn1.RawValue
+		if _, err := asn1.Unmarshal(field.Bytes, &exts); err != nil {
+			return nil, errors.Wrap(err, "invalid extensions")
+		}
+		var kept []byte
+		for _, raw := range exts {
+			var ext pkix.Extension
+			if _, err := asn1.Unmarshal(raw.FullBytes, &ext); err != nil {
+				return nil, errors.Wrap(err, "invalid extension")
+			}
+			if !ext.Id.Equal(oidSCTList) {
+				kept = append(kept, raw.FullBytes...)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		inner, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: kept})
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: inner})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, wrapped...)
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: out})
+}
+
+// verifySCT verifies a TLS encoded SCT over entry, returning the log that issued it.
+func verifySCT(b []byte, logs *CTLogList, entryType uint16, entry []byte, now time.Time) (*CTLog, error) {
+	var id [sha256.Size]byte
+	if len(b) < 1+sha256.Size+8+2 || b[0] != 0 {
+		return nil, errors.New("invalid or unsupported SCT")
+	}
+	copy(id[:], b[1:1+sha256.Size])
+	timestamp := b[1+sha256.Size : 1+sha256.Size+8]
+	b = b[1+sha256.Size+8:]
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n+4 {
+		return nil, errors.New("truncated SCT")
+	}
+	extensions := b[2 : 2+n]
+	b = b[2+n:]
+	hashAlg, sigAlg, sig := b[0], b[1], b[4:]
+	if int(binary.BigEndian.Uint16(b[2:])) != len(sig) {
+		return nil, errors.New("invalid SCT signature length")
+	}
+	log, ok := logs.logs[id]
+	if !ok {
+		return nil, errors.New("SCT from unknown log")
+	}
+	ms := binary.BigEndian.Uint64(timestamp)
+	issued := time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond))
+	if issued.After(now) {
+		return nil, errors.New("SCT timestamped in the future")
+	}
+	if !log.Retired.IsZero() && issued.After(log.Retired) {
+		return nil, errors.New("SCT issued after log retired")
+	}
+
+	var signed bytes.Buffer
+	signed.Write([]byte{0, 0}) // v1, certificate_timestamp
+	signed.Write(timestamp)
+	binary.Write(&signed, binary.BigEndian, entryType)
+	signed.Write(entry)
+	binary.Write(&signed, binary.BigEndian, uint16(len(extensions)))
+	signed.Write(extensions)
+	digest := sha256.Sum256(signed.Bytes())
+
+	const hashSHA256, sigRSA, sigECDSA = 4, 1, 3
+	if hashAlg != hashSHA256 {
+		return nil, errors.New("unsupported SCT hash algorithm")
+	}
+	switch key := log.Key.(type) {
+	case *ecdsa.PublicKey:
+		if sigAlg == sigECDSA && ecdsa.VerifyASN1(key, digest[:], sig) {
+			return log, nil
+		}
+	case *rsa.PublicKey:
+		if sigAlg == sigRSA && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil {
+			return log, nil
+		}
+	}
+	return nil, errors.New("invalid SCT signature")
+}
+
+// uint24Prefixed returns b prefixed by its 24 bit length.
+func uint24Prefixed(b []byte) []byte {
+	return append([]byte{byte(len(b) >> 16), byte(len(b) >> 8), byte(len(b))}, b...)
+}
@@ -0,0 +1,263 @@
+package tlsutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// testCTLog is a CT log signing SCTs with its key.
+type testCTLog struct {
+	key *ecdsa.PrivateKey
+	log *CTLog
+}
+
+func newTestCTLog(t *testing.T, name string) *testCTLog {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := NewCTLog(name, name+" operator", der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCTLog{key: key, log: log}
+}
+
+// sign returns a TLS encoded SCT over entry, timestamped at ts.
+func (l *testCTLog) sign(t *testing.T, entryType uint16, entry []byte, ts time.Time) []byte {
+	t.Helper()
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], uint64(ts.UnixNano()/int64(time.Millisecond)))
+	var signed bytes.Buffer
+	signed.Write([]byte{0, 0})
+	signed.Write(timestamp[:])
+	binary.Write(&signed, binary.BigEndian, entryType)
+	signed.Write(entry)
+	signed.Write([]byte{0, 0})
+	digest := sha256.Sum256(signed.Bytes())
+	sig, err := ecdsa.SignASN1(rand.Reader, l.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sct bytes.Buffer
+	sct.WriteByte(0)
+	sct.Write(l.log.ID[:])
+	sct.Write(timestamp[:])
+	sct.Write([]byte{0, 0, 4, 3})
+	binary.Write(&sct, binary.BigEndian, uint16(len(sig)))
+	sct.Write(sig)
+	return sct.Bytes()
+}
+
+// encodeSCTList returns the TLS encoded SignedCertificateTimestampList of scts.
+func encodeSCTList(scts ...[]byte) []byte {
+	var body bytes.Buffer
+	for _, sct := range scts {
+		binary.Write(&body, binary.BigEndian, uint16(len(sct)))
+		body.Write(sct)
+	}
+	return append([]byte{byte(body.Len() >> 8), byte(body.Len())}, body.Bytes()...)
+}
+
+// issueWithEmbeddedSCTs issues a leaf from ca with SCTs from logs embedded, signed over its precertificate.
+func issueWithEmbeddedSCTs(t *testing.T, ca *tls.Certificate, ts time.Time, logs ...*testCTLog) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	pre, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Leaf, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preCert, err := x509.ParseCertificate(pre)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyHash := sha256.Sum256(ca.Leaf.RawSubjectPublicKeyInfo)
+	entry := append(keyHash[:], uint24Prefixed(preCert.RawTBSCertificate)...)
+	var scts [][]byte
+	for _, l := range logs {
+		scts = append(scts, l.sign(t, sctEntryPrecert, entry, ts))
+	}
+	value, err := asn1.Marshal(encodeSCTList(scts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.ExtraExtensions = []pkix.Extension{{Id: oidSCTList, Value: value}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Leaf, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der, ca.Leaf.Raw}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestSCTVerification(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	log1, log2, unknown := newTestCTLog(t, "log1"), newTestCTLog(t, "log2"), newTestCTLog(t, "unknown")
+	list := NewCTLogList(log1.log, log2.log)
+	v, err := NewSCTVerifier(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified := func(cert *tls.Certificate, scts ...[]byte) tls.ConnectionState {
+		return tls.ConnectionState{
+			PeerCertificates:            []*x509.Certificate{cert.Leaf, ca.Leaf},
+			VerifiedChains:              [][]*x509.Certificate{{cert.Leaf, ca.Leaf}},
+			SignedCertificateTimestamps: scts,
+		}
+	}
+
+	embedded := issueWithEmbeddedSCTs(t, ca, now.Add(-time.Minute), log1, log2)
+	if err := v.VerifyConnection(verified(embedded)); err != nil {
+		t.Fatal(err)
+	}
+	one := issueWithEmbeddedSCTs(t, ca, now.Add(-time.Minute), log1, unknown)
+	if err := v.VerifyConnection(verified(one)); errors.Cause(err) != ErrInsufficientSCTs {
+		t.Fatalf("expected ErrInsufficientSCTs, got %v", err)
+	}
+	future := issueWithEmbeddedSCTs(t, ca, now.Add(time.Hour), log1, log2)
+	if err := v.VerifyConnection(verified(future)); errors.Cause(err) != ErrInsufficientSCTs {
+		t.Fatalf("expected ErrInsufficientSCTs for future SCTs, got %v", err)
+	}
+
+	// SCTs delivered in the TLS extension sign the certificate itself.
+	plain := newTestCertificate(t, "example.com", ca, now.Add(-time.Hour), now.Add(time.Hour))
+	entry := uint24Prefixed(plain.Leaf.Raw)
+	cs := verified(plain, log1.sign(t, sctEntryX509, entry, now), log2.sign(t, sctEntryX509, entry, now))
+	if err := v.VerifyConnection(cs); err != nil {
+		t.Fatal(err)
+	}
+	cs.SignedCertificateTimestamps[1] = log2.sign(t, sctEntryX509, uint24Prefixed(ca.Leaf.Raw), now)
+	if err := v.VerifyConnection(cs); errors.Cause(err) != ErrInsufficientSCTs {
+		t.Fatalf("expected ErrInsufficientSCTs for SCT of another certificate, got %v", err)
+	}
+
+	// Retiring a log invalidates its later SCTs.
+	retired := *log2.log
+	retired.Retired = now.Add(-time.Hour)
+	v.SetLogList(NewCTLogList(log1.log, &retired))
+	if err := v.VerifyConnection(verified(embedded)); errors.Cause(err) != ErrInsufficientSCTs {
+		t.Fatalf("expected ErrInsufficientSCTs after retirement, got %v", err)
+	}
+}
+
+func TestParseCTLogList(t *testing.T) {
+	log1, log2 := newTestCTLog(t, "log1"), newTestCTLog(t, "log2")
+	der1, _ := x509.MarshalPKIXPublicKey(&log1.key.PublicKey)
+	der2, _ := x509.MarshalPKIXPublicKey(&log2.key.PublicKey)
+	retired := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	encode := func(id1 []byte) []byte {
+		data, err := json.Marshal(map[string]interface{}{
+			"operators": []interface{}{map[string]interface{}{
+				"name": "Example",
+				"logs": []interface{}{
+					map[string]interface{}{"description": "log1", "log_id": id1, "key": der1,
+						"state": map[string]interface{}{"retired": map[string]interface{}{"timestamp": retired}}},
+					map[string]interface{}{"description": "log2", "log_id": log2.log.ID[:], "key": der2,
+						"state": map[string]interface{}{"pending": map[string]interface{}{"timestamp": retired}}},
+				},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	list, err := ParseCTLogList(encode(log1.log.ID[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.logs) != 1 {
+		t.Fatalf("expected pending log omitted, got %d logs", len(list.logs))
+	}
+	if l := list.logs[log1.log.ID]; l == nil || !l.Retired.Equal(retired) || l.Operator != "Example" {
+		t.Fatalf("unexpected log %+v", l)
+	}
+	if _, err := ParseCTLogList(encode(log2.log.ID[:])); err == nil {
+		t.Fatal("expected error for mismatched log ID")
+	}
+}
+
+func TestSCTVerificationOperators(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	log1, log2 := newTestCTLog(t, "log1"), newTestCTLog(t, "log2")
+	sibling := *log2.log
+	sibling.Operator = log1.log.Operator
+	v, err := NewSCTVerifier(NewCTLogList(log1.log, &sibling))
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded := issueWithEmbeddedSCTs(t, ca, now.Add(-time.Minute), log1, log2)
+	cs := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{embedded.Leaf, ca.Leaf},
+		VerifiedChains:   [][]*x509.Certificate{{embedded.Leaf, ca.Leaf}},
+	}
+	if err := v.VerifyConnection(cs); errors.Cause(err) != ErrInsufficientSCTs {
+		t.Fatalf("expected SCTs of one operator's logs to count once, got %v", err)
+	}
+}
+
+func TestFetchCTLogList(t *testing.T) {
+	log1 := newTestCTLog(t, "log1")
+	der, _ := x509.MarshalPKIXPublicKey(&log1.key.PublicKey)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"operators": []interface{}{map[string]interface{}{
+				"name": "Example",
+				"logs": []interface{}{map[string]interface{}{"description": "log1", "log_id": log1.log.ID[:], "key": der}},
+			}},
+		})
+	}))
+	defer srv.Close()
+	list, err := FetchCTLogList(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.logs[log1.log.ID] == nil {
+		t.Fatal("fetched log list missing log")
+	}
+	if _, err := FetchCTLogList(context.Background(), srv.Client(), srv.URL+"/missing"); err == nil {
+		t.Fatal("expected error for missing list")
+	}
+}
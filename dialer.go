@@ -0,0 +1,47 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+const (
+	defaultDialTimeout   = 30 * time.Second
+	defaultDialKeepAlive = 30 * time.Second
+)
+
+// NewDialer returns a tls.Dialer with a config of opts over the defaults of NewClientTLSConfig, and a 30 second
+// connect timeout.
+func NewDialer(opts ...ClientOption) (*tls.Dialer, error) {
+	cfg, err := NewClientTLSConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultDialKeepAlive},
+		Config:    cfg,
+	}, nil
+}
+
+// DialContext dials addr with d, completing the handshake. If serverName is not empty it's sent and verified instead
+// of the host of addr, or the config's ServerName, such as when dialing a server by IP address.
+func DialContext(ctx context.Context, d *tls.Dialer, network, addr, serverName string) (*tls.Conn, error) {
+	if serverName != "" {
+		cfg := &tls.Config{}
+		if d.Config != nil {
+			// The clone shares the session cache, so resumption still works across names.
+			cfg = d.Config.Clone()
+		}
+		cfg.ServerName = serverName
+		override := *d
+		override.Config = cfg
+		d = &override
+	}
+	c, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.(*tls.Conn), nil
+}
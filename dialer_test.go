@@ -0,0 +1,63 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestDialContext(t *testing.T) {
+	now := time.Now()
+	serverCert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*serverCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				c.(*tls.Conn).Handshake()
+				io.Copy(ioutil.Discard, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.Leaf)
+	d, err := NewDialer(func(cfg *tls.Config) error {
+		cfg.RootCAs = roots
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if c, err := DialContext(ctx, d, "tcp", l.Addr().String(), ""); err == nil {
+		c.Close()
+		t.Fatal("expected verification of IP address to fail")
+	}
+	c, err := DialContext(ctx, d, "tcp", l.Addr().String(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := c.ConnectionState().ServerName; got != "example.com" {
+		t.Fatalf("expected SNI example.com, got %q", got)
+	}
+	if d.Config.ServerName != "" {
+		t.Fatal("dialer config modified")
+	}
+	if d.NetDialer.Timeout != defaultDialTimeout {
+		t.Fatal("expected dial timeout")
+	}
+}
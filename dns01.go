@@ -0,0 +1,660 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// acmeAccountKey is the cache key of the ACME account key, shared with autocert.
+	acmeAccountKey = "acme_account+key"
+	// dnsIssueTimeout bounds a single certificate issuance, dns-01 propagation can be slow.
+	dnsIssueTimeout = 10 * time.Minute
+	// dnsRetryAfter is how long to wait before retrying a failed renewal.
+	dnsRetryAfter = time.Hour
+	// dnsPollInterval is how often DNS is queried while waiting for a challenge record to propagate.
+	dnsPollInterval = 2 * time.Second
+)
+
+// DNSProvider publishes the TXT records answering ACME dns-01 challenges. Providers must allow several TXT values
+// for the same name to coexist, as a certificate for example.com and *.example.com requires two.
+type DNSProvider interface {
+	// Present creates a TXT record named fqdn containing value.
+	Present(ctx context.Context, fqdn, value string) error
+	// Cleanup removes the TXT record previously created by Present.
+	Cleanup(ctx context.Context, fqdn, value string) error
+}
+
+// DNSOption configures a DNSManager.
+type DNSOption func(*DNSManager) error
+
+// DNSManager obtains and renews certificates from an ACME CA, proving control of each domain with dns-01 challenges
+// rather than autocert's tls-alpn-01/http-01. Suitable for servers that can not be reached by the CA, such as those
+// behind load balancers.
+type DNSManager struct {
+	// Client is the ACME client. DirectoryURL defaults to Let's Encrypt, and Key to a KeyType key stored in Cache.
+	Client *acme.Client
+	// Provider publishes challenge records.
+	Provider DNSProvider
+	// Cache optionally stores the account key and certificates, in the same format as autocert.
+	Cache autocert.Cache
+	// HostPolicy controls which hosts certificates are requested for. All hosts are permitted if nil.
+	HostPolicy autocert.HostPolicy
+	// Email is an optional contact address for the ACME account.
+	Email string
+	// RenewBefore is how long before expiry to renew, 30 days if zero.
+	RenewBefore time.Duration
+	// PropagationTimeout is how long to wait for challenge records to be visible in DNS before asking the CA to
+	// validate them. Zero skips the check.
+	PropagationTimeout time.Duration
+	// Wildcards are domains for which a single *.domain certificate, also covering domain itself, is issued and
+	// served for every direct subdomain. Names covered by a wildcard are permitted regardless of HostPolicy.
+	Wildcards []string
+	// Hooks are fired as certificates are issued, renewed or fail.
+	Hooks *ACMEHooks
+	// KeyType of issued certificates, and any newly created account key.
+	KeyType KeyType
+	// Fallbacks are further CAs tried in order, should issuance from Client's CA fail. All share the account key.
+	Fallbacks []*ACMECA
+
+	clientMu   sync.Mutex
+	registered bool
+	ariLoaded  bool
+	ariURL     string
+
+	stateMu sync.Mutex
+	state   map[string]*dnsCertState
+}
+
+// ACMECA is a fallback ACME certificate authority.
+type ACMECA struct {
+	// DirectoryURL is the CA's directory URL.
+	DirectoryURL string
+	// ExternalAccountBinding binds the account to one held with the CA, required by some such as ZeroSSL.
+	ExternalAccountBinding *acme.ExternalAccountBinding
+
+	client     *acme.Client
+	registered bool
+}
+
+// dnsCertState holds the current certificate for a name. mu is held whilst the first certificate is obtained.
+// renewAt is the time chosen from the CA's ARI suggested window, if any.
+type dnsCertState struct {
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	renewing    bool
+	nextRenew   time.Time
+	renewAt     time.Time
+	checkingARI bool
+	nextARI     time.Time
+}
+
+// NewDNSManager returns a DNSManager using provider to answer challenges, configured by opts.
+func NewDNSManager(provider DNSProvider, opts ...DNSOption) (*DNSManager, error) {
+	if provider == nil {
+		return nil, errors.New("DNS manager requires a DNSProvider")
+	}
+	m := &DNSManager{
+		Provider:           provider,
+		PropagationTimeout: 2 * time.Minute,
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// WithDNSManager configures TLS to obtain certificates from m.
+func WithDNSManager(m *DNSManager) Option {
+	return func(cfg *tls.Config) error {
+		return setGetCertificate(cfg, m.GetCertificate)
+	}
+}
+
+// WithACMEDNS01 configures TLS to use ACME with dns-01 challenges answered by provider, configured by DNSOptions.
+func WithACMEDNS01(provider DNSProvider, opts ...DNSOption) Option {
+	return func(cfg *tls.Config) error {
+		m, err := NewDNSManager(provider, opts...)
+		if err != nil {
+			return err
+		}
+		return WithDNSManager(m)(cfg)
+	}
+}
+
+// WithDNSDirectoryURL sets the ACME CA's directory URL.
+func WithDNSDirectoryURL(url string) DNSOption {
+	return func(m *DNSManager) error {
+		if m.Client == nil {
+			m.Client = &acme.Client{}
+		}
+		m.Client.DirectoryURL = url
+		return nil
+	}
+}
+
+// WithDNSFallbackCA adds a CA to issue from should those preceding it fail, due to outage or rate limiting for
+// example. eab may be nil for CAs not requiring external account binding.
+func WithDNSFallbackCA(directoryURL string, eab *acme.ExternalAccountBinding) DNSOption {
+	return func(m *DNSManager) error {
+		if directoryURL == "" {
+			return errors.New("fallback CA requires a directory URL")
+		}
+		m.Fallbacks = append(m.Fallbacks, &ACMECA{DirectoryURL: directoryURL, ExternalAccountBinding: eab})
+		return nil
+	}
+}
+
+// WithDNSEmail sets the ACME account contact address.
+func WithDNSEmail(email string) DNSOption {
+	return func(m *DNSManager) error {
+		m.Email = email
+		return nil
+	}
+}
+
+// WithDNSHosts restricts certificates to hosts.
+func WithDNSHosts(hosts ...string) DNSOption {
+	return func(m *DNSManager) error {
+		if len(hosts) > 0 {
+			m.HostPolicy = autocert.HostWhitelist(hosts...)
+		}
+		return nil
+	}
+}
+
+// WithDNSHostPolicyFunc sets the policy consulted before requesting a certificate for a host.
+func WithDNSHostPolicyFunc(policy autocert.HostPolicy) DNSOption {
+	return func(m *DNSManager) error {
+		m.HostPolicy = policy
+		return nil
+	}
+}
+
+// WithDNSCache sets the cache for the account key and certificates.
+func WithDNSCache(cache autocert.Cache) DNSOption {
+	return func(m *DNSManager) error {
+		m.Cache = cache
+		return nil
+	}
+}
+
+// WithDNSDirCache configures a cache directory for the account key and certificates.
+func WithDNSDirCache(dir string) DNSOption {
+	return func(m *DNSManager) error {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		m.Cache = autocert.DirCache(dir)
+		return nil
+	}
+}
+
+// WithDNSRenewBefore configures how long before expiry certificates are renewed.
+func WithDNSRenewBefore(d time.Duration) DNSOption {
+	return func(m *DNSManager) error {
+		if d <= 0 {
+			return errors.Errorf("DNS renew before %s must be positive", d)
+		}
+		m.RenewBefore = d
+		return nil
+	}
+}
+
+// WithDNSPropagationTimeout sets how long to wait for challenge records to appear in DNS, zero disables the check.
+func WithDNSPropagationTimeout(d time.Duration) DNSOption {
+	return func(m *DNSManager) error {
+		m.PropagationTimeout = d
+		return nil
+	}
+}
+
+// WithDNSHooks sets the hooks fired over the certificate lifecycle.
+func WithDNSHooks(hooks *ACMEHooks) DNSOption {
+	return func(m *DNSManager) error {
+		m.Hooks = hooks
+		return nil
+	}
+}
+
+// WithDNSWildcard issues a wildcard certificate for each domain, served for domain and its direct subdomains,
+// rather than one certificate per host.
+func WithDNSWildcard(domains ...string) DNSOption {
+	return func(m *DNSManager) error {
+		for _, d := range domains {
+			d = normalizeServerName(strings.TrimPrefix(d, "*."))
+			if d == "" || strings.Contains(d, "*") {
+				return errors.Errorf("invalid wildcard domain %q", d)
+			}
+			m.Wildcards = append(m.Wildcards, d)
+		}
+		return nil
+	}
+}
+
+// certName returns the certificate cache key, and domains to request, for serving name.
+func (m *DNSManager) certName(name string) (key string, domains []string, wildcard bool) {
+	parent := name
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		parent = name[i+1:]
+	}
+	for _, w := range m.Wildcards {
+		if name == w || parent == w {
+			return "*." + w, []string{"*." + w, w}, true
+		}
+	}
+	return name, []string{name}, false
+}
+
+// normalizeServerName lower cases name and strips any trailing dot.
+func normalizeServerName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// helloContext returns the handshake's context, or a background context for hand built ClientHelloInfos.
+func helloContext(hello *tls.ClientHelloInfo) context.Context {
+	if ctx := hello.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// GetCertificate implements tls.Config's GetCertificate, obtaining a certificate for the requested server name on
+// first use, and renewing it in the background as it nears expiry, or within the CA's ARI suggested window.
+func (m *DNSManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := normalizeServerName(hello.ServerName)
+	if name == "" {
+		return nil, errors.New("tlsutil: missing server name")
+	}
+	ctx, cancel := context.WithTimeout(helloContext(hello), dnsIssueTimeout)
+	defer cancel()
+	key, domains, wildcard := m.certName(name)
+	var policy func(context.Context) error
+	if !wildcard && m.HostPolicy != nil {
+		policy = func(ctx context.Context) error { return m.HostPolicy(ctx, name) }
+	}
+	return m.certificate(ctx, key, domains, policy)
+}
+
+func (m *DNSManager) certState(name string) *dnsCertState {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	if m.state == nil {
+		m.state = make(map[string]*dnsCertState)
+	}
+	s, ok := m.state[name]
+	if !ok {
+		s = &dnsCertState{}
+		m.state[name] = s
+	}
+	return s
+}
+
+// certificate returns the certificate stored under key, obtaining one for domains if necessary. policy, if not nil,
+// is consulted before first loading the certificate.
+func (m *DNSManager) certificate(ctx context.Context, key string, domains []string, policy func(context.Context) error) (*tls.Certificate, error) {
+	s := m.certState(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cert == nil {
+		if policy != nil {
+			if err := policy(ctx); err != nil {
+				return nil, err
+			}
+		}
+		cert, err := m.cacheGet(ctx, key)
+		if err != nil {
+			if cert, err = m.obtain(ctx, key, domains); err != nil {
+				m.Hooks.failed(key, err)
+				return nil, err
+			}
+		}
+		s.cert = cert
+		m.Hooks.issued(key, cert.Leaf)
+	}
+	now := time.Now()
+	due := m.renewDue(s.cert.Leaf, now) || (!s.renewAt.IsZero() && !now.Before(s.renewAt))
+	if due && !s.renewing && now.After(s.nextRenew) {
+		s.renewing = true
+		go m.renew(key, domains, s)
+	} else if !due && !s.checkingARI && now.After(s.nextARI) {
+		s.checkingARI = true
+		go m.checkRenewalInfo(s)
+	}
+	return s.cert, nil
+}
+
+func (m *DNSManager) renewDue(leaf *x509.Certificate, now time.Time) bool {
+	d := m.RenewBefore
+	if d <= 0 {
+		d = 30 * 24 * time.Hour
+	}
+	return leaf.NotAfter.Sub(now) < d
+}
+
+func (m *DNSManager) renew(key string, domains []string, s *dnsCertState) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsIssueTimeout)
+	defer cancel()
+	cert, err := m.obtain(ctx, key, domains)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.renewing = false
+	if err != nil {
+		s.nextRenew = time.Now().Add(dnsRetryAfter)
+		m.Hooks.failed(key, err)
+		return
+	}
+	s.cert = cert
+	s.renewAt, s.nextARI = time.Time{}, time.Time{}
+	m.Hooks.renewed(key, cert.Leaf)
+}
+
+// obtain requests a new certificate for domains, failing over from the primary CA to each fallback in turn.
+func (m *DNSManager) obtain(ctx context.Context, key string, domains []string) (*tls.Certificate, error) {
+	cert, err := m.obtainFrom(ctx, nil, key, domains)
+	if err == nil || len(m.Fallbacks) == 0 {
+		return cert, err
+	}
+	msgs := []string{err.Error()}
+	for _, ca := range m.Fallbacks {
+		if ctx.Err() != nil {
+			break
+		}
+		if cert, err = m.obtainFrom(ctx, ca, key, domains); err == nil {
+			return cert, nil
+		}
+		msgs = append(msgs, ca.DirectoryURL+": "+err.Error())
+	}
+	return nil, errors.Errorf("all ACME CAs failed: %s", strings.Join(msgs, "; "))
+}
+
+// obtainFrom requests a new certificate for domains from ca, or the primary CA if nil, caching it under key.
+func (m *DNSManager) obtainFrom(ctx context.Context, ca *ACMECA, key string, domains []string) (*tls.Certificate, error) {
+	client, err := m.acmeClient(ctx, ca)
+	if err != nil {
+		return nil, err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ACME order")
+	}
+	for _, u := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, u); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, errors.Wrap(err, "ACME order failed")
+	}
+
+	certKey, err := m.KeyType.generate()
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: domains}, certKey)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to finalize ACME order")
+	}
+	cert, err := newACMECertificate(der, certKey)
+	if err != nil {
+		return nil, err
+	}
+	// A failure to cache is not fatal, the certificate is still good to serve.
+	m.cachePut(ctx, key, cert)
+	return cert, nil
+}
+
+// authorize satisfies the dns-01 challenge of the authorization at url, if not already valid.
+func (m *DNSManager) authorize(ctx context.Context, client *acme.Client, url string) error {
+	z, err := client.GetAuthorization(ctx, url)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch ACME authorization")
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return errors.Errorf("no dns-01 challenge offered for %s", z.Identifier.Value)
+	}
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := "_acme-challenge." + z.Identifier.Value + "."
+	if err := m.Provider.Present(ctx, fqdn, value); err != nil {
+		return errors.Wrapf(err, "failed to present dns-01 record %s", fqdn)
+	}
+	defer func() {
+		// Clean up even if ctx has expired.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		m.Provider.Cleanup(ctx, fqdn, value)
+	}()
+
+	if err := m.waitPropagation(ctx, fqdn, value); err != nil {
+		return err
+	}
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return errors.Wrap(err, "failed to accept dns-01 challenge")
+	}
+	if _, err := client.WaitAuthorization(ctx, z.URI); err != nil {
+		return errors.Wrapf(err, "dns-01 authorization for %s failed", z.Identifier.Value)
+	}
+	return nil
+}
+
+// waitPropagation polls DNS until fqdn has a TXT record containing value.
+func (m *DNSManager) waitPropagation(ctx context.Context, fqdn, value string) error {
+	if m.PropagationTimeout <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.PropagationTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(dnsPollInterval)
+	defer ticker.Stop()
+	for {
+		txts, _ := net.DefaultResolver.LookupTXT(ctx, fqdn)
+		for _, txt := range txts {
+			if txt == value {
+				return nil
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Errorf("dns-01 record %s not visible after %s", fqdn, m.PropagationTimeout)
+		}
+	}
+}
+
+// acmeClient returns the ACME client for ca, or the primary CA if nil, loading or creating the account key and
+// registering on first use.
+func (m *DNSManager) acmeClient(ctx context.Context, ca *ACMECA) (*acme.Client, error) {
+	client, registered, eab, err := m.accountClient(ctx, ca)
+	if err != nil {
+		return nil, err
+	}
+	m.clientMu.Lock()
+	done := *registered
+	m.clientMu.Unlock()
+	if done {
+		return client, nil
+	}
+	// Registered without holding clientMu, so a slow CA doesn't hold up issuance from the others. Concurrent first
+	// uses may both register, the later finding the account exists.
+	var contact []string
+	if m.Email != "" {
+		contact = []string{"mailto:" + m.Email}
+	}
+	_, err = client.Register(ctx, &acme.Account{Contact: contact, ExternalAccountBinding: eab}, autocert.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, errors.Wrap(err, "failed to register ACME account")
+	}
+	m.clientMu.Lock()
+	*registered = true
+	m.clientMu.Unlock()
+	return client, nil
+}
+
+// accountClient returns the ACME client for ca, or the primary CA if nil, loading or creating the account key on
+// first use, with its registered flag, guarded by clientMu, and external account binding.
+func (m *DNSManager) accountClient(ctx context.Context, ca *ACMECA) (*acme.Client, *bool, *acme.ExternalAccountBinding, error) {
+	m.clientMu.Lock()
+	defer m.clientMu.Unlock()
+
+	if m.Client == nil {
+		m.Client = &acme.Client{}
+	}
+	if m.Client.Key == nil {
+		key, err := m.accountKey(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		m.Client.Key = key
+	}
+	if ca == nil {
+		return m.Client, &m.registered, nil, nil
+	}
+	if ca.client == nil {
+		ca.client = &acme.Client{
+			Key:          m.Client.Key,
+			HTTPClient:   m.Client.HTTPClient,
+			UserAgent:    m.Client.UserAgent,
+			DirectoryURL: ca.DirectoryURL,
+		}
+	}
+	return ca.client, &ca.registered, ca.ExternalAccountBinding, nil
+}
+
+// accountKey loads the account key from the cache, generating and storing a new one if absent.
+func (m *DNSManager) accountKey(ctx context.Context) (crypto.Signer, error) {
+	if m.Cache != nil {
+		if data, err := m.Cache.Get(ctx, acmeAccountKey); err == nil {
+			block, _ := pem.Decode(data)
+			if block == nil {
+				return nil, errors.New("invalid cached ACME account key")
+			}
+			return parsePrivateKey(block.Bytes)
+		} else if err != autocert.ErrCacheMiss {
+			return nil, err
+		}
+	}
+	key, err := m.KeyType.generate()
+	if err != nil {
+		return nil, err
+	}
+	if m.Cache != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.Cache.Put(ctx, acmeAccountKey, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// parsePrivateKey parses a DER encoded EC, PKCS#1 RSA or PKCS#8 private key.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("unsupported private key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+	return signer, nil
+}
+
+// newACMECertificate returns a tls.Certificate from an issued chain, checking the leaf matches key.
+func newACMECertificate(der [][]byte, key crypto.Signer) (*tls.Certificate, error) {
+	if len(der) == 0 {
+		return nil, errors.New("ACME CA returned no certificate")
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse issued certificate")
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return nil, errors.New("issued certificate does not match private key")
+	}
+	return &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// cacheGet returns the cached certificate for name, provided it has not expired.
+func (m *DNSManager) cacheGet(ctx context.Context, name string) (*tls.Certificate, error) {
+	if m.Cache == nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	data, err := m.Cache.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return nil, errors.New("cached certificate expired")
+	}
+	return &cert, nil
+}
+
+// cachePut stores cert under name as a PEM private key followed by the certificate chain.
+func (m *DNSManager) cachePut(ctx context.Context, name string, cert *tls.Certificate) error {
+	if m.Cache == nil {
+		return nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	for _, c := range cert.Certificate {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+	return m.Cache.Put(ctx, name, data)
+}
@@ -0,0 +1,156 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type nopProvider struct{}
+
+func (nopProvider) Present(ctx context.Context, fqdn, value string) error { return nil }
+func (nopProvider) Cleanup(ctx context.Context, fqdn, value string) error { return nil }
+
+func TestDNSManagerCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	cache := memCache{}
+	m, err := NewDNSManager(nopProvider{}, WithDNSCache(cache), WithDNSRenewBefore(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(48*time.Hour))
+	if err := m.cachePut(ctx, "example.com", cert); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.cacheGet(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Fatal("cached certificate differs")
+	}
+
+	// Served from cache without contacting the CA.
+	served, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "Example.COM."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if served.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Fatal("served certificate differs")
+	}
+
+	if m.renewDue(cert.Leaf, now) {
+		t.Fatal("renewal not yet due")
+	}
+	if !m.renewDue(cert.Leaf, now.Add(25*time.Hour)) {
+		t.Fatal("expected renewal due within RenewBefore of expiry")
+	}
+
+	expired := newTestCertificate(t, "old.example.com", nil, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if err := m.cachePut(ctx, "old.example.com", expired); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.cacheGet(ctx, "old.example.com"); err == nil {
+		t.Fatal("expected expired cached certificate to be ignored")
+	}
+
+	if _, err := NewDNSManager(nil); err == nil {
+		t.Fatal("expected nil provider to be rejected")
+	}
+}
+
+func TestDNSManagerWildcard(t *testing.T) {
+	m, err := NewDNSManager(nopProvider{}, WithDNSWildcard("*.Example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"example.com":     "*.example.com",
+		"www.example.com": "*.example.com",
+		"a.b.example.com": "a.b.example.com",
+		"example.org":     "example.org",
+		"notexample.com":  "notexample.com",
+		"api.example.com": "*.example.com",
+	} {
+		key, domains, _ := m.certName(name)
+		if key != want {
+			t.Errorf("%s: expected %s, got %s", name, want, key)
+		}
+		if key == "*.example.com" && (len(domains) != 2 || domains[1] != "example.com") {
+			t.Errorf("%s: expected wildcard and apex, got %v", name, domains)
+		}
+	}
+}
+
+// newACMEStub returns a server of just enough of an ACME CA's API for accounts to register.
+func newACMEStub() *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		switch r.URL.Path {
+		case "/dir":
+			json.NewEncoder(w).Encode(map[string]string{
+				"newNonce":   srv.URL + "/nonce",
+				"newAccount": srv.URL + "/account",
+				"newOrder":   srv.URL + "/order",
+			})
+		case "/nonce":
+		case "/account":
+			w.Header().Set("Location", srv.URL+"/account/1")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"status":"valid"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return srv
+}
+
+func TestDNSManagerFallback(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusForbidden)
+	}))
+	defer primary.Close()
+	fallbackCA := newACMEStub()
+	defer fallbackCA.Close()
+
+	m, err := NewDNSManager(nopProvider{},
+		WithDNSDirectoryURL(primary.URL+"/dir"),
+		WithDNSFallbackCA(fallbackCA.URL+"/dir", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := m.acmeClient(ctx, nil); err == nil {
+		t.Fatal("expected primary CA registration to fail")
+	}
+	if m.registered {
+		t.Fatal("primary account registered despite failure")
+	}
+	fallback, err := m.acmeClient(ctx, m.Fallbacks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fallback == m.Client || fallback.DirectoryURL != fallbackCA.URL+"/dir" {
+		t.Fatal("fallback CA does not have its own client")
+	}
+	if fallback.Key != m.Client.Key {
+		t.Fatal("fallback CA does not share the account key")
+	}
+	if !m.Fallbacks[0].registered {
+		t.Fatal("fallback account not registered")
+	}
+	if again, err := m.acmeClient(ctx, m.Fallbacks[0]); err != nil || again != fallback {
+		t.Fatal("fallback client not reused")
+	}
+
+	if _, err := NewDNSManager(nopProvider{}, WithDNSFallbackCA("", nil)); err == nil {
+		t.Fatal("expected error for empty directory URL")
+	}
+}
@@ -0,0 +1,131 @@
+// Package cloudflare provides a tlsutil.DNSProvider creating ACME dns-01 challenge records via the Cloudflare API.
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/renthraysk/tlsutil"
+)
+
+// DefaultBaseURL is the Cloudflare v4 API endpoint.
+const DefaultBaseURL = "https://api.cloudflare.com/client/v4"
+
+// Provider creates TXT records in a single Cloudflare zone.
+type Provider struct {
+	// ZoneID identifies the zone containing the challenge records.
+	ZoneID string
+	// Token is an API token with DNS edit permission for the zone.
+	Token string
+	// TTL of challenge records in seconds, 120 if zero.
+	TTL int
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// BaseURL defaults to DefaultBaseURL.
+	BaseURL string
+}
+
+var _ tlsutil.DNSProvider = (*Provider)(nil)
+
+// New returns a Provider for zoneID authenticating with token.
+func New(zoneID, token string) *Provider {
+	return &Provider{ZoneID: zoneID, Token: token}
+}
+
+type dnsRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+}
+
+type response struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// Present creates a TXT record named fqdn containing value.
+func (p *Provider) Present(ctx context.Context, fqdn, value string) error {
+	ttl := p.TTL
+	if ttl == 0 {
+		ttl = 120
+	}
+	rec := dnsRecord{Type: "TXT", Name: strings.TrimSuffix(fqdn, "."), Content: value, TTL: ttl}
+	return p.do(ctx, http.MethodPost, "/zones/"+p.ZoneID+"/dns_records", rec, nil)
+}
+
+// Cleanup removes every TXT record named fqdn containing value.
+func (p *Provider) Cleanup(ctx context.Context, fqdn, value string) error {
+	q := url.Values{
+		"type":    {"TXT"},
+		"name":    {strings.TrimSuffix(fqdn, ".")},
+		"content": {value},
+	}
+	var recs []dnsRecord
+	if err := p.do(ctx, http.MethodGet, "/zones/"+p.ZoneID+"/dns_records?"+q.Encode(), nil, &recs); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err := p.do(ctx, http.MethodDelete, "/zones/"+p.ZoneID+"/dns_records/"+rec.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Provider) do(ctx context.Context, method, path string, in, out interface{}) error {
+	base := p.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, base+path, &body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "cloudflare request failed")
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return errors.Wrapf(err, "cloudflare: invalid response, status %s", resp.Status)
+	}
+	if !r.Success {
+		if len(r.Errors) > 0 {
+			return errors.Errorf("cloudflare: %s (code %d)", r.Errors[0].Message, r.Errors[0].Code)
+		}
+		return errors.Errorf("cloudflare: request failed, status %s", resp.Status)
+	}
+	if out != nil {
+		return json.Unmarshal(r.Result, out)
+	}
+	return nil
+}
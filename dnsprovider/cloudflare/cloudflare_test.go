@@ -0,0 +1,69 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestPresentCleanup(t *testing.T) {
+	var mu sync.Mutex
+	records := map[string]dnsRecord{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer token" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "errors": []map[string]interface{}{{"code": 10000, "message": "Authentication error"}}})
+			return
+		}
+		var result interface{}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/zones/zone/dns_records":
+			var rec dnsRecord
+			json.NewDecoder(r.Body).Decode(&rec)
+			rec.ID = rec.Content
+			records[rec.ID] = rec
+			result = rec
+		case r.Method == http.MethodGet && r.URL.Path == "/zones/zone/dns_records":
+			var recs []dnsRecord
+			for _, rec := range records {
+				if rec.Name == r.URL.Query().Get("name") && rec.Content == r.URL.Query().Get("content") {
+					recs = append(recs, rec)
+				}
+			}
+			result = recs
+		case r.Method == http.MethodDelete:
+			delete(records, r.URL.Path[len("/zones/zone/dns_records/"):])
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result})
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	p := &Provider{ZoneID: "zone", Token: "token", BaseURL: srv.URL}
+	for _, v := range []string{"a", "b"} {
+		if err := p.Present(ctx, "_acme-challenge.example.com.", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rec := records["a"]; rec.Name != "_acme-challenge.example.com" || rec.Type != "TXT" || rec.TTL != 120 {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if err := p.Cleanup(ctx, "_acme-challenge.example.com.", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := records["a"]; ok || len(records) != 1 {
+		t.Fatalf("expected only record b to remain, got %v", records)
+	}
+
+	p.Token = "wrong"
+	if err := p.Present(ctx, "_acme-challenge.example.com.", "c"); err == nil {
+		t.Fatal("expected authentication error")
+	}
+}
@@ -0,0 +1,88 @@
+// Package rfc2136 provides a tlsutil.DNSProvider creating ACME dns-01 challenge records with RFC 2136 dynamic
+// updates, as supported by BIND, Knot, PowerDNS and others.
+package rfc2136
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/renthraysk/tlsutil"
+)
+
+// Provider sends TSIG signed dynamic updates to an authoritative name server.
+type Provider struct {
+	// Nameserver is the host:port of the primary name server accepting updates.
+	Nameserver string
+	// Zone is the zone containing the challenge records.
+	Zone string
+	// TSIGKey is the name of the TSIG key, and TSIGSecret its base64 encoded secret. Updates are unsigned if empty.
+	TSIGKey    string
+	TSIGSecret string
+	// TSIGAlgorithm defaults to dns.HmacSHA256.
+	TSIGAlgorithm string
+	// TTL of challenge records in seconds, 60 if zero.
+	TTL uint32
+	// Timeout of each update, 10 seconds if zero.
+	Timeout time.Duration
+}
+
+var _ tlsutil.DNSProvider = (*Provider)(nil)
+
+// New returns a Provider updating zone on nameserver, signed with the TSIG key named key with base64 secret.
+func New(nameserver, zone, key, secret string) *Provider {
+	return &Provider{Nameserver: nameserver, Zone: zone, TSIGKey: key, TSIGSecret: secret}
+}
+
+// Present adds a TXT record named fqdn containing value.
+func (p *Provider) Present(ctx context.Context, fqdn, value string) error {
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(p.Zone))
+	m.Insert([]dns.RR{p.txt(fqdn, value)})
+	return p.exchange(ctx, m)
+}
+
+// Cleanup removes the TXT record named fqdn containing value, leaving any others.
+func (p *Provider) Cleanup(ctx context.Context, fqdn, value string) error {
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(p.Zone))
+	m.Remove([]dns.RR{p.txt(fqdn, value)})
+	return p.exchange(ctx, m)
+}
+
+func (p *Provider) txt(fqdn, value string) *dns.TXT {
+	ttl := p.TTL
+	if ttl == 0 {
+		ttl = 60
+	}
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: dns.Fqdn(fqdn), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: ttl},
+		Txt: []string{value},
+	}
+}
+
+func (p *Provider) exchange(ctx context.Context, m *dns.Msg) error {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	c := &dns.Client{Net: "tcp", Timeout: timeout}
+	if p.TSIGKey != "" {
+		alg := p.TSIGAlgorithm
+		if alg == "" {
+			alg = dns.HmacSHA256
+		}
+		key := dns.Fqdn(p.TSIGKey)
+		m.SetTsig(key, alg, 300, time.Now().Unix())
+		c.TsigSecret = map[string]string{key: p.TSIGSecret}
+	}
+	r, _, err := c.ExchangeContext(ctx, m, p.Nameserver)
+	if err != nil {
+		return errors.Wrap(err, "rfc2136: update failed")
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return errors.Errorf("rfc2136: update rejected: %s", dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
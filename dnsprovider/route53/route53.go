@@ -0,0 +1,243 @@
+// Package route53 provides a tlsutil.DNSProvider creating ACME dns-01 challenge records in AWS Route 53.
+package route53
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/renthraysk/tlsutil"
+)
+
+const (
+	// DefaultEndpoint is the global Route 53 API endpoint.
+	DefaultEndpoint = "https://route53.amazonaws.com"
+	apiVersion      = "2013-04-01"
+	xmlns           = "https://route53.amazonaws.com/doc/2013-04-01/"
+	// Route 53 is a global service, signed for us-east-1.
+	signingRegion = "us-east-1"
+	pollInterval  = 5 * time.Second
+)
+
+// Provider creates TXT records in a single Route 53 hosted zone. Record sets are read, modified and written back,
+// so concurrent challenges for the same name keep each other's values.
+type Provider struct {
+	// HostedZoneID identifies the hosted zone containing the challenge records.
+	HostedZoneID string
+	// TTL of challenge records in seconds, 60 if zero.
+	TTL int
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Endpoint defaults to DefaultEndpoint.
+	Endpoint string
+
+	creds credentials
+	mu    sync.Mutex
+}
+
+var _ tlsutil.DNSProvider = (*Provider)(nil)
+
+// New returns a Provider for hostedZoneID using the given static credentials. sessionToken may be empty.
+func New(hostedZoneID, accessKeyID, secretAccessKey, sessionToken string) *Provider {
+	return &Provider{
+		HostedZoneID: hostedZoneID,
+		creds:        credentials{accessKeyID: accessKeyID, secretAccessKey: secretAccessKey, sessionToken: sessionToken},
+	}
+}
+
+// NewFromEnv returns a Provider for hostedZoneID using credentials from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+func NewFromEnv(hostedZoneID string) (*Provider, error) {
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return nil, errors.New("route53: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	return New(hostedZoneID, id, secret, os.Getenv("AWS_SESSION_TOKEN")), nil
+}
+
+type resourceRecordSet struct {
+	Name   string   `xml:"Name"`
+	Type   string   `xml:"Type"`
+	TTL    int      `xml:"TTL,omitempty"`
+	Values []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+type change struct {
+	Action string            `xml:"Action"`
+	RRSet  resourceRecordSet `xml:"ResourceRecordSet"`
+}
+
+type changeRequest struct {
+	XMLName xml.Name `xml:"ChangeResourceRecordSetsRequest"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Changes []change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type changeInfo struct {
+	ID     string `xml:"ChangeInfo>Id"`
+	Status string `xml:"ChangeInfo>Status"`
+}
+
+type listResponse struct {
+	RRSets []resourceRecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+}
+
+type errorResponse struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// Present adds value to the TXT record set named fqdn, and waits for the change to propagate to Route 53's
+// name servers.
+func (p *Provider) Present(ctx context.Context, fqdn, value string) error {
+	return p.modify(ctx, fqdn, func(values []string) []string {
+		for _, v := range values {
+			if v == quote(value) {
+				return values
+			}
+		}
+		return append(values, quote(value))
+	})
+}
+
+// Cleanup removes value from the TXT record set named fqdn, deleting the set once empty.
+func (p *Provider) Cleanup(ctx context.Context, fqdn, value string) error {
+	return p.modify(ctx, fqdn, func(values []string) []string {
+		var out []string
+		for _, v := range values {
+			if v != quote(value) {
+				out = append(out, v)
+			}
+		}
+		return out
+	})
+}
+
+func quote(value string) string {
+	return strconv.Quote(value)
+}
+
+func (p *Provider) modify(ctx context.Context, fqdn string, fn func([]string) []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fqdn = strings.TrimSuffix(fqdn, ".") + "."
+	current, err := p.recordSet(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	ttl := p.TTL
+	if ttl == 0 {
+		ttl = 60
+	}
+	var old []string
+	if current != nil {
+		old = current.Values
+		ttl = current.TTL
+	}
+	values := fn(append([]string(nil), old...))
+
+	var req changeRequest
+	switch {
+	case len(values) > 0:
+		req.Changes = []change{{Action: "UPSERT", RRSet: resourceRecordSet{Name: fqdn, Type: "TXT", TTL: ttl, Values: values}}}
+	case current != nil:
+		req.Changes = []change{{Action: "DELETE", RRSet: *current}}
+	default:
+		return nil
+	}
+	req.Xmlns = xmlns
+
+	body, err := xml.Marshal(req)
+	if err != nil {
+		return err
+	}
+	var info changeInfo
+	if err := p.do(ctx, http.MethodPost, "/hostedzone/"+p.HostedZoneID+"/rrset", nil, append([]byte(xml.Header), body...), &info); err != nil {
+		return err
+	}
+	return p.waitInSync(ctx, info)
+}
+
+// recordSet returns the TXT record set named fqdn, or nil if none.
+func (p *Provider) recordSet(ctx context.Context, fqdn string) (*resourceRecordSet, error) {
+	q := url.Values{"name": {fqdn}, "type": {"TXT"}, "maxitems": {"1"}}
+	var list listResponse
+	if err := p.do(ctx, http.MethodGet, "/hostedzone/"+p.HostedZoneID+"/rrset", q, nil, &list); err != nil {
+		return nil, err
+	}
+	for _, rrs := range list.RRSets {
+		if rrs.Type == "TXT" && strings.EqualFold(rrs.Name, fqdn) {
+			return &rrs, nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *Provider) waitInSync(ctx context.Context, info changeInfo) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for info.Status != "INSYNC" {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		id := strings.TrimPrefix(info.ID, "/change/")
+		if err := p.do(ctx, http.MethodGet, "/change/"+id, nil, nil, &info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Provider) do(ctx context.Context, method, path string, q url.Values, body []byte, out interface{}) error {
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	u := endpoint + "/" + apiVersion + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "text/xml")
+	}
+	sign(req, body, p.creds, signingRegion, "route53", time.Now())
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "route53 request failed")
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e errorResponse
+		if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+			return errors.Errorf("route53: %s: %s", e.Code, e.Message)
+		}
+		return errors.Errorf("route53: request failed, status %s", resp.Status)
+	}
+	return xml.Unmarshal(data, out)
+}
@@ -0,0 +1,90 @@
+package route53
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeRoute53 holds a single zone's TXT record sets.
+type fakeRoute53 struct {
+	sets map[string]resourceRecordSet
+}
+
+func (f *fakeRoute53) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<ErrorResponse><Error><Code>AccessDenied</Code><Message>denied</Message></Error></ErrorResponse>`))
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/2013-04-01/hostedzone/Z1/rrset":
+		var list listResponse
+		if rrs, ok := f.sets[r.URL.Query().Get("name")]; ok {
+			list.RRSets = append(list.RRSets, rrs)
+		}
+		xml.NewEncoder(w).Encode(struct {
+			XMLName xml.Name `xml:"ListResourceRecordSetsResponse"`
+			listResponse
+		}{listResponse: list})
+	case r.Method == http.MethodPost && r.URL.Path == "/2013-04-01/hostedzone/Z1/rrset":
+		body, _ := ioutil.ReadAll(r.Body)
+		var req changeRequest
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, c := range req.Changes {
+			switch c.Action {
+			case "UPSERT":
+				f.sets[c.RRSet.Name] = c.RRSet
+			case "DELETE":
+				delete(f.sets, c.RRSet.Name)
+			}
+		}
+		w.Write([]byte(`<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id><Status>INSYNC</Status></ChangeInfo></ChangeResourceRecordSetsResponse>`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPresentCleanup(t *testing.T) {
+	f := &fakeRoute53{sets: map[string]resourceRecordSet{}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	ctx := context.Background()
+	p := New("Z1", "AKID", "secret", "")
+	p.Endpoint = srv.URL
+
+	const name = "_acme-challenge.example.com."
+	for _, v := range []string{"a", "b"} {
+		if err := p.Present(ctx, name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := f.sets[name].Values; len(got) != 2 || got[0] != `"a"` || got[1] != `"b"` {
+		t.Fatalf("expected both values kept, got %v", got)
+	}
+	if err := p.Cleanup(ctx, name, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.sets[name].Values; len(got) != 1 || got[0] != `"b"` {
+		t.Fatalf("expected only b to remain, got %v", got)
+	}
+	if err := p.Cleanup(ctx, name, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.sets[name]; ok {
+		t.Fatal("expected record set deleted once empty")
+	}
+
+	p.creds.accessKeyID = "WRONG"
+	if err := p.Present(ctx, name, "c"); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("expected AccessDenied, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// LoadDualKeyPair loads an ECDSA and an RSA certificate for the same names, returned ECDSA first. Served together,
+// crypto/tls, SNIRouter and CertStore pick the first a client supports, so modern clients get ECDSA and those
+// supporting only RSA get RSA.
+func LoadDualKeyPair(ecdsaCertFile, ecdsaKeyFile, rsaCertFile, rsaKeyFile string) ([]*tls.Certificate, error) {
+	ec, err := tls.LoadX509KeyPair(ecdsaCertFile, ecdsaKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load ECDSA keypair")
+	}
+	rc, err := tls.LoadX509KeyPair(rsaCertFile, rsaKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load RSA keypair")
+	}
+	return dualKeyPair(&ec, &rc)
+}
+
+// dualKeyPair checks ec and rc are ECDSA and RSA certificates for the same names.
+func dualKeyPair(ec, rc *tls.Certificate) ([]*tls.Certificate, error) {
+	if _, ok := ec.PrivateKey.(*ecdsa.PrivateKey); !ok {
+		return nil, errors.New("ECDSA keypair does not have an ECDSA key")
+	}
+	if _, ok := rc.PrivateKey.(*rsa.PrivateKey); !ok {
+		return nil, errors.New("RSA keypair does not have an RSA key")
+	}
+	ecLeaf, rcLeaf := leafOf(ec), leafOf(rc)
+	if ecLeaf == nil || rcLeaf == nil {
+		return nil, errors.New("failed to parse certificate")
+	}
+	ec.Leaf, rc.Leaf = ecLeaf, rcLeaf
+	if !sameNames(ecLeaf.DNSNames, rcLeaf.DNSNames) {
+		return nil, errors.Errorf("ECDSA certificate names %v differ from RSA certificate names %v", ecLeaf.DNSNames, rcLeaf.DNSNames)
+	}
+	return []*tls.Certificate{ec, rc}, nil
+}
+
+// sameNames reports whether a and b hold the same names, in any order.
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if normalizeServerName(a[i]) != normalizeServerName(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// WithDualKeyPair loads an ECDSA and an RSA certificate for the same names, as LoadDualKeyPair, and appends them to
+// tls.Config's Certificates, ECDSA first.
+func WithDualKeyPair(ecdsaCertFile, ecdsaKeyFile, rsaCertFile, rsaKeyFile string) Option {
+	return func(cfg *tls.Config) error {
+		certs, err := LoadDualKeyPair(ecdsaCertFile, ecdsaKeyFile, rsaCertFile, rsaKeyFile)
+		if err != nil {
+			return err
+		}
+		for _, cert := range certs {
+			cfg.Certificates = append(cfg.Certificates, *cert)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package tlsutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestRSACertificate returns a self signed RSA certificate for cn.
+func newTestRSACertificate(t *testing.T, cn string) *tls.Certificate {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// handshakeKeyAlgorithm returns the public key algorithm of the certificate server presents to client.
+func handshakeKeyAlgorithm(t *testing.T, server, client *tls.Config) x509.PublicKeyAlgorithm {
+	t.Helper()
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- tls.Server(sc, server).Handshake()
+	}()
+	conn := tls.Client(cc, client)
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	return conn.ConnectionState().PeerCertificates[0].PublicKeyAlgorithm
+}
+
+func TestDualKeyPair(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	ec := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	rc := newTestRSACertificate(t, "example.com")
+	ecCert, ecKey := writeKeyPair(t, dir, ec)
+	rsaDir := filepath.Join(dir, "rsa")
+	if err := os.Mkdir(rsaDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	rsaCert, rsaKey := writeKeyPair(t, rsaDir, rc)
+
+	server, err := NewTLSConfig(WithDualKeyPair(ecCert, ecKey, rsaCert, rsaKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTLSConfig(WithDualKeyPair(rsaCert, rsaKey, ecCert, ecKey)); err == nil {
+		t.Fatal("expected error for swapped key types")
+	}
+
+	modern := &tls.Config{ServerName: "example.com", InsecureSkipVerify: true}
+	if alg := handshakeKeyAlgorithm(t, server, modern); alg != x509.ECDSA {
+		t.Fatalf("modern client got %s, expected ECDSA", alg)
+	}
+	old := &tls.Config{
+		ServerName:         "example.com",
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites:       []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	}
+	if alg := handshakeKeyAlgorithm(t, server, old); alg != x509.RSA {
+		t.Fatalf("RSA only client got %s, expected RSA", alg)
+	}
+
+	// The same selection applies to an SNIRouter host.
+	certs, err := LoadDualKeyPair(ecCert, ecKey, rsaCert, rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewSNIRouter()
+	if err := r.SetCertificate("example.com", certs...); err != nil {
+		t.Fatal(err)
+	}
+	routed, err := NewTLSConfig(WithSNIRouter(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alg := handshakeKeyAlgorithm(t, routed, old); alg != x509.RSA {
+		t.Fatalf("RSA only client got %s from router, expected RSA", alg)
+	}
+	if alg := handshakeKeyAlgorithm(t, routed, modern); alg != x509.ECDSA {
+		t.Fatalf("modern client got %s from router, expected ECDSA", alg)
+	}
+}
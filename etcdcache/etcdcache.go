@@ -0,0 +1,115 @@
+// Package etcdcache provides an autocert.Cache backed by etcd, for clustered deployments sharing ACME material.
+package etcdcache
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/renthraysk/tlsutil"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// accountKey is the autocert cache key of the ACME account private key.
+const accountKey = "acme_account+key"
+
+// ErrConflict is returned by Put when another replica has already stored the ACME account key.
+var ErrConflict = errors.New("etcdcache: account key already exists")
+
+// store is the subset of key value operations Cache requires.
+type store interface {
+	get(ctx context.Context, key string) ([]byte, bool, error)
+	put(ctx context.Context, key string, value []byte) error
+	// create stores value only if key does not exist, reporting whether it did so.
+	create(ctx context.Context, key string, value []byte) (bool, error)
+	delete(ctx context.Context, key string) error
+}
+
+// Cache is an autocert.Cache storing entries under a key prefix in etcd. The account key is only ever created, so
+// replicas racing to register can't overwrite each other's, whilst certificates are last writer wins as any
+// replica's valid certificate will do.
+type Cache struct {
+	store  store
+	prefix string
+}
+
+var _ autocert.Cache = (*Cache)(nil)
+
+// New returns a Cache storing entries in kv beneath prefix.
+func New(kv clientv3.KV, prefix string) *Cache {
+	return &Cache{store: etcdStore{kv}, prefix: prefix}
+}
+
+// WithACMEEtcdCache configures ACME to cache certificates in etcd beneath prefix.
+func WithACMEEtcdCache(kv clientv3.KV, prefix string) tlsutil.ACMEOption {
+	return func(mgr *autocert.Manager) error {
+		mgr.Cache = New(kv, prefix)
+		return nil
+	}
+}
+
+// Get returns the data for key, or autocert.ErrCacheMiss.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, ok, err := c.store.get(ctx, c.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, nil
+}
+
+// Put stores data under key. The account key is only stored if absent, otherwise ErrConflict is returned so
+// autocert retries with the winning replica's key.
+func (c *Cache) Put(ctx context.Context, key string, data []byte) error {
+	if key != accountKey {
+		return c.store.put(ctx, c.prefix+key, data)
+	}
+	created, err := c.store.create(ctx, c.prefix+key, data)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Delete removes key.
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	return c.store.delete(ctx, c.prefix+key)
+}
+
+type etcdStore struct {
+	kv clientv3.KV
+}
+
+func (s etcdStore) get(ctx context.Context, key string) ([]byte, bool, error) {
+	resp, err := s.kv.Get(ctx, key)
+	if err != nil || len(resp.Kvs) == 0 {
+		return nil, false, err
+	}
+	return resp.Kvs[0].Value, true, nil
+}
+
+func (s etcdStore) put(ctx context.Context, key string, value []byte) error {
+	_, err := s.kv.Put(ctx, key, string(value))
+	return err
+}
+
+func (s etcdStore) create(ctx context.Context, key string, value []byte) (bool, error) {
+	resp, err := s.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+func (s etcdStore) delete(ctx context.Context, key string) error {
+	_, err := s.kv.Delete(ctx, key)
+	return err
+}
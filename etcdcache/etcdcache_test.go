@@ -0,0 +1,134 @@
+package etcdcache
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// memStore is an in memory store with etcd's create if absent semantics.
+type memStore struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func (s *memStore) get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.m[key]
+	return v, ok, nil
+}
+
+func (s *memStore) put(_ context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.m[key] = value
+	return nil
+}
+
+func (s *memStore) create(_ context.Context, key string, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; ok {
+		return false, nil
+	}
+	s.m[key] = value
+	return true, nil
+}
+
+func (s *memStore) delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+	return nil
+}
+
+func TestRacingReplicas(t *testing.T) {
+	ctx := context.Background()
+	s := &memStore{m: make(map[string][]byte)}
+	replicas := []*Cache{{store: s, prefix: "acme/"}, {store: s, prefix: "acme/"}}
+
+	// Both replicas miss, generate an account key and race to store it.
+	var wg sync.WaitGroup
+	errs := make([]error, len(replicas))
+	for i, c := range replicas {
+		if _, err := c.Get(ctx, accountKey); err != autocert.ErrCacheMiss {
+			t.Fatalf("expected cache miss, got %v", err)
+		}
+		wg.Add(1)
+		go func(i int, c *Cache) {
+			defer wg.Done()
+			errs[i] = c.Put(ctx, accountKey, []byte{byte('a' + i)})
+		}(i, c)
+	}
+	wg.Wait()
+
+	var won int
+	for _, err := range errs {
+		switch err {
+		case nil:
+			won++
+		case ErrConflict:
+		default:
+			t.Fatal(err)
+		}
+	}
+	if won != 1 {
+		t.Fatalf("expected exactly one account key write to succeed, got %d", won)
+	}
+	a, _ := replicas[0].Get(ctx, accountKey)
+	b, _ := replicas[1].Get(ctx, accountKey)
+	if string(a) != string(b) {
+		t.Fatal("replicas disagree on account key")
+	}
+
+	// Certificates from either replica are acceptable, so both writes succeed.
+	for i, c := range replicas {
+		if err := c.Put(ctx, "example.com", []byte{byte('0' + i)}); err != nil {
+			t.Fatalf("replica %d certificate write: %v", i, err)
+		}
+	}
+	if v, _ := replicas[0].Get(ctx, "example.com"); string(v) != "1" {
+		t.Fatalf("expected last writer to win, got %q", v)
+	}
+
+	if err := replicas[0].Delete(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replicas[1].Get(ctx, "example.com"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected cache miss after delete, got %v", err)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	ctx := context.Background()
+	s := &memStore{m: make(map[string][]byte)}
+	prod, staging := &Cache{store: s, prefix: "prod/"}, &Cache{store: s, prefix: "staging/"}
+
+	if err := prod.Put(ctx, "example.com", []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.m["prod/example.com"]; !ok {
+		t.Fatal("expected entry stored beneath prefix")
+	}
+	if _, err := staging.Get(ctx, "example.com"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected other prefix's entries hidden, got %v", err)
+	}
+	// Each deployment registers its own account.
+	if err := prod.Put(ctx, accountKey, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := staging.Put(ctx, accountKey, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	var mgr autocert.Manager
+	if err := WithACMEEtcdCache(nil, "prod/")(&mgr); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := mgr.Cache.(*Cache); !ok || c.prefix != "prod/" {
+		t.Fatalf("expected etcd cache beneath prod/, got %#v", mgr.Cache)
+	}
+}
@@ -0,0 +1,198 @@
+// Package etcdticket shares TLS session ticket keys across a fleet through etcd, with a single elected replica
+// generating keys.
+package etcdticket
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/renthraysk/tlsutil"
+	"github.com/renthraysk/tlsutil/internal/ticketkeys"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	// sessionTTL is the lease TTL, in seconds, of a replica's election session.
+	sessionTTL = 30
+	// checkInterval is how often the leader checks whether rotation is due.
+	checkInterval = 10 * time.Second
+)
+
+// Rotator distributes session ticket keys through etcd. Replicas campaign in an election beneath prefix, the leader
+// generating a new key every interval and storing the ring, encrypted with a shared AEAD, beneath prefix. Every replica
+// watches the stored ring and applies it to its tls.Configs. Writes are fenced on the leader's election key, so a
+// replica that has lost leadership, but not yet noticed, can not overwrite a newer ring.
+type Rotator struct {
+	client   *clientv3.Client
+	prefix   string
+	aead     cipher.AEAD
+	n        int
+	duration time.Duration
+	id       string
+	configs  ticketkeys.Configs
+}
+
+// New returns a Rotator coordinating through client beneath prefix, accepting tickets encrypted with the last n keys
+// and rotating every d. aead must be shared by all replicas, and protects keys stored in etcd.
+func New(client *clientv3.Client, prefix string, aead cipher.AEAD, n int, d time.Duration) (*Rotator, error) {
+	if aead == nil {
+		return nil, errors.New("etcdticket: AEAD required")
+	}
+	if n < 1 || n > 255 {
+		return nil, errors.New("etcdticket: key count must be between 1 and 255")
+	}
+	if d < checkInterval {
+		return nil, errors.Errorf("etcdticket: rotation interval must be at least %v", checkInterval)
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	return &Rotator{
+		client:   client,
+		prefix:   prefix,
+		aead:     aead,
+		n:        n,
+		duration: d,
+		id:       hex.EncodeToString(id[:]),
+	}, nil
+}
+
+// WithSessionTicketKeys configures TLS to use session ticket keys distributed by r.
+func WithSessionTicketKeys(r *Rotator) tlsutil.Option {
+	return func(cfg *tls.Config) error {
+		r.configs.Add(cfg)
+		return nil
+	}
+}
+
+func (r *Rotator) keysKey() string { return r.prefix + "keys" }
+
+// Run campaigns for leadership and distributes keys until ctx is cancelled, or the replica's session expires.
+func (r *Rotator) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	session, err := concurrency.NewSession(r.client, concurrency.WithTTL(sessionTTL), concurrency.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "failed to create etcd session")
+	}
+	defer session.Close()
+	election := concurrency.NewElection(session, r.prefix+"election")
+
+	rev, err := r.reload(ctx)
+	if err != nil {
+		return err
+	}
+	// Watch from just after the loaded revision, so no update is missed.
+	watch := r.client.Watch(ctx, r.keysKey(), clientv3.WithRev(rev+1))
+
+	elected := make(chan error, 1)
+	go func() { elected <- election.Campaign(ctx, r.id) }()
+
+	var check <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-session.Done():
+			return errors.New("etcdticket: session expired")
+
+		case err := <-elected:
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return errors.Wrap(err, "failed to campaign for session ticket key rotation")
+			}
+			ticker := time.NewTicker(checkInterval)
+			defer ticker.Stop()
+			check = ticker.C
+			if err := r.rotateIfDue(ctx, election); err != nil {
+				return err
+			}
+
+		case <-check:
+			if err := r.rotateIfDue(ctx, election); err != nil {
+				return err
+			}
+
+		case resp, ok := <-watch:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return errors.New("etcdticket: watch closed")
+			}
+			if err := resp.Err(); err != nil {
+				return errors.Wrap(err, "failed to watch session ticket keys")
+			}
+			for _, ev := range resp.Events {
+				if ev.IsCreate() || ev.IsModify() {
+					if err := r.apply(ev.Kv.Value); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+}
+
+// rotateIfDue stores a ring with a new key if the current one is older than the rotation interval.
+func (r *Rotator) rotateIfDue(ctx context.Context, election *concurrency.Election) error {
+	current := r.configs.Ring()
+	if len(current.Keys) > 0 && time.Since(current.RotatedAt) < r.duration {
+		return nil
+	}
+	ring, err := current.Rotate(r.n, time.Now())
+	if err != nil {
+		return err
+	}
+	data, err := ticketkeys.Seal(r.aead, []byte(r.keysKey()), ring)
+	if err != nil {
+		return err
+	}
+	// Fence on the election key still being ours, it is deleted when leadership is lost.
+	resp, err := r.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(election.Key()), "=", election.Rev())).
+		Then(clientv3.OpPut(r.keysKey(), string(data))).
+		Commit()
+	if err != nil {
+		return errors.Wrap(err, "failed to store session ticket keys")
+	}
+	if !resp.Succeeded {
+		return errors.New("etcdticket: lost leadership")
+	}
+	r.configs.Apply(ring)
+	return nil
+}
+
+// reload applies the stored ring, returning the revision it was read at.
+func (r *Rotator) reload(ctx context.Context) (int64, error) {
+	resp, err := r.client.Get(ctx, r.keysKey())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to load session ticket keys")
+	}
+	if len(resp.Kvs) > 0 {
+		if err := r.apply(resp.Kvs[0].Value); err != nil {
+			return 0, err
+		}
+	}
+	return resp.Header.Revision, nil
+}
+
+func (r *Rotator) apply(data []byte) error {
+	ring, err := ticketkeys.Open(r.aead, []byte(r.keysKey()), data)
+	if err != nil {
+		return errors.Wrap(err, "etcdticket")
+	}
+	r.configs.Apply(ring)
+	return nil
+}
@@ -0,0 +1,218 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultExpiryInterval is how often an ExpiryMonitor checks by default.
+const defaultExpiryInterval = time.Hour
+
+// ExpiryWarning describes a certificate whose remaining validity has dropped below a threshold.
+type ExpiryWarning struct {
+	// Name is the name the certificate's source was added to the monitor under.
+	Name      string
+	Leaf      *x509.Certificate
+	Remaining time.Duration
+	Threshold time.Duration
+}
+
+// ExpiryOption configures an ExpiryMonitor.
+type ExpiryOption func(*ExpiryMonitor) error
+
+// WithExpiryInterval sets how often the monitor checks certificates, hourly by default.
+func WithExpiryInterval(d time.Duration) ExpiryOption {
+	return func(m *ExpiryMonitor) error {
+		if d <= 0 {
+			return errors.New("expiry check interval must be positive")
+		}
+		m.interval = d
+		return nil
+	}
+}
+
+// WithExpiryClock sets the clock the monitor reads time from and schedules checks with.
+func WithExpiryClock(c Clock) ExpiryOption {
+	return func(m *ExpiryMonitor) error {
+		m.clock = c
+		return nil
+	}
+}
+
+// ExpiryMonitor periodically inspects certificates, calling a function once for each threshold a certificate's
+// remaining validity drops below. For static certificates that nothing renews.
+type ExpiryMonitor struct {
+	thresholds []time.Duration
+	onWarning  func(ExpiryWarning)
+	interval   time.Duration
+	clock      Clock
+
+	mu      sync.Mutex
+	sources map[string]func() []*tls.Certificate
+	// warned is the smallest threshold warned of, by leaf fingerprint.
+	warned map[[sha256.Size]byte]time.Duration
+}
+
+// NewExpiryMonitor returns an ExpiryMonitor calling onWarning as certificates fall below each of thresholds.
+func NewExpiryMonitor(thresholds []time.Duration, onWarning func(ExpiryWarning), opts ...ExpiryOption) (*ExpiryMonitor, error) {
+	if len(thresholds) == 0 {
+		return nil, errors.New("expiry monitor requires at least one threshold")
+	}
+	if onWarning == nil {
+		return nil, errors.New("expiry monitor requires a warning function")
+	}
+	m := &ExpiryMonitor{
+		thresholds: append([]time.Duration(nil), thresholds...),
+		onWarning:  onWarning,
+		interval:   defaultExpiryInterval,
+		clock:      systemClock{},
+		sources:    make(map[string]func() []*tls.Certificate),
+		warned:     make(map[[sha256.Size]byte]time.Duration),
+	}
+	for _, d := range m.thresholds {
+		if d <= 0 {
+			return nil, errors.Errorf("expiry threshold %s must be positive", d)
+		}
+	}
+	// Largest first, so a check warns of the smallest threshold crossed.
+	sort.Slice(m.thresholds, func(i, j int) bool { return m.thresholds[i] > m.thresholds[j] })
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Add monitors the certificates returned by certs under name, replacing any source previously added under it.
+func (m *ExpiryMonitor) Add(name string, certs func() []*tls.Certificate) {
+	m.mu.Lock()
+	m.sources[name] = certs
+	m.mu.Unlock()
+}
+
+// AddConfig monitors the Certificates of cfg.
+func (m *ExpiryMonitor) AddConfig(name string, cfg *tls.Config) {
+	m.Add(name, func() []*tls.Certificate {
+		certs := make([]*tls.Certificate, len(cfg.Certificates))
+		for i := range cfg.Certificates {
+			certs[i] = &cfg.Certificates[i]
+		}
+		return certs
+	})
+}
+
+// AddCertReloader monitors the current certificate of r.
+func (m *ExpiryMonitor) AddCertReloader(name string, r *CertReloader) {
+	m.Add(name, func() []*tls.Certificate { return []*tls.Certificate{r.Certificate()} })
+}
+
+// AddCertStore monitors every certificate in s.
+func (m *ExpiryMonitor) AddCertStore(name string, s *CertStore) {
+	m.Add(name, func() []*tls.Certificate {
+		snap := s.Snapshot()
+		certs := make([]*tls.Certificate, 0, len(snap))
+		for _, cert := range snap {
+			certs = append(certs, cert)
+		}
+		return certs
+	})
+}
+
+// Remove stops monitoring the source added under name.
+func (m *ExpiryMonitor) Remove(name string) {
+	m.mu.Lock()
+	delete(m.sources, name)
+	m.mu.Unlock()
+}
+
+// Check inspects every monitored certificate now, calling the warning function for newly crossed thresholds.
+func (m *ExpiryMonitor) Check() {
+	now := m.clock.Now()
+	var warnings []ExpiryWarning
+
+	m.mu.Lock()
+	seen := make(map[[sha256.Size]byte]bool)
+	for name, certs := range m.sources {
+		for _, cert := range certs() {
+			leaf := leafOf(cert)
+			if leaf == nil {
+				continue
+			}
+			sum := sha256.Sum256(leaf.Raw)
+			seen[sum] = true
+			remaining := leaf.NotAfter.Sub(now)
+			threshold, crossed := m.crossed(remaining)
+			if !crossed {
+				continue
+			}
+			if warned, ok := m.warned[sum]; ok && warned <= threshold {
+				continue
+			}
+			m.warned[sum] = threshold
+			warnings = append(warnings, ExpiryWarning{Name: name, Leaf: leaf, Remaining: remaining, Threshold: threshold})
+		}
+	}
+	// Forget replaced certificates.
+	for sum := range m.warned {
+		if !seen[sum] {
+			delete(m.warned, sum)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, w := range warnings {
+		m.onWarning(w)
+	}
+}
+
+// crossed returns the smallest threshold remaining is below.
+func (m *ExpiryMonitor) crossed(remaining time.Duration) (time.Duration, bool) {
+	for i := len(m.thresholds) - 1; i >= 0; i-- {
+		if remaining < m.thresholds[i] {
+			return m.thresholds[i], true
+		}
+	}
+	return 0, false
+}
+
+// Run checks immediately, then every interval until ctx is done.
+func (m *ExpiryMonitor) Run(ctx context.Context) error {
+	m.Check()
+	t := m.clock.NewTimer(m.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C():
+			m.Check()
+			t.Reset(m.interval)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// leafOf returns the parsed leaf of cert, or nil if it has none.
+func leafOf(cert *tls.Certificate) *x509.Certificate {
+	if cert == nil {
+		return nil
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf
+	}
+	if len(cert.Certificate) == 0 {
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
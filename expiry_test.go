@@ -0,0 +1,86 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestExpiryMonitor(t *testing.T) {
+	now := time.Now()
+	clock := newFakeClock(now)
+	var warnings []ExpiryWarning
+	m, err := NewExpiryMonitor([]time.Duration{24 * time.Hour, 7 * 24 * time.Hour}, func(w ExpiryWarning) {
+		warnings = append(warnings, w)
+	}, WithExpiryClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(10*24*time.Hour))
+	m.AddConfig("static", &tls.Config{Certificates: []tls.Certificate{*cert}})
+
+	m.Check()
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings %v", warnings)
+	}
+
+	clock.Advance(4 * 24 * time.Hour)
+	m.Check()
+	m.Check()
+	if len(warnings) != 1 || warnings[0].Threshold != 7*24*time.Hour || warnings[0].Name != "static" {
+		t.Fatalf("expected one 7 day warning, got %v", warnings)
+	}
+
+	clock.Advance(5*24*time.Hour + time.Hour)
+	m.Check()
+	if len(warnings) != 2 || warnings[1].Threshold != 24*time.Hour {
+		t.Fatalf("expected 1 day warning, got %v", warnings)
+	}
+	if warnings[1].Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0 {
+		t.Fatal("warning for wrong certificate")
+	}
+
+	// A certificate first seen well within both thresholds warns once, of the smallest.
+	s := NewCertStore()
+	short := newTestCertificate(t, "short.example", nil, now, clock.Now().Add(time.Hour))
+	if err := s.SetCertificate("short", short); err != nil {
+		t.Fatal(err)
+	}
+	m.Remove("static")
+	m.AddCertStore("store", s)
+	m.Check()
+	if len(warnings) != 3 || warnings[2].Threshold != 24*time.Hour || warnings[2].Name != "store" {
+		t.Fatalf("expected store warning, got %v", warnings)
+	}
+}
+
+func TestExpiryMonitorRun(t *testing.T) {
+	now := time.Now()
+	clock := newFakeClock(now)
+	warned := make(chan ExpiryWarning, 1)
+	m, err := NewExpiryMonitor([]time.Duration{24 * time.Hour}, func(w ExpiryWarning) { warned <- w },
+		WithExpiryClock(clock), WithExpiryInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(25*time.Hour))
+	m.Add("cert", func() []*tls.Certificate { return []*tls.Certificate{cert} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+	<-clock.armed
+	clock.Advance(2 * time.Hour)
+	if w := <-warned; w.Name != "cert" {
+		t.Fatalf("unexpected warning %v", w)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if _, err := NewExpiryMonitor(nil, func(ExpiryWarning) {}); err == nil {
+		t.Fatal("expected error without thresholds")
+	}
+}
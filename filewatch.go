@@ -0,0 +1,190 @@
+package tlsutil
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// reloadDebounce coalesces bursts of file events, such as a certificate and key being rewritten together.
+const reloadDebounce = 100 * time.Millisecond
+
+// reloadRetries is the number of further attempts made, with doubling delays, when a watched reload fails as the
+// files may still be being written.
+const reloadRetries = 5
+
+// fileWatcher calls reload in the background whenever any of files change, shared by the reloaders.
+type fileWatcher struct {
+	files   []string
+	poll    time.Duration
+	reload  func() error
+	changed func() (bool, error)
+	onError func(error)
+
+	mu   sync.Mutex
+	stop func() error
+	done chan struct{}
+}
+
+// start begins watching, if not already. Directories are watched rather than the files themselves, so files
+// replaced by rename, or Kubernetes' symlink swapping, are followed. If poll is positive, changed is instead polled
+// every poll.
+func (fw *fileWatcher) start() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.stop != nil {
+		return nil
+	}
+	if fw.poll > 0 {
+		quit, done := make(chan struct{}), make(chan struct{})
+		fw.stop = func() error {
+			close(quit)
+			return nil
+		}
+		fw.done = done
+		go fw.pollFiles(quit, done)
+		return nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "failed to create file watcher")
+	}
+	dirs := make(map[string]bool)
+	for _, file := range fw.files {
+		dirs[filepath.Dir(file)] = true
+	}
+	for dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return errors.Wrapf(err, "failed to watch %s", dir)
+		}
+	}
+	fw.stop, fw.done = w.Close, make(chan struct{})
+	go fw.watch(w, fw.done)
+	return nil
+}
+
+// startOnFirstUse returns a function starting fw on its first call, then reloading if the files changed since they
+// were loaded, reporting failures to the error handler. The options reloading for the life of the process watch from
+// their config's first use rather than as they're applied, so a config discarded as a later option failed leaves
+// nothing watching.
+func (fw *fileWatcher) startOnFirstUse() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if err := fw.start(); err != nil {
+				fw.reportError(err)
+				return
+			}
+			if changed, err := fw.changed(); err != nil {
+				fw.reportError(err)
+			} else if changed {
+				if err := fw.reload(); err != nil {
+					fw.reportError(err)
+				}
+			}
+		})
+	}
+}
+
+// pollFiles reloads whenever changed reports the files differ from those last loaded.
+func (fw *fileWatcher) pollFiles(quit, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(fw.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := fw.changed()
+			if err != nil {
+				fw.reportError(err)
+				continue
+			}
+			if changed {
+				if err := fw.reloadRetrying(quit); err != nil {
+					fw.reportError(err)
+				}
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// reloadRetrying reloads, retrying with doubling delays as the files may be part written, until success, retries are
+// exhausted, or quit is closed.
+func (fw *fileWatcher) reloadRetrying(quit chan struct{}) error {
+	err := fw.reload()
+	for attempt := 1; err != nil && attempt <= reloadRetries; attempt++ {
+		t := time.NewTimer(reloadDebounce << attempt)
+		select {
+		case <-t.C:
+		case <-quit:
+			t.Stop()
+			return nil
+		}
+		err = fw.reload()
+	}
+	return err
+}
+
+func (fw *fileWatcher) watch(w *fsnotify.Watcher, done chan struct{}) {
+	defer close(done)
+	names := make(map[string]bool)
+	for _, file := range fw.files {
+		names[filepath.Clean(file)] = true
+	}
+	var debounce <-chan time.Time
+	var attempt int
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			// Kubernetes atomically repoints the ..data symlink of a mounted volume.
+			if names[filepath.Clean(ev.Name)] || filepath.Base(ev.Name) == "..data" {
+				debounce, attempt = time.After(reloadDebounce), 0
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			fw.reportError(errors.Wrap(err, "file watcher"))
+		case <-debounce:
+			debounce = nil
+			if err := fw.reload(); err != nil {
+				// Files written in place, rather than renamed, may be seen part written.
+				if attempt < reloadRetries {
+					attempt++
+					debounce = time.After(reloadDebounce << attempt)
+					continue
+				}
+				fw.reportError(err)
+			}
+		}
+	}
+}
+
+func (fw *fileWatcher) reportError(err error) {
+	if fw.onError != nil {
+		fw.onError(err)
+	}
+}
+
+// close stops watching.
+func (fw *fileWatcher) close() error {
+	fw.mu.Lock()
+	stop, done := fw.stop, fw.done
+	fw.stop, fw.done = nil, nil
+	fw.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	err := stop()
+	<-done
+	return err
+}
@@ -0,0 +1,61 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+)
+
+func TestPinnedServerCertificates(t *testing.T) {
+	now := time.Now()
+	device := newTestCertificate(t, "device.local", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	other := newTestCertificate(t, "device.local", nil, now.Add(-time.Hour), now.Add(time.Hour))
+
+	server, err := NewTLSConfig(WithKeyPairPEM(encodeKeyPair(t, device)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewTLSConfig(WithPinnedServerCertificates(Fingerprint(device.Leaf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ServerName = "device.local"
+	if alg := handshakeKeyAlgorithm(t, server, client); alg != x509.ECDSA {
+		t.Fatalf("unexpected key algorithm %s", alg)
+	}
+
+	verify := client.VerifyConnection
+	if err := verify(tls.ConnectionState{PeerCertificates: []*x509.Certificate{other.Leaf}}); err != ErrFingerprintMismatch {
+		t.Fatalf("expected ErrFingerprintMismatch, got %v", err)
+	}
+	expired := newTestCertificate(t, "device.local", nil, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	clientOnly := issueTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil)
+	pinned, err := NewTLSConfig(WithPinnedServerCertificates(Fingerprint(expired.Leaf), Fingerprint(clientOnly.Leaf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cert := range []*tls.Certificate{expired, clientOnly} {
+		if err := pinned.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert.Leaf}}); err == nil {
+			t.Errorf("%s: expected pinned certificate to be rejected", cert.Leaf.Subject)
+		}
+	}
+
+	srv, err := NewTLSConfig(WithPinnedClientCertificates(Fingerprint(clientOnly.Leaf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.ClientAuth != tls.RequireAnyClientCert {
+		t.Fatal("expected client certificates to be required")
+	}
+	if err := srv.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientOnly.Leaf}}); err != nil {
+		t.Fatal(err)
+	}
+}
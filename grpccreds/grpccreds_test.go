@@ -0,0 +1,34 @@
+package grpccreds
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/renthraysk/tlsutil"
+)
+
+func TestCredentials(t *testing.T) {
+	if _, err := NewServerCredentials(); err == nil {
+		t.Fatal("expected error without a server certificate")
+	}
+	if _, err := NewServerCredentials(tlsutil.WithKeyPair("missing.crt", "missing.key")); err == nil {
+		t.Fatal("expected option error")
+	}
+	creds, err := NewServerCredentials(func(cfg *tls.Config) error {
+		cfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return nil, nil }
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := creds.Info().SecurityProtocol; p != "tls" {
+		t.Fatalf("unexpected security protocol %q", p)
+	}
+	client, err := NewClientCredentials(tlsutil.WithServerName("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := client.Info().ServerName; name != "example.com" {
+		t.Fatalf("unexpected server name %q", name)
+	}
+}
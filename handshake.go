@@ -0,0 +1,371 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// handshakeTimeout is how long a connection is given to complete its handshake by default.
+	handshakeTimeout = 10 * time.Second
+	// acceptRetryMin and acceptRetryMax bound the doubling delay between retries of temporary Accept failures, as
+	// net/http's Server does.
+	acceptRetryMin = 5 * time.Millisecond
+	acceptRetryMax = time.Second
+)
+
+// HandshakeFailure classifies why a handshake failed.
+type HandshakeFailure int
+
+const (
+	// HandshakeFailureOther is any failure not otherwise classified, such as the client disconnecting.
+	HandshakeFailureOther HandshakeFailure = iota
+	// HandshakeFailureUnknownServerName is a client asking for a server name there's no certificate for.
+	HandshakeFailureUnknownServerName
+	// HandshakeFailureNoSharedCipher is a client supporting none of the cipher suites, curves or signature
+	// algorithms configured.
+	HandshakeFailureNoSharedCipher
+	// HandshakeFailureClientCertificate is a client certificate missing or rejected by verification.
+	HandshakeFailureClientCertificate
+	// HandshakeFailureLegacyVersion is a client offering only TLS versions below the minimum configured.
+	HandshakeFailureLegacyVersion
+	// HandshakeFailureNotTLS is a client speaking something other than TLS, such as plain http.
+	HandshakeFailureNotTLS
+	// HandshakeFailureTimeout is a handshake not completed within the handshake timeout.
+	HandshakeFailureTimeout
+	// HandshakeFailureOverloaded is a connection closed without a handshake as the handshake limit was reached, and
+	// the queue was full or it waited too long.
+	HandshakeFailureOverloaded
+)
+
+// ErrHandshakeOverloaded is the error of connections closed by a handshake listener's limit.
+var ErrHandshakeOverloaded = errors.New("tlsutil: too many handshakes in flight")
+
+var handshakeFailureNames = [...]string{
+	HandshakeFailureOther:             "other",
+	HandshakeFailureUnknownServerName: "unknown server name",
+	HandshakeFailureNoSharedCipher:    "no shared cipher",
+	HandshakeFailureClientCertificate: "client certificate",
+	HandshakeFailureLegacyVersion:     "legacy version",
+	HandshakeFailureNotTLS:            "not TLS",
+	HandshakeFailureTimeout:           "timeout",
+	HandshakeFailureOverloaded:        "overloaded",
+}
+
+func (f HandshakeFailure) String() string {
+	if f < 0 || int(f) >= len(handshakeFailureNames) {
+		return "unknown"
+	}
+	return handshakeFailureNames[f]
+}
+
+// HandshakeError is a failed handshake of a connection accepted by a handshake listener.
+type HandshakeError struct {
+	Failure    HandshakeFailure
+	RemoteAddr net.Addr
+	// ServerName is the server name the client asked for, if it got as far as sending one.
+	ServerName string
+	Err        error
+}
+
+func (e *HandshakeError) Error() string {
+	return "TLS handshake from " + e.RemoteAddr.String() + " failed (" + e.Failure.String() + "): " + e.Err.Error()
+}
+
+// Cause returns the error the handshake failed with.
+func (e *HandshakeError) Cause() error { return e.Err }
+
+// Unwrap returns the error the handshake failed with.
+func (e *HandshakeError) Unwrap() error { return e.Err }
+
+// peerRejections are the errors of this package's verifiers rejecting a peer's certificate.
+var peerRejections = []error{
+	ErrPeerNotAllowed, ErrPeerDenied, ErrWeakCredential, ErrCertificateRevoked, ErrFingerprintMismatch,
+	ErrPinMismatch, ErrSPIFFEIDNotAllowed,
+}
+
+// classifyHandshakeError returns the HandshakeFailure err is, from a server's handshake.
+func classifyHandshakeError(err error) HandshakeFailure {
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	switch {
+	case errors.Is(err, ErrHandshakeOverloaded):
+		return HandshakeFailureOverloaded
+	case errors.As(err, &netErr) && netErr.Timeout(), errors.Is(err, context.DeadlineExceeded):
+		return HandshakeFailureTimeout
+	case errors.As(err, &recordErr):
+		return HandshakeFailureNotTLS
+	case errors.Is(err, ErrUnknownServerName), errors.Is(err, ErrServerNameRejected):
+		return HandshakeFailureUnknownServerName
+	case errors.As(err, &verifyErr):
+		return HandshakeFailureClientCertificate
+	}
+	for _, rejection := range peerRejections {
+		if errors.Is(err, rejection) {
+			return HandshakeFailureClientCertificate
+		}
+	}
+	// crypto/tls's own errors are only distinguishable by message.
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "no certificates configured"), strings.Contains(msg, "no certificate for server name"):
+		return HandshakeFailureUnknownServerName
+	case strings.Contains(msg, "no cipher suite supported by both"), strings.Contains(msg, "no ECDHE curve supported by both"),
+		strings.Contains(msg, "no mutually supported"), strings.Contains(msg, "no supported signature algorithm"):
+		return HandshakeFailureNoSharedCipher
+	case strings.Contains(msg, "client didn't provide a certificate"), strings.Contains(msg, "failed to verify certificate"):
+		return HandshakeFailureClientCertificate
+	case strings.Contains(msg, "unsupported versions"), strings.Contains(msg, "unsupported SSLv2"):
+		return HandshakeFailureLegacyVersion
+	}
+	return HandshakeFailureOther
+}
+
+// HandshakeListenerOption configures a handshake listener.
+type HandshakeListenerOption func(*handshakeListener) error
+
+// WithHandshakeErrorHandler sets a function called with every failed handshake, such as to log or count them.
+func WithHandshakeErrorHandler(fn func(*HandshakeError)) HandshakeListenerOption {
+	return func(l *handshakeListener) error {
+		l.onError = fn
+		return nil
+	}
+}
+
+// WithHandshakeTimeout sets how long a connection is given to complete its handshake, 10 seconds by default.
+func WithHandshakeTimeout(d time.Duration) HandshakeListenerOption {
+	return func(l *handshakeListener) error {
+		if d <= 0 {
+			return errors.New("handshake timeout must be positive")
+		}
+		l.timeout = d
+		return nil
+	}
+}
+
+// WithHandshakeLimit bounds the handshakes in flight to max, so a burst of new connections, such as after a load
+// balancer fails over, can't starve established connections of CPU. Up to queue connections wait for a handshake to
+// complete, for up to wait each, further connections are closed at once. A max around the number of CPUs suits most
+// servers.
+func WithHandshakeLimit(max, queue int, wait time.Duration) HandshakeListenerOption {
+	return func(l *handshakeListener) error {
+		if max <= 0 {
+			return errors.New("handshake limit must be positive")
+		}
+		if queue < 0 {
+			return errors.New("handshake queue must not be negative")
+		}
+		if queue > 0 && wait <= 0 {
+			return errors.New("handshake queue wait must be positive")
+		}
+		l.slots = make(chan struct{}, max)
+		l.queue, l.wait = int32(queue), wait
+		return nil
+	}
+}
+
+// handshakeListener is a TLS listener only returning connections having completed their handshakes.
+type handshakeListener struct {
+	ln      net.Listener
+	cfg     *tls.Config
+	onError func(*HandshakeError)
+	timeout time.Duration
+
+	// slots holds a value per handshake in flight, if limited, with up to queue more waiting up to wait for one.
+	slots   chan struct{}
+	queue   int32
+	wait    time.Duration
+	waiting int32
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	acceptErr error
+}
+
+// NewHandshakeListener returns a TLS listener of cfg on ln that completes handshakes before Accept returns their
+// connections, reporting those failing, classified, to the error handler. Handshakes run concurrently, so a slow
+// client doesn't hold up others, and within the handshake timeout.
+func NewHandshakeListener(ln net.Listener, cfg *tls.Config, opts ...HandshakeListenerOption) (net.Listener, error) {
+	l := &handshakeListener{
+		ln:      ln,
+		cfg:     cfg,
+		timeout: handshakeTimeout,
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(l); err != nil {
+			return nil, err
+		}
+	}
+	go l.serve()
+	return l, nil
+}
+
+// serve accepts connections, handshaking each, until the listener fails or is closed. Temporary failures, such as
+// running out of file descriptors, are retried with a doubling delay.
+func (l *handshakeListener) serve() {
+	var delay time.Duration
+	for {
+		c, err := l.ln.Accept()
+		if err != nil {
+			if !temporaryAcceptError(err) {
+				l.close(err)
+				return
+			}
+			if delay *= 2; delay == 0 {
+				delay = acceptRetryMin
+			} else if delay > acceptRetryMax {
+				delay = acceptRetryMax
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-l.done:
+				timer.Stop()
+				return
+			}
+			continue
+		}
+		delay = 0
+		go l.handshake(c)
+	}
+}
+
+// temporaryAcceptError reports whether err, from Accept, is worth retrying: a timeout, running out of file
+// descriptors, or a connection aborted before it was accepted.
+func temporaryAcceptError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) || errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// handshake completes the handshake of conn, within the handshake limit if any, passing it to Accept on success.
+func (l *handshakeListener) handshake(conn net.Conn) {
+	if l.slots != nil && !l.acquire() {
+		l.overloaded(conn)
+		return
+	}
+	c := tls.Server(conn, l.cfg)
+	err := l.handshakeContext(c)
+	if l.slots != nil {
+		// Released before Accept, so a slow Accept doesn't hold up handshakes.
+		<-l.slots
+	}
+	if err != nil {
+		l.fail(c, err)
+		return
+	}
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+// handshakeContext completes the handshake of c within the handshake timeout, or until the listener is closed.
+func (l *handshakeListener) handshakeContext(c *tls.Conn) error {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-l.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return c.HandshakeContext(ctx)
+}
+
+// acquire takes a handshake slot, waiting in the queue for one if there's room, reporting whether it did.
+func (l *handshakeListener) acquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if atomic.AddInt32(&l.waiting, 1) > l.queue {
+		atomic.AddInt32(&l.waiting, -1)
+		return false
+	}
+	defer atomic.AddInt32(&l.waiting, -1)
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-l.done:
+	}
+	return false
+}
+
+// overloaded closes conn, rejected by the handshake limit, then reports it. Without reading from conn, so the
+// rejection isn't held up by a client that's slow to send a PROXY protocol header.
+func (l *handshakeListener) overloaded(conn net.Conn) {
+	raw := conn
+	if pc, ok := conn.(*ProxyConn); ok {
+		raw = pc.Conn
+	}
+	addr := raw.RemoteAddr()
+	conn.Close()
+	if l.onError != nil {
+		l.onError(&HandshakeError{
+			Failure:    HandshakeFailureOverloaded,
+			RemoteAddr: addr,
+			Err:        ErrHandshakeOverloaded,
+		})
+	}
+}
+
+// fail reports the failed handshake of c, closing it.
+func (l *handshakeListener) fail(c *tls.Conn, err error) {
+	if l.onError != nil {
+		l.onError(&HandshakeError{
+			Failure:    classifyHandshakeError(err),
+			RemoteAddr: c.RemoteAddr(),
+			ServerName: c.ConnectionState().ServerName,
+			Err:        err,
+		})
+	}
+	c.Close()
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, l.acceptErr
+	}
+}
+
+func (l *handshakeListener) Close() error {
+	return l.close(net.ErrClosed)
+}
+
+// close closes the listener, Accept returning acceptErr from then on.
+func (l *handshakeListener) close(acceptErr error) error {
+	var err error
+	l.closeOnce.Do(func() {
+		l.acceptErr = acceptErr
+		err = l.ln.Close()
+		close(l.done)
+	})
+	return err
+}
+
+func (l *handshakeListener) Addr() net.Addr { return l.ln.Addr() }
@@ -0,0 +1,286 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestHandshakeListener(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	cert := newTestCertificate(t, "example.com", ca, now.Add(-time.Hour), now.Add(time.Hour))
+	stranger := newTestCertificate(t, "stranger", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Leaf)
+	server := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "example.com" {
+				return nil, ErrUnknownServerName
+			}
+			return cert, nil
+		},
+		ClientAuth:             tls.VerifyClientCertIfGiven,
+		ClientCAs:              clientCAs,
+		MinVersion:             tls.VersionTLS12,
+		SessionTicketsDisabled: true,
+	}
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := make(chan *HandshakeError, 1)
+	ln, err := NewHandshakeListener(tcp, server, WithHandshakeTimeout(time.Second),
+		WithHandshakeErrorHandler(func(err *HandshakeError) { failures <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+	dial := func(cfg *tls.Config) {
+		if c, err := tls.Dial("tcp", tcp.Addr().String(), cfg); err == nil {
+			c.Close()
+		}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		client  *tls.Config
+		failure HandshakeFailure
+	}{
+		{"unknown server name", &tls.Config{ServerName: "other.example", InsecureSkipVerify: true}, HandshakeFailureUnknownServerName},
+		{"legacy version", &tls.Config{ServerName: "example.com", InsecureSkipVerify: true, MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11}, HandshakeFailureLegacyVersion},
+		{"no shared cipher", &tls.Config{ServerName: "example.com", InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA}}, HandshakeFailureNoSharedCipher},
+		{"client certificate", &tls.Config{ServerName: "example.com", InsecureSkipVerify: true,
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return stranger, nil }},
+			HandshakeFailureClientCertificate},
+	} {
+		dial(tt.client)
+		select {
+		case err := <-failures:
+			if err.Failure != tt.failure {
+				t.Errorf("%s: classified as %s: %v", tt.name, err.Failure, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no failure reported", tt.name)
+		}
+	}
+
+	raw, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	if err := <-failures; err.Failure != HandshakeFailureNotTLS {
+		t.Errorf("plain http classified as %s: %v", err.Failure, err)
+	}
+	raw.Close()
+
+	// A silent client times out without holding up others.
+	silent, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	c, err := tls.Dial("tcp", tcp.Addr().String(), &tls.Config{ServerName: "example.com", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("good connection not accepted")
+	}
+	if err := <-failures; err.Failure != HandshakeFailureTimeout {
+		t.Errorf("silent client classified as %s: %v", err.Failure, err)
+	}
+
+	ln.Close()
+	if _, ok := <-accepted; ok {
+		t.Fatal("accepted after close")
+	}
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestHandshakeLimit(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := make(chan *HandshakeError, 4)
+	ln, err := NewHandshakeListener(tcp, &tls.Config{Certificates: []tls.Certificate{*cert}},
+		WithHandshakeTimeout(2*time.Second), WithHandshakeLimit(1, 1, 200*time.Millisecond),
+		WithHandshakeErrorHandler(func(err *HandshakeError) { failures <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	// A silent client holds the only slot, a second waits in the queue until giving up, a third finds the queue
+	// full.
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", tcp.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		time.Sleep(50 * time.Millisecond)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-failures:
+			if err.Failure != HandshakeFailureOverloaded {
+				t.Fatalf("expected overload, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected queued connections rejected")
+		}
+	}
+
+	// Once the slot is released by the silent client's timeout, handshakes proceed.
+	if err := (<-failures).Failure; err != HandshakeFailureTimeout {
+		t.Fatalf("expected silent client timed out, got %s", err)
+	}
+	c, err := tls.Dial("tcp", tcp.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	if _, err := NewHandshakeListener(tcp, &tls.Config{}, WithHandshakeLimit(0, 0, 0)); err == nil {
+		t.Fatal("expected error for zero limit")
+	}
+}
+
+func TestHandshakeLimitProxyProtocol(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := NewProxyProtocolListener(tcp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := make(chan *HandshakeError, 2)
+	ln, err := NewHandshakeListener(proxy, &tls.Config{Certificates: []tls.Certificate{*cert}},
+		WithHandshakeLimit(1, 0, 0), WithHandshakeErrorHandler(func(err *HandshakeError) { failures <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The first client holds the only slot, neither sending a header. The second is rejected without waiting on its
+	// header.
+	first, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	time.Sleep(50 * time.Millisecond)
+	second, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	select {
+	case err := <-failures:
+		if err.Failure != HandshakeFailureOverloaded {
+			t.Fatalf("expected overload, got %v", err)
+		}
+		if err.RemoteAddr.String() != second.LocalAddr().String() {
+			t.Fatalf("expected rejection from %s, got %s", second.LocalAddr(), err.RemoteAddr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rejection waited on the PROXY protocol header")
+	}
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected rejected connection closed, got %v", err)
+	}
+}
+
+// flakyListener fails its first failures Accepts with err.
+type flakyListener struct {
+	net.Listener
+	failures int32
+	err      error
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&l.failures, -1) >= 0 {
+		return nil, l.err
+	}
+	return l.Listener.Accept()
+}
+
+func TestHandshakeListenerAcceptRetry(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	ln, err := NewHandshakeListener(&flakyListener{Listener: tcp, failures: 3, err: emfile},
+		&tls.Config{Certificates: []tls.Certificate{*cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := tls.Dial("tcp", tcp.Addr().String(), &tls.Config{InsecureSkipVerify: true}); err == nil {
+			defer c.Close()
+			c.Read(make([]byte, 1))
+		}
+	}()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("expected temporary failures retried, got %v", err)
+	}
+	c.Close()
+
+	// Other failures stop the listener.
+	failure := errors.New("listener broken")
+	broken, err := NewHandshakeListener(&flakyListener{Listener: tcp, failures: 1, err: failure}, &tls.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broken.Accept(); err != failure {
+		t.Fatalf("expected listener failure, got %v", err)
+	}
+}
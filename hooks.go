@@ -0,0 +1,87 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+)
+
+// ACMEHooks are callbacks fired over the lifecycle of ACME certificates. Any may be nil. Hooks are called
+// synchronously, so should not block.
+type ACMEHooks struct {
+	// OnIssued is called when a certificate for host is first obtained, or loaded from cache.
+	OnIssued func(host string, leaf *x509.Certificate)
+	// OnRenewed is called when the certificate for host is replaced by a renewal.
+	OnRenewed func(host string, leaf *x509.Certificate)
+	// OnFailed is called when obtaining a certificate for host fails.
+	OnFailed func(host string, err error)
+}
+
+func (h *ACMEHooks) issued(host string, leaf *x509.Certificate) {
+	if h != nil && h.OnIssued != nil {
+		h.OnIssued(host, leaf)
+	}
+}
+
+func (h *ACMEHooks) renewed(host string, leaf *x509.Certificate) {
+	if h != nil && h.OnRenewed != nil {
+		h.OnRenewed(host, leaf)
+	}
+}
+
+func (h *ACMEHooks) failed(host string, err error) {
+	if h != nil && h.OnFailed != nil {
+		h.OnFailed(host, err)
+	}
+}
+
+// WithACMEHooks is WithACME, additionally firing hooks as certificates are issued, renewed, or fail. As autocert
+// doesn't expose its lifecycle, events are inferred from the certificates it serves: the first served for a host is
+// issued, a change of serial is a renewal, and any error a failure, including host policy rejections.
+func WithACMEHooks(hooks *ACMEHooks, opts ...ACMEOption) Option {
+	return func(cfg *tls.Config) error {
+		mgr, err := newACMEManager(opts...)
+		if err != nil {
+			return err
+		}
+		return setGetCertificate(cfg, observeCertificates(hooks, mgr.GetCertificate))
+	}
+}
+
+// observeCertificates wraps getCertificate firing hooks on changes to the certificate served for each host.
+func observeCertificates(hooks *ACMEHooks, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	var mu sync.Mutex
+	serials := make(map[string]string)
+
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		host := normalizeServerName(hello.ServerName)
+		cert, err := getCertificate(hello)
+		if host == "" {
+			return cert, err
+		}
+		if err != nil {
+			hooks.failed(host, err)
+			return nil, err
+		}
+		leaf := cert.Leaf
+		if leaf == nil {
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return cert, nil
+			}
+		}
+
+		serial := leaf.SerialNumber.String()
+		mu.Lock()
+		prev, seen := serials[host]
+		serials[host] = serial
+		mu.Unlock()
+
+		switch {
+		case !seen:
+			hooks.issued(host, leaf)
+		case prev != serial:
+			hooks.renewed(host, leaf)
+		}
+		return cert, nil
+	}
+}
@@ -0,0 +1,46 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestObserveCertificates(t *testing.T) {
+	now := time.Now()
+	first := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	second := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(2*time.Hour))
+
+	var events []string
+	hooks := &ACMEHooks{
+		OnIssued:  func(host string, _ *x509.Certificate) { events = append(events, "issued "+host) },
+		OnRenewed: func(host string, _ *x509.Certificate) { events = append(events, "renewed "+host) },
+		OnFailed:  func(host string, _ error) { events = append(events, "failed "+host) },
+	}
+	serve := first
+	var fail error
+	getCertificate := observeCertificates(hooks, func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return serve, fail
+	})
+
+	hello := &tls.ClientHelloInfo{ServerName: "example.com"}
+	getCertificate(hello)
+	getCertificate(hello)
+	serve = second
+	getCertificate(hello)
+	fail = errors.New("rate limited")
+	getCertificate(hello)
+
+	want := []string{"issued example.com", "renewed example.com", "failed example.com"}
+	if len(events) != len(want) {
+		t.Fatalf("expected %v, got %v", want, events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, events)
+		}
+	}
+}
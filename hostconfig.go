@@ -0,0 +1,145 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// HostConfigs serves per host configurations via GetConfigForClient, each the tls.Config it is added to with host
+// specific Options applied. Hosts are exact or wildcard, as for SNIRouter. Handshakes for other hosts use the base
+// configuration unchanged.
+//
+// Host configurations are materialized on their first handshake, so take the session ticket keys the base had then.
+// Include WithKeyRotator in a host's options to keep its keys current.
+type HostConfigs struct {
+	mu      sync.Mutex
+	base    *tls.Config
+	opts    map[string][]Option
+	configs map[string]*tls.Config
+}
+
+// NewHostConfigs returns a HostConfigs without any hosts.
+func NewHostConfigs() *HostConfigs {
+	return &HostConfigs{opts: make(map[string][]Option), configs: make(map[string]*tls.Config)}
+}
+
+// WithHostConfigs configures TLS to apply the per host options of h, with the tls.Config as their base.
+func WithHostConfigs(h *HostConfigs) Option {
+	return func(cfg *tls.Config) error {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.base != nil {
+			return errors.New("host configs already in use by another tls.Config")
+		}
+		if err := setGetConfigForClient(cfg, h.getConfigForClient); err != nil {
+			return err
+		}
+		h.base = cfg
+		return nil
+	}
+}
+
+// WithHostOptions configures TLS to apply opts to handshakes for host.
+func WithHostOptions(host string, opts ...Option) Option {
+	return func(cfg *tls.Config) error {
+		h := NewHostConfigs()
+		if err := h.Set(host, opts...); err != nil {
+			return err
+		}
+		return WithHostConfigs(h)(cfg)
+	}
+}
+
+// Set sets the options applied for host, replacing any previously set. If the base is known, the options are
+// checked by applying them to a clone of it.
+func (h *HostConfigs) Set(host string, opts ...Option) error {
+	name, err := normalizeHost(host)
+	if err != nil {
+		return err
+	}
+	opts = append([]Option(nil), opts...)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.base != nil {
+		if _, err := h.materialize(opts); err != nil {
+			return errors.Wrapf(err, "invalid options for host %q", host)
+		}
+	}
+	h.opts[name] = opts
+	delete(h.configs, name)
+	return nil
+}
+
+// SetClientAuth sets host's client authentication to auth, verifying client certificates against pool only, for
+// multi-tenant mutual TLS. It replaces any options previously set for host.
+func (h *HostConfigs) SetClientAuth(host string, auth tls.ClientAuthType, pool *x509.CertPool, opts ...Option) error {
+	return h.Set(host, append([]Option{WithClientAuth(auth), WithClientCAPool(pool)}, opts...)...)
+}
+
+// Remove removes the options for host, reporting whether there were any.
+func (h *HostConfigs) Remove(host string) bool {
+	name, err := normalizeHost(host)
+	if err != nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.opts[name]; !ok {
+		return false
+	}
+	delete(h.opts, name)
+	delete(h.configs, name)
+	return true
+}
+
+// materialize returns a clone of the base with opts applied.
+func (h *HostConfigs) materialize(opts []Option) (*tls.Config, error) {
+	cfg := h.base.Clone()
+	cfg.GetConfigForClient = nil
+	// Clone pools, so options appending to them, such as WithClientCAs, don't leak into the base or other hosts.
+	if cfg.ClientCAs != nil {
+		cfg.ClientCAs = cfg.ClientCAs.Clone()
+	}
+	if cfg.RootCAs != nil {
+		cfg.RootCAs = cfg.RootCAs.Clone()
+	}
+	if err := Apply(cfg, opts...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// lookup returns the host key matching name, preferring an exact host to a wildcard.
+func (h *HostConfigs) lookup(name string) (string, bool) {
+	if _, ok := h.opts[name]; ok {
+		return name, true
+	}
+	if w := wildcardOf(name); w != "" {
+		if _, ok := h.opts[w]; ok {
+			return w, true
+		}
+	}
+	return "", false
+}
+
+func (h *HostConfigs) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	name := normalizeServerName(hello.ServerName)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key, ok := h.lookup(name)
+	if !ok {
+		return nil, nil
+	}
+	if cfg, ok := h.configs[key]; ok {
+		return cfg, nil
+	}
+	cfg, err := h.materialize(h.opts[key])
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to configure host %q", key)
+	}
+	h.configs[key] = cfg
+	return cfg, nil
+}
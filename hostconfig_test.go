@@ -0,0 +1,143 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestHostConfigs(t *testing.T) {
+	h := NewHostConfigs()
+	if err := h.Set("admin.example.com", WithMinVersion(tls.VersionTLS13), WithClientAuth(tls.RequireAndVerifyClientCert)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Set("*.legacy.example.com", WithMinVersion(tls.VersionTLS10)); err != nil {
+		t.Fatal(err)
+	}
+	// Options after WithHostConfigs are still part of the base.
+	cfg, err := NewTLSConfig(WithHostConfigs(h), WithNextProtos("h2", "http/1.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "Admin.Example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.MinVersion != tls.VersionTLS13 || got.ClientAuth != tls.RequireAndVerifyClientCert || len(got.NextProtos) != 2 {
+		t.Fatalf("host options not applied: %+v", got)
+	}
+	if got.GetConfigForClient != nil {
+		t.Fatal("host config must not have GetConfigForClient")
+	}
+	again, _ := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "admin.example.com"})
+	if again != got {
+		t.Fatal("expected materialized config to be reused")
+	}
+	if got, _ := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "old.legacy.example.com"}); got == nil || got.MinVersion != tls.VersionTLS10 {
+		t.Fatal("wildcard host options not applied")
+	}
+	if got, _ := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "www.example.com"}); got != nil {
+		t.Fatal("expected base config for other hosts")
+	}
+
+	// Invalid options are rejected once the base is known.
+	if err := h.Set("bad.example.com", WithMaxVersion(tls.VersionTLS12), WithMinVersion(tls.VersionTLS13)); err == nil {
+		t.Fatal("expected conflicting versions to be rejected")
+	}
+	if err := h.Set("admin.example.com", WithMinVersion(tls.VersionTLS12)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "admin.example.com"}); got.MinVersion != tls.VersionTLS12 {
+		t.Fatal("replaced host options not applied")
+	}
+	if !h.Remove("admin.example.com") {
+		t.Fatal("expected host to be removed")
+	}
+	if got, _ := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "admin.example.com"}); got != nil {
+		t.Fatal("removed host still configured")
+	}
+}
+
+func TestHostConfigsClientAuth(t *testing.T) {
+	now := time.Now()
+	caA := newTestCertificate(t, "tenant a", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	caB := newTestCertificate(t, "tenant b", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	poolA := x509.NewCertPool()
+	poolA.AddCert(caA.Leaf)
+
+	h := NewHostConfigs()
+	if err := h.SetClientAuth("a.example.com", tls.RequireAndVerifyClientCert, poolA); err != nil {
+		t.Fatal(err)
+	}
+	pemB := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caB.Certificate[0]})
+	if err := h.Set("b.example.com", WithClientAuth(tls.VerifyClientCertIfGiven), WithClientCAs(pemB)); err != nil {
+		t.Fatal(err)
+	}
+	base := x509.NewCertPool()
+	cfg, err := NewTLSConfig(WithClientCAPool(base), WithHostConfigs(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientA := newTestCertificate(t, "client a", caA, now.Add(-time.Hour), now.Add(time.Hour))
+	verifies := func(host string, client *tls.Certificate) bool {
+		got, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: host})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Leaf.Verify(x509.VerifyOptions{Roots: got.ClientCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		return err == nil
+	}
+	if !verifies("a.example.com", clientA) {
+		t.Fatal("tenant a client rejected by tenant a")
+	}
+	if verifies("b.example.com", clientA) {
+		t.Fatal("tenant a client accepted by tenant b")
+	}
+	got, _ := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "a.example.com"})
+	if got.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatal("tenant client auth not applied")
+	}
+	if !base.Equal(x509.NewCertPool()) {
+		t.Fatal("host options modified the base pool")
+	}
+}
+
+func TestHostConfigsNextProtos(t *testing.T) {
+	h := NewHostConfigs()
+	if err := h.Set("legacy.example.com", WithoutNextProtos("h2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Set("challenge.example.com", WithNextProtos(acmeTLSALPNProto)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Set("h2.example.com", WithNextProtosOnly("h2")); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(WithNextProtos("h2", "http/1.1"), WithHostConfigs(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string][]string{
+		"legacy.example.com":    {"http/1.1"},
+		"challenge.example.com": {acmeTLSALPNProto},
+		"h2.example.com":        {"h2"},
+	} {
+		got, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: host})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.NextProtos) != len(want) || got.NextProtos[0] != want[0] {
+			t.Errorf("%s: got %v, expected %v", host, got.NextProtos, want)
+		}
+	}
+	if len(cfg.NextProtos) != 2 {
+		t.Fatalf("host options modified base protocols %v", cfg.NextProtos)
+	}
+	if err := h.Set("none.example.com", WithNextProtosOnly("spdy/3")); err == nil {
+		t.Fatal("expected error restricting to unconfigured protocols")
+	}
+}
@@ -0,0 +1,108 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HSTS wraps h to add a Strict-Transport-Security header to every response, instructing browsers to only use https
+// for maxAge. It should wrap the handler of the TLS server, browsers ignore the header over plain http.
+func HSTS(h http.Handler, maxAge time.Duration, includeSubDomains bool) http.Handler {
+	v := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	if includeSubDomains {
+		v += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", v)
+		h.ServeHTTP(w, r)
+	})
+}
+
+const (
+	// httpReadHeaderTimeout bounds reading request headers, so idle connections opened by slowloris style clients
+	// are closed. Bodies aren't bounded, handlers should set their own deadlines for those.
+	httpReadHeaderTimeout = 10 * time.Second
+	// httpIdleTimeout bounds how long a keep alive connection waits for its next request.
+	httpIdleTimeout = 120 * time.Second
+)
+
+// NewHTTPServer returns an http.Server for addr serving handler over TLS configured by opts, with the defaults of
+// https://blog.gopheracademy.com/advent-2016/exposing-go-on-the-internet/ for its timeouts. Options are applied
+// over TLS 1.2 or later and ALPN of h2 and http/1.1, so WithoutNextProtos("h2") disables HTTP/2. Serve it with
+// ListenAndServeTLS("", "").
+func NewHTTPServer(addr string, handler http.Handler, opts ...Option) (*http.Server, error) {
+	cfg, err := NewTLSConfigFrom(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         cfg,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+	if len(filterProtos(cfg.NextProtos, []string{"h2"}, true)) == 0 {
+		// A non nil, empty, TLSNextProto stops net/http configuring HTTP/2 itself.
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	return srv, nil
+}
+
+// RedirectToHTTPS returns a handler redirecting requests to the same host, path and query over https, with status,
+// one of 301, 302, 307 or 308. 307 and 308 have clients repeat the method and body, rather than GET.
+func RedirectToHTTPS(status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+		}
+		if host == "" {
+			http.Error(w, "missing host", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
+
+// validRedirectStatus reports whether status is a redirect RedirectToHTTPS can use.
+func validRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// newPlainHTTPServer returns an http.Server for port 80 serving h, with timeouts suitable for redirects.
+func newPlainHTTPServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         ":80",
+		Handler:      h,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+}
+
+// NewRedirectServer returns an http.Server for port 80 redirecting every request to https with status, see
+// RedirectToHTTPS. Browsers ignore HSTS over plain http, so to have them stop making http requests wrap the TLS
+// server's handler with HSTS. Use NewACMERedirectServer instead to also answer ACME http-01 challenges.
+func NewRedirectServer(status int) (*http.Server, error) {
+	if !validRedirectStatus(status) {
+		return nil, errors.Errorf("invalid redirect status %d", status)
+	}
+	return newPlainHTTPServer(RedirectToHTTPS(status)), nil
+}
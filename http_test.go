@@ -0,0 +1,92 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPServer(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})
+
+	for _, tt := range []struct {
+		name  string
+		opts  []Option
+		proto string
+	}{
+		{"http2", nil, "HTTP/2.0"},
+		{"without h2", []Option{WithoutNextProtos("h2")}, "HTTP/1.1"},
+	} {
+		srv, err := NewHTTPServer("127.0.0.1:0", h, append([]Option{func(cfg *tls.Config) error {
+			cfg.Certificates = []tls.Certificate{*cert}
+			return nil
+		}}, tt.opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if srv.ReadHeaderTimeout == 0 || srv.IdleTimeout == 0 || srv.TLSConfig.MinVersion != tls.VersionTLS12 {
+			t.Fatalf("%s: missing defaults", tt.name)
+		}
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go srv.ServeTLS(ln, "", "")
+
+		roots := x509.NewCertPool()
+		roots.AddCert(cert.Leaf)
+		client, err := NewHTTPClient(WithServerName("example.com"), func(cfg *tls.Config) error {
+			cfg.RootCAs = roots
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get("https://" + ln.Addr().String())
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.Proto != tt.proto {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.proto, resp.Proto)
+		}
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	for _, tt := range []struct {
+		host, target, location string
+		status                 int
+	}{
+		{"example.com", "/a/b?c=d", "https://example.com/a/b?c=d", http.StatusMovedPermanently},
+		{"example.com:8080", "/", "https://example.com/", http.StatusPermanentRedirect},
+		{"[::1]:80", "/x", "https://[::1]/x", http.StatusFound},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		RedirectToHTTPS(tt.status).ServeHTTP(w, r)
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s%s: expected %d to %s, got %d to %s", tt.host, tt.target, tt.status, tt.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+	if _, err := NewRedirectServer(http.StatusOK); err == nil {
+		t.Fatal("expected error for non redirect status")
+	}
+	srv, err := NewRedirectServer(http.StatusPermanentRedirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Addr != ":80" || srv.ReadTimeout == 0 {
+		t.Fatal("unexpected redirect server defaults")
+	}
+}
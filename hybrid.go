@@ -0,0 +1,63 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+)
+
+// HybridOrder is the order a hybrid GetCertificate consults static and ACME certificates.
+type HybridOrder int
+
+const (
+	// StaticFirst serves a static certificate if one matches, otherwise obtains one by ACME.
+	StaticFirst HybridOrder = iota
+	// ACMEFirst obtains a certificate by ACME, serving a static certificate only if that fails.
+	ACMEFirst
+)
+
+// FirstCertificate returns a GetCertificate function trying each of fns in turn, returning the first certificate
+// obtained, or the last error if none are.
+func FirstCertificate(fns ...func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		err := errors.New("tlsutil: no certificate sources")
+		for _, fn := range fns {
+			var cert *tls.Certificate
+			if cert, err = fn(hello); err == nil && cert != nil {
+				return cert, nil
+			}
+		}
+		if err == nil {
+			err = errors.New("tlsutil: no certificate")
+		}
+		return nil, err
+	}
+}
+
+// WithHybridACME configures TLS to serve the certificates of static, and ACME for other hosts, in order. For
+// certificates, such as EV, that must be served as is. static's default certificate, if any, is only served when
+// neither has a certificate, so combine with a HostPolicy to keep ACME from attempting every name.
+func WithHybridACME(static *SNIRouter, order HybridOrder, opts ...ACMEOption) Option {
+	return func(cfg *tls.Config) error {
+		mgr, err := newACMEManager(opts...)
+		if err != nil {
+			return err
+		}
+		getCertificate, err := hybridCertificate(static, mgr.GetCertificate, order)
+		if err != nil {
+			return err
+		}
+		return setGetCertificate(cfg, getCertificate)
+	}
+}
+
+// hybridCertificate returns the GetCertificate of static and acme combined in order.
+func hybridCertificate(static *SNIRouter, acme func(*tls.ClientHelloInfo) (*tls.Certificate, error), order HybridOrder) (func(*tls.ClientHelloInfo) (*tls.Certificate, error), error) {
+	switch order {
+	case StaticFirst:
+		return FirstCertificate(static.hostCertificate, acme, static.defaultCertificate), nil
+	case ACMEFirst:
+		return FirstCertificate(acme, static.hostCertificate, static.defaultCertificate), nil
+	}
+	return nil, errors.Errorf("unknown hybrid order %d", int(order))
+}
@@ -0,0 +1,65 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestHybridCertificate(t *testing.T) {
+	now := time.Now()
+	static := NewSNIRouter()
+	ev := newTestCertificate(t, "ev.example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	if err := static.AddCertificate(ev); err != nil {
+		t.Fatal(err)
+	}
+	issued := newTestCertificate(t, "acme", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	var acmeCalls int
+	acme := func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		acmeCalls++
+		if hello.ServerName == "denied.example.com" {
+			return nil, errors.New("host not permitted")
+		}
+		return issued, nil
+	}
+
+	staticFirst, err := hybridCertificate(static, acme, StaticFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := staticFirst(&tls.ClientHelloInfo{ServerName: "ev.example.com"}); got != ev || acmeCalls != 0 {
+		t.Fatal("expected static certificate without consulting ACME")
+	}
+	if got, _ := staticFirst(&tls.ClientHelloInfo{ServerName: "www.example.com"}); got != issued {
+		t.Fatal("expected ACME certificate for other hosts")
+	}
+	if _, err := staticFirst(&tls.ClientHelloInfo{ServerName: "denied.example.com"}); err == nil {
+		t.Fatal("expected error without a default certificate")
+	}
+	placeholder, err := NewPlaceholderCertificate(KeyECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := static.SetDefaultCertificate(placeholder); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := staticFirst(&tls.ClientHelloInfo{ServerName: "www.example.com"}); got != issued {
+		t.Fatal("default certificate shadowed ACME")
+	}
+	if got, _ := staticFirst(&tls.ClientHelloInfo{ServerName: "denied.example.com"}); got != placeholder {
+		t.Fatal("expected default certificate when ACME fails")
+	}
+
+	acmeFirst, err := hybridCertificate(static, acme, ACMEFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := acmeFirst(&tls.ClientHelloInfo{ServerName: "ev.example.com"}); got != issued {
+		t.Fatal("expected ACME certificate first")
+	}
+	if _, err := hybridCertificate(static, acme, HybridOrder(7)); err == nil {
+		t.Fatal("expected error for unknown order")
+	}
+}
@@ -0,0 +1,97 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// PeerIdentity is the identity asserted by a peer's leaf certificate.
+type PeerIdentity struct {
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	// SPIFFEID is the peer's SPIFFE ID, if its certificate is an SVID.
+	SPIFFEID     *url.URL
+	SerialNumber *big.Int
+	// VerifiedByTLS is whether crypto/tls itself verified the certificate's chain, as reported by VerifiedChains. It's
+	// false when verification is done instead in VerifyConnection, as by a ChainVerifier or the reloading and bundle CA
+	// options, so false doesn't mean unverified where such a verifier is configured. Also false with a non verifying
+	// ClientAuth, RequireAnyClientCert or RequestClientCert, and no such verifier.
+	VerifiedByTLS bool
+	Certificate   *x509.Certificate
+}
+
+// PeerIdentityOf returns the identity of the peer of cs, false if it presented no certificate.
+func PeerIdentityOf(cs *tls.ConnectionState) (*PeerIdentity, bool) {
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return nil, false
+	}
+	leaf := cs.PeerCertificates[0]
+	id := &PeerIdentity{
+		CommonName:     leaf.Subject.CommonName,
+		DNSNames:       leaf.DNSNames,
+		EmailAddresses: leaf.EmailAddresses,
+		IPAddresses:    leaf.IPAddresses,
+		URIs:           leaf.URIs,
+		SerialNumber:   leaf.SerialNumber,
+		VerifiedByTLS:  len(cs.VerifiedChains) > 0,
+		Certificate:    leaf,
+	}
+	if spiffeID, err := SPIFFEID(leaf); err == nil {
+		id.SPIFFEID = spiffeID
+	}
+	return id, true
+}
+
+type peerIdentityKey struct{}
+
+type tlsConnKey struct{}
+
+// ContextWithPeerIdentity returns a copy of ctx carrying id.
+func ContextWithPeerIdentity(ctx context.Context, id *PeerIdentity) context.Context {
+	return context.WithValue(ctx, peerIdentityKey{}, id)
+}
+
+// PeerIdentityFromContext returns the peer identity of a request context, as set by PeerIdentityHandler, or of the
+// connection recorded by ConnContext. False if there's no TLS connection or the peer presented no certificate.
+func PeerIdentityFromContext(ctx context.Context) (*PeerIdentity, bool) {
+	if id, ok := ctx.Value(peerIdentityKey{}).(*PeerIdentity); ok {
+		return id, true
+	}
+	if c, ok := ctx.Value(tlsConnKey{}).(*tls.Conn); ok {
+		cs := c.ConnectionState()
+		if cs.HandshakeComplete {
+			return PeerIdentityOf(&cs)
+		}
+	}
+	return nil, false
+}
+
+// PeerIdentityHandler wraps h to add the identity of the client's certificate, if any, to each request's context,
+// retrieved by PeerIdentityFromContext. Rejecting clients without acceptable certificates is the TLS configuration's
+// responsibility, this only extracts the identity.
+func PeerIdentityHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := PeerIdentityOf(r.TLS); ok {
+			r = r.WithContext(ContextWithPeerIdentity(r.Context(), id))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ConnContext records TLS connections in their context, for use as http.Server's ConnContext, so code handed only
+// the context can retrieve the peer's identity by PeerIdentityFromContext once the handshake completes. The
+// handshake is not performed here, as ConnContext runs in the server's accept loop.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		return context.WithValue(ctx, tlsConnKey{}, tc)
+	}
+	return ctx
+}
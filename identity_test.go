@@ -0,0 +1,77 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPeerIdentityHandler(t *testing.T) {
+	svid := newTestSVID(t, "spiffe://example.org/billing")
+	var got *PeerIdentity
+	h := PeerIdentityHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = PeerIdentityFromContext(r.Context())
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	r.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{svid},
+		VerifiedChains:   [][]*x509.Certificate{{svid}},
+	}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got == nil {
+		t.Fatal("no identity in request context")
+	}
+	if got.CommonName != "workload" || got.SerialNumber.Cmp(svid.SerialNumber) != 0 || !got.VerifiedByTLS {
+		t.Fatalf("unexpected identity %+v", got)
+	}
+	if got.SPIFFEID == nil || got.SPIFFEID.String() != "spiffe://example.org/billing" {
+		t.Fatalf("unexpected SPIFFE ID %v", got.SPIFFEID)
+	}
+
+	got = nil
+	r.TLS = &tls.ConnectionState{}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got != nil {
+		t.Fatal("identity without client certificate")
+	}
+}
+
+func TestConnContext(t *testing.T) {
+	now := time.Now()
+	serverCert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	clientCert := newTestCertificate(t, "client", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	pool := x509.NewCertPool()
+	pool.AddCert(serverCert.Leaf)
+
+	c1, c2 := net.Pipe()
+	server := tls.Server(c1, &tls.Config{Certificates: []tls.Certificate{*serverCert}, ClientAuth: tls.RequireAnyClientCert})
+	client := tls.Client(c2, &tls.Config{RootCAs: pool, ServerName: "example.com", Certificates: []tls.Certificate{*clientCert}})
+	defer server.Close()
+	defer client.Close()
+
+	ctx := ConnContext(context.Background(), server)
+	if _, ok := PeerIdentityFromContext(ctx); ok {
+		t.Fatal("identity before handshake")
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- client.Handshake() }()
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	id, ok := PeerIdentityFromContext(ctx)
+	if !ok || id.CommonName != "client" || id.VerifiedByTLS {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+	if ConnContext(context.Background(), c2) != context.Background() {
+		t.Fatal("plain connection recorded")
+	}
+}
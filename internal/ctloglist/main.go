@@ -0,0 +1,52 @@
+// Command ctloglist fetches Chrome's CT log list, writing it for tlsutil to bundle once it parses. Run by go generate
+// in the tlsutil package.
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/renthraysk/tlsutil"
+)
+
+func main() {
+	out := flag.String("o", "ct_log_list.json", "file to write the log list to")
+	url := flag.String("url", tlsutil.CTLogListURL, "URL of the log list")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	data, err := fetch(ctx, *url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := tlsutil.ParseCTLogList(data); err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*out, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// fetch returns the body of url.
+func fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch CT log list")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to fetch CT log list: %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 4<<20))
+}
@@ -0,0 +1,114 @@
+// Package ticketkeys holds the session ticket key ring shared through a coordination service by redisticket and
+// etcdticket.
+package ticketkeys
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// headerLen is the length of the generation and rotation time preceding the keys.
+const headerLen = 16
+
+// Ring is a generation of session ticket keys, newest first.
+type Ring struct {
+	Generation uint64
+	RotatedAt  time.Time
+	Keys       [][32]byte
+}
+
+// Rotate returns the next generation of r, a new random key followed by at most n-1 of r's keys.
+func (r Ring) Rotate(n int, now time.Time) (Ring, error) {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return Ring{}, errors.Wrap(err, "failed to generate session ticket key")
+	}
+	keys := append([][32]byte{key}, r.Keys...)
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return Ring{Generation: r.Generation + 1, RotatedAt: now, Keys: keys}, nil
+}
+
+// Seal encrypts r with aead, binding it to ad.
+func Seal(aead cipher.AEAD, ad []byte, r Ring) ([]byte, error) {
+	plain := make([]byte, headerLen, headerLen+32*len(r.Keys))
+	binary.BigEndian.PutUint64(plain, r.Generation)
+	binary.BigEndian.PutUint64(plain[8:], uint64(r.RotatedAt.UnixNano()))
+	for _, k := range r.Keys {
+		plain = append(plain, k[:]...)
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, ad), nil
+}
+
+// Open decrypts a Ring sealed with aead and ad.
+func Open(aead cipher.AEAD, ad []byte, data []byte) (Ring, error) {
+	ns := aead.NonceSize()
+	if len(data) < ns {
+		return Ring{}, errors.New("truncated session ticket keys")
+	}
+	plain, err := aead.Open(nil, data[:ns], data[ns:], ad)
+	if err != nil {
+		return Ring{}, errors.Wrap(err, "failed to decrypt session ticket keys")
+	}
+	if len(plain) < headerLen+32 || (len(plain)-headerLen)%32 != 0 {
+		return Ring{}, errors.New("malformed session ticket keys")
+	}
+	r := Ring{
+		Generation: binary.BigEndian.Uint64(plain),
+		RotatedAt:  time.Unix(0, int64(binary.BigEndian.Uint64(plain[8:]))),
+		Keys:       make([][32]byte, (len(plain)-headerLen)/32),
+	}
+	for i := range r.Keys {
+		copy(r.Keys[i][:], plain[headerLen+32*i:])
+	}
+	return r, nil
+}
+
+// Configs applies the current Ring to a set of tls.Configs.
+type Configs struct {
+	mu   sync.Mutex
+	cfgs []*tls.Config
+	ring Ring
+}
+
+// Add adds cfg, setting the current keys if any.
+func (c *Configs) Add(cfg *tls.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfgs = append(c.cfgs, cfg)
+	if len(c.ring.Keys) > 0 {
+		cfg.SetSessionTicketKeys(c.ring.Keys)
+	}
+}
+
+// Ring returns the current Ring.
+func (c *Configs) Ring() Ring {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ring
+}
+
+// Apply sets r's keys on every config, unless r is no newer than the current Ring, reporting whether it did so.
+func (c *Configs) Apply(r Ring) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.Generation <= c.ring.Generation || len(r.Keys) == 0 {
+		return false
+	}
+	c.ring = r
+	for _, cfg := range c.cfgs {
+		cfg.SetSessionTicketKeys(r.Keys)
+	}
+	return true
+}
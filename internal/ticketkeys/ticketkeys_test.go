@@ -0,0 +1,76 @@
+package ticketkeys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func newTestAEAD(t *testing.T) cipher.AEAD {
+	t.Helper()
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func TestRingRotate(t *testing.T) {
+	var r Ring
+	var err error
+	for i := 0; i < 4; i++ {
+		prev := r
+		if r, err = r.Rotate(3, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if len(prev.Keys) > 0 && r.Keys[1] != prev.Keys[0] {
+			t.Fatal("previous key not retained")
+		}
+	}
+	if len(r.Keys) != 3 || r.Generation != 4 {
+		t.Fatalf("got %d keys, generation %d", len(r.Keys), r.Generation)
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	aead := newTestAEAD(t)
+	r := Ring{Generation: 7, RotatedAt: time.Unix(0, 12345), Keys: [][32]byte{{1}, {2}}}
+	data, err := Seal(aead, []byte("a"), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Open(aead, []byte("a"), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Generation != 7 || !got.RotatedAt.Equal(r.RotatedAt) || len(got.Keys) != 2 || got.Keys[1] != r.Keys[1] {
+		t.Fatalf("round trip mismatch: %+v", got)
+	}
+	if _, err := Open(aead, []byte("b"), data); err == nil {
+		t.Fatal("expected ring bound to other associated data to be rejected")
+	}
+	data[len(data)-1] ^= 1
+	if _, err := Open(aead, []byte("a"), data); err == nil {
+		t.Fatal("expected tampered ring to be rejected")
+	}
+}
+
+func TestConfigsApply(t *testing.T) {
+	var c Configs
+	c.Add(&tls.Config{})
+	if !c.Apply(Ring{Generation: 2, Keys: [][32]byte{{2}}}) {
+		t.Fatal("newer ring not applied")
+	}
+	if c.Apply(Ring{Generation: 1, Keys: [][32]byte{{1}}}) {
+		t.Fatal("stale ring applied")
+	}
+	if c.Ring().Keys[0] != [32]byte{2} {
+		t.Fatal("current ring changed")
+	}
+}
@@ -0,0 +1,118 @@
+// Package k8scache provides an autocert.Cache that stores ACME material in a Kubernetes Secret.
+package k8scache
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+	"github.com/renthraysk/tlsutil"
+	"golang.org/x/crypto/acme/autocert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// maxConflictRetries is the number of times a write is retried after losing an update race.
+const maxConflictRetries = 5
+
+// Cache is an autocert.Cache storing every entry as a data key within a single Secret. Secrets are limited to 1MiB,
+// enough for a few hundred certificates.
+type Cache struct {
+	secrets typedcorev1.SecretInterface
+	name    string
+}
+
+var _ autocert.Cache = (*Cache)(nil)
+
+// New returns a Cache using the Secret name in namespace, which is created on first write if it does not exist.
+func New(client kubernetes.Interface, namespace, name string) *Cache {
+	return &Cache{
+		secrets: client.CoreV1().Secrets(namespace),
+		name:    name,
+	}
+}
+
+// WithACMEKubernetesCache configures ACME to cache certificates in the Secret name in namespace, using the pod's
+// in cluster service account.
+func WithACMEKubernetesCache(namespace, name string) tlsutil.ACMEOption {
+	return func(mgr *autocert.Manager) error {
+		cfg, err := rest.InClusterConfig()
+		if err != nil {
+			return errors.Wrap(err, "failed to load in cluster config")
+		}
+		client, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return errors.Wrap(err, "failed to create kubernetes client")
+		}
+		mgr.Cache = New(client, namespace, name)
+		return nil
+	}
+}
+
+// dataKey maps autocert keys, which may contain characters such as '+', to valid Secret data keys.
+func dataKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// Get returns the data for key, or autocert.ErrCacheMiss.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	s, err := c.secrets.Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := s.Data[dataKey(key)]
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, nil
+}
+
+// Put stores data under key, creating the Secret if necessary.
+func (c *Cache) Put(ctx context.Context, key string, data []byte) error {
+	return c.update(ctx, true, func(d map[string][]byte) {
+		d[dataKey(key)] = data
+	})
+}
+
+// Delete removes key from the Secret.
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	return c.update(ctx, false, func(d map[string][]byte) {
+		delete(d, dataKey(key))
+	})
+}
+
+// update applies fn to the Secret's data, retrying if a concurrent writer modified the Secret in the meantime.
+func (c *Cache) update(ctx context.Context, create bool, fn func(map[string][]byte)) error {
+	for i := 0; ; i++ {
+		s, err := c.secrets.Get(ctx, c.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			if !create {
+				return nil
+			}
+			s = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: c.name},
+				Type:       corev1.SecretTypeOpaque,
+				Data:       make(map[string][]byte),
+			}
+			fn(s.Data)
+			_, err = c.secrets.Create(ctx, s, metav1.CreateOptions{})
+		} else if err == nil {
+			if s.Data == nil {
+				s.Data = make(map[string][]byte)
+			}
+			fn(s.Data)
+			_, err = c.secrets.Update(ctx, s, metav1.UpdateOptions{})
+		}
+		if (apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)) && i < maxConflictRetries {
+			continue
+		}
+		return err
+	}
+}
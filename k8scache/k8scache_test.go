@@ -0,0 +1,85 @@
+package k8scache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme/autocert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	c := New(client, "acme", "certs")
+
+	if _, err := c.Get(ctx, "example.com"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected cache miss without Secret, got %v", err)
+	}
+	if err := c.Delete(ctx, "example.com"); err != nil {
+		t.Fatalf("expected delete without Secret to succeed, got %v", err)
+	}
+	// Keys such as autocert's "example.com+rsa" aren't valid Secret data keys as they are.
+	if err := c.Put(ctx, "example.com+rsa", []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(ctx, "acme_account+key", []byte("key")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Get(ctx, "example.com+rsa"); err != nil || string(data) != "cert" {
+		t.Fatalf("expected cert, got %q, %v", data, err)
+	}
+	if _, err := c.Get(ctx, "example.org"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected cache miss for absent key, got %v", err)
+	}
+	s, err := client.CoreV1().Secrets("acme").Get(ctx, "certs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Type != corev1.SecretTypeOpaque || len(s.Data) != 2 {
+		t.Fatalf("expected opaque Secret of 2 keys, got %s of %d", s.Type, len(s.Data))
+	}
+
+	if err := c.Delete(ctx, "example.com+rsa"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "example.com+rsa"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected cache miss after delete, got %v", err)
+	}
+	if data, err := c.Get(ctx, "acme_account+key"); err != nil || string(data) != "key" {
+		t.Fatalf("expected other keys kept, got %q, %v", data, err)
+	}
+}
+
+func TestCacheConflict(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "certs", Namespace: "acme"}})
+	conflicts := 2
+	client.PrependReactor("update", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			return false, nil, nil
+		}
+		conflicts--
+		return true, nil, apierrors.NewConflict(corev1.Resource("secrets"), "certs", errors.New("modified"))
+	})
+	c := New(client, "acme", "certs")
+
+	// Lost races are retried, the Secret having no data yet.
+	if err := c.Put(ctx, "example.com", []byte("cert")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Get(ctx, "example.com"); err != nil || string(data) != "cert" {
+		t.Fatalf("expected cert after retries, got %q, %v", data, err)
+	}
+
+	conflicts = maxConflictRetries + 1
+	if err := c.Put(ctx, "example.org", []byte("cert")); !apierrors.IsConflict(err) {
+		t.Fatalf("expected conflict once retries are exhausted, got %v", err)
+	}
+}
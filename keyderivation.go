@@ -0,0 +1,55 @@
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// keyDerivationInfo is the HKDF info prefix of derived session ticket keys.
+const keyDerivationInfo = "tlsutil session ticket key"
+
+// WithKeyDerivation derives session ticket keys from secret and the current interval, rather than generating them
+// randomly. Instances sharing secret, interval and key count use the same keys at the same time without any
+// coordination, allowing tickets to be resumed across a load balanced fleet. Clocks should be kept in sync, as keys
+// change on interval boundaries since the Unix epoch. secret must be at least 32 bytes of high entropy material.
+func WithKeyDerivation(secret []byte) KeyRotatorOption {
+	return func(r *KeyRotator) error {
+		if len(secret) < 32 {
+			return errors.New("session ticket key derivation secret must be at least 32 bytes")
+		}
+		r.secret = append([]byte(nil), secret...)
+		return nil
+	}
+}
+
+// bucket returns the index of the interval containing t.
+func (r *KeyRotator) bucket(t time.Time) int64 {
+	return t.UnixNano() / int64(r.duration)
+}
+
+// untilNextBucket returns the time from t until the start of the next interval.
+func (r *KeyRotator) untilNextBucket(t time.Time) time.Duration {
+	return time.Unix(0, (r.bucket(t)+1)*int64(r.duration)).Sub(t)
+}
+
+// deriveKeys returns the ring of keys for the interval containing t, newest first.
+func (r *KeyRotator) deriveKeys(t time.Time) ([][32]byte, error) {
+	keys := make([][32]byte, cap(r.keys))
+	b := r.bucket(t)
+	info := make([]byte, len(keyDerivationInfo)+16)
+	copy(info, keyDerivationInfo)
+	for i := range keys {
+		// Interval and duration both bind the key, so differently configured instances never collide.
+		binary.BigEndian.PutUint64(info[len(keyDerivationInfo):], uint64(b-int64(i)))
+		binary.BigEndian.PutUint64(info[len(keyDerivationInfo)+8:], uint64(r.duration))
+		if _, err := io.ReadFull(hkdf.New(sha256.New, r.secret, nil, info), keys[i][:]); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,56 @@
+package tlsutil
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestKeyDerivation(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	a, err := NewKeyRotator(3, time.Hour, WithKeyDerivation(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewKeyRotator(3, time.Hour, WithKeyDerivation(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.keys) != 3 {
+		t.Fatalf("expected full ring of derived keys, got %d", len(a.keys))
+	}
+	for i := range a.keys {
+		if a.keys[i] != b.keys[i] {
+			t.Fatalf("key %d differs between instances", i)
+		}
+	}
+
+	now := time.Now()
+	cur, err := a.deriveKeys(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := a.deriveKeys(now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next[1] != cur[0] || next[2] != cur[1] || next[0] == cur[0] {
+		t.Fatal("derived ring did not shift by one interval")
+	}
+
+	if d := a.untilNextBucket(time.Unix(3600*10+600, 0)); d != 50*time.Minute {
+		t.Fatalf("expected 50m until next interval, got %v", d)
+	}
+
+	other, err := NewKeyRotator(3, 2*time.Hour, WithKeyDerivation(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.keys[0] == a.keys[0] {
+		t.Fatal("keys for different intervals collide")
+	}
+
+	if _, err := NewKeyRotator(3, time.Hour, WithKeyDerivation([]byte("short"))); err == nil {
+		t.Fatal("expected error for short secret")
+	}
+}
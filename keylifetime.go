@@ -0,0 +1,46 @@
+package tlsutil
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxTicketLifetime is the longest a TLS 1.3 ticket may be used, RFC 8446 section 4.6.1. Clients discard older
+	// tickets, so accepting them for longer serves no purpose.
+	maxTicketLifetime = 7 * 24 * time.Hour
+	// maxTicketKeys bounds the key ring size.
+	maxTicketKeys = 64
+)
+
+// SessionTicketKeySchedule returns the key count and rotation interval with which each key issues tickets for issue,
+// after which tickets issued with it are still accepted for at least accept. A ticket issued just before its key is
+// replaced is resumable for accept, one issued just after the key was introduced for up to issue+accept.
+func SessionTicketKeySchedule(issue, accept time.Duration) (n int, d time.Duration, err error) {
+	if issue <= 0 {
+		return 0, 0, errors.New("session ticket key issue period must be positive")
+	}
+	if accept < 0 {
+		return 0, 0, errors.New("session ticket accept period must not be negative")
+	}
+	if accept > maxTicketLifetime {
+		return 0, 0, errors.Errorf("session ticket accept period %v exceeds the maximum ticket lifetime %v", accept, maxTicketLifetime)
+	}
+	// One key issuing, followed by enough retired keys to span accept.
+	retired := (accept + issue - 1) / issue
+	if retired+1 > maxTicketKeys {
+		return 0, 0, errors.Errorf("issuing for %v and accepting for %v requires more than %d keys", issue, accept, maxTicketKeys)
+	}
+	return int(retired) + 1, issue, nil
+}
+
+// NewKeyRotatorLifetime returns a KeyRotator issuing tickets with each key for issue, and accepting them for at least
+// accept after, as computed by SessionTicketKeySchedule.
+func NewKeyRotatorLifetime(issue, accept time.Duration, opts ...KeyRotatorOption) (*KeyRotator, error) {
+	n, d, err := SessionTicketKeySchedule(issue, accept)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyRotator(n, d, opts...)
+}
@@ -0,0 +1,51 @@
+package tlsutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionTicketKeySchedule(t *testing.T) {
+	for _, tt := range []struct {
+		issue, accept time.Duration
+		n             int
+	}{
+		{time.Hour, 0, 1},
+		{time.Hour, time.Hour, 2},
+		{time.Hour, 90 * time.Minute, 3},
+		{time.Hour, 24 * time.Hour, 25},
+		{24 * time.Hour, 7 * 24 * time.Hour, 8},
+		{time.Hour, 30 * time.Minute, 2},
+	} {
+		n, d, err := SessionTicketKeySchedule(tt.issue, tt.accept)
+		if err != nil {
+			t.Fatalf("%v/%v: %v", tt.issue, tt.accept, err)
+		}
+		if n != tt.n || d != tt.issue {
+			t.Errorf("%v/%v: got %d keys every %v, want %d", tt.issue, tt.accept, n, d, tt.n)
+		}
+		// The most recently retired key's tickets are accepted for at least accept.
+		if time.Duration(n-1)*d < tt.accept {
+			t.Errorf("%v/%v: ring too short", tt.issue, tt.accept)
+		}
+	}
+
+	for _, tt := range []struct{ issue, accept time.Duration }{
+		{0, time.Hour},
+		{time.Hour, -time.Hour},
+		{time.Hour, 8 * 24 * time.Hour},
+		{time.Minute, 7 * 24 * time.Hour},
+	} {
+		if _, _, err := SessionTicketKeySchedule(tt.issue, tt.accept); err == nil {
+			t.Errorf("%v/%v: expected error", tt.issue, tt.accept)
+		}
+	}
+
+	r, err := NewKeyRotatorLifetime(time.Hour, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cap(r.keys) != 3 || r.duration != time.Hour {
+		t.Fatalf("got %d keys every %v", cap(r.keys), r.duration)
+	}
+}
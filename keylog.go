@@ -0,0 +1,54 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// WithKeyLogWriter writes TLS master secrets in NSS key log format to w, for use by external programs such as Wireshark.
+// Use of this compromises security, and should only be used for debugging.
+func WithKeyLogWriter(w io.Writer) Option {
+	return func(cfg *tls.Config) error {
+		cfg.KeyLogWriter = w
+		return nil
+	}
+}
+
+var keyLog struct {
+	once sync.Once
+	file *os.File
+	err  error
+}
+
+// openKeyLog opens the file named by SSLKEYLOGFILE once per process, so repeated use of WithKeyLogFromEnv shares a
+// single descriptor rather than leaking one per config.
+func openKeyLog() (*os.File, error) {
+	keyLog.once.Do(func() {
+		name := os.Getenv("SSLKEYLOGFILE")
+		if name == "" {
+			return
+		}
+		keyLog.file, keyLog.err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	})
+	return keyLog.file, keyLog.err
+}
+
+// WithKeyLogFromEnv appends TLS master secrets to the file named by the SSLKEYLOGFILE environment variable, if set.
+// The variable is read, and the file opened, on first use only. Use of this compromises security, and should only be
+// used for debugging.
+func WithKeyLogFromEnv() Option {
+	return func(cfg *tls.Config) error {
+		f, err := openKeyLog()
+		if err != nil {
+			return errors.Wrap(err, "failed to open key log file")
+		}
+		if f != nil {
+			cfg.KeyLogWriter = f
+		}
+		return nil
+	}
+}
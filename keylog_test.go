@@ -0,0 +1,78 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyLogWriter(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	var keys bytes.Buffer
+	client, err := NewTLSConfig(WithKeyLogWriter(&keys), func(cfg *tls.Config) error {
+		cfg.ServerName, cfg.RootCAs = "example.com", roots
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &tls.Config{Certificates: []tls.Certificate{*cert}, SessionTicketsDisabled: true}
+	if _, err := clientHandshake(t, server, client); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(keys.Bytes(), []byte("CLIENT_TRAFFIC_SECRET_0 ")) {
+		t.Fatalf("expected NSS key log lines, got %q", keys.String())
+	}
+}
+
+func TestKeyLogFromEnv(t *testing.T) {
+	reset := func() {
+		keyLog.once, keyLog.file, keyLog.err = sync.Once{}, nil, nil
+	}
+	reset()
+	defer reset()
+	t.Setenv("SSLKEYLOGFILE", "")
+	cfg, err := NewTLSConfig(WithKeyLogFromEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.KeyLogWriter != nil {
+		t.Fatal("expected no key log without SSLKEYLOGFILE")
+	}
+
+	reset()
+	path := filepath.Join(t.TempDir(), "keys.log")
+	t.Setenv("SSLKEYLOGFILE", path)
+	cfg1, err := NewTLSConfig(WithKeyLogFromEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keyLog.file.Close()
+	cfg2, err := NewTLSConfig(WithKeyLogFromEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg1.KeyLogWriter == nil || cfg1.KeyLogWriter != cfg2.KeyLogWriter {
+		t.Fatal("expected configs to share one key log file")
+	}
+	if _, err := cfg1.KeyLogWriter.Write([]byte("CLIENT_RANDOM x y\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "CLIENT_RANDOM x y\n" {
+		t.Fatalf("expected line written to %s, got %q, %v", path, data, err)
+	}
+
+	reset()
+	t.Setenv("SSLKEYLOGFILE", filepath.Join(t.TempDir(), "missing", "keys.log"))
+	if _, err := NewTLSConfig(WithKeyLogFromEnv()); err == nil {
+		t.Fatal("expected error for unopenable file")
+	}
+}
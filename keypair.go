@@ -0,0 +1,112 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// WithKeyPairPEM parses a certificate from PEM encoded certPEM, keyPEM pair, and append to tls.Config's Certificates
+func WithKeyPairPEM(certPEM, keyPEM []byte) Option {
+	return func(cfg *tls.Config) error {
+		cer, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return errors.Wrap(err, "failed to load keypair")
+		}
+		cfg.Certificates = append(cfg.Certificates, cer)
+		return nil
+	}
+}
+
+// WithKeyPairReader reads a PEM encoded certificate and key from certReader, keyReader, and append to tls.Config's Certificates
+func WithKeyPairReader(certReader, keyReader io.Reader) Option {
+	return func(cfg *tls.Config) error {
+		certPEM, err := ioutil.ReadAll(certReader)
+		if err != nil {
+			return errors.Wrap(err, "failed to read certificate")
+		}
+		keyPEM, err := ioutil.ReadAll(keyReader)
+		if err != nil {
+			return errors.Wrap(err, "failed to read key")
+		}
+		return WithKeyPairPEM(certPEM, keyPEM)(cfg)
+	}
+}
+
+// WithKeyPairFS load a certificate from a certFile, keyFile pair within fsys, and append to tls.Config's Certificates
+func WithKeyPairFS(fsys fs.FS, certFile, keyFile string) Option {
+	return func(cfg *tls.Config) error {
+		certPEM, err := fs.ReadFile(fsys, certFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read certificate")
+		}
+		keyPEM, err := fs.ReadFile(fsys, keyFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read key")
+		}
+		return WithKeyPairPEM(certPEM, keyPEM)(cfg)
+	}
+}
+
+// WithEncryptedKeyPair load a certificate from a certFile, keyFile pair, where keyFile is encrypted with the passphrase
+// returned by the passphrase func, and append to tls.Config's Certificates.
+// Both traditional encrypted PEM and PKCS#8 (PBES2) encrypted keys are supported.
+func WithEncryptedKeyPair(certFile, keyFile string, passphrase func() ([]byte, error)) Option {
+	return func(cfg *tls.Config) error {
+		certPEM, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read certificate")
+		}
+		keyPEM, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read key")
+		}
+		pass, err := passphrase()
+		if err != nil {
+			return errors.Wrap(err, "failed to obtain passphrase")
+		}
+		keyPEM, err = decryptKeyPEM(keyPEM, pass)
+		if err != nil {
+			return errors.Wrap(err, "failed to decrypt key")
+		}
+		return WithKeyPairPEM(certPEM, keyPEM)(cfg)
+	}
+}
+
+// WithKeyPairFromEnv load a certificate from the environment variables certVar, keyVar, and append to tls.Config's
+// Certificates. Values may be either PEM, or base64 encoded PEM.
+func WithKeyPairFromEnv(certVar, keyVar string) Option {
+	return func(cfg *tls.Config) error {
+		certPEM, err := pemFromEnv(certVar)
+		if err != nil {
+			return errors.Wrap(err, "failed to read certificate")
+		}
+		keyPEM, err := pemFromEnv(keyVar)
+		if err != nil {
+			return errors.Wrap(err, "failed to read key")
+		}
+		return WithKeyPairPEM(certPEM, keyPEM)(cfg)
+	}
+}
+
+func pemFromEnv(name string) ([]byte, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return nil, errors.Errorf("environment variable %s not set", name)
+	}
+	b := []byte(v)
+	if bytes.Contains(b, []byte("-----BEGIN")) {
+		return b, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, errors.Wrapf(err, "environment variable %s is neither PEM nor base64", name)
+	}
+	return b, nil
+}
@@ -0,0 +1,78 @@
+package tlsutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestKeyPairPEM(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	certPEM, keyPEM := encodeKeyPair(t, cert)
+
+	cfg, err := NewTLSConfig(WithKeyPairPEM(certPEM, keyPEM), WithKeyPairReader(bytes.NewReader(certPEM), bytes.NewReader(keyPEM)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 2 {
+		t.Fatalf("expected 2 certificates appended, got %d", len(cfg.Certificates))
+	}
+	if !bytes.Equal(cfg.Certificates[1].Certificate[0], cert.Leaf.Raw) {
+		t.Fatal("expected certificate read")
+	}
+
+	other := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	_, otherKey := encodeKeyPair(t, other)
+	if _, err := NewTLSConfig(WithKeyPairPEM(certPEM, otherKey)); err == nil {
+		t.Fatal("expected error for mismatched key")
+	}
+	if _, err := NewTLSConfig(WithKeyPairReader(bytes.NewReader(certPEM), failingReader{})); err == nil {
+		t.Fatal("expected error for failed read")
+	}
+}
+
+func TestKeyPairFS(t *testing.T) {
+	now := time.Now()
+	certPEM, keyPEM := encodeKeyPair(t, newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour)))
+	fsys := fstest.MapFS{
+		"tls/cert.pem": &fstest.MapFile{Data: certPEM},
+		"tls/key.pem":  &fstest.MapFile{Data: keyPEM},
+	}
+
+	cfg, err := NewTLSConfig(WithKeyPairFS(fsys, "tls/cert.pem", "tls/key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 1 {
+		t.Fatalf("expected 1 certificate, got %d", len(cfg.Certificates))
+	}
+	if _, err := NewTLSConfig(WithKeyPairFS(fsys, "tls/cert.pem", "tls/missing.pem")); err == nil {
+		t.Fatal("expected error for missing key")
+	}
+}
+
+func TestKeyPairFromEnv(t *testing.T) {
+	now := time.Now()
+	certPEM, keyPEM := encodeKeyPair(t, newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour)))
+	// PEM as it is, and base64 encoded as when newlines can't be set.
+	t.Setenv("TLS_CERT", string(certPEM))
+	t.Setenv("TLS_KEY", base64.StdEncoding.EncodeToString(keyPEM))
+
+	cfg, err := NewTLSConfig(WithKeyPairFromEnv("TLS_CERT", "TLS_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Certificates) != 1 {
+		t.Fatalf("expected 1 certificate, got %d", len(cfg.Certificates))
+	}
+	if _, err := NewTLSConfig(WithKeyPairFromEnv("TLS_CERT", "TLS_UNSET_KEY")); err == nil {
+		t.Fatal("expected error for unset variable")
+	}
+	t.Setenv("TLS_KEY", "not base64!")
+	if _, err := NewTLSConfig(WithKeyPairFromEnv("TLS_CERT", "TLS_KEY")); err == nil {
+		t.Fatal("expected error for value neither PEM nor base64")
+	}
+}
@@ -1,77 +1,461 @@
 package tlsutil
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/tls"
-	"io"
+	mrand "math/rand"
+	"os"
+	"os/signal"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/renthraysk/group"
 )
 
+// keyRotationRetry is the longest wait before retrying a failed rotation.
+const keyRotationRetry = time.Minute
+
+// KeyRotationFailure is the behaviour of a KeyRotator on failing to generate a session ticket key.
+type KeyRotationFailure int
+
+const (
+	// RotationRetry keeps using the current keys and retries shortly, the default.
+	RotationRetry KeyRotationFailure = iota
+	// RotationDisable disables session tickets if the first key can not be generated. Later failures are retried, as
+	// a tls.Config can not be modified once in use.
+	RotationDisable
+	// RotationAbort fails NewKeyRotator, or stops Run returning the error.
+	RotationAbort
+)
+
+// KeyRotatorOption configures a KeyRotator.
+type KeyRotatorOption func(*KeyRotator) error
+
+// WithKeyRotationFailure sets the behaviour on failing to generate a key.
+func WithKeyRotationFailure(f KeyRotationFailure) KeyRotatorOption {
+	return func(r *KeyRotator) error {
+		if f < RotationRetry || f > RotationAbort {
+			return errors.Errorf("unknown key rotation failure behaviour %d", int(f))
+		}
+		r.failure = f
+		return nil
+	}
+}
+
+// WithKeyRotationErrorHandler sets a function called with every rotation error.
+func WithKeyRotationErrorHandler(fn func(error)) KeyRotatorOption {
+	return func(r *KeyRotator) error {
+		r.onError = fn
+		return nil
+	}
+}
+
+// WithKeyRotationSignals additionally rotates keys on receipt of any of sigs, such as syscall.SIGUSR2, whilst running.
+func WithKeyRotationSignals(sigs ...os.Signal) KeyRotatorOption {
+	return func(r *KeyRotator) error {
+		r.signals = append(r.signals, sigs...)
+		return nil
+	}
+}
+
+// WithKeyRotationAlignment rotates on multiples of the rotation interval since the Unix epoch, so replicas with
+// synchronised clocks rotate together. Derived keys are always aligned.
+func WithKeyRotationAlignment() KeyRotatorOption {
+	return func(r *KeyRotator) error {
+		r.align = true
+		return nil
+	}
+}
+
+// WithKeyRotationJitter delays each rotation by a random duration up to max, less than the rotation interval.
+func WithKeyRotationJitter(max time.Duration) KeyRotatorOption {
+	return func(r *KeyRotator) error {
+		if max < 0 || max >= r.duration {
+			return errors.Errorf("key rotation jitter %v must be within the rotation interval %v", max, r.duration)
+		}
+		r.jitter = max
+		return nil
+	}
+}
+
+// RotationObserver is notified of every KeyRotator rotation, for metrics and alerting.
+type RotationObserver interface {
+	// KeyRotated is called after a rotation, with the time the newest key was introduced.
+	KeyRotated(newest time.Time)
+	// KeyRotationFailed is called when a rotation fails.
+	KeyRotationFailed(err error)
+}
+
+// WithRotationObserver sets an observer of rotations.
+func WithRotationObserver(o RotationObserver) KeyRotatorOption {
+	return func(r *KeyRotator) error {
+		r.observer = o
+		return nil
+	}
+}
+
+// WithKeyRotationClock sets the clock used to time rotations.
+func WithKeyRotationClock(c Clock) KeyRotatorOption {
+	return func(r *KeyRotator) error {
+		if c == nil {
+			return errors.New("key rotation clock must not be nil")
+		}
+		r.clock = c
+		return nil
+	}
+}
+
+// WithKeyRotationCallback calls fn with a copy of the key ring, newest first, whenever it changes. For consumers of
+// keys other than tls.Config, fn must not call the KeyRotator.
+func WithKeyRotationCallback(fn func(keys [][32]byte)) KeyRotatorOption {
+	return func(r *KeyRotator) error {
+		r.onKeys = append(r.onKeys, fn)
+		return nil
+	}
+}
+
+// KeyRotator periodically rotates the session ticket keys of tls.Configs, keeping a ring of previous keys so
+// tickets issued before a rotation can still be resumed.
 type KeyRotator struct {
-	cfg      *tls.Config
 	duration time.Duration
-	keys     [][32]byte
-	stop     chan chan struct{}
+	failure  KeyRotationFailure
+	onError  func(error)
+	source   KeySource
+	secret   []byte
+	signals  []os.Signal
+	align    bool
+	jitter   time.Duration
+
+	lifeMu   sync.Mutex
+	done     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+	reset    chan struct{}
+
+	observer RotationObserver
+	clock    Clock
+
+	mu     sync.Mutex
+	cfgs   []*tls.Config
+	onKeys []func([][32]byte)
+	keys   [][32]byte
+	newest time.Time
+}
+
+// NewKeyRotator returns a KeyRotator accepting tickets encrypted with the last n keys, generating a new key every d.
+// A ticket is therefore resumable for between (n-1)*d and n*d after issue, see NewKeyRotatorLifetime to specify that
+// directly. The first key is generated immediately, attach to a tls.Config with WithKeyRotator.
+func NewKeyRotator(n int, d time.Duration, opts ...KeyRotatorOption) (*KeyRotator, error) {
+	if n < 1 {
+		return nil, errors.New("key rotator requires at least one key")
+	}
+	if d <= 0 {
+		return nil, errors.New("key rotation interval must be positive")
+	}
+	r := &KeyRotator{
+		duration: d,
+		clock:    systemClock{},
+		source:   readerKeySource{rand.Reader},
+		keys:     make([][32]byte, 0, n),
+		stopped:  make(chan struct{}),
+		reset:    make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.rotate(context.Background()); err != nil {
+		err = errors.Wrap(err, "failed to generate session ticket key")
+		if r.failure == RotationAbort {
+			return nil, err
+		}
+		r.reportError(err)
+	}
+	return r, nil
+}
+
+// WithKeyRotator configures TLS to use session ticket keys from r. A KeyRotator may drive any number of configs,
+// sharing one key ring and schedule between listeners.
+func WithKeyRotator(r *KeyRotator) Option {
+	return func(cfg *tls.Config) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, c := range r.cfgs {
+			if c == cfg {
+				return nil
+			}
+		}
+		r.cfgs = append(r.cfgs, cfg)
+		if len(r.keys) > 0 {
+			cfg.SetSessionTicketKeys(r.keys)
+		} else if r.failure == RotationDisable {
+			cfg.SessionTicketsDisabled = true
+		}
+		return nil
+	}
 }
 
-func (r *KeyRotator) read(key []byte) (int, error) {
-	if r.cfg.Rand != nil {
-		return io.ReadFull(r.cfg.Rand, key)
+func (r *KeyRotator) reportError(err error) {
+	if r.onError != nil {
+		r.onError(err)
 	}
-	return rand.Read(key)
 }
 
-func (r *KeyRotator) rotate() error {
-	var key [32]byte
+// retryInterval is how long to wait after a failed rotation.
+func (r *KeyRotator) retryInterval() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.duration < keyRotationRetry {
+		return r.duration
+	}
+	return keyRotationRetry
+}
+
+// Rotate immediately generates a new key to issue tickets with. Tickets issued with previous keys are still accepted
+// until they fall out of the ring. Derived keys can not be rotated, as they are a function of the time.
+func (r *KeyRotator) Rotate() error {
+	return r.rotateNow(context.Background())
+}
 
+func (r *KeyRotator) rotateNow(ctx context.Context) error {
+	if r.secret != nil {
+		return errors.New("derived session ticket keys can not be rotated manually")
+	}
+	return r.rotate(ctx)
+}
+
+// rotate changes the keys, notifying any observer.
+func (r *KeyRotator) rotate(ctx context.Context) error {
+	newest, err := r.rotateKeys(ctx)
+	if r.observer != nil {
+		if err != nil {
+			r.observer.KeyRotationFailed(err)
+		} else {
+			r.observer.KeyRotated(newest)
+		}
+	}
+	return err
+}
+
+// rotateKeys changes the keys, returning when the newest was introduced.
+func (r *KeyRotator) rotateKeys(ctx context.Context) (time.Time, error) {
+	if r.secret != nil {
+		now := r.clock.Now()
+		keys, err := r.deriveKeys(now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		newest := time.Unix(0, r.bucket(now)*int64(r.duration))
+		r.setKeys(keys, newest)
+		return newest, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, keySourceTimeout)
+	defer cancel()
+	key, err := r.source.SessionTicketKey(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	newest := r.clock.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if len(r.keys) < cap(r.keys) {
 		r.keys = r.keys[:len(r.keys)+1]
 	}
 	copy(r.keys[1:], r.keys[:])
+	r.keys[0] = key
+	r.newest = newest
+	r.publish()
+	return newest, nil
+}
 
-	_, err := r.read(key[:])
-	if err == nil {
-		r.keys[0] = key
+// publish hands the current keys to every config and callback. r.mu must be held.
+func (r *KeyRotator) publish() {
+	for _, cfg := range r.cfgs {
+		cfg.SetSessionTicketKeys(r.keys)
 	}
-	r.cfg.SetSessionTicketKeys(r.keys)
-	return err
+	for _, fn := range r.onKeys {
+		fn(append([][32]byte(nil), r.keys...))
+	}
+}
+
+// NewestKeyAge returns how long ago the key tickets are issued with was introduced, false if there is no key. An
+// age much beyond the rotation interval indicates rotation has stalled.
+func (r *KeyRotator) NewestKeyAge() (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.keys) == 0 {
+		return 0, false
+	}
+	return r.clock.Now().Sub(r.newest), true
+}
+
+// setKeys replaces the key ring.
+func (r *KeyRotator) setKeys(keys [][32]byte, newest time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys[:0], keys...)
+	r.newest = newest
+	r.publish()
 }
 
+// interval returns how long to wait from now until the next rotation.
+func (r *KeyRotator) interval(now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.duration
+	if r.secret != nil || r.align {
+		d = r.untilNextBucket(now)
+	}
+	if r.jitter > 0 {
+		d += time.Duration(mrand.Int63n(int64(r.jitter)))
+	}
+	return d
+}
+
+// Start rotates keys until Stop is called.
 func (r *KeyRotator) Start() error {
-	timer := time.NewTicker(r.duration)
+	return r.Run(context.Background())
+}
+
+// Run rotates keys until ctx is cancelled, or Stop is called. Errors are only returned with RotationAbort. Run returns
+// at once if r has been stopped, and fails if already running.
+func (r *KeyRotator) Run(ctx context.Context) error {
+	r.lifeMu.Lock()
+	if r.done != nil {
+		r.lifeMu.Unlock()
+		return errors.New("key rotator already running")
+	}
+	select {
+	case <-r.stopped:
+		r.lifeMu.Unlock()
+		return nil
+	default:
+	}
+	done := make(chan struct{})
+	r.done = done
+	r.lifeMu.Unlock()
+	defer func() {
+		r.lifeMu.Lock()
+		r.done = nil
+		r.lifeMu.Unlock()
+		close(done)
+	}()
+
+	next := r.interval(r.clock.Now())
+	r.mu.Lock()
+	if len(r.keys) == 0 {
+		next = r.retryInterval()
+	}
+	r.mu.Unlock()
+	timer := r.clock.NewTimer(next)
 	defer timer.Stop()
+
+	var sigs chan os.Signal
+	if len(r.signals) > 0 {
+		sigs = make(chan os.Signal, 1)
+		signal.Notify(sigs, r.signals...)
+		defer signal.Stop(sigs)
+	}
+
 	for {
 		select {
-		case <-timer.C:
-			r.rotate()
+		case <-timer.C():
+			next = r.interval(r.clock.Now())
+			if err := r.rotate(ctx); err != nil {
+				err = errors.Wrap(err, "failed to rotate session ticket key")
+				r.reportError(err)
+				if r.failure == RotationAbort {
+					return err
+				}
+				next = r.retryInterval()
+			}
+			timer.Reset(next)
+
+		case <-sigs:
+			if err := r.rotateNow(ctx); err != nil {
+				err = errors.Wrap(err, "failed to rotate session ticket key on signal")
+				r.reportError(err)
+				if r.failure == RotationAbort {
+					return err
+				}
+			}
 
-		case q := <-r.stop:
-			close(q)
+		case <-r.reset:
+			if !timer.Stop() {
+				// Drain a concurrent expiry so Reset starts afresh.
+				select {
+				case <-timer.C():
+				default:
+				}
+			}
+			timer.Reset(r.interval(r.clock.Now()))
+
+		case <-ctx.Done():
+			return nil
+
+		case <-r.stopped:
 			return nil
 		}
 	}
 }
 
+// SetInterval changes the rotation interval, taking effect from now. Not supported with derived keys, as their values
+// depend on the interval.
+func (r *KeyRotator) SetInterval(d time.Duration) error {
+	if d <= 0 {
+		return errors.New("key rotation interval must be positive")
+	}
+	if r.secret != nil {
+		return errors.New("derived session ticket key interval can not be changed")
+	}
+	r.mu.Lock()
+	if r.jitter >= d {
+		r.mu.Unlock()
+		return errors.Errorf("key rotation jitter %v must be within the rotation interval %v", r.jitter, d)
+	}
+	r.duration = d
+	r.mu.Unlock()
+	select {
+	case r.reset <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Stop permanently stops rotation, waiting for a running Start or Run to return. It is safe to call more than once,
+// or without having started. err is ignored, it satisfies group's member interface.
 func (r *KeyRotator) Stop(err error) {
-	q := make(chan struct{})
-	r.stop <- q
-	<-q
+	r.stopOnce.Do(func() { close(r.stopped) })
+	r.lifeMu.Lock()
+	done := r.done
+	r.lifeMu.Unlock()
+	if done != nil {
+		<-done
+	}
 }
 
-// WithSessionTicketKeyRotation
-func WithSessionTicketKeyRotation(g *group.Group, n int, d time.Duration) Option {
+// Close implements io.Closer, stopping rotation.
+func (r *KeyRotator) Close() error {
+	r.Stop(nil)
+	return nil
+}
+
+// WithSessionTicketKeyRotation configures TLS to rotate session ticket keys every d, accepting the last n, so tickets
+// are resumable for between (n-1)*d and n*d. Rotation is run as a member of g. Session tickets are disabled if no key
+// can be generated, unless overridden by opts.
+func WithSessionTicketKeyRotation(g *group.Group, n int, d time.Duration, opts ...KeyRotatorOption) Option {
 	return func(cfg *tls.Config) error {
-		r := &KeyRotator{
-			cfg:      cfg,
-			duration: d,
-			keys:     make([][32]byte, 0, n),
-			stop:     make(chan chan struct{}),
+		r, err := NewKeyRotator(n, d, append([]KeyRotatorOption{WithKeyRotationFailure(RotationDisable)}, opts...)...)
+		if err != nil {
+			return err
 		}
-		if err := r.rotate(); err != nil {
-			cfg.SessionTicketsDisabled = true
-			return nil
+		if err := WithKeyRotator(r)(cfg); err != nil {
+			return err
 		}
 		g.Add(r)
 		return nil
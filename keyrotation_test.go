@@ -0,0 +1,464 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestKeyRotatorRun(t *testing.T) {
+	r, err := NewKeyRotator(3, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+	if len(r.keys) != 3 {
+		t.Fatalf("expected full key ring after rotations, got %d keys", len(r.keys))
+	}
+}
+
+func TestNewKeyRotator(t *testing.T) {
+	if _, err := NewKeyRotator(0, time.Hour); err == nil {
+		t.Fatal("expected error for empty key ring")
+	}
+	if _, err := NewKeyRotator(2, 0); err == nil {
+		t.Fatal("expected error for zero interval")
+	}
+	r, err := NewKeyRotator(2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(WithKeyRotator(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.cfgs) != 1 || r.cfgs[0] != cfg {
+		t.Fatal("rotator not attached")
+	}
+
+	done := make(chan error)
+	go func() { done <- r.Start() }()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("no entropy") }
+
+func TestKeyRotatorFailure(t *testing.T) {
+	var errs []error
+	onError := WithKeyRotationErrorHandler(func(err error) { errs = append(errs, err) })
+	failing := WithKeySource(readerKeySource{failingReader{}})
+
+	if _, err := NewKeyRotator(2, time.Hour, failing, WithKeyRotationFailure(RotationAbort)); err == nil {
+		t.Fatal("expected RotationAbort to fail construction")
+	}
+
+	r, err := NewKeyRotator(2, time.Hour, failing, onError, WithKeyRotationFailure(RotationDisable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected error reported, got %v", errs)
+	}
+	cfg, err := NewTLSConfig(WithKeyRotator(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.SessionTicketsDisabled {
+		t.Fatal("expected session tickets disabled")
+	}
+
+	r, err = NewKeyRotator(2, time.Millisecond, onError, WithKeyRotationFailure(RotationAbort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.source = readerKeySource{failingReader{}}
+	if err := r.Run(context.Background()); err == nil {
+		t.Fatal("expected Run to return rotation error")
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected second error reported, got %v", errs)
+	}
+}
+
+func TestKeyRotatorRotate(t *testing.T) {
+	r, err := NewKeyRotator(2, time.Hour, WithKeyRotationSignals(syscall.SIGUSR2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := r.keys[0]
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if r.keys[0] == first || r.keys[1] != first {
+		t.Fatal("Rotate did not add a new key")
+	}
+
+	// Catch SIGUSR2 so signals sent before Run is listening do not terminate the test.
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, syscall.SIGUSR2)
+	defer signal.Stop(caught)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+	second := func() [32]byte {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.keys[0]
+	}
+	before := second()
+	deadline := time.Now().Add(5 * time.Second)
+	for second() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("signal did not rotate key")
+		}
+		// Run may not yet be listening, so keep signalling.
+		syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewKeyRotator(2, time.Hour, WithKeyDerivation(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Rotate(); err == nil {
+		t.Fatal("expected error rotating derived keys")
+	}
+}
+
+func TestKeyRotatorInterval(t *testing.T) {
+	now := time.Unix(3600*10+600, 0)
+	r, err := NewKeyRotator(2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := r.interval(now); d != time.Hour {
+		t.Fatalf("expected unaligned interval, got %v", d)
+	}
+	r, err = NewKeyRotator(2, time.Hour, WithKeyRotationAlignment(), WithKeyRotationJitter(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if d := r.interval(now); d < 50*time.Minute || d >= 51*time.Minute {
+			t.Fatalf("aligned interval with jitter %v out of range", d)
+		}
+	}
+	if _, err := NewKeyRotator(2, time.Hour, WithKeyRotationJitter(time.Hour)); err == nil {
+		t.Fatal("expected error for jitter not less than interval")
+	}
+}
+
+type countingObserver struct {
+	rotated, failed int
+}
+
+func (o *countingObserver) KeyRotated(newest time.Time) { o.rotated++ }
+func (o *countingObserver) KeyRotationFailed(err error) { o.failed++ }
+
+func TestRotationObserver(t *testing.T) {
+	o := &countingObserver{}
+	r, err := NewKeyRotator(2, time.Hour, WithRotationObserver(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	r.source = readerKeySource{failingReader{}}
+	if err := r.Rotate(); err == nil {
+		t.Fatal("expected rotation failure")
+	}
+	if o.rotated != 2 || o.failed != 1 {
+		t.Fatalf("got %d rotations, %d failures", o.rotated, o.failed)
+	}
+	if age, ok := r.NewestKeyAge(); !ok || age > time.Minute {
+		t.Fatalf("unexpected newest key age %v, %v", age, ok)
+	}
+
+	empty, err := NewKeyRotator(2, time.Hour, WithKeySource(readerKeySource{failingReader{}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := empty.NewestKeyAge(); ok {
+		t.Fatal("expected no newest key")
+	}
+}
+
+// fakeClock is a Clock advanced manually. armed receives whenever a timer is created or reset.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	armed  chan struct{}
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, armed: make(chan struct{}, 16)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	c.mu.Lock()
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing due timers.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		if t.active && !t.deadline.After(c.now) {
+			t.active = false
+			t.c <- c.now
+		}
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	was := t.active
+	t.deadline, t.active = t.clock.now.Add(d), true
+	t.clock.mu.Unlock()
+	t.clock.armed <- struct{}{}
+	return was
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	was := t.active
+	t.active = false
+	return was
+}
+
+// blockingObserver reports rotations on rotated, after waiting for release if set.
+type blockingObserver struct {
+	rotated chan time.Time
+	release chan struct{}
+}
+
+func (o *blockingObserver) KeyRotated(newest time.Time) {
+	if o.release != nil {
+		<-o.release
+	}
+	o.rotated <- newest
+}
+
+func (o *blockingObserver) KeyRotationFailed(err error) {}
+
+func TestKeyRotatorClock(t *testing.T) {
+	clock := newFakeClock(time.Unix(1000000, 0))
+	o := &blockingObserver{rotated: make(chan time.Time, 1)}
+	r, err := NewKeyRotator(3, time.Hour, WithKeyRotationClock(clock), WithRotationObserver(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-o.rotated
+
+	done := make(chan error)
+	go func() { done <- r.Start() }()
+	<-clock.armed
+
+	var history [][32]byte
+	history = append(history, r.keys[0])
+	for i := 0; i < 4; i++ {
+		clock.Advance(time.Hour)
+		if got := <-o.rotated; !got.Equal(clock.Now()) {
+			t.Fatalf("newest key time %v, expected %v", got, clock.Now())
+		}
+		<-clock.armed
+		r.mu.Lock()
+		keys := append([][32]byte(nil), r.keys...)
+		r.mu.Unlock()
+		history = append([][32]byte{keys[0]}, history...)
+		if want := len(history); want <= 3 && len(keys) != want {
+			t.Fatalf("rotation %d: expected %d keys, got %d", i, want, len(keys))
+		}
+		for j := range keys {
+			if keys[j] != history[j] {
+				t.Fatalf("rotation %d: key %d out of order", i, j)
+			}
+		}
+	}
+	if len(r.keys) != 3 {
+		t.Fatalf("ring did not wrap at 3 keys, got %d", len(r.keys))
+	}
+
+	// Stop whilst a rotation is in progress waits for it to complete.
+	o.release = make(chan struct{})
+	clock.Advance(time.Hour)
+	stopped := make(chan struct{})
+	go func() {
+		r.Stop(nil)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned during rotation")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(o.release)
+	<-o.rotated
+	<-stopped
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyRotatorMultipleConfigs(t *testing.T) {
+	var got [][32]byte
+	r, err := NewKeyRotator(2, time.Hour, WithKeyRotationCallback(func(keys [][32]byte) { got = keys }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := &tls.Config{}, &tls.Config{}
+	for _, cfg := range []*tls.Config{a, b, a} {
+		if err := WithKeyRotator(r)(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(r.cfgs) != 2 {
+		t.Fatalf("expected 2 configs, got %d", len(r.cfgs))
+	}
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != r.keys[0] {
+		t.Fatal("callback not given current keys")
+	}
+	got[0] = [32]byte{}
+	if r.keys[0] == got[0] {
+		t.Fatal("callback given the ring itself rather than a copy")
+	}
+}
+
+func TestKeySource(t *testing.T) {
+	calls := 0
+	src := KeySourceFunc(func(ctx context.Context) ([32]byte, error) {
+		calls++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("key source called without a deadline")
+		}
+		return [32]byte{byte(calls)}, nil
+	})
+	r, err := NewKeyRotator(2, time.Hour, WithKeySource(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || r.keys[0] != [32]byte{2} || r.keys[1] != [32]byte{1} {
+		t.Fatal("keys not taken from source")
+	}
+	if _, err := NewKeyRotator(2, time.Hour, WithKeySource(nil)); err == nil {
+		t.Fatal("expected error for nil key source")
+	}
+}
+
+func TestKeyRotatorLifecycle(t *testing.T) {
+	r, err := NewKeyRotator(2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stop before Start neither blocks nor panics when repeated.
+	r.Stop(nil)
+	r.Stop(errors.New("ignored"))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatalf("Run after Stop: %v", err)
+	}
+
+	clock := newFakeClock(time.Unix(1000000, 0))
+	o := &blockingObserver{rotated: make(chan time.Time, 1)}
+	r, err = NewKeyRotator(2, time.Hour, WithKeyRotationClock(clock), WithRotationObserver(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-o.rotated
+	done := make(chan error)
+	go func() { done <- r.Start() }()
+	<-clock.armed
+
+	if err := r.Run(context.Background()); err == nil {
+		t.Fatal("expected error running twice")
+	}
+
+	if err := r.SetInterval(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	<-clock.armed
+	clock.Advance(time.Minute)
+	<-o.rotated
+	<-clock.armed
+
+	if err := r.SetInterval(0); err == nil {
+		t.Fatal("expected error for zero interval")
+	}
+
+	r.Stop(nil)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	r.Stop(nil)
+
+	d, err := NewKeyRotator(2, time.Hour, WithKeyDerivation(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetInterval(time.Minute); err == nil {
+		t.Fatal("expected error changing derived key interval")
+	}
+}
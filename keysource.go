@@ -0,0 +1,50 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// keySourceTimeout bounds a single request for key material.
+const keySourceTimeout = 30 * time.Second
+
+// KeySource provides session ticket key material, for example from a KMS or HSM rather than the local random source.
+type KeySource interface {
+	SessionTicketKey(ctx context.Context) ([32]byte, error)
+}
+
+// KeySourceFunc adapts a function to a KeySource.
+type KeySourceFunc func(ctx context.Context) ([32]byte, error)
+
+// SessionTicketKey returns fn(ctx).
+func (fn KeySourceFunc) SessionTicketKey(ctx context.Context) ([32]byte, error) {
+	return fn(ctx)
+}
+
+// readerKeySource reads keys from an io.Reader, crypto/rand's by default.
+type readerKeySource struct {
+	r io.Reader
+}
+
+func (s readerKeySource) SessionTicketKey(ctx context.Context) ([32]byte, error) {
+	var key [32]byte
+	_, err := io.ReadFull(s.r, key[:])
+	return key, err
+}
+
+// WithKeySource sets the source of session ticket keys. Not used with WithKeyDerivation.
+func WithKeySource(src KeySource) KeyRotatorOption {
+	return func(r *KeyRotator) error {
+		if src == nil {
+			return errors.New("key source must not be nil")
+		}
+		r.source = src
+		return nil
+	}
+}
+
+var _ KeySource = readerKeySource{rand.Reader}
@@ -0,0 +1,49 @@
+// Package awskms provides session ticket keys generated by AWS KMS, for policies requiring HSM derived key material.
+package awskms
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/pkg/errors"
+	"github.com/renthraysk/tlsutil"
+)
+
+// API is the subset of the KMS client Source requires, satisfied by *kms.Client.
+type API interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+}
+
+// Source is a tlsutil.KeySource returning the plaintext of AES-256 data keys generated under a KMS key.
+type Source struct {
+	client API
+	keyID  string
+}
+
+var _ tlsutil.KeySource = (*Source)(nil)
+
+// New returns a Source generating data keys under keyID, a key ID, ARN or alias.
+func New(client API, keyID string) *Source {
+	return &Source{client: client, keyID: keyID}
+}
+
+// SessionTicketKey returns the plaintext of a new data key. The encrypted copy is discarded, ticket keys are never
+// persisted.
+func (s *Source) SessionTicketKey(ctx context.Context) ([32]byte, error) {
+	var key [32]byte
+
+	out, err := s.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(s.keyID),
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return key, errors.Wrap(err, "failed to generate KMS data key")
+	}
+	if len(out.Plaintext) != len(key) {
+		return key, errors.Errorf("KMS returned a %d byte data key, expected %d", len(out.Plaintext), len(key))
+	}
+	copy(key[:], out.Plaintext)
+	return key, nil
+}
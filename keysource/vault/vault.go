@@ -0,0 +1,86 @@
+// Package vault provides session ticket keys generated by HashiCorp Vault's transit secrets engine.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/renthraysk/tlsutil"
+)
+
+// Source is a tlsutil.KeySource requesting 256 bit data keys from a transit key.
+type Source struct {
+	// Address of the Vault server, such as https://vault:8200.
+	Address string
+	// Token authenticates requests.
+	Token string
+	// Mount is the transit engine's mount path, "transit" if empty.
+	Mount string
+	// Key is the name of the transit key.
+	Key string
+	// HTTPClient is used for requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+var _ tlsutil.KeySource = (*Source)(nil)
+
+// New returns a Source using the transit key named key, at the default mount.
+func New(address, token, key string) *Source {
+	return &Source{Address: address, Token: token, Key: key}
+}
+
+// SessionTicketKey returns the plaintext of a new data key.
+func (s *Source) SessionTicketKey(ctx context.Context) ([32]byte, error) {
+	var key [32]byte
+
+	mount := s.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	url := strings.TrimSuffix(s.Address, "/") + "/v1/" + strings.Trim(mount, "/") + "/datakey/plaintext/" + s.Key
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte(`{"bits":256}`)))
+	if err != nil {
+		return key, err
+	}
+	req.Header.Set("X-Vault-Token", s.Token)
+	req.Header.Set("Content-Type", "application/json")
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return key, errors.Wrap(err, "failed to request vault data key")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return key, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return key, errors.Errorf("vault data key request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return key, errors.Wrap(err, "failed to decode vault data key")
+	}
+	plain, err := base64.StdEncoding.DecodeString(out.Data.Plaintext)
+	if err != nil {
+		return key, errors.Wrap(err, "failed to decode vault data key")
+	}
+	if len(plain) != len(key) {
+		return key, errors.Errorf("vault returned a %d byte data key, expected %d", len(plain), len(key))
+	}
+	copy(key[:], plain)
+	return key, nil
+}
@@ -0,0 +1,43 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	want := bytes.Repeat([]byte{7}, 32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/transit/datakey/plaintext/tickets" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]string{
+				"plaintext":  base64.StdEncoding.EncodeToString(want),
+				"ciphertext": "vault:v1:abc",
+			},
+		})
+	}))
+	defer srv.Close()
+
+	key, err := New(srv.URL, "token", "tickets").SessionTicketKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key[:], want) {
+		t.Fatal("unexpected key")
+	}
+	if _, err := New(srv.URL, "wrong", "tickets").SessionTicketKey(context.Background()); err == nil {
+		t.Fatal("expected error for rejected token")
+	}
+}
@@ -0,0 +1,83 @@
+package tlsutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// KeyType is the algorithm of generated private keys.
+type KeyType int
+
+const (
+	// KeyECDSAP256 is ECDSA on P-256, the default.
+	KeyECDSAP256 KeyType = iota
+	// KeyECDSAP384 is ECDSA on P-384.
+	KeyECDSAP384
+	// KeyRSA2048 is 2048 bit RSA.
+	KeyRSA2048
+	// KeyRSA4096 is 4096 bit RSA.
+	KeyRSA4096
+)
+
+func (t KeyType) String() string {
+	switch t {
+	case KeyECDSAP256:
+		return "ECDSA P-256"
+	case KeyECDSAP384:
+		return "ECDSA P-384"
+	case KeyRSA2048:
+		return "RSA 2048"
+	case KeyRSA4096:
+		return "RSA 4096"
+	}
+	return "unknown key type"
+}
+
+// generate returns a new private key of type t.
+func (t KeyType) generate() (crypto.Signer, error) {
+	switch t {
+	case KeyECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	}
+	return nil, errors.Errorf("unsupported key type %d", int(t))
+}
+
+// WithACMEKeyType configures the key type of certificates issued by autocert. autocert only supports ECDSA P-256,
+// its default, which still falls back to RSA for clients lacking ECDSA support, or RSA 2048 only. It always uses a
+// P-256 account key, use a DNSManager with WithDNSKeyType for full control.
+func WithACMEKeyType(t KeyType) ACMEOption {
+	return func(mgr *autocert.Manager) error {
+		switch t {
+		case KeyECDSAP256:
+			mgr.ForceRSA = false
+		case KeyRSA2048:
+			mgr.ForceRSA = true
+		default:
+			return errors.Errorf("autocert does not support %s keys", t)
+		}
+		return nil
+	}
+}
+
+// WithDNSKeyType sets the key type of both issued certificates and, when first created, the ACME account key.
+func WithDNSKeyType(t KeyType) DNSOption {
+	return func(m *DNSManager) error {
+		if t < KeyECDSAP256 || t > KeyRSA4096 {
+			return errors.Errorf("unsupported key type %d", int(t))
+		}
+		m.KeyType = t
+		return nil
+	}
+}
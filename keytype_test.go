@@ -0,0 +1,41 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestKeyTypeGenerate(t *testing.T) {
+	for _, kt := range []KeyType{KeyECDSAP256, KeyECDSAP384, KeyRSA2048} {
+		key, err := kt.generate()
+		if err != nil {
+			t.Fatalf("%s: %v", kt, err)
+		}
+		switch k := key.(type) {
+		case *ecdsa.PrivateKey:
+			if kt == KeyRSA2048 {
+				t.Errorf("%s: got ECDSA key", kt)
+			}
+		case *rsa.PrivateKey:
+			if k.N.BitLen() != 2048 {
+				t.Errorf("%s: got %d bit key", kt, k.N.BitLen())
+			}
+		}
+	}
+	if _, err := KeyType(42).generate(); err == nil {
+		t.Error("expected error for unknown key type")
+	}
+}
+
+func TestWithACMEKeyType(t *testing.T) {
+	var mgr autocert.Manager
+	if err := WithACMEKeyType(KeyRSA2048)(&mgr); err != nil || !mgr.ForceRSA {
+		t.Fatalf("expected ForceRSA, err %v", err)
+	}
+	if err := WithACMEKeyType(KeyECDSAP384)(&mgr); err == nil {
+		t.Fatal("expected error for P-384")
+	}
+}
@@ -0,0 +1,57 @@
+package tlsutil
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// ACMEMetrics receives per host ACME certificate metrics, for adapters to systems such as Prometheus to implement.
+type ACMEMetrics interface {
+	// CertificateIssued counts certificates first obtained, or loaded from cache, for host.
+	CertificateIssued(host string)
+	// CertificateRenewed counts renewals for host.
+	CertificateRenewed(host string)
+	// CertificateFailed counts failures to obtain a certificate for host.
+	CertificateFailed(host string)
+	// CertificateExpiry reports the expiry of the certificate now served for host. Adapters should derive the
+	// remaining validity gauge from it at collection time, eg as notAfter - now.
+	CertificateExpiry(host string, notAfter time.Time)
+}
+
+// MetricsHooks returns ACMEHooks reporting to m, for use with WithACMEHooks or WithDNSHooks.
+func MetricsHooks(m ACMEMetrics) *ACMEHooks {
+	return &ACMEHooks{
+		OnIssued: func(host string, leaf *x509.Certificate) {
+			m.CertificateIssued(host)
+			m.CertificateExpiry(host, leaf.NotAfter)
+		},
+		OnRenewed: func(host string, leaf *x509.Certificate) {
+			m.CertificateRenewed(host)
+			m.CertificateExpiry(host, leaf.NotAfter)
+		},
+		OnFailed: func(host string, _ error) {
+			m.CertificateFailed(host)
+		},
+	}
+}
+
+// CombineHooks returns ACMEHooks calling each of hooks in turn.
+func CombineHooks(hooks ...*ACMEHooks) *ACMEHooks {
+	return &ACMEHooks{
+		OnIssued: func(host string, leaf *x509.Certificate) {
+			for _, h := range hooks {
+				h.issued(host, leaf)
+			}
+		},
+		OnRenewed: func(host string, leaf *x509.Certificate) {
+			for _, h := range hooks {
+				h.renewed(host, leaf)
+			}
+		},
+		OnFailed: func(host string, err error) {
+			for _, h := range hooks {
+				h.failed(host, err)
+			}
+		},
+	}
+}
@@ -0,0 +1,39 @@
+package tlsutil
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type countingMetrics struct {
+	issued, renewed, failed int
+	expiry                  time.Time
+}
+
+func (m *countingMetrics) CertificateIssued(string)                { m.issued++ }
+func (m *countingMetrics) CertificateRenewed(string)               { m.renewed++ }
+func (m *countingMetrics) CertificateFailed(string)                { m.failed++ }
+func (m *countingMetrics) CertificateExpiry(_ string, t time.Time) { m.expiry = t }
+
+func TestMetricsHooks(t *testing.T) {
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	leaf := &x509.Certificate{NotAfter: notAfter}
+
+	var m countingMetrics
+	var other int
+	hooks := CombineHooks(MetricsHooks(&m), &ACMEHooks{OnFailed: func(string, error) { other++ }}, nil)
+	hooks.issued("example.com", leaf)
+	hooks.renewed("example.com", leaf)
+	hooks.renewed("example.com", leaf)
+	hooks.failed("example.com", errors.New("failed"))
+
+	if m.issued != 1 || m.renewed != 2 || m.failed != 1 || !m.expiry.Equal(notAfter) {
+		t.Fatalf("unexpected metrics %+v", m)
+	}
+	if other != 1 {
+		t.Fatal("expected combined hooks to be called")
+	}
+}
@@ -0,0 +1,74 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ErrMissingStaple is returned when a must staple certificate has no valid OCSP staple to serve it with.
+var ErrMissingStaple = errors.New("tlsutil: must staple certificate has no valid OCSP staple")
+
+// oidTLSFeature is the TLS feature extension, RFC 7633.
+var oidTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// tlsFeatureStatusRequest is the status_request TLS extension, whose presence in the TLS feature extension makes a
+// certificate must staple.
+const tlsFeatureStatusRequest = 5
+
+// MustStaple reports whether cert requires an OCSP staple, by the TLS feature extension listing status_request.
+// Clients honouring it reject the certificate if served without a staple.
+func MustStaple(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, f := range features {
+			if f == tlsFeatureStatusRequest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateStaple checks that if cert is must staple, it has an OCSP staple for it, of good status, valid at now.
+// OCSPStapler enforces this itself, this is for certificates stapled otherwise.
+func ValidateStaple(cert *tls.Certificate, now time.Time) error {
+	leaf := leafOf(cert)
+	if leaf == nil {
+		return errors.New("failed to parse certificate")
+	}
+	if !MustStaple(leaf) {
+		return nil
+	}
+	if len(cert.OCSPStaple) == 0 {
+		return errors.Wrapf(ErrMissingStaple, "certificate %q", leaf.Subject)
+	}
+	if len(cert.Certificate) < 2 {
+		return errors.Errorf("certificate %q has no issuer to verify its staple", leaf.Subject)
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse issuer of certificate %q", leaf.Subject)
+	}
+	resp, err := ocsp.ParseResponseForCert(cert.OCSPStaple, leaf, issuer)
+	if err != nil {
+		return errors.Wrapf(ErrMissingStaple, "certificate %q: %v", leaf.Subject, err)
+	}
+	if resp.Status != ocsp.Good {
+		return errors.Wrapf(ErrMissingStaple, "certificate %q staple not of good status", leaf.Subject)
+	}
+	if !resp.NextUpdate.IsZero() && !now.Before(resp.NextUpdate) {
+		return errors.Wrapf(ErrMissingStaple, "certificate %q staple expired", leaf.Subject)
+	}
+	return nil
+}
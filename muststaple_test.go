@@ -0,0 +1,122 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+// issueTestMustStapleLeaf returns a must staple certificate issued by ca with an OCSP responder of server.
+func issueTestMustStapleLeaf(t *testing.T, ca *tls.Certificate, server string) *tls.Certificate {
+	t.Helper()
+	value, err := asn1.Marshal([]int{tlsFeatureStatusRequest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issueTestCertificate(t, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "example.com"},
+		DNSNames:        []string{"example.com"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:      []string{server},
+		ExtraExtensions: []pkix.Extension{{Id: oidTLSFeature, Value: value}},
+	}, ca)
+}
+
+func TestMustStaple(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(2*time.Hour))
+	responder := &testOCSPResponder{ca: ca, status: ocsp.Good}
+	srv := httptest.NewServer(responder)
+	defer srv.Close()
+	leaf := issueTestMustStapleLeaf(t, ca, srv.URL)
+
+	if !MustStaple(leaf.Leaf) || MustStaple(ca.Leaf) {
+		t.Fatal("must staple not detected")
+	}
+	if err := ValidateStaple(leaf, now); errors.Cause(err) != ErrMissingStaple {
+		t.Fatalf("expected ErrMissingStaple, got %v", err)
+	}
+	stapled := *leaf
+	stapled.OCSPStaple = responder.response(now, leaf.Leaf.SerialNumber, nil)
+	if err := ValidateStaple(&stapled, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateStaple(&stapled, now.Add(2*time.Hour)); errors.Cause(err) != ErrMissingStaple {
+		t.Fatalf("expected ErrMissingStaple for expired staple, got %v", err)
+	}
+	if err := ValidateStaple(ca, now); err != nil {
+		t.Fatalf("certificate not must staple: %v", err)
+	}
+
+	// The first handshake waits for the staple.
+	s, err := NewOCSPStapler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := s.GetCertificate(func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return leaf, nil })
+	got, err := get(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.OCSPStaple) == 0 {
+		t.Fatal("must staple certificate served without staple")
+	}
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	unstapled := issueTestMustStapleLeaf(t, ca, down.URL)
+	get = s.GetCertificate(func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return unstapled, nil })
+	if _, err := get(&tls.ClientHelloInfo{}); errors.Cause(err) != ErrMissingStaple {
+		t.Fatalf("expected ErrMissingStaple, got %v", err)
+	}
+}
+
+func TestMustStapleConcurrentHandshakes(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(2*time.Hour))
+	responder := &testOCSPResponder{ca: ca, status: ocsp.Good}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		responder.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	leaf := issueTestMustStapleLeaf(t, ca, srv.URL)
+
+	s, err := NewOCSPStapler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := s.GetCertificate(func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return leaf, nil })
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			got, err := get(&tls.ClientHelloInfo{})
+			if err == nil && len(got.OCSPStaple) == 0 {
+				err = errors.New("served without staple")
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&responder.queries); n != 1 {
+		t.Fatalf("expected handshakes to share 1 query, got %d", n)
+	}
+}
@@ -0,0 +1,355 @@
+package tlsutil
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// maxOCSPResponseSize bounds the size of an OCSP response.
+	maxOCSPResponseSize = 64 << 10
+	// defaultOCSPTimeout bounds an OCSP query.
+	defaultOCSPTimeout = 5 * time.Second
+	// defaultOCSPRefresh is how long a response without a next update time is cached.
+	defaultOCSPRefresh = time.Hour
+)
+
+// oidOCSPNonce is the OCSP nonce extension, RFC 8954.
+var oidOCSPNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+// OCSPOption configures an OCSPChecker.
+type OCSPOption func(*OCSPChecker) error
+
+// WithOCSPPolicy sets the policy for certificates whose OCSP status can not be determined, soft fail by default.
+func WithOCSPPolicy(p RevocationPolicy) OCSPOption {
+	return func(c *OCSPChecker) error {
+		c.policy = p
+		return nil
+	}
+}
+
+// WithOCSPTimeout sets the time allowed for an OCSP query, 5 seconds by default.
+func WithOCSPTimeout(d time.Duration) OCSPOption {
+	return func(c *OCSPChecker) error {
+		if d <= 0 {
+			return errors.New("OCSP timeout must be positive")
+		}
+		c.timeout = d
+		return nil
+	}
+}
+
+// WithOCSPNonce sends a nonce with each query, rejecting responses echoing a different one. Responses without a
+// nonce are accepted, as many responders, serving pre-signed responses, ignore nonces.
+func WithOCSPNonce() OCSPOption {
+	return func(c *OCSPChecker) error {
+		c.nonce = true
+		return nil
+	}
+}
+
+// WithOCSPHTTPClient sets the HTTP client OCSP queries are made with.
+func WithOCSPHTTPClient(client *http.Client) OCSPOption {
+	return func(c *OCSPChecker) error {
+		c.client = client
+		return nil
+	}
+}
+
+// WithOCSPClock sets the clock OCSP response freshness is judged by.
+func WithOCSPClock(clock Clock) OCSPOption {
+	return func(c *OCSPChecker) error {
+		c.clock = clock
+		return nil
+	}
+}
+
+// ocspEntry is a cached OCSP status.
+type ocspEntry struct {
+	status  int
+	expires time.Time
+}
+
+// OCSPChecker checks the peer's leaf certificate by OCSP, using a stapled response if the peer sent one, otherwise
+// querying the certificate's responders. Responses are cached until their next update.
+type OCSPChecker struct {
+	policy  RevocationPolicy
+	timeout time.Duration
+	nonce   bool
+	client  *http.Client
+	clock   Clock
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]ocspEntry
+}
+
+// NewOCSPChecker returns an OCSPChecker configured by opts.
+func NewOCSPChecker(opts ...OCSPOption) (*OCSPChecker, error) {
+	c := &OCSPChecker{
+		timeout: defaultOCSPTimeout,
+		client:  http.DefaultClient,
+		clock:   systemClock{},
+		cache:   make(map[[sha256.Size]byte]ocspEntry),
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// WithOCSPChecker configures TLS to reject peer certificates revoked according to c, after any other verification.
+// On servers this checks client certificates, on clients server certificates.
+func WithOCSPChecker(c *OCSPChecker) Option {
+	return func(cfg *tls.Config) error {
+		appendVerifyConnection(cfg, c.VerifyConnection)
+		return nil
+	}
+}
+
+// WithOCSPChecking configures TLS to reject peer certificates revoked according to a new OCSPChecker configured by
+// opts.
+func WithOCSPChecking(opts ...OCSPOption) Option {
+	return func(cfg *tls.Config) error {
+		c, err := NewOCSPChecker(opts...)
+		if err != nil {
+			return err
+		}
+		return WithOCSPChecker(c)(cfg)
+	}
+}
+
+// VerifyConnection implements tls.Config's VerifyConnection.
+func (c *OCSPChecker) VerifyConnection(cs tls.ConnectionState) error {
+	chain := cs.PeerCertificates
+	if len(cs.VerifiedChains) > 0 {
+		chain = cs.VerifiedChains[0]
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	if len(chain) < 2 {
+		return c.unknown(chain[0], errors.New("issuer unknown"))
+	}
+	if len(cs.OCSPResponse) > 0 {
+		if resp, err := c.parse(cs.OCSPResponse, chain[0], chain[1], nil); err == nil {
+			return c.status(chain[0], resp.Status)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	return c.Check(ctx, chain[0], chain[1])
+}
+
+// Check checks cert, issued by issuer, with its OCSP responders.
+func (c *OCSPChecker) Check(ctx context.Context, cert, issuer *x509.Certificate) error {
+	key := ocspCacheKey(cert, issuer)
+	now := c.clock.Now()
+	c.mu.Lock()
+	e, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return c.status(cert, e.status)
+	}
+	if len(cert.OCSPServer) == 0 {
+		return c.unknown(cert, errors.New("no OCSP responder"))
+	}
+	var err error
+	for _, server := range cert.OCSPServer {
+		var resp *ocsp.Response
+		if resp, err = c.query(ctx, server, cert, issuer); err != nil {
+			continue
+		}
+		expires := resp.NextUpdate
+		if expires.IsZero() {
+			expires = now.Add(defaultOCSPRefresh)
+		}
+		if resp.Status != ocsp.Unknown {
+			c.mu.Lock()
+			c.cache[key] = ocspEntry{status: resp.Status, expires: expires}
+			c.mu.Unlock()
+		}
+		return c.status(cert, resp.Status)
+	}
+	return c.unknown(cert, err)
+}
+
+// status returns the error, if any, for an OCSP status of cert.
+func (c *OCSPChecker) status(cert *x509.Certificate, status int) error {
+	switch status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return errors.Wrapf(ErrCertificateRevoked, "certificate %q", cert.Subject)
+	}
+	return c.unknown(cert, errors.New("OCSP status unknown"))
+}
+
+// unknown applies the policy to cert, whose status could not be determined because of err.
+func (c *OCSPChecker) unknown(cert *x509.Certificate, err error) error {
+	if c.policy == RevocationHardFail {
+		return errors.Wrapf(err, "revocation status of certificate %q unknown", cert.Subject)
+	}
+	return nil
+}
+
+// query queries server for the status of cert.
+func (c *OCSPChecker) query(ctx context.Context, server string, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	der, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create OCSP request")
+	}
+	var nonce []byte
+	if c.nonce {
+		if der, nonce, err = addOCSPNonce(der); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, server, bytes.NewReader(der))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid OCSP responder")
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query OCSP responder")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to query OCSP responder %s: %s", server, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read OCSP response")
+	}
+	return c.parse(body, cert, issuer, nonce)
+}
+
+// parse parses and checks an OCSP response for cert, which must echo nonce if it has one.
+func (c *OCSPChecker) parse(der []byte, cert, issuer *x509.Certificate, nonce []byte) (*ocsp.Response, error) {
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid OCSP response")
+	}
+	now := c.clock.Now()
+	if resp.ThisUpdate.After(now.Add(time.Minute)) {
+		return nil, errors.New("OCSP response is not yet valid")
+	}
+	if !resp.NextUpdate.IsZero() && !now.Before(resp.NextUpdate) {
+		return nil, errors.New("OCSP response has expired")
+	}
+	if nonce != nil {
+		if got, ok := ocspResponseNonce(der, resp); ok && !bytes.Equal(got, nonce) {
+			return nil, errors.New("OCSP response nonce mismatch")
+		}
+	}
+	return resp, nil
+}
+
+// ocspCacheKey identifies cert, issued by issuer.
+func ocspCacheKey(cert, issuer *x509.Certificate) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(issuer.RawSubjectPublicKeyInfo)
+	h.Write(cert.SerialNumber.Bytes())
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// ocspRequest and ocspTBSRequest are enough of RFC 6960's OCSPRequest to add request extensions, which
+// x/crypto/ocsp does not support.
+type ocspRequest struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []asn1.RawValue
+	Extensions  []pkix.Extension `asn1:"explicit,tag:2,optional"`
+}
+
+// addOCSPNonce returns the OCSP request der with a random nonce extension added, and the extension's value.
+func addOCSPNonce(der []byte) ([]byte, []byte, error) {
+	var req ocspRequest
+	if rest, err := asn1.Unmarshal(der, &req); err != nil || len(rest) != 0 {
+		return nil, nil, errors.New("failed to parse OCSP request")
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate OCSP nonce")
+	}
+	value, err := asn1.Marshal(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.TBSRequest.Extensions = append(req.TBSRequest.Extensions, pkix.Extension{Id: oidOCSPNonce, Value: value})
+	if der, err = asn1.Marshal(req); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal OCSP request")
+	}
+	return der, value, nil
+}
+
+// ocspResponseBytes through ocspResponseData are enough of RFC 6960's OCSPResponse to read the response
+// extensions, which x/crypto/ocsp does not expose.
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []asn1.RawValue
+	Extensions     []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// ocspResponseNonce returns the nonce of the response der, already parsed as resp, from its response extensions or
+// failing that the single response extensions some responders use.
+func ocspResponseNonce(der []byte, resp *ocsp.Response) ([]byte, bool) {
+	var r ocspResponse
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(der, &r); err == nil {
+		if _, err := asn1.Unmarshal(r.Response.Response, &basic); err == nil {
+			for _, ext := range basic.TBSResponseData.Extensions {
+				if ext.Id.Equal(oidOCSPNonce) {
+					return ext.Value, true
+				}
+			}
+		}
+	}
+	for _, ext := range resp.Extensions {
+		if ext.Id.Equal(oidOCSPNonce) {
+			return ext.Value, true
+		}
+	}
+	return nil, false
+}
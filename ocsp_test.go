@@ -0,0 +1,170 @@
+package tlsutil
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testOCSPResponder answers OCSP queries with status for every certificate, echoing any nonce unless badNonce.
+type testOCSPResponder struct {
+	ca       *tls.Certificate
+	status   int32
+	badNonce int32
+	queries  int32
+}
+
+func (r *testOCSPResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt32(&r.queries, 1)
+	body, _ := ioutil.ReadAll(req.Body)
+	var ocspReq ocspRequest
+	if _, err := asn1.Unmarshal(body, &ocspReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var parsed struct {
+		Cert struct {
+			HashAlgorithm pkix.AlgorithmIdentifier
+			NameHash      []byte
+			IssuerKeyHash []byte
+			SerialNumber  *big.Int
+		}
+	}
+	if _, err := asn1.Unmarshal(ocspReq.TBSRequest.RequestList[0].FullBytes, &parsed); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write(r.response(time.Now(), parsed.Cert.SerialNumber, ocspReq.TBSRequest.Extensions))
+}
+
+func (r *testOCSPResponder) response(now time.Time, serial *big.Int, exts []pkix.Extension) []byte {
+	tmpl := ocsp.Response{
+		Status:       int(atomic.LoadInt32(&r.status)),
+		SerialNumber: serial,
+		ThisUpdate:   now.Add(-time.Minute),
+		NextUpdate:   now.Add(time.Hour),
+	}
+	if tmpl.Status == ocsp.Revoked {
+		tmpl.RevokedAt = now.Add(-time.Minute)
+	}
+	for _, ext := range exts {
+		if ext.Id.Equal(oidOCSPNonce) {
+			if atomic.LoadInt32(&r.badNonce) != 0 {
+				ext.Value = []byte{4, 1, 0}
+			}
+			tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, ext)
+		}
+	}
+	der, err := ocsp.CreateResponse(r.ca.Leaf, r.ca.Leaf, tmpl, r.ca.PrivateKey.(crypto.Signer))
+	if err != nil {
+		panic(err)
+	}
+	return der
+}
+
+// issueTestOCSPLeaf returns a certificate issued by ca with an OCSP responder of server.
+func issueTestOCSPLeaf(t *testing.T, ca *tls.Certificate, server string) *tls.Certificate {
+	t.Helper()
+	return issueTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "example.com"},
+		DNSNames:    []string{"example.com"},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:  []string{server},
+	}, ca)
+}
+
+func TestOCSPChecker(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(2*time.Hour))
+	responder := &testOCSPResponder{ca: ca, status: ocsp.Good}
+	srv := httptest.NewServer(responder)
+	defer srv.Close()
+	leaf := issueTestOCSPLeaf(t, ca, srv.URL)
+
+	c, err := NewOCSPChecker(WithOCSPPolicy(RevocationHardFail), WithOCSPNonce())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(WithOCSPChecker(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf.Leaf, ca.Leaf}}}
+	for i := 0; i < 2; i++ {
+		if err := cfg.VerifyConnection(cs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&responder.queries); n != 1 {
+		t.Fatalf("expected cached response, got %d queries", n)
+	}
+
+	revoked := issueTestOCSPLeaf(t, ca, srv.URL)
+	atomic.StoreInt32(&responder.status, ocsp.Revoked)
+	cs.VerifiedChains[0][0] = revoked.Leaf
+	if err := cfg.VerifyConnection(cs); errors.Cause(err) != ErrCertificateRevoked {
+		t.Fatalf("expected ErrCertificateRevoked, got %v", err)
+	}
+
+	mismatch := issueTestOCSPLeaf(t, ca, srv.URL)
+	atomic.StoreInt32(&responder.status, ocsp.Good)
+	atomic.StoreInt32(&responder.badNonce, 1)
+	cs.VerifiedChains[0][0] = mismatch.Leaf
+	if err := cfg.VerifyConnection(cs); err == nil {
+		t.Fatal("expected nonce mismatch to fail")
+	}
+
+	// A stapled response is used without querying.
+	stapled := issueTestOCSPLeaf(t, ca, srv.URL)
+	queries := atomic.LoadInt32(&responder.queries)
+	atomic.StoreInt32(&responder.status, ocsp.Revoked)
+	cs = tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{stapled.Leaf, ca.Leaf}},
+		OCSPResponse:   responder.response(now, stapled.Leaf.SerialNumber, nil),
+	}
+	if err := cfg.VerifyConnection(cs); errors.Cause(err) != ErrCertificateRevoked {
+		t.Fatalf("expected stapled revocation, got %v", err)
+	}
+	if atomic.LoadInt32(&responder.queries) != queries {
+		t.Fatal("responder queried despite staple")
+	}
+}
+
+func TestOCSPCheckerUnavailable(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	leaf := issueTestOCSPLeaf(t, ca, srv.URL)
+	cs := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf.Leaf, ca.Leaf}}}
+
+	soft, err := NewOCSPChecker(WithOCSPTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := soft.VerifyConnection(cs); err != nil {
+		t.Fatalf("soft fail rejected: %v", err)
+	}
+	hard, err := NewOCSPChecker(WithOCSPPolicy(RevocationHardFail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := hard.VerifyConnection(cs); err == nil {
+		t.Fatal("expected hard fail")
+	}
+}
@@ -0,0 +1,77 @@
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+)
+
+// ErrPinMismatch is returned by peer verification when no certificate matches a pinned key.
+var ErrPinMismatch = errors.New("tlsutil: peer public key not pinned")
+
+// SPKIPin returns the pin of cert's public key, the SHA-256 of its SubjectPublicKeyInfo, as used by HPKP.
+func SPKIPin(cert *x509.Certificate) [sha256.Size]byte {
+	return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+}
+
+// ParseSPKIPin parses a base64 encoded pin, as in HPKP's pin-sha256 directive or the output of
+// openssl x509 -pubkey | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64.
+func ParseSPKIPin(s string) ([sha256.Size]byte, error) {
+	var pin [sha256.Size]byte
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return pin, errors.Wrap(err, "invalid pin")
+	}
+	if len(b) != sha256.Size {
+		return pin, errors.Errorf("invalid pin length %d", len(b))
+	}
+	copy(pin[:], b)
+	return pin, nil
+}
+
+// WithPinnedPeerKeys configures TLS to only accept peers whose verified chain includes a certificate with one of the
+// pinned public keys, as HPKP. Include backup pins, of keys not yet in use, so rotating to them doesn't lock out
+// clients. If chain verification is skipped, only the leaf, whose key the handshake proves possession of, is
+// matched.
+func WithPinnedPeerKeys(pins ...[sha256.Size]byte) Option {
+	return func(cfg *tls.Config) error {
+		if len(pins) == 0 {
+			return errors.New("no pinned keys")
+		}
+		set := make(map[[sha256.Size]byte]bool, len(pins))
+		for _, pin := range pins {
+			set[pin] = true
+		}
+		appendVerifyPeerCertificate(cfg, func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			return verifyPinnedKeys(set, rawCerts, verifiedChains)
+		})
+		return nil
+	}
+}
+
+func verifyPinnedKeys(pins map[[sha256.Size]byte]bool, rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 {
+		if len(rawCerts) == 0 {
+			return ErrPinMismatch
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return errors.Wrap(err, "failed to parse peer certificate")
+		}
+		if pins[SPKIPin(leaf)] {
+			return nil
+		}
+		return ErrPinMismatch
+	}
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if pins[SPKIPin(cert)] {
+				return nil
+			}
+		}
+	}
+	return ErrPinMismatch
+}
@@ -0,0 +1,58 @@
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestPinnedPeerKeys(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	leaf := newTestCertificate(t, "example.com", ca, now.Add(-time.Hour), now.Add(time.Hour))
+	other := newTestCertificate(t, "other", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	var backup [sha256.Size]byte
+	backup[0] = 1
+
+	pin := SPKIPin(ca.Leaf)
+	caPin, err := ParseSPKIPin(base64.StdEncoding.EncodeToString(pin[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(WithPinnedPeerKeys(caPin, backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains := [][]*x509.Certificate{{leaf.Leaf, ca.Leaf}}
+	if err := cfg.VerifyPeerCertificate(leaf.Certificate, chains); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.VerifyPeerCertificate(other.Certificate, [][]*x509.Certificate{{other.Leaf}}); err != ErrPinMismatch {
+		t.Fatalf("expected ErrPinMismatch, got %v", err)
+	}
+	// Without verified chains only the leaf counts, a presented CA certificate is not proof of anything.
+	if err := cfg.VerifyPeerCertificate(leaf.Certificate, nil); err != ErrPinMismatch {
+		t.Fatalf("expected ErrPinMismatch for unverified chain, got %v", err)
+	}
+	leafPinned, err := NewTLSConfig(WithPinnedPeerKeys(SPKIPin(leaf.Leaf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leafPinned.VerifyPeerCertificate(leaf.Certificate, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Options compose rather than replace each other.
+	both, err := NewTLSConfig(WithPinnedPeerKeys(caPin), WithPinnedPeerKeys(SPKIPin(leaf.Leaf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := both.VerifyPeerCertificate(leaf.Certificate, chains); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseSPKIPin("AAAA"); err == nil {
+		t.Fatal("expected error for short pin")
+	}
+}
@@ -0,0 +1,67 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// WithPKCS12 loads the leaf certificate, private key and any intermediates from a PKCS#12 (.pfx/.p12) file, and
+// append to tls.Config's Certificates. Both legacy 3DES/RC2 and modern AES (PBES2) encrypted bundles, as produced by
+// OpenSSL 3 and current Windows, are supported.
+func WithPKCS12(path string, password string) Option {
+	return func(cfg *tls.Config) error {
+		pfx, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "failed to read PKCS#12")
+		}
+		key, leaf, caCerts, err := pkcs12.DecodeChain(pfx, password)
+		if err != nil {
+			return errors.Wrap(err, "failed to decode PKCS#12")
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return errors.Wrap(err, "unsupported PKCS#12 private key")
+		}
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+		var certPEM []byte
+		for _, cert := range orderChain(leaf, caCerts) {
+			certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+		}
+		return WithKeyPairPEM(certPEM, keyPEM)(cfg)
+	}
+}
+
+// orderChain returns leaf followed by each issuer in turn from certs. Certificates not part of the leaf's chain are
+// appended at the end.
+func orderChain(leaf *x509.Certificate, certs []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{leaf}
+	used := make([]bool, len(certs))
+	for c := leaf; ; {
+		next := -1
+		for i, o := range certs {
+			if !used[i] && !bytes.Equal(o.Raw, c.Raw) && bytes.Equal(c.RawIssuer, o.RawSubject) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		used[next] = true
+		c = certs[next]
+		chain = append(chain, c)
+	}
+	for i, c := range certs {
+		if !used[i] {
+			chain = append(chain, c)
+		}
+	}
+	return chain
+}
@@ -0,0 +1,66 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestPKCS12(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	root := newTestCertificate(t, "root", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	inter := issueTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "intermediate"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root)
+	leaf := newTestCertificate(t, "example.com", inter, now.Add(-time.Hour), now.Add(time.Hour))
+
+	for _, tt := range []struct {
+		name    string
+		encoder *pkcs12.Encoder
+	}{
+		{"modern.p12", pkcs12.Modern},
+		{"legacy.p12", pkcs12.LegacyRC2},
+	} {
+		// Issuers out of order, as some tools write them.
+		pfx, err := tt.encoder.Encode(leaf.PrivateKey, leaf.Leaf, []*x509.Certificate{root.Leaf, inter.Leaf}, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, tt.name)
+		if err := ioutil.WriteFile(path, pfx, 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := NewTLSConfig(WithPKCS12(path, "secret"))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := []*tls.Certificate{leaf, inter, root}
+		if got := cfg.Certificates[0].Certificate; len(got) != len(want) {
+			t.Fatalf("%s: expected chain of %d, got %d", tt.name, len(want), len(got))
+		}
+		for i, c := range want {
+			if !bytes.Equal(cfg.Certificates[0].Certificate[i], c.Leaf.Raw) {
+				t.Errorf("%s: certificate %d of chain out of order", tt.name, i)
+			}
+		}
+		if _, err := NewTLSConfig(WithPKCS12(path, "wrong")); err == nil {
+			t.Fatalf("%s: expected error for wrong password", tt.name)
+		}
+	}
+	if _, err := NewTLSConfig(WithPKCS12(filepath.Join(dir, "missing.p12"), "secret")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
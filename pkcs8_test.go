@@ -0,0 +1,115 @@
+package tlsutil
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"testing"
+)
+
+func passphrase(p string) func() ([]byte, error) {
+	return func() ([]byte, error) { return []byte(p), nil }
+}
+
+func TestWithEncryptedKeyPair(t *testing.T) {
+	for _, keyFile := range []string{
+		"testdata/key-pkcs8-sha1.pem",
+		"testdata/key-pkcs8-sha256.pem",
+		"testdata/key-pkcs8-aes128.pem",
+		"testdata/key-traditional.pem",
+	} {
+		t.Run(keyFile, func(t *testing.T) {
+			cfg, err := NewTLSConfig(WithEncryptedKeyPair("testdata/cert.pem", keyFile, passphrase("secret")))
+			if err != nil {
+				t.Fatalf("expected key to decrypt: %v", err)
+			}
+			want, err := tls.LoadX509KeyPair("testdata/cert.pem", "testdata/key.pem")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := cfg.Certificates[0].PrivateKey.(interface{ Equal(crypto.PrivateKey) bool })
+			if !got.Equal(want.PrivateKey) {
+				t.Fatal("decrypted key differs from plaintext key")
+			}
+
+			if _, err := NewTLSConfig(WithEncryptedKeyPair("testdata/cert.pem", keyFile, passphrase("wrong"))); err == nil {
+				t.Fatal("expected wrong passphrase to fail")
+			}
+		})
+	}
+}
+
+func TestDecryptPKCS8WrongPassphrase(t *testing.T) {
+	der := encryptedKeyDER(t, "testdata/key-pkcs8-sha256.pem")
+	if _, err := decryptPKCS8(der, []byte("wrong")); err != x509.IncorrectPasswordError {
+		t.Fatalf("expected IncorrectPasswordError, got %v", err)
+	}
+}
+
+func TestDecryptPKCS8RejectsBadParameters(t *testing.T) {
+	der := encryptedKeyDER(t, "testdata/key-pkcs8-sha256.pem")
+
+	for name, mutate := range map[string]func(*pbkdf2Params){
+		"zero iterations": func(p *pbkdf2Params) { p.Iterations = 0 },
+		"huge iterations": func(p *pbkdf2Params) { p.Iterations = maxPBKDF2Iterations + 1 },
+		"key length":      func(p *pbkdf2Params) { p.KeyLength = 16 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decryptPKCS8(mutatePBKDF2(t, der, mutate), []byte("secret")); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func encryptedKeyDER(t *testing.T, path string) []byte {
+	t.Helper()
+	keyPEM, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("%s: expected ENCRYPTED PRIVATE KEY", path)
+	}
+	return block.Bytes
+}
+
+// mutatePBKDF2 re-encodes der with the PBKDF2 parameters modified by fn.
+func mutatePBKDF2(t *testing.T, der []byte, fn func(*pbkdf2Params)) []byte {
+	t.Helper()
+	var info encryptedPrivateKeyInfo
+	var params pbes2Params
+	var kdf pbkdf2Params
+
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		t.Fatal(err)
+	}
+	fn(&kdf)
+	params.KeyDerivationFunc.Parameters = marshalRaw(t, kdf)
+	info.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: marshalRaw(t, params)}
+	b, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func marshalRaw(t *testing.T, v interface{}) asn1.RawValue {
+	t.Helper()
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return asn1.RawValue{FullBytes: b}
+}
@@ -0,0 +1,46 @@
+package tlsutil
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// placeholderLifetime is the validity of placeholder certificates.
+const placeholderLifetime = 365 * 24 * time.Hour
+
+// NewPlaceholderCertificate returns a self signed certificate of key type t naming no hosts, to serve handshakes for
+// unknown server names without revealing any real certificate. Clients will fail to verify it.
+func NewPlaceholderCertificate(t KeyType) (*tls.Certificate, error) {
+	key, err := t.generate()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate placeholder key")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate serial number")
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "invalid"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(placeholderLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create placeholder certificate")
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse placeholder certificate")
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,304 @@
+package tlsutil
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// proxyHeaderTimeout is how long a connection is given to send its PROXY protocol header by default.
+	proxyHeaderTimeout = 5 * time.Second
+	// proxyV1MaxLength is the longest a v1 header may be, including the CRLF.
+	proxyV1MaxLength = 107
+)
+
+// proxyV2Signature begins every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyOption configures a PROXY protocol listener.
+type ProxyOption func(*proxyListener) error
+
+// WithProxyTrustedNetworks only reads PROXY protocol headers from connections from the CIDR ranges given, such as
+// the load balancers' subnets. Other connections are served as they are, their addresses their own. By default
+// every connection must send a header.
+func WithProxyTrustedNetworks(cidrs ...string) ProxyOption {
+	return func(l *proxyListener) error {
+		for _, cidr := range cidrs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return errors.Wrapf(err, "invalid trusted network %q", cidr)
+			}
+			l.trusted = append(l.trusted, n)
+		}
+		return nil
+	}
+}
+
+// WithProxyHeaderOptional serves connections from trusted sources without a PROXY protocol header as they are, rather
+// than failing them, as when health checks connect directly.
+func WithProxyHeaderOptional() ProxyOption {
+	return func(l *proxyListener) error {
+		l.optional = true
+		return nil
+	}
+}
+
+// WithProxyHeaderTimeout sets how long a connection is given to send its header, 5 seconds by default.
+func WithProxyHeaderTimeout(d time.Duration) ProxyOption {
+	return func(l *proxyListener) error {
+		if d <= 0 {
+			return errors.New("PROXY header timeout must be positive")
+		}
+		l.timeout = d
+		return nil
+	}
+}
+
+type proxyListener struct {
+	net.Listener
+	trusted  []*net.IPNet
+	optional bool
+	timeout  time.Duration
+}
+
+// NewProxyProtocolListener wraps ln, such as a TCP listener behind an AWS NLB or HAProxy, to read PROXY protocol v1
+// and v2 headers from connections, so their RemoteAddr and LocalAddr are those of the original client connection.
+// Wrap ln before making a TLS listener of it, as the header precedes the handshake. Headers are read on a
+// connection's first use, not by Accept, so slow clients don't hold up others.
+func NewProxyProtocolListener(ln net.Listener, opts ...ProxyOption) (net.Listener, error) {
+	l := &proxyListener{Listener: ln, timeout: proxyHeaderTimeout}
+	for _, opt := range opts {
+		if err := opt(l); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusts(c.RemoteAddr()) {
+		return c, nil
+	}
+	return &ProxyConn{Conn: c, r: bufio.NewReader(c), optional: l.optional, timeout: l.timeout}, nil
+}
+
+// trusts reports whether headers are read from connections from addr.
+func (l *proxyListener) trusts(addr net.Addr) bool {
+	if len(l.trusted) == 0 {
+		return true
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range l.trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyConn is a connection whose addresses are read from its PROXY protocol header.
+type ProxyConn struct {
+	net.Conn
+	r        *bufio.Reader
+	optional bool
+	timeout  time.Duration
+
+	once     sync.Once
+	src, dst net.Addr
+	err      error
+
+	// deadlineMu guards readDeadline, the deadline set by the connection's user, restored once the header is read.
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+}
+
+// readHeader reads the PROXY protocol header, once.
+func (c *ProxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.src, c.dst, c.err = readProxyHeader(c.r, c.optional)
+		if c.err != nil {
+			c.err = errors.Wrapf(c.err, "invalid PROXY protocol header from %s", c.Conn.RemoteAddr())
+		}
+		c.deadlineMu.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.deadlineMu.Unlock()
+	})
+}
+
+// SetDeadline implements net.Conn, recording the read deadline to restore after reading the header.
+func (c *ProxyConn) SetDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn, recording the deadline to restore after reading the header.
+func (c *ProxyConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// Read implements net.Conn, reading what follows the header.
+func (c *ProxyConn) Read(b []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the original client, if the header gave one.
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	if c.readHeader(); c.src != nil {
+		return c.src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the original client connected to, if the header gave one.
+func (c *ProxyConn) LocalAddr() net.Addr {
+	if c.readHeader(); c.dst != nil {
+		return c.dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ProxyAddrs returns the original client's and destination's addresses given by the PROXY protocol header of conn,
+// or of the connection underlying a tls.Conn. ok is false if conn had no header giving them.
+func ProxyAddrs(conn net.Conn) (src, dst net.Addr, ok bool) {
+	if tc, isTLS := conn.(*tls.Conn); isTLS {
+		conn = tc.NetConn()
+	}
+	c, isProxy := conn.(*ProxyConn)
+	if !isProxy {
+		return nil, nil, false
+	}
+	if c.readHeader(); c.src == nil {
+		return nil, nil, false
+	}
+	return c.src, c.dst, true
+}
+
+// readProxyHeader reads a v1 or v2 PROXY protocol header from r, returning the addresses it gives, nil for
+// UNKNOWN and LOCAL headers. With optional, a connection not beginning with a header is left unread.
+func readProxyHeader(r *bufio.Reader, optional bool) (src, dst net.Addr, err error) {
+	prefix, err := r.Peek(5)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case bytes.Equal(prefix, []byte("PROXY")):
+		return readProxyV1(r)
+	case bytes.Equal(prefix, proxyV2Signature[:5]):
+		return readProxyV2(r)
+	case optional:
+		return nil, nil, nil
+	}
+	return nil, nil, errors.New("missing header")
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("v1 header too long")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, errors.Errorf("malformed v1 header %q", line)
+	}
+	src, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseProxyV1Addr(ip, port string) (*net.TCPAddr, error) {
+	a := net.ParseIP(ip)
+	if a == nil {
+		return nil, errors.Errorf("invalid address %q", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errors.Errorf("invalid port %q", port)
+	}
+	return &net.TCPAddr{IP: a, Port: int(p)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Signature) {
+		return nil, nil, errors.New("invalid v2 signature")
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, errors.Errorf("unsupported v2 version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, nil, err
+	}
+	switch hdr[12] & 0xf {
+	case 0:
+		// LOCAL, such as the proxy's own health checks.
+		return nil, nil, nil
+	case 1:
+	default:
+		return nil, nil, errors.Errorf("unsupported v2 command %d", hdr[12]&0xf)
+	}
+	var size int
+	switch hdr[13] {
+	case 0x11:
+		size = net.IPv4len
+	case 0x21:
+		size = net.IPv6len
+	default:
+		// Not TCP over IP, so the addresses are left to the connection.
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, errors.New("v2 header too short for its addresses")
+	}
+	src := &net.TCPAddr{IP: net.IP(body[:size]), Port: int(binary.BigEndian.Uint16(body[2*size:]))}
+	dst := &net.TCPAddr{IP: net.IP(body[size : 2*size]), Port: int(binary.BigEndian.Uint16(body[2*size+2:]))}
+	return src, dst, nil
+}
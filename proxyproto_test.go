@@ -0,0 +1,116 @@
+package tlsutil
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(cmd, fam byte, addrs ...byte) string {
+		hdr := append(append([]byte(nil), proxyV2Signature...), 0x20|cmd, fam, 0, byte(len(addrs)))
+		return string(append(hdr, addrs...))
+	}
+	for _, tt := range []struct {
+		name, header string
+		optional     bool
+		src, dst     string
+		ok           bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", false, "192.0.2.1:56324", "198.51.100.1:443", true},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 1 443\r\n", false, "[2001:db8::1]:1", "[2001:db8::2]:443", true},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", false, "", "", true},
+		{"v1 bad port", "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n", false, "", "", false},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n", false, "", "", false},
+		{"v2 tcp4", v2(1, 0x11, 192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb), false, "192.0.2.1:56324", "198.51.100.1:443", true},
+		{"v2 local", v2(0, 0x00), false, "", "", true},
+		{"v2 short", v2(1, 0x11, 192, 0, 2, 1), false, "", "", false},
+		{"missing", "\x16\x03\x01\x00\xff", false, "", "", false},
+		{"optional missing", "\x16\x03\x01\x00\xff", true, "", "", true},
+	} {
+		r := bufio.NewReader(strings.NewReader(tt.header + "rest"))
+		src, dst, err := readProxyHeader(r, tt.optional)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok %v, got %v", tt.name, tt.ok, err)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if got := addrString(src); got != tt.src {
+			t.Errorf("%s: expected source %q, got %q", tt.name, tt.src, got)
+		}
+		if got := addrString(dst); got != tt.dst {
+			t.Errorf("%s: expected destination %q, got %q", tt.name, tt.dst, got)
+		}
+		if rest, _ := r.Peek(4); tt.src != "" && !bytes.Equal(rest, []byte("rest")) {
+			t.Errorf("%s: header not consumed exactly, %q follows", tt.name, rest)
+		}
+	}
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl, err := NewProxyProtocolListener(tcp, WithProxyTrustedNetworks("127.0.0.0/8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := tls.NewListener(pl, &tls.Config{Certificates: []tls.Certificate{*cert}})
+	defer ln.Close()
+
+	addrs := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			addrs <- err.Error()
+			return
+		}
+		defer c.Close()
+		if err := c.(*tls.Conn).Handshake(); err != nil {
+			addrs <- err.Error()
+			return
+		}
+		src, _, ok := ProxyAddrs(c)
+		if !ok {
+			addrs <- "no PROXY addresses"
+			return
+		}
+		addrs <- c.RemoteAddr().String() + " " + src.String()
+	}()
+
+	raw, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 443\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	c := tls.Client(raw, &tls.Config{InsecureSkipVerify: true})
+	defer c.Close()
+	if err := c.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-addrs; got != "192.0.2.1:56324 192.0.2.1:56324" {
+		t.Fatalf("unexpected addresses %q", got)
+	}
+
+	if _, err := NewProxyProtocolListener(tcp, WithProxyTrustedNetworks("not a cidr")); err == nil {
+		t.Fatal("expected error for invalid network")
+	}
+}
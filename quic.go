@@ -0,0 +1,51 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+)
+
+// ALPNHTTP3 is the ALPN protocol of HTTP/3.
+const ALPNHTTP3 = "h3"
+
+// NewQUICTLSConfig returns a new tls.Config for a QUIC server, such as quic-go's http3.Server, with all options applied
+// over TLS 1.3 only and ALPN of h3, then checked to suit QUIC, which requires TLS 1.3 and an ALPN protocol.
+//
+// Certificates from ACME work as over TCP, but ACME's tls-alpn-01 challenges can't be answered over QUIC, so
+// the TCP listener, or an http-01 server, must answer them. Session ticket keys, and their rotation by
+// WithKeyRotator, apply as they do to TCP servers, sharing a KeyRotator between a TCP and a QUIC config lets
+// sessions resume across both. Configs returned by GetConfigForClient aren't checked, they must also be TLS 1.3.
+func NewQUICTLSConfig(opts ...Option) (*tls.Config, error) {
+	cfg, err := NewTLSConfigFrom(&tls.Config{
+		MinVersion: tls.VersionTLS13,
+		NextProtos: []string{ALPNHTTP3},
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateQUICConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validateQUICConfig checks cfg can be used for QUIC.
+func validateQUICConfig(cfg *tls.Config) error {
+	if cfg.MinVersion < tls.VersionTLS13 {
+		return errors.Errorf("minimum TLS version %#04x is below QUIC's TLS 1.3", cfg.MinVersion)
+	}
+	if cfg.MaxVersion != 0 && cfg.MaxVersion < tls.VersionTLS13 {
+		return errors.Errorf("maximum TLS version %#04x is below QUIC's TLS 1.3", cfg.MaxVersion)
+	}
+	if len(cfg.NextProtos) == 0 {
+		return errors.New("QUIC requires an ALPN protocol")
+	}
+	for _, proto := range cfg.NextProtos {
+		switch proto {
+		case "h2", "http/1.1", acmeTLSALPNProto:
+			return errors.Errorf("ALPN protocol %q is only used over TCP", proto)
+		}
+	}
+	return nil
+}
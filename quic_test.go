@@ -0,0 +1,30 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestNewQUICTLSConfig(t *testing.T) {
+	cfg, err := NewQUICTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 || len(cfg.NextProtos) != 1 || cfg.NextProtos[0] != ALPNHTTP3 {
+		t.Fatalf("unexpected QUIC defaults %+v", cfg)
+	}
+	if _, err := NewQUICTLSConfig(WithNextProtos("h3", "doq")); err != nil {
+		t.Fatal(err)
+	}
+	for name, opt := range map[string]Option{
+		"TLS 1.2":     WithTLS12(),
+		"max TLS 1.2": WithMaxVersion(tls.VersionTLS12),
+		"no ALPN":     WithoutNextProtos(ALPNHTTP3),
+		"h2":          WithNextProtos("h3", "h2"),
+		"tls-alpn-01": WithNextProtos("h3", acmeTLSALPNProto),
+	} {
+		if _, err := NewQUICTLSConfig(opt); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Package redisticket shares TLS session ticket keys across a fleet through Redis, so tickets issued by one instance
+// can be resumed on any other.
+package redisticket
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/renthraysk/tlsutil"
+	"github.com/renthraysk/tlsutil/internal/ticketkeys"
+)
+
+const (
+	// leaseTTL is how long the generating instance's lease lasts without renewal.
+	leaseTTL = 30 * time.Second
+	// pollInterval is how often the lease is renewed, and keys reloaded should a published update be missed.
+	pollInterval = leaseTTL / 3
+)
+
+// backend is the subset of Redis operations a Rotator requires.
+type backend interface {
+	// lease acquires or renews the generator lease for id, reporting whether id holds it.
+	lease(ctx context.Context, id string, ttl time.Duration) (bool, error)
+	// load returns the stored key ring, or nil if there is none.
+	load(ctx context.Context) ([]byte, error)
+	// store saves and publishes the key ring only if id still holds the lease, reporting whether it did so.
+	store(ctx context.Context, id string, data []byte) (bool, error)
+	// subscribe returns published key rings until ctx is done.
+	subscribe(ctx context.Context) (<-chan []byte, error)
+}
+
+// Rotator distributes session ticket keys through Redis. Whichever instance holds a lease generates a new key every
+// interval, publishing the ring encrypted with a shared AEAD. Every instance, including the generator, applies
+// published rings to its tls.Configs. Until the first ring is loaded configs use Go's own per-process keys.
+type Rotator struct {
+	// OnError, if set, is called with errors communicating with Redis, and Run continues. Otherwise Run returns them.
+	OnError func(error)
+
+	backend  backend
+	prefix   string
+	aead     cipher.AEAD
+	n        int
+	duration time.Duration
+	id       string
+	configs  ticketkeys.Configs
+}
+
+// New returns a Rotator storing keys in client beneath prefix, accepting tickets encrypted with the last n keys and
+// rotating every d. aead must be shared by all instances, and protects keys at rest and in transit.
+func New(client *redis.Client, prefix string, aead cipher.AEAD, n int, d time.Duration) (*Rotator, error) {
+	return newRotator(redisBackend{client: client, prefix: prefix}, prefix, aead, n, d)
+}
+
+func newRotator(b backend, prefix string, aead cipher.AEAD, n int, d time.Duration) (*Rotator, error) {
+	if aead == nil {
+		return nil, errors.New("redisticket: AEAD required")
+	}
+	if n < 1 || n > 255 {
+		return nil, errors.New("redisticket: key count must be between 1 and 255")
+	}
+	if d < leaseTTL {
+		return nil, errors.Errorf("redisticket: rotation interval must be at least %v", leaseTTL)
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	return &Rotator{
+		backend:  b,
+		prefix:   prefix,
+		aead:     aead,
+		n:        n,
+		duration: d,
+		id:       hex.EncodeToString(id[:]),
+	}, nil
+}
+
+// WithSessionTicketKeys configures TLS to use session ticket keys distributed by r.
+func WithSessionTicketKeys(r *Rotator) tlsutil.Option {
+	return func(cfg *tls.Config) error {
+		r.configs.Add(cfg)
+		return nil
+	}
+}
+
+// Run distributes keys until ctx is cancelled.
+func (r *Rotator) Run(ctx context.Context) error {
+	updates, err := r.backend.subscribe(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to subscribe to session ticket keys")
+	}
+	if err := r.reload(ctx); err != nil && r.OnError == nil {
+		return err
+	} else if err != nil {
+		r.OnError(err)
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.tick(ctx); err != nil && ctx.Err() == nil {
+			if r.OnError == nil {
+				return err
+			}
+			r.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case data, ok := <-updates:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return errors.New("redisticket: subscription closed")
+			}
+			if err := r.apply(data); err != nil {
+				if r.OnError == nil {
+					return err
+				}
+				r.OnError(err)
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick renews the generator lease, rotating if due, otherwise reloads keys in case a published update was missed.
+func (r *Rotator) tick(ctx context.Context) error {
+	leader, err := r.backend.lease(ctx, r.id, leaseTTL)
+	if err != nil {
+		return errors.Wrap(err, "failed to acquire session ticket key lease")
+	}
+	if !leader {
+		return r.reload(ctx)
+	}
+	// A new generator continues from the stored ring, so earlier keys remain valid.
+	if err := r.reload(ctx); err != nil {
+		return err
+	}
+	if ring := r.configs.Ring(); len(ring.Keys) > 0 && time.Since(ring.RotatedAt) < r.duration {
+		return nil
+	}
+	return r.rotate(ctx)
+}
+
+// rotate publishes a ring with a new key, provided r still holds the lease.
+func (r *Rotator) rotate(ctx context.Context) error {
+	ring, err := r.configs.Ring().Rotate(r.n, time.Now())
+	if err != nil {
+		return err
+	}
+	data, err := ticketkeys.Seal(r.aead, []byte(r.prefix), ring)
+	if err != nil {
+		return err
+	}
+	stored, err := r.backend.store(ctx, r.id, data)
+	if err != nil {
+		return errors.Wrap(err, "failed to publish session ticket keys")
+	}
+	if stored {
+		// Otherwise lost the lease since checking, the new generator publishes instead.
+		r.configs.Apply(ring)
+	}
+	return nil
+}
+
+func (r *Rotator) reload(ctx context.Context) error {
+	data, err := r.backend.load(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to load session ticket keys")
+	}
+	if data == nil {
+		return nil
+	}
+	return r.apply(data)
+}
+
+// apply installs a published ring, ignoring any older than the current.
+func (r *Rotator) apply(data []byte) error {
+	ring, err := ticketkeys.Open(r.aead, []byte(r.prefix), data)
+	if err != nil {
+		return errors.Wrap(err, "redisticket")
+	}
+	r.configs.Apply(ring)
+	return nil
+}
+
+// storeScript saves and publishes the ring only whilst the caller holds the lease, fencing off a previous generator
+// that has yet to notice its lease expired.
+var storeScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[2])
+redis.call("PUBLISH", KEYS[2], ARGV[2])
+return 1
+`)
+
+// renewScript extends the lease only if held by the caller.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// redisBackend stores the lease at prefix+"lease", and publishes rings to and stores them at prefix+"keys".
+type redisBackend struct {
+	client *redis.Client
+	prefix string
+}
+
+func (b redisBackend) lease(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	ok, err := b.client.SetNX(ctx, b.prefix+"lease", id, ttl).Result()
+	if err != nil || ok {
+		return ok, err
+	}
+	n, err := renewScript.Run(ctx, b.client, []string{b.prefix + "lease"}, id, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (b redisBackend) load(ctx context.Context) ([]byte, error) {
+	data, err := b.client.Get(ctx, b.prefix+"keys").Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (b redisBackend) store(ctx context.Context, id string, data []byte) (bool, error) {
+	n, err := storeScript.Run(ctx, b.client, []string{b.prefix + "lease", b.prefix + "keys"}, id, data).Int()
+	return n == 1, err
+}
+
+func (b redisBackend) subscribe(ctx context.Context) (<-chan []byte, error) {
+	ps := b.client.Subscribe(ctx, b.prefix+"keys")
+	// Wait for confirmation, so updates published after subscribe returns are not missed.
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		defer ps.Close()
+		msgs := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				select {
+				case out <- []byte(msg.Payload):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,142 @@
+package redisticket
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/tls"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memBackend is an in memory backend, with a lease that can be expired on demand.
+type memBackend struct {
+	mu     sync.Mutex
+	holder string
+	data   []byte
+	subs   []chan []byte
+}
+
+func (b *memBackend) lease(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.holder == "" {
+		b.holder = id
+	}
+	return b.holder == id, nil
+}
+
+func (b *memBackend) expire() {
+	b.mu.Lock()
+	b.holder = ""
+	b.mu.Unlock()
+}
+
+func (b *memBackend) load(ctx context.Context) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data, nil
+}
+
+func (b *memBackend) store(ctx context.Context, id string, data []byte) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.holder != id {
+		return false, nil
+	}
+	b.data = data
+	for _, c := range b.subs {
+		select {
+		case c <- data:
+		default:
+		}
+	}
+	return true, nil
+}
+
+func (b *memBackend) subscribe(ctx context.Context) (<-chan []byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := make(chan []byte, 16)
+	b.subs = append(b.subs, c)
+	return c, nil
+}
+
+func newTestAEAD(t *testing.T) cipher.AEAD {
+	t.Helper()
+	block, err := aes.NewCipher(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func TestRotatorSharesKeys(t *testing.T) {
+	ctx := context.Background()
+	b := &memBackend{}
+	aead := newTestAEAD(t)
+	a, err := newRotator(b, "t/", aead, 3, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newRotator(b, "t/", aead, 3, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WithSessionTicketKeys(c)(&tls.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a takes the lease and generates, c follows.
+	if err := a.tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	first := a.configs.Ring()
+	if got := c.configs.Ring(); len(got.Keys) != 1 || got.Keys[0] != first.Keys[0] {
+		t.Fatal("follower did not load generator's key")
+	}
+
+	// Lease moves to c, which continues the ring from a's keys.
+	b.expire()
+	if ok, _ := b.lease(ctx, c.id, leaseTTL); !ok {
+		t.Fatal("lease not acquired")
+	}
+	if err := c.rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	second := c.configs.Ring()
+	if len(second.Keys) != 2 || second.Keys[1] != first.Keys[0] || second.Generation != 2 {
+		t.Fatalf("new generator did not continue the ring: %d keys, generation %d", len(second.Keys), second.Generation)
+	}
+
+	// a, no longer holding the lease, can not publish.
+	if err := a.rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.configs.Ring(); got.Generation != 2 || got.Keys[0] != second.Keys[0] {
+		t.Fatal("fenced generator's keys were published")
+	}
+}
+
+func TestRotatorValidation(t *testing.T) {
+	if _, err := newRotator(&memBackend{}, "t/", nil, 2, time.Hour); err == nil {
+		t.Fatal("expected error without AEAD")
+	}
+	if _, err := newRotator(&memBackend{}, "t/", newTestAEAD(t), 2, time.Second); err == nil {
+		t.Fatal("expected error for interval shorter than lease")
+	}
+	if _, err := newRotator(&memBackend{}, "t/", newTestAEAD(t), 0, time.Hour); err == nil {
+		t.Fatal("expected error for empty ring")
+	}
+}
@@ -0,0 +1,228 @@
+package tlsutil
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReloadOption configures a CertReloader.
+type ReloadOption func(*CertReloader) error
+
+// WithReloadErrorHandler sets a function called with every failed reload. The previous certificate continues to be
+// served.
+func WithReloadErrorHandler(fn func(error)) ReloadOption {
+	return func(r *CertReloader) error {
+		r.watcher.onError = fn
+		return nil
+	}
+}
+
+// WithReloadCallback registers fn to be called after the certificate is replaced by one with different content, with
+// the old and new leaf certificates. For logging, metrics, or draining connections made with the old certificate.
+func WithReloadCallback(fn func(old, new *x509.Certificate)) ReloadOption {
+	return func(r *CertReloader) error {
+		r.onReload = append(r.onReload, fn)
+		return nil
+	}
+}
+
+// WithReloadPolling checks the files for changes every interval, by content hash, instead of relying on file system
+// notifications. For NFS or similar mounts where inotify events are unreliable.
+func WithReloadPolling(interval time.Duration) ReloadOption {
+	return func(r *CertReloader) error {
+		if interval <= 0 {
+			return errors.New("reload polling interval must be positive")
+		}
+		r.watcher.poll = interval
+		return nil
+	}
+}
+
+// WithReloadStore additionally sets each loaded certificate in store under name, so several reloaders can feed one
+// CertStore.
+func WithReloadStore(store *CertStore, name string) ReloadOption {
+	return func(r *CertReloader) error {
+		if store == nil {
+			return errors.New("reload store must not be nil")
+		}
+		r.store, r.storeName = store, name
+		return nil
+	}
+}
+
+// CertReloader serves a certificate loaded from a certFile, keyFile pair, reloading it when either changes.
+type CertReloader struct {
+	certFile, keyFile string
+	onReload          []func(old, new *x509.Certificate)
+	store             *CertStore
+	storeName         string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	sum  [sha256.Size]byte
+
+	watcher fileWatcher
+}
+
+// NewCertReloader returns a CertReloader having loaded the certificate in certFile, keyFile. Call Watch to reload as
+// they change.
+func NewCertReloader(certFile, keyFile string, opts ...ReloadOption) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	r.watcher.files = []string{certFile, keyFile}
+	r.watcher.reload, r.watcher.changed = r.Reload, r.changed
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// WithCertReloader configures TLS to serve the certificate of r.
+func WithCertReloader(r *CertReloader) Option {
+	return func(cfg *tls.Config) error {
+		return setGetCertificate(cfg, r.GetCertificate)
+	}
+}
+
+// WithKeyPairReload configures TLS to serve the certificate in certFile, keyFile, reloading it whenever either
+// changes for the life of the process, watching from the first handshake. Failures to watch are reported to the
+// reload error handler. To stop watching, use WithCertReloader and close the CertReloader, such as by WithRunClosers.
+func WithKeyPairReload(certFile, keyFile string, opts ...ReloadOption) Option {
+	return func(cfg *tls.Config) error {
+		r, err := NewCertReloader(certFile, keyFile, opts...)
+		if err != nil {
+			return err
+		}
+		watch := r.watcher.startOnFirstUse()
+		return setGetCertificate(cfg, func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			watch()
+			return r.GetCertificate(hello)
+		})
+	}
+}
+
+// WithClientCertReloader configures TLS to present the certificate of r as the client certificate.
+func WithClientCertReloader(r *CertReloader) Option {
+	return func(cfg *tls.Config) error {
+		return setGetClientCertificate(cfg, r.GetClientCertificate)
+	}
+}
+
+// WithClientKeyPairReload configures TLS to present the client certificate in certFile, keyFile, reloading it
+// whenever either changes for the life of the process, watching from the first handshake as WithKeyPairReload does.
+// New handshakes, including those of long lived clients reconnecting, pick up the rotated certificate.
+func WithClientKeyPairReload(certFile, keyFile string, opts ...ReloadOption) Option {
+	return func(cfg *tls.Config) error {
+		r, err := NewCertReloader(certFile, keyFile, opts...)
+		if err != nil {
+			return err
+		}
+		watch := r.watcher.startOnFirstUse()
+		return setGetClientCertificate(cfg, func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			watch()
+			return r.GetClientCertificate(info)
+		})
+	}
+}
+
+// Certificate returns the current certificate.
+func (r *CertReloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// GetCertificate implements tls.Config's GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// GetClientCertificate implements tls.Config's GetClientCertificate.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// readFiles returns the contents of the certificate and key files, and their hash.
+func (r *CertReloader) readFiles() (certPEM, keyPEM []byte, sum [sha256.Size]byte, err error) {
+	if certPEM, err = ioutil.ReadFile(r.certFile); err != nil {
+		return nil, nil, sum, errors.Wrap(err, "failed to read certificate")
+	}
+	if keyPEM, err = ioutil.ReadFile(r.keyFile); err != nil {
+		return nil, nil, sum, errors.Wrap(err, "failed to read key")
+	}
+	h := sha256.New()
+	h.Write(certPEM)
+	h.Write(keyPEM)
+	copy(sum[:], h.Sum(nil))
+	return certPEM, keyPEM, sum, nil
+}
+
+// Reload loads the certificate files, replacing the current certificate only if they parse and pass
+// ValidateCertificate.
+func (r *CertReloader) Reload() error {
+	certPEM, keyPEM, sum, err := r.readFiles()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return errors.Wrap(err, "failed to reload keypair")
+	}
+	if err := ValidateCertificate(&cert, time.Now()); err != nil {
+		return errors.Wrap(err, "invalid reloaded certificate")
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return errors.Wrap(err, "failed to parse reloaded certificate")
+		}
+	}
+	r.mu.Lock()
+	if r.store != nil {
+		if err := r.store.SetCertificate(r.storeName, &cert); err != nil {
+			r.mu.Unlock()
+			return err
+		}
+	}
+	old, changed := r.cert, sum != r.sum
+	r.cert, r.sum = &cert, sum
+	r.mu.Unlock()
+	if old != nil && changed {
+		for _, fn := range r.onReload {
+			fn(old.Leaf, cert.Leaf)
+		}
+	}
+	return nil
+}
+
+// Watch reloads the certificate in the background whenever its files change, until Close. Directories are watched
+// rather than the files themselves, so files replaced by rename, or Kubernetes' symlink swapping, are followed. With
+// WithReloadPolling the files are polled instead.
+func (r *CertReloader) Watch() error {
+	return r.watcher.start()
+}
+
+// changed reports whether the files' contents differ from those last loaded.
+func (r *CertReloader) changed() (bool, error) {
+	_, _, sum, err := r.readFiles()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return sum != r.sum, nil
+}
+
+// Close stops watching.
+func (r *CertReloader) Close() error {
+	return r.watcher.close()
+}
@@ -0,0 +1,305 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes cert as PEM encoded certificate and key files in dir.
+func writeKeyPair(t *testing.T, dir string, cert *tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	var certPEM []byte
+	for _, der := range cert.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, keyFile := writeKeyPair(t, dir, first)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(WithCertReloader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := cfg.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Leaf.SerialNumber.Cmp(first.Leaf.SerialNumber) != 0 {
+		t.Fatal("initial certificate not served")
+	}
+
+	second := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	writeKeyPair(t, dir, second)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Certificate().Leaf.SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Fatal("reloaded certificate not served")
+	}
+
+	// A broken reload keeps the previous certificate.
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected reload to fail without key")
+	}
+	if r.Certificate().Leaf.SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Fatal("failed reload replaced certificate")
+	}
+
+	if err := r.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloaderPolling(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, keyFile := writeKeyPair(t, dir, first)
+
+	r, err := NewCertReloader(certFile, keyFile, WithReloadPolling(5*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	second := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	writeKeyPair(t, dir, second)
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Certificate().Leaf.SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("polling did not pick up the new certificate")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewCertReloader(certFile, keyFile, WithReloadPolling(0)); err == nil {
+		t.Fatal("expected error for zero polling interval")
+	}
+}
+
+func TestKeyPairReload(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, keyFile := writeKeyPair(t, dir, first)
+
+	cfg, err := NewTLSConfig(WithKeyPairReload(certFile, keyFile, WithReloadPolling(5*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func() *big.Int {
+		got, err := cfg.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.Leaf.SerialNumber
+	}
+
+	// Changes before the first handshake, with nothing yet watching, are picked up by it.
+	second := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	writeKeyPair(t, dir, second)
+	if serial().Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Fatal("first handshake did not pick up the changed certificate")
+	}
+
+	// Then watched.
+	third := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	writeKeyPair(t, dir, third)
+	deadline := time.Now().Add(5 * time.Second)
+	for serial().Cmp(third.Leaf.SerialNumber) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := NewTLSConfig(WithKeyPairReload(filepath.Join(dir, "missing.pem"), keyFile)); err == nil {
+		t.Fatal("expected error for missing certificate")
+	}
+}
+
+func TestCertReloaderCallback(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, keyFile := writeKeyPair(t, dir, first)
+
+	var calls int
+	var gotOld, gotNew *x509.Certificate
+	r, err := NewCertReloader(certFile, keyFile, WithReloadCallback(func(old, new *x509.Certificate) {
+		calls++
+		gotOld, gotNew = old, new
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Fatal("callback called for initial load")
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 0 {
+		t.Fatal("callback called for unchanged files")
+	}
+
+	second := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	writeKeyPair(t, dir, second)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 callback, got %d", calls)
+	}
+	if gotOld.SerialNumber.Cmp(first.Leaf.SerialNumber) != 0 || gotNew.SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Fatal("callback given wrong certificates")
+	}
+}
+
+func TestCertReloaderValidation(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, keyFile := writeKeyPair(t, dir, first)
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := newTestCertificate(t, "example.com", nil, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	writeKeyPair(t, dir, expired)
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected reload of expired certificate to fail")
+	}
+	if r.Certificate().Leaf.SerialNumber.Cmp(first.Leaf.SerialNumber) != 0 {
+		t.Fatal("expired certificate replaced current")
+	}
+}
+
+func TestCertReloaderPartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, keyFile := writeKeyPair(t, dir, first)
+
+	errs := make(chan error, 10)
+	r, err := NewCertReloader(certFile, keyFile, WithReloadPolling(5*time.Millisecond),
+		WithReloadErrorHandler(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Write a truncated certificate, then complete it after the first reload attempt.
+	second := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	sub := t.TempDir()
+	newCert, newKey := writeKeyPair(t, sub, second)
+	certPEM, err := ioutil.ReadFile(newCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, certPEM[:len(certPEM)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * reloadDebounce)
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Certificate().Leaf.SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("completed certificate not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected error reported: %v", err)
+	default:
+	}
+}
+
+func TestClientCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	first := newTestCertificate(t, "client", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, keyFile := writeKeyPair(t, dir, first)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(WithClientCertReloader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WithClientCertReloader(r)(cfg); err != ErrGetClientCertificateConflict {
+		t.Fatalf("expected ErrGetClientCertificateConflict, got %v", err)
+	}
+
+	second := newTestCertificate(t, "client", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	writeKeyPair(t, dir, second)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := cfg.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Leaf.SerialNumber.Cmp(second.Leaf.SerialNumber) != 0 {
+		t.Fatal("reloaded client certificate not presented")
+	}
+}
@@ -0,0 +1,80 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+)
+
+// WithRootCAsFromFile appends the CA certificates from PEM files to tls.Config's RootCAs, used to verify server certificates.
+func WithRootCAsFromFile(paths ...string) Option {
+	return func(cfg *tls.Config) error {
+		pool, err := appendPEMFiles(cfg.RootCAs, paths...)
+		if err != nil {
+			return errors.Wrap(err, "failed to load root CAs")
+		}
+		cfg.RootCAs = pool
+		return nil
+	}
+}
+
+// WithRootCAsGlob appends the CA certificates from the PEM files matching glob patterns, or in directories matching,
+// to tls.Config's RootCAs. Certificates in several files are added once, and any block that's not a certificate fails
+// with its file and line.
+func WithRootCAsGlob(patterns ...string) Option {
+	return func(cfg *tls.Config) error {
+		pool, err := appendPEMGlob(cfg.RootCAs, patterns...)
+		if err != nil {
+			return errors.Wrap(err, "failed to load root CAs")
+		}
+		cfg.RootCAs = pool
+		return nil
+	}
+}
+
+// WithSystemRootsPlus sets tls.Config's RootCAs to the system pool, plus any additional PEM encoded CA certificates.
+// On Windows and macOS the platform verifier is still consulted, see WithPlatformVerifier.
+func WithSystemRootsPlus(pemCerts ...[]byte) Option {
+	return func(cfg *tls.Config) error {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return errors.Wrap(err, "failed to load system roots")
+		}
+		for _, p := range pemCerts {
+			if pool, err = appendPEM(pool, p); err != nil {
+				return errors.Wrap(err, "failed to load root CAs")
+			}
+		}
+		cfg.RootCAs = pool
+		return nil
+	}
+}
+
+// WithPlatformVerifier clears tls.Config's RootCAs, so server certificates are verified by the operating system's
+// verifier. On Windows and macOS that honours the platform's trust settings, including enterprise managed roots,
+// distrusted certificates and revocation policy, rather than only a snapshot of the root certificates, elsewhere it's
+// verification against the system roots. To trust additional CAs as well, use WithSystemRootsPlus or
+// WithSystemRootsPlusFiles instead, as on Windows and macOS pools derived from the system pool still consult the
+// platform verifier before the CAs added.
+func WithPlatformVerifier() Option {
+	return func(cfg *tls.Config) error {
+		cfg.RootCAs = nil
+		return nil
+	}
+}
+
+// WithSystemRootsPlusFiles sets tls.Config's RootCAs to the system pool, plus the CA certificates from PEM files.
+func WithSystemRootsPlusFiles(paths ...string) Option {
+	return func(cfg *tls.Config) error {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return errors.Wrap(err, "failed to load system roots")
+		}
+		if pool, err = appendPEMFiles(pool, paths...); err != nil {
+			return errors.Wrap(err, "failed to load root CAs")
+		}
+		cfg.RootCAs = pool
+		return nil
+	}
+}
@@ -0,0 +1,81 @@
+package tlsutil
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlatformVerifier(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	leaf := newTestCertificate(t, "example.com", ca, now.Add(-time.Hour), now.Add(time.Hour))
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	writeCA(t, path, ca)
+
+	cfg, err := NewClientTLSConfig(WithRootCAsFromFile(path), WithPlatformVerifier())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RootCAs != nil {
+		t.Fatal("expected RootCAs cleared for the platform verifier")
+	}
+
+	if _, err := x509.SystemCertPool(); err != nil {
+		t.Skipf("no system roots: %v", err)
+	}
+	cfg, err = NewClientTLSConfig(WithSystemRootsPlusFiles(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaf.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: cfg.RootCAs}); err != nil {
+		t.Fatalf("extra CA not trusted: %v", err)
+	}
+	if _, err := NewClientTLSConfig(WithSystemRootsPlusFiles(filepath.Join(t.TempDir(), "missing.pem"))); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestRootCAsFromFile(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	ca1 := newTestCertificate(t, "ca1", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	ca2 := newTestCertificate(t, "ca2", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	leaf := newTestCertificate(t, "example.com", ca2, now.Add(-time.Hour), now.Add(time.Hour))
+	path1, path2 := filepath.Join(dir, "ca1.pem"), filepath.Join(dir, "ca2.pem")
+	writeCA(t, path1, ca1)
+	writeCA(t, path2, ca2)
+
+	cfg, err := NewClientTLSConfig(WithRootCAsFromFile(path1), WithRootCAsFromFile(path2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaf.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: cfg.RootCAs}); err != nil {
+		t.Fatalf("expected CAs of both files trusted: %v", err)
+	}
+	if _, err := NewClientTLSConfig(WithRootCAsFromFile(filepath.Join(dir, "missing.pem"))); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestSystemRootsPlus(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	leaf := newTestCertificate(t, "example.com", ca, now.Add(-time.Hour), now.Add(time.Hour))
+	if _, err := x509.SystemCertPool(); err != nil {
+		t.Skipf("no system roots: %v", err)
+	}
+
+	cfg, err := NewClientTLSConfig(WithSystemRootsPlus(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leaf.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: cfg.RootCAs}); err != nil {
+		t.Fatalf("extra CA not trusted: %v", err)
+	}
+	if _, err := NewClientTLSConfig(WithSystemRootsPlus([]byte("not PEM"))); err == nil {
+		t.Fatal("expected error for PEM without certificates")
+	}
+}
@@ -0,0 +1,170 @@
+package tlsutil
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// runShutdownTimeout is how long RunHTTPServer waits for in flight requests to complete by default.
+const runShutdownTimeout = 30 * time.Second
+
+// RunOption configures RunHTTPServer.
+type RunOption func(*runConfig) error
+
+type runConfig struct {
+	redirect *http.Server
+	timeout  time.Duration
+	signals  []os.Signal
+	runners  []func(context.Context) error
+	closers  []io.Closer
+}
+
+// WithRunRedirectServer also serves srv, plain http such as the port 80 server of NewACMEHTTPServer or
+// NewRedirectServer, shutting it down alongside the TLS server.
+func WithRunRedirectServer(srv *http.Server) RunOption {
+	return func(c *runConfig) error {
+		c.redirect = srv
+		return nil
+	}
+}
+
+// WithRunShutdownTimeout sets how long in flight requests are given to complete on shutdown, 30 seconds by default.
+func WithRunShutdownTimeout(d time.Duration) RunOption {
+	return func(c *runConfig) error {
+		if d <= 0 {
+			return errors.New("shutdown timeout must be positive")
+		}
+		c.timeout = d
+		return nil
+	}
+}
+
+// WithRunSignals sets the signals that shut the servers down, SIGINT and SIGTERM by default.
+func WithRunSignals(sigs ...os.Signal) RunOption {
+	return func(c *runConfig) error {
+		c.signals = append([]os.Signal(nil), sigs...)
+		return nil
+	}
+}
+
+// WithRunBackground runs fns for the life of the servers, such as the Run methods of an OCSPStapler, TrustBundle,
+// ExpiryMonitor or KeyRotator, cancelling their context on shutdown and waiting for them to return. One returning an
+// error before then shuts the servers down, RunHTTPServer returning the error.
+func WithRunBackground(fns ...func(context.Context) error) RunOption {
+	return func(c *runConfig) error {
+		c.runners = append(c.runners, fns...)
+		return nil
+	}
+}
+
+// WithRunClosers closes closers once the servers have shut down, such as watching CertReloaders and
+// CAPoolReloaders, or a KeyRotator started by Start.
+func WithRunClosers(closers ...io.Closer) RunOption {
+	return func(c *runConfig) error {
+		c.closers = append(c.closers, closers...)
+		return nil
+	}
+}
+
+// RunHTTPServer serves srv over TLS, with the certificates of its TLSConfig, until ctx is done, a shutdown signal is
+// received, or a server or background function fails. Then shuts down gracefully, giving in flight requests until the
+// shutdown timeout to complete, and stops the background functions and closers owned. Returns nil after a graceful shutdown. Under
+// systemd socket activation the sockets with FileDescriptorName https and http are served, see Listen.
+func RunHTTPServer(ctx context.Context, srv *http.Server, opts ...RunOption) error {
+	c := runConfig{timeout: runShutdownTimeout, signals: []os.Signal{os.Interrupt, syscall.SIGTERM}}
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return err
+		}
+	}
+	if srv.TLSConfig == nil {
+		return errors.New("server has no TLS config")
+	}
+	sigs := make(chan os.Signal, 1)
+	if len(c.signals) > 0 {
+		signal.Notify(sigs, c.signals...)
+		defer signal.Stop(sigs)
+	}
+
+	servers := []*http.Server{srv}
+	if c.redirect != nil {
+		servers = append(servers, c.redirect)
+	}
+	listeners := make([]net.Listener, len(servers))
+	for i, s := range servers {
+		name, addr := "https", s.Addr
+		if s != srv {
+			name = "http"
+		}
+		if addr == "" {
+			addr = ":" + name
+		}
+		ln, err := listenActivated(name, "tcp", addr)
+		if err != nil {
+			for _, ln := range listeners[:i] {
+				ln.Close()
+			}
+			return err
+		}
+		listeners[i] = ln
+	}
+
+	errs := make(chan error, len(servers)+len(c.runners))
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, fn := range c.runners {
+		wg.Add(1)
+		go func(fn func(context.Context) error) {
+			defer wg.Done()
+			// Errors once shutdown has begun are those of being stopped.
+			if err := fn(runCtx); err != nil && runCtx.Err() == nil {
+				errs <- errors.Wrap(err, "background function failed")
+			}
+		}(fn)
+	}
+
+	for i, s := range servers {
+		go func(s *http.Server, ln net.Listener) {
+			if s == srv {
+				errs <- s.ServeTLS(ln, "", "")
+			} else {
+				errs <- s.Serve(ln)
+			}
+		}(s, listeners[i])
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case <-sigs:
+	case err = <-errs:
+	}
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), c.timeout)
+	defer shutdownCancel()
+	for _, s := range servers {
+		if serr := s.Shutdown(shutdownCtx); serr != nil && err == nil {
+			err = errors.Wrap(serr, "failed to shut down gracefully")
+		}
+	}
+	cancel()
+	wg.Wait()
+	for _, closer := range c.closers {
+		if cerr := closer.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// freeAddr returns a loopback address with a port free at the time of asking.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// closerFunc adapts a function to io.Closer.
+type closerFunc func() error
+
+func (fn closerFunc) Close() error { return fn() }
+
+func TestRunHTTPServer(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	client, err := NewHTTPClient(WithServerName("example.com"), func(cfg *tls.Config) error {
+		cfg.RootCAs = roots
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		stop func(cancel context.CancelFunc)
+	}{
+		{"context", func(cancel context.CancelFunc) { cancel() }},
+		{"signal", func(context.CancelFunc) { syscall.Kill(syscall.Getpid(), syscall.SIGUSR1) }},
+	} {
+		srv, err := NewHTTPServer(freeAddr(t), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			func(cfg *tls.Config) error {
+				cfg.Certificates = []tls.Certificate{*cert}
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		redirect := &http.Server{Addr: freeAddr(t), Handler: http.NotFoundHandler()}
+		var background, closed int32
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- RunHTTPServer(ctx, srv, WithRunRedirectServer(redirect), WithRunSignals(syscall.SIGUSR1),
+				WithRunBackground(func(ctx context.Context) error {
+					<-ctx.Done()
+					atomic.StoreInt32(&background, 1)
+					return ctx.Err()
+				}),
+				WithRunClosers(closerFunc(func() error {
+					atomic.StoreInt32(&closed, 1)
+					return nil
+				})))
+		}()
+
+		var resp *http.Response
+		for i := 0; ; i++ {
+			if resp, err = client.Get("https://" + srv.Addr); err == nil || i == 50 {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp, err = http.Get("http://" + redirect.Addr); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		tt.stop(cancel)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: not shut down", tt.name)
+		}
+		cancel()
+		if atomic.LoadInt32(&background) == 0 || atomic.LoadInt32(&closed) == 0 {
+			t.Fatalf("%s: background functions and closers not stopped", tt.name)
+		}
+	}
+
+	srv := &http.Server{Addr: freeAddr(t)}
+	if err := RunHTTPServer(context.Background(), srv); err == nil {
+		t.Fatal("expected error without TLS config")
+	}
+}
+
+func TestRunHTTPServerBackgroundFailure(t *testing.T) {
+	now := time.Now()
+	cert := newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	srv, err := NewHTTPServer(freeAddr(t), http.NotFoundHandler(), func(cfg *tls.Config) error {
+		cfg.Certificates = []tls.Certificate{*cert}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("refresh failed")
+	var stopped int32
+	done := make(chan error, 1)
+	go func() {
+		done <- RunHTTPServer(context.Background(), srv, WithRunSignals(),
+			WithRunBackground(
+				func(ctx context.Context) error { return failure },
+				func(ctx context.Context) error {
+					<-ctx.Done()
+					atomic.StoreInt32(&stopped, 1)
+					return ctx.Err()
+				}))
+	}()
+	select {
+	case err := <-done:
+		if errors.Cause(err) != failure {
+			t.Fatalf("expected background failure, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("failing background function did not shut the server down")
+	}
+	if atomic.LoadInt32(&stopped) == 0 {
+		t.Fatal("other background functions not stopped")
+	}
+}
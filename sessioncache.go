@@ -0,0 +1,80 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// SessionCacheMetrics receives client session cache lookups, for adapters to systems such as Prometheus to
+// implement.
+type SessionCacheMetrics interface {
+	// SessionCacheHit counts lookups finding a session to resume.
+	SessionCacheHit()
+	// SessionCacheMiss counts lookups requiring a full handshake.
+	SessionCacheMiss()
+}
+
+// SessionCacheCounter is a SessionCacheMetrics counting hits and misses in memory.
+type SessionCacheCounter struct {
+	hits, misses uint64
+}
+
+// SessionCacheHit implements SessionCacheMetrics.
+func (c *SessionCacheCounter) SessionCacheHit() { atomic.AddUint64(&c.hits, 1) }
+
+// SessionCacheMiss implements SessionCacheMetrics.
+func (c *SessionCacheCounter) SessionCacheMiss() { atomic.AddUint64(&c.misses, 1) }
+
+// Hits returns the number of hits counted.
+func (c *SessionCacheCounter) Hits() uint64 { return atomic.LoadUint64(&c.hits) }
+
+// Misses returns the number of misses counted.
+func (c *SessionCacheCounter) Misses() uint64 { return atomic.LoadUint64(&c.misses) }
+
+// meteredSessionCache reports lookups of cache to metrics.
+type meteredSessionCache struct {
+	cache   tls.ClientSessionCache
+	metrics SessionCacheMetrics
+}
+
+func (c *meteredSessionCache) Get(key string) (*tls.ClientSessionState, bool) {
+	cs, ok := c.cache.Get(key)
+	if ok {
+		c.metrics.SessionCacheHit()
+	} else {
+		c.metrics.SessionCacheMiss()
+	}
+	return cs, ok
+}
+
+func (c *meteredSessionCache) Put(key string, cs *tls.ClientSessionState) {
+	c.cache.Put(key, cs)
+}
+
+// WithClientSessionCache configures a client to resume sessions, caching up to capacity in memory, evicting the
+// least recently used. Zero uses crypto/tls's default capacity.
+func WithClientSessionCache(capacity int) ClientOption {
+	return func(cfg *tls.Config) error {
+		if capacity < 0 {
+			return errors.New("session cache capacity must not be negative")
+		}
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(capacity)
+		return nil
+	}
+}
+
+// WithSessionCacheMetrics reports lookups of the client session cache already configured to metrics.
+func WithSessionCacheMetrics(metrics SessionCacheMetrics) ClientOption {
+	return func(cfg *tls.Config) error {
+		if metrics == nil {
+			return errors.New("session cache metrics must not be nil")
+		}
+		if cfg.ClientSessionCache == nil {
+			return errors.New("no client session cache configured")
+		}
+		cfg.ClientSessionCache = &meteredSessionCache{cache: cfg.ClientSessionCache, metrics: metrics}
+		return nil
+	}
+}
@@ -0,0 +1,46 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientSessionCache(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var counter SessionCacheCounter
+	transport, err := NewHTTPTransport(WithClientSessionCache(8), WithSessionCacheMetrics(&counter), func(cfg *tls.Config) error {
+		cfg.RootCAs = srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.DisableKeepAlives = true
+	client := &http.Client{Transport: transport}
+	var resumed []bool
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		resumed = append(resumed, resp.TLS.DidResume)
+	}
+	if resumed[0] || !resumed[1] || !resumed[2] {
+		t.Fatalf("expected later connections resumed, got %v", resumed)
+	}
+	if counter.Hits() != 2 || counter.Misses() != 1 {
+		t.Fatalf("expected 2 hits and 1 miss, got %d and %d", counter.Hits(), counter.Misses())
+	}
+
+	if _, err := NewTLSConfig(WithSessionCacheMetrics(&counter)); err == nil {
+		t.Fatal("expected error without session cache")
+	}
+	if _, err := NewTLSConfig(WithClientSessionCache(8), WithSessionCacheMetrics(nil)); err == nil {
+		t.Fatal("expected error for nil metrics")
+	}
+}
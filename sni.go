@@ -0,0 +1,199 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownServerName is returned by SNIRouter's GetCertificate, aborting the handshake, when no host matches the
+// requested server name and there is no default certificate.
+var ErrUnknownServerName = errors.New("tlsutil: no certificate for server name")
+
+// SNIRouter serves certificates by the requested server name, from host mappings that may be changed whilst serving.
+// Hosts are either exact names, or wildcards of the form *.example.com matching a single leftmost label.
+type SNIRouter struct {
+	mu       sync.RWMutex
+	hosts    map[string][]*tls.Certificate
+	fallback *tls.Certificate
+}
+
+// NewSNIRouter returns an SNIRouter without any hosts.
+func NewSNIRouter() *SNIRouter {
+	return &SNIRouter{hosts: make(map[string][]*tls.Certificate)}
+}
+
+// WithSNIRouter configures TLS to serve certificates from r.
+func WithSNIRouter(r *SNIRouter) Option {
+	return func(cfg *tls.Config) error {
+		return setGetCertificate(cfg, r.GetCertificate)
+	}
+}
+
+// normalizeHost returns host normalized as a server name, failing if it is not a valid exact or wildcard host.
+func normalizeHost(host string) (string, error) {
+	h := normalizeServerName(host)
+	labels := h
+	if strings.HasPrefix(h, "*.") {
+		labels = h[2:]
+	}
+	if labels == "" || strings.Contains(labels, "*") || strings.HasPrefix(labels, ".") || strings.Contains(labels, "..") {
+		return "", errors.Errorf("invalid host %q", host)
+	}
+	return h, nil
+}
+
+// SetCertificate maps host to certs, replacing any previous mapping. With more than one certificate the first the
+// client supports is served, so a host may have both RSA and ECDSA certificates.
+func (r *SNIRouter) SetCertificate(host string, certs ...*tls.Certificate) error {
+	h, err := normalizeHost(host)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return errors.Errorf("no certificates for host %q", host)
+	}
+	cs := make([]*tls.Certificate, len(certs))
+	for i, cert := range certs {
+		if cert == nil || len(cert.Certificate) == 0 {
+			return errors.Errorf("certificate for host %q has no chain", host)
+		}
+		if cert.Leaf == nil {
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return errors.Wrapf(err, "failed to parse certificate for host %q", host)
+			}
+			c := *cert
+			c.Leaf = leaf
+			cert = &c
+		}
+		cs[i] = cert
+	}
+	r.mu.Lock()
+	r.hosts[h] = cs
+	r.mu.Unlock()
+	return nil
+}
+
+// AddCertificate maps each DNS name of cert's leaf to cert, replacing previous mappings of those names.
+func (r *SNIRouter) AddCertificate(cert *tls.Certificate) error {
+	leaf := leafOf(cert)
+	if leaf == nil {
+		return errors.New("failed to parse certificate")
+	}
+	if len(leaf.DNSNames) == 0 {
+		return errors.Errorf("certificate %q has no DNS names", leaf.Subject)
+	}
+	for _, name := range leaf.DNSNames {
+		if err := r.SetCertificate(name, cert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove removes the mapping of host, reporting whether there was one.
+func (r *SNIRouter) Remove(host string) bool {
+	h, err := normalizeHost(host)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.hosts[h]; !ok {
+		return false
+	}
+	delete(r.hosts, h)
+	return true
+}
+
+// SetDefaultCertificate sets the certificate served when no host matches, including handshakes without a server
+// name. A placeholder from NewPlaceholderCertificate avoids revealing real names to scanners. If nil, the default,
+// such handshakes are aborted with ErrUnknownServerName.
+func (r *SNIRouter) SetDefaultCertificate(cert *tls.Certificate) error {
+	if cert != nil && len(cert.Certificate) == 0 {
+		return errors.New("default certificate has no chain")
+	}
+	r.mu.Lock()
+	r.fallback = cert
+	r.mu.Unlock()
+	return nil
+}
+
+// Hosts returns the mapped hosts, sorted.
+func (r *SNIRouter) Hosts() []string {
+	r.mu.RLock()
+	hosts := make([]string, 0, len(r.hosts))
+	for h := range r.hosts {
+		hosts = append(hosts, h)
+	}
+	r.mu.RUnlock()
+	sort.Strings(hosts)
+	return hosts
+}
+
+// lookup returns the certificates for name, preferring an exact mapping to a wildcard one.
+func (r *SNIRouter) lookup(name string) []*tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if certs, ok := r.hosts[name]; ok {
+		return certs
+	}
+	if w := wildcardOf(name); w != "" {
+		return r.hosts[w]
+	}
+	return nil
+}
+
+// wildcardOf returns the wildcard host matching name, or "" if there is none.
+func wildcardOf(name string) string {
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return "*" + name[i:]
+	}
+	return ""
+}
+
+// GetCertificate implements tls.Config's GetCertificate.
+func (r *SNIRouter) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert, err := r.hostCertificate(hello); err == nil {
+		return cert, nil
+	}
+	return r.defaultCertificate(hello)
+}
+
+// hostCertificate returns the certificate of the host matching the requested server name, ignoring any default.
+func (r *SNIRouter) hostCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := normalizeServerName(hello.ServerName)
+	certs := r.lookup(name)
+	if len(certs) == 0 {
+		return nil, errors.Wrapf(ErrUnknownServerName, "%q", name)
+	}
+	return selectCertificate(hello, certs), nil
+}
+
+// defaultCertificate returns the default certificate, or ErrUnknownServerName if there is none.
+func (r *SNIRouter) defaultCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	fallback := r.fallback
+	r.mu.RUnlock()
+	if fallback == nil {
+		return nil, errors.Wrapf(ErrUnknownServerName, "%q", normalizeServerName(hello.ServerName))
+	}
+	return fallback, nil
+}
+
+// selectCertificate returns the first of certs the client supports, or the first if none are, as crypto/tls does.
+func selectCertificate(hello *tls.ClientHelloInfo, certs []*tls.Certificate) *tls.Certificate {
+	if len(certs) > 1 {
+		for _, cert := range certs {
+			if hello.SupportsCertificate(cert) == nil {
+				return cert
+			}
+		}
+	}
+	return certs[0]
+}
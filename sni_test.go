@@ -0,0 +1,113 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestSNIRouter(t *testing.T) {
+	now := time.Now()
+	exact := newTestCertificate(t, "www.example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	wildcard := newTestCertificate(t, "*.example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))
+
+	r := NewSNIRouter()
+	cfg, err := NewTLSConfig(WithSNIRouter(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddCertificate(exact); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetCertificate("*.Example.com.", wildcard); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want *tls.Certificate
+	}{
+		{"www.example.com", exact},
+		{"WWW.example.com.", exact},
+		{"api.example.com", wildcard},
+		{"a.b.example.com", nil},
+		{"example.com", nil},
+		{"", nil},
+	} {
+		got, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: tt.name})
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%q: expected no certificate", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.name, err)
+			continue
+		}
+		if got.Leaf.SerialNumber.Cmp(tt.want.Leaf.SerialNumber) != 0 {
+			t.Errorf("%q: wrong certificate %s", tt.name, got.Leaf.Subject)
+		}
+	}
+
+	if !r.Remove("www.example.com") {
+		t.Fatal("expected mapping to be removed")
+	}
+	got, err := cfg.GetCertificate(&tls.ClientHelloInfo{ServerName: "www.example.com"})
+	if err != nil || got.Leaf.SerialNumber.Cmp(wildcard.Leaf.SerialNumber) != 0 {
+		t.Fatal("expected wildcard after removing exact mapping")
+	}
+	if hosts := r.Hosts(); len(hosts) != 1 || hosts[0] != "*.example.com" {
+		t.Fatalf("unexpected hosts %v", hosts)
+	}
+
+	for _, host := range []string{"", "*", "*.", "a.*.example.com", "**.example.com", "a..example.com"} {
+		if err := r.SetCertificate(host, exact); err == nil {
+			t.Errorf("%q: expected invalid host", host)
+		}
+	}
+}
+
+func TestSNIRouterDefault(t *testing.T) {
+	now := time.Now()
+	r := NewSNIRouter()
+	if err := r.AddCertificate(newTestCertificate(t, "example.com", nil, now.Add(-time.Hour), now.Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	unknown := &tls.ClientHelloInfo{ServerName: "scanner.invalid"}
+	if _, err := r.GetCertificate(unknown); errors.Cause(err) != ErrUnknownServerName {
+		t.Fatalf("expected ErrUnknownServerName, got %v", err)
+	}
+
+	placeholder, err := NewPlaceholderCertificate(KeyECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(placeholder.Leaf.DNSNames) != 0 {
+		t.Fatal("placeholder must not name hosts")
+	}
+	if err := r.SetDefaultCertificate(placeholder); err != nil {
+		t.Fatal(err)
+	}
+	for _, hello := range []*tls.ClientHelloInfo{unknown, {}} {
+		got, err := r.GetCertificate(hello)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != placeholder {
+			t.Fatalf("%q: expected placeholder", hello.ServerName)
+		}
+	}
+	if got, _ := r.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); got == placeholder {
+		t.Fatal("placeholder served for known host")
+	}
+
+	if err := r.SetDefaultCertificate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetCertificate(unknown); errors.Cause(err) != ErrUnknownServerName {
+		t.Fatal("expected handshake to abort after removing default")
+	}
+}
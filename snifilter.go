@@ -0,0 +1,76 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+
+	"github.com/pkg/errors"
+)
+
+// ErrServerNameRejected is returned from GetConfigForClient, aborting the handshake before any certificate is
+// selected, for server names rejected by WithSNIAllowlist or WithSNIDenylist.
+var ErrServerNameRejected = errors.New("tlsutil: server name rejected")
+
+// hostSet is a set of exact and wildcard hosts.
+type hostSet map[string]bool
+
+func newHostSet(hosts []string) (hostSet, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts")
+	}
+	s := make(hostSet, len(hosts))
+	for _, host := range hosts {
+		h, err := normalizeHost(host)
+		if err != nil {
+			return nil, err
+		}
+		s[h] = true
+	}
+	return s, nil
+}
+
+// matches reports whether the normalized server name is in the set, exactly or by wildcard.
+func (s hostSet) matches(name string) bool {
+	return name != "" && (s[name] || s[wildcardOf(name)])
+}
+
+// WithSNIAllowlist aborts handshakes, including those without a server name, unless the server name matches one of
+// hosts, exact or wildcard as for SNIRouter. So scanners connecting by address aren't served a real certificate.
+// It wraps any GetConfigForClient already configured, so should follow options setting it.
+func WithSNIAllowlist(hosts ...string) Option {
+	return func(cfg *tls.Config) error {
+		allowed, err := newHostSet(hosts)
+		if err != nil {
+			return errors.Wrap(err, "invalid SNI allowlist")
+		}
+		return filterServerNames(cfg, func(name string) bool { return allowed.matches(name) })
+	}
+}
+
+// WithSNIDenylist aborts handshakes whose server name matches one of hosts, exact or wildcard as for SNIRouter.
+// It wraps any GetConfigForClient already configured, so should follow options setting it.
+func WithSNIDenylist(hosts ...string) Option {
+	return func(cfg *tls.Config) error {
+		denied, err := newHostSet(hosts)
+		if err != nil {
+			return errors.Wrap(err, "invalid SNI denylist")
+		}
+		return filterServerNames(cfg, func(name string) bool { return !denied.matches(name) })
+	}
+}
+
+// filterServerNames sets GetConfigForClient to reject server names not accepted by accept, before calling any
+// GetConfigForClient previously set.
+func filterServerNames(cfg *tls.Config, accept func(name string) bool) error {
+	next := cfg.GetConfigForClient
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		name := normalizeServerName(hello.ServerName)
+		if !accept(name) {
+			return nil, errors.Wrapf(ErrServerNameRejected, "%q", name)
+		}
+		if next != nil {
+			return next(hello)
+		}
+		return nil, nil
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestSNIFilter(t *testing.T) {
+	h := NewHostConfigs()
+	if err := h.Set("admin.example.com", WithMinVersion(tls.VersionTLS13)); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(
+		WithHostConfigs(h),
+		WithSNIAllowlist("example.com", "*.example.com"),
+		WithSNIDenylist("internal.example.com"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		ok   bool
+	}{
+		{"example.com", true},
+		{"WWW.Example.com.", true},
+		{"internal.example.com", false},
+		{"a.b.example.com", false},
+		{"other.example", false},
+		{"", false},
+	} {
+		_, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: tt.name})
+		if tt.ok && err != nil {
+			t.Errorf("%q: unexpected error %v", tt.name, err)
+		}
+		if !tt.ok && errors.Cause(err) != ErrServerNameRejected {
+			t.Errorf("%q: expected ErrServerNameRejected, got %v", tt.name, err)
+		}
+	}
+	got, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{ServerName: "admin.example.com"})
+	if err != nil || got == nil || got.MinVersion != tls.VersionTLS13 {
+		t.Fatal("wrapped GetConfigForClient not called")
+	}
+
+	// A denylist alone accepts handshakes without a server name.
+	cfg, err = NewTLSConfig(WithSNIDenylist("*.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTLSConfig(WithSNIAllowlist()); err == nil {
+		t.Fatal("expected error for empty allowlist")
+	}
+}
@@ -0,0 +1,90 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSPIFFEIDNotAllowed is returned by peer verification when the peer's SPIFFE ID is not allowed.
+var ErrSPIFFEIDNotAllowed = errors.New("tlsutil: SPIFFE ID not allowed")
+
+// SPIFFEID returns the SPIFFE ID of an X.509 SVID, its single spiffe URI SAN.
+func SPIFFEID(cert *x509.Certificate) (*url.URL, error) {
+	if len(cert.URIs) != 1 {
+		return nil, errors.Errorf("certificate %q has %d URI SANs, an SVID has exactly one", cert.Subject, len(cert.URIs))
+	}
+	id := cert.URIs[0]
+	if err := validateSPIFFEID(id); err != nil {
+		return nil, errors.Wrapf(err, "certificate %q", cert.Subject)
+	}
+	return id, nil
+}
+
+// validateSPIFFEID checks id is a SPIFFE ID of a workload.
+func validateSPIFFEID(id *url.URL) error {
+	switch {
+	case id.Scheme != "spiffe":
+		return errors.Errorf("%q is not a SPIFFE ID", id)
+	case id.Host == "" || id.Port() != "" || id.User != nil || strings.ToLower(id.Host) != id.Host:
+		return errors.Errorf("SPIFFE ID %q has an invalid trust domain", id)
+	case id.Path == "" || id.Path == "/" || strings.HasSuffix(id.Path, "/") || id.RawQuery != "" || id.Fragment != "":
+		return errors.Errorf("SPIFFE ID %q has an invalid path", id)
+	}
+	return nil
+}
+
+// NewSPIFFEVerifier returns a Verifier accepting only peers whose leaf certificate is an SVID of trustDomain, and if
+// any allowedIDs are given, with one of those IDs.
+func NewSPIFFEVerifier(trustDomain string, allowedIDs ...string) (Verifier, error) {
+	trustDomain = strings.ToLower(strings.TrimPrefix(trustDomain, "spiffe://"))
+	if trustDomain == "" || strings.ContainsAny(trustDomain, "/:@") {
+		return nil, errors.Errorf("invalid SPIFFE trust domain %q", trustDomain)
+	}
+	allowed := make(map[string]bool, len(allowedIDs))
+	for _, s := range allowedIDs {
+		id, err := url.Parse(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid SPIFFE ID %q", s)
+		}
+		if err := validateSPIFFEID(id); err != nil {
+			return nil, err
+		}
+		if id.Host != trustDomain {
+			return nil, errors.Errorf("SPIFFE ID %q is not in trust domain %q", s, trustDomain)
+		}
+		allowed[id.String()] = true
+	}
+	return VerifierFunc(func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("peer presented no certificate")
+		}
+		id, err := SPIFFEID(cs.PeerCertificates[0])
+		if err != nil {
+			return err
+		}
+		if id.Host != trustDomain {
+			return errors.Wrapf(ErrSPIFFEIDNotAllowed, "%q is not in trust domain %q", id, trustDomain)
+		}
+		if len(allowed) > 0 && !allowed[id.String()] {
+			return errors.Wrapf(ErrSPIFFEIDNotAllowed, "%q", id)
+		}
+		return nil
+	}), nil
+}
+
+// WithSPIFFEVerification configures TLS to accept only peers whose leaf certificate is an SVID of trustDomain, and if
+// any allowedIDs are given, with one of those IDs. This checks identity only, the chain must be verified against the
+// trust domain's bundle by ClientCAs or RootCAs as usual.
+func WithSPIFFEVerification(trustDomain string, allowedIDs ...string) Option {
+	return func(cfg *tls.Config) error {
+		v, err := NewSPIFFEVerifier(trustDomain, allowedIDs...)
+		if err != nil {
+			return err
+		}
+		return WithVerifiers(v)(cfg)
+	}
+}
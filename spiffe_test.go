@@ -0,0 +1,68 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newTestSVID returns a certificate with the URI SANs ids.
+func newTestSVID(t *testing.T, ids ...string) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "workload"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	for _, id := range ids {
+		u, err := url.Parse(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	return issueTestCertificate(t, tmpl, nil).Leaf
+}
+
+func TestSPIFFEVerification(t *testing.T) {
+	cfg, err := NewTLSConfig(WithSPIFFEVerification("example.org", "spiffe://example.org/billing", "spiffe://example.org/web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain, err := NewTLSConfig(WithSPIFFEVerification("spiffe://example.org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		ids            []string
+		allowed, inDom bool
+	}{
+		{[]string{"spiffe://example.org/billing"}, true, true},
+		{[]string{"spiffe://example.org/other"}, false, true},
+		{[]string{"spiffe://evil.org/billing"}, false, false},
+		{[]string{"spiffe://example.org/billing", "spiffe://example.org/web"}, false, false},
+		{[]string{"https://example.org/billing"}, false, false},
+		{[]string{"spiffe://example.org/"}, false, false},
+		{nil, false, false},
+	} {
+		cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{newTestSVID(t, tt.ids...)}}
+		if err := cfg.VerifyConnection(cs); (err == nil) != tt.allowed {
+			t.Errorf("%v: allowed %v, got %v", tt.ids, tt.allowed, err)
+		}
+		if err := domain.VerifyConnection(cs); (err == nil) != tt.inDom {
+			t.Errorf("%v: in trust domain %v, got %v", tt.ids, tt.inDom, err)
+		}
+	}
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{newTestSVID(t, "spiffe://example.org/other")}}
+	if err := cfg.VerifyConnection(cs); errors.Cause(err) != ErrSPIFFEIDNotAllowed {
+		t.Fatalf("expected ErrSPIFFEIDNotAllowed, got %v", err)
+	}
+	if _, err := NewTLSConfig(WithSPIFFEVerification("example.org", "spiffe://other.org/x")); err == nil {
+		t.Fatal("expected error for allowed ID outside trust domain")
+	}
+}
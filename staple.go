@@ -0,0 +1,436 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// staplerRetryMin and staplerRetryMax bound the doubling delay between failed fetches of a staple.
+	staplerRetryMin = time.Minute
+	staplerRetryMax = time.Hour
+	// staplerRunInterval is how often Run refreshes staples due, so they stay fresh without handshakes.
+	staplerRunInterval = time.Minute
+	// staplerIdleExpiry is how long a certificate may go unserved before Run forgets it, such as one replaced by
+	// renewal.
+	staplerIdleExpiry = 24 * time.Hour
+)
+
+// StaplerOption configures an OCSPStapler.
+type StaplerOption func(*OCSPStapler) error
+
+// WithStaplerHTTPClient sets the HTTP client OCSP responses are fetched with.
+func WithStaplerHTTPClient(client *http.Client) StaplerOption {
+	return func(s *OCSPStapler) error {
+		s.fetcher.client = client
+		return nil
+	}
+}
+
+// WithStaplerTimeout sets the time allowed for fetching a response, 5 seconds by default.
+func WithStaplerTimeout(d time.Duration) StaplerOption {
+	return func(s *OCSPStapler) error {
+		if d <= 0 {
+			return errors.New("stapler timeout must be positive")
+		}
+		s.fetcher.timeout = d
+		return nil
+	}
+}
+
+// WithStaplerClock sets the clock staple freshness is judged by.
+func WithStaplerClock(clock Clock) StaplerOption {
+	return func(s *OCSPStapler) error {
+		s.fetcher.clock = clock
+		return nil
+	}
+}
+
+// WithStaplerCache persists fetched responses to cache, such as an autocert.DirCache or the Redis and etcd caches,
+// so after a restart certificates are stapled from it rather than waiting on their responders.
+func WithStaplerCache(cache autocert.Cache) StaplerOption {
+	return func(s *OCSPStapler) error {
+		s.cache = cache
+		return nil
+	}
+}
+
+// WithStaplerErrorHandler sets a function called with every failed fetch. Any previous staple continues to be served
+// until it expires.
+func WithStaplerErrorHandler(fn func(error)) StaplerOption {
+	return func(s *OCSPStapler) error {
+		s.onError = fn
+		return nil
+	}
+}
+
+// stapleEntry is the stapling state of one certificate.
+type stapleEntry struct {
+	cert    *tls.Certificate
+	leaf    *x509.Certificate
+	issuer  *x509.Certificate
+	stapled *tls.Certificate
+	// mustStaple is whether the certificate has the TLS feature extension requiring a staple.
+	mustStaple bool
+	// expires is the staple's next update, after which it's no longer served.
+	expires  time.Time
+	refresh  time.Time
+	served   time.Time
+	failures int
+	// fetch is the fetch in flight, if any, shared by all waiting on a staple.
+	fetch *stapleFetch
+}
+
+// stapleFetch is a fetch of a staple, done closed once err is set.
+type stapleFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// OCSPStapler fetches OCSP responses for served certificates from their responders and staples them, refreshing
+// each halfway through its validity, and backing off from failures. Handshakes never wait on a fetch, a
+// certificate is served without a staple until its first response arrives.
+type OCSPStapler struct {
+	fetcher *OCSPChecker
+	cache   autocert.Cache
+	onError func(error)
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*stapleEntry
+}
+
+// NewOCSPStapler returns an OCSPStapler configured by opts.
+func NewOCSPStapler(opts ...StaplerOption) (*OCSPStapler, error) {
+	fetcher, err := NewOCSPChecker()
+	if err != nil {
+		return nil, err
+	}
+	s := &OCSPStapler{fetcher: fetcher, entries: make(map[[sha256.Size]byte]*stapleEntry)}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// WithOCSPStapler configures TLS to staple responses from s to served certificates, wrapping GetCertificate, or if
+// unset serving Certificates by it. Apply after the options providing certificates.
+func WithOCSPStapler(s *OCSPStapler) Option {
+	return func(cfg *tls.Config) error {
+		get := cfg.GetCertificate
+		if get == nil {
+			if len(cfg.Certificates) == 0 {
+				return errors.New("no certificates to staple")
+			}
+			certs := make([]*tls.Certificate, len(cfg.Certificates))
+			for i := range cfg.Certificates {
+				certs[i] = &cfg.Certificates[i]
+			}
+			get = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return selectCertificate(hello, certs), nil
+			}
+		}
+		cfg.GetCertificate = s.GetCertificate(get)
+		return nil
+	}
+}
+
+// WithOCSPStapling configures TLS to staple OCSP responses to served certificates, with a new OCSPStapler of opts.
+// Staples are refreshed as they're served, nothing runs in the background. To also refresh them between handshakes,
+// use WithOCSPStapler with a stapler whose Run is owned by the caller, such as by WithRunBackground.
+func WithOCSPStapling(opts ...StaplerOption) Option {
+	return func(cfg *tls.Config) error {
+		s, err := NewOCSPStapler(opts...)
+		if err != nil {
+			return err
+		}
+		return WithOCSPStapler(s)(cfg)
+	}
+}
+
+// WithACMEStapler configures TLS to use ACME, configured by opts, stapling the issued certificates with s. Staples
+// are held per issued certificate, so a renewed certificate is stapled afresh.
+func WithACMEStapler(s *OCSPStapler, opts ...ACMEOption) Option {
+	return func(cfg *tls.Config) error {
+		mgr, err := newACMEManager(opts...)
+		if err != nil {
+			return err
+		}
+		return setGetCertificate(cfg, s.GetCertificate(mgr.GetCertificate))
+	}
+}
+
+// WithACMEStapling configures TLS to use ACME, configured by opts, stapling the issued certificates with a new
+// OCSPStapler, refreshing staples as they're served. See WithOCSPStapling.
+func WithACMEStapling(opts ...ACMEOption) Option {
+	return func(cfg *tls.Config) error {
+		s, err := NewOCSPStapler()
+		if err != nil {
+			return err
+		}
+		return WithACMEStapler(s, opts...)(cfg)
+	}
+}
+
+// GetCertificate wraps get, stapling the certificates it returns, other than ACME challenge certificates. Must
+// staple certificates are never served without a staple, the handshake waits for one to be fetched, failing with
+// ErrMissingStaple if none can be.
+func (s *OCSPStapler) GetCertificate(get func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := get(hello)
+		if err != nil || cert == nil || isACMEChallenge(hello) {
+			return cert, err
+		}
+		stapled, mustStaple := s.staple(cert)
+		if len(stapled.OCSPStaple) > 0 || !mustStaple {
+			return stapled, nil
+		}
+		ctx, cancel := context.WithTimeout(helloContext(hello), s.fetcher.timeout)
+		defer cancel()
+		if err := s.Refresh(ctx, cert); err != nil {
+			return nil, errors.Wrapf(ErrMissingStaple, "%v", err)
+		}
+		if stapled, _ = s.staple(cert); len(stapled.OCSPStaple) == 0 {
+			return nil, ErrMissingStaple
+		}
+		return stapled, nil
+	}
+}
+
+// Staple returns cert with its current staple, if it has one, starting a fetch in the background if one is due.
+// Certificates already stapled, or without an OCSP responder or issuer, are returned as is.
+func (s *OCSPStapler) Staple(cert *tls.Certificate) *tls.Certificate {
+	stapled, _ := s.staple(cert)
+	return stapled
+}
+
+// staple is Staple, also reporting whether cert is must staple.
+func (s *OCSPStapler) staple(cert *tls.Certificate) (*tls.Certificate, bool) {
+	if len(cert.OCSPStaple) > 0 || len(cert.Certificate) == 0 {
+		return cert, false
+	}
+	key := sha256.Sum256(cert.Certificate[0])
+	now := s.fetcher.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		if e = newStapleEntry(cert); e == nil {
+			return cert, false
+		}
+		s.entries[key] = e
+	}
+	e.served = now
+	if e.issuer == nil {
+		return cert, e.mustStaple
+	}
+	if e.fetch == nil && !now.Before(e.refresh) {
+		s.startFetch(e, true)
+	}
+	if e.stapled == nil || !now.Before(e.expires) {
+		return cert, e.mustStaple
+	}
+	return e.stapled, e.mustStaple
+}
+
+// newStapleEntry returns the stapling state of cert, nil if it can't be parsed. Certificates without an OCSP
+// responder or issuer have a nil issuer, and are never stapled.
+func newStapleEntry(cert *tls.Certificate) *stapleEntry {
+	leaf := leafOf(cert)
+	if leaf == nil {
+		return nil
+	}
+	e := &stapleEntry{cert: cert, leaf: leaf, mustStaple: MustStaple(leaf)}
+	if len(cert.Certificate) < 2 || len(leaf.OCSPServer) == 0 {
+		return e
+	}
+	if issuer, err := x509.ParseCertificate(cert.Certificate[1]); err == nil {
+		e.issuer = issuer
+	}
+	return e
+}
+
+// Refresh fetches a staple for cert now, for priming staples before serving, waiting for any fetch already in flight
+// rather than starting another. The fetch is bounded by the stapler's timeout, and carries on should ctx be done
+// first.
+func (s *OCSPStapler) Refresh(ctx context.Context, cert *tls.Certificate) error {
+	e := newStapleEntry(cert)
+	if e == nil || e.issuer == nil {
+		return errors.New("certificate has no OCSP responder or issuer")
+	}
+	key := sha256.Sum256(cert.Certificate[0])
+	s.mu.Lock()
+	if prev, ok := s.entries[key]; ok {
+		e = prev
+	} else {
+		s.entries[key] = e
+	}
+	f := e.fetch
+	if f == nil {
+		f = s.startFetch(e, false)
+	}
+	s.mu.Unlock()
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startFetch starts updating e in the background, reporting failure to the error handler if report. s.mu must be
+// held, and no fetch for e be in flight.
+func (s *OCSPStapler) startFetch(e *stapleEntry, report bool) *stapleFetch {
+	f := &stapleFetch{done: make(chan struct{})}
+	e.fetch = f
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.fetcher.timeout)
+		defer cancel()
+		f.err = s.update(ctx, e)
+		s.mu.Lock()
+		e.fetch = nil
+		s.mu.Unlock()
+		close(f.done)
+		if f.err != nil && report && s.onError != nil {
+			s.onError(f.err)
+		}
+	}()
+	return f
+}
+
+// update fetches a response for e from its responders, scheduling the next refresh. Without a staple in memory, a
+// response persisted to the cache is used if fresh.
+func (s *OCSPStapler) update(ctx context.Context, e *stapleEntry) error {
+	s.mu.Lock()
+	load := s.cache != nil && e.stapled == nil
+	s.mu.Unlock()
+	if load {
+		if resp := s.load(ctx, e); resp != nil {
+			s.mu.Lock()
+			s.apply(e, resp)
+			fresh := s.fetcher.clock.Now().Before(e.refresh)
+			s.mu.Unlock()
+			if fresh {
+				return nil
+			}
+		}
+	}
+
+	var resp *ocsp.Response
+	var err error
+	for _, server := range e.leaf.OCSPServer {
+		if resp, err = s.fetcher.query(ctx, server, e.leaf, e.issuer); err == nil {
+			if resp.Status == ocsp.Unknown {
+				err = errors.Errorf("OCSP responder %s does not know certificate", server)
+				continue
+			}
+			break
+		}
+	}
+	if err == nil && s.cache != nil {
+		if perr := s.cache.Put(ctx, stapleCacheKey(e.leaf), resp.Raw); perr != nil && s.onError != nil {
+			s.onError(errors.Wrap(perr, "failed to persist OCSP staple"))
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		e.refresh = s.fetcher.clock.Now().Add(stapleBackoff(e.failures))
+		e.failures++
+		return errors.Wrapf(err, "failed to fetch OCSP staple for %q", e.leaf.Subject)
+	}
+	s.apply(e, resp)
+	if resp.Status == ocsp.Revoked {
+		return errors.Wrapf(ErrCertificateRevoked, "served certificate %q", e.leaf.Subject)
+	}
+	return nil
+}
+
+// load returns the valid response for e in the cache, or nil.
+func (s *OCSPStapler) load(ctx context.Context, e *stapleEntry) *ocsp.Response {
+	der, err := s.cache.Get(ctx, stapleCacheKey(e.leaf))
+	if err != nil {
+		if err != autocert.ErrCacheMiss && s.onError != nil {
+			s.onError(errors.Wrap(err, "failed to load OCSP staple"))
+		}
+		return nil
+	}
+	resp, err := s.fetcher.parse(der, e.leaf, e.issuer, nil)
+	if err != nil || resp.Status == ocsp.Unknown {
+		return nil
+	}
+	return resp
+}
+
+// apply makes resp e's staple, refreshed halfway through its validity. s.mu must be held.
+func (s *OCSPStapler) apply(e *stapleEntry, resp *ocsp.Response) {
+	stapled := *e.cert
+	stapled.OCSPStaple = resp.Raw
+	e.stapled, e.failures = &stapled, 0
+	e.expires, e.refresh = resp.NextUpdate, s.fetcher.clock.Now().Add(defaultOCSPRefresh)
+	if !resp.NextUpdate.IsZero() {
+		e.refresh = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	} else {
+		e.expires = e.refresh
+	}
+}
+
+// stapleCacheKey is the cache key of leaf's staple.
+func stapleCacheKey(leaf *x509.Certificate) string {
+	fp := Fingerprint(leaf)
+	return "ocsp-" + hex.EncodeToString(fp[:])
+}
+
+// stapleBackoff returns the delay before retrying after failures previous consecutive failures.
+func stapleBackoff(failures int) time.Duration {
+	d := staplerRetryMin
+	for i := 0; i < failures && d < staplerRetryMax; i++ {
+		d *= 2
+	}
+	if d > staplerRetryMax {
+		d = staplerRetryMax
+	}
+	return d
+}
+
+// Run refreshes staples as they fall due, so they stay fresh between handshakes, and forgets certificates expired or
+// no longer served, until ctx is done.
+func (s *OCSPStapler) Run(ctx context.Context) error {
+	timer := s.fetcher.clock.NewTimer(staplerRunInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C():
+		}
+		s.tick(s.fetcher.clock.Now())
+		timer.Reset(staplerRunInterval)
+	}
+}
+
+// tick refreshes staples due at now, and forgets certificates expired or idle.
+func (s *OCSPStapler) tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, e := range s.entries {
+		if now.After(e.leaf.NotAfter) || now.Sub(e.served) > staplerIdleExpiry {
+			delete(s.entries, key)
+			continue
+		}
+		if e.issuer != nil && e.fetch == nil && !now.Before(e.refresh) {
+			s.startFetch(e, true)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// waitStaple returns the staple s serves for cert once it has one.
+func waitStaple(t *testing.T, s *OCSPStapler, cert *tls.Certificate) []byte {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if staple := s.Staple(cert).OCSPStaple; len(staple) > 0 {
+			return staple
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no staple fetched")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOCSPStapler(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(2*time.Hour))
+	responder := &testOCSPResponder{ca: ca, status: ocsp.Good}
+	srv := httptest.NewServer(responder)
+	defer srv.Close()
+	leaf := issueTestOCSPLeaf(t, ca, srv.URL)
+
+	clock := newFakeClock(now)
+	s, err := NewOCSPStapler(WithStaplerClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(func(cfg *tls.Config) error {
+		cfg.Certificates = []tls.Certificate{*leaf}
+		return nil
+	}, WithOCSPStapler(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := cfg.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.OCSPStaple) > 0 {
+		t.Fatal("handshake waited on fetch")
+	}
+	staple := waitStaple(t, s, &cfg.Certificates[0])
+	resp, err := ocsp.ParseResponseForCert(staple, leaf.Leaf, ca.Leaf)
+	if err != nil || resp.Status != ocsp.Good {
+		t.Fatalf("unexpected staple %v %v", resp, err)
+	}
+	if len(cfg.Certificates[0].OCSPStaple) > 0 {
+		t.Fatal("configured certificate modified")
+	}
+	if n := atomic.LoadInt32(&responder.queries); n != 1 {
+		t.Fatalf("expected 1 query, got %d", n)
+	}
+
+	// Refreshed halfway through the response's validity.
+	clock.Advance(45 * time.Minute)
+	s.Staple(&cfg.Certificates[0])
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&responder.queries) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("staple not refreshed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOCSPStapling(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(2*time.Hour))
+	responder := &testOCSPResponder{ca: ca, status: ocsp.Good}
+	srv := httptest.NewServer(responder)
+	defer srv.Close()
+	leaf := issueTestOCSPLeaf(t, ca, srv.URL)
+
+	cfg, err := NewTLSConfig(func(cfg *tls.Config) error {
+		cfg.Certificates = []tls.Certificate{*leaf}
+		return nil
+	}, WithOCSPStapling())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stapled as served, without a stapler running.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := cfg.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.OCSPStaple) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no staple served")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := NewTLSConfig(WithOCSPStapling()); err == nil {
+		t.Fatal("expected error without certificates")
+	}
+}
+
+func TestOCSPStaplerBackoff(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(2*time.Hour))
+	var queries int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	leaf := issueTestOCSPLeaf(t, ca, srv.URL)
+
+	errs := make(chan error, 10)
+	clock := newFakeClock(now)
+	s, err := NewOCSPStapler(WithStaplerClock(clock), WithStaplerErrorHandler(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Staple(leaf)
+	<-errs
+	s.Staple(leaf)
+	clock.Advance(staplerRetryMin / 2)
+	s.Staple(leaf)
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Fatalf("expected backoff, got %d queries", n)
+	}
+	clock.Advance(staplerRetryMin)
+	s.Staple(leaf)
+	<-errs
+	if n := atomic.LoadInt32(&queries); n != 2 {
+		t.Fatalf("expected retry after backoff, got %d queries", n)
+	}
+	if d := stapleBackoff(20); d != staplerRetryMax {
+		t.Fatalf("expected backoff capped at %s, got %s", staplerRetryMax, d)
+	}
+}
+
+func TestOCSPStaplerCache(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(2*time.Hour))
+	responder := &testOCSPResponder{ca: ca, status: ocsp.Good}
+	srv := httptest.NewServer(responder)
+	defer srv.Close()
+	leaf := issueTestOCSPLeaf(t, ca, srv.URL)
+
+	cache := memCache{}
+	s, err := NewOCSPStapler(WithStaplerCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Refresh(context.Background(), leaf); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache) != 1 {
+		t.Fatalf("expected staple persisted, cache has %d entries", len(cache))
+	}
+
+	// A restarted stapler serves the persisted staple without querying the responder.
+	restarted, err := NewOCSPStapler(WithStaplerCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Refresh(context.Background(), leaf); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&responder.queries); n != 1 {
+		t.Fatalf("expected persisted staple used, got %d queries", n)
+	}
+	if len(restarted.Staple(leaf).OCSPStaple) == 0 {
+		t.Fatal("persisted staple not served")
+	}
+
+	// An expired persisted staple is fetched again.
+	clock := newFakeClock(now.Add(2 * time.Hour))
+	later, err := NewOCSPStapler(WithStaplerCache(cache), WithStaplerClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	later.Refresh(context.Background(), leaf)
+	if n := atomic.LoadInt32(&responder.queries); n != 2 {
+		t.Fatalf("expected expired staple refetched, got %d queries", n)
+	}
+}
+
+func TestOCSPStaplerRotation(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(2*time.Hour))
+	responder := &testOCSPResponder{ca: ca, status: ocsp.Good}
+	srv := httptest.NewServer(responder)
+	defer srv.Close()
+	// Valid for longer than the idle expiry.
+	issue := func() *tls.Certificate {
+		return issueTestCertificate(t, &x509.Certificate{
+			Subject:    pkix.Name{CommonName: "example.com"},
+			NotBefore:  now.Add(-time.Hour),
+			NotAfter:   now.Add(2 * staplerIdleExpiry),
+			OCSPServer: []string{srv.URL},
+		}, ca)
+	}
+	first, second := issue(), issue()
+
+	// Stands in for an ACME manager, renewing between handshakes.
+	current := first
+	clock := newFakeClock(now)
+	s, err := NewOCSPStapler(WithStaplerClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := s.GetCertificate(func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return current, nil })
+	get(&tls.ClientHelloInfo{})
+	waitStaple(t, s, first)
+	current = second
+	get(&tls.ClientHelloInfo{})
+	staple := waitStaple(t, s, second)
+	if _, err := ocsp.ParseResponseForCert(staple, second.Leaf, ca.Leaf); err != nil {
+		t.Fatalf("renewed certificate stapled with wrong response: %v", err)
+	}
+
+	// Challenge certificates are served as is.
+	challenge, err := get(&tls.ClientHelloInfo{SupportedProtos: []string{acmeTLSALPNProto}})
+	if err != nil || challenge != second {
+		t.Fatal("challenge certificate not passed through")
+	}
+
+	// The replaced certificate is forgotten once idle, while the current one is kept.
+	s.tick(now.Add(staplerIdleExpiry / 2))
+	clock.Advance(staplerIdleExpiry / 2)
+	get(&tls.ClientHelloInfo{})
+	s.tick(now.Add(staplerIdleExpiry + time.Minute))
+	s.mu.Lock()
+	_, kept := s.entries[sha256.Sum256(second.Certificate[0])]
+	n := len(s.entries)
+	s.mu.Unlock()
+	if n != 1 || !kept {
+		t.Fatalf("expected only the current certificate kept, have %d entries", n)
+	}
+}
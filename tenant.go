@@ -0,0 +1,214 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTenantCacheSize   = 1024
+	defaultTenantCacheTTL    = time.Hour
+	defaultTenantNegativeTTL = time.Minute
+	// tenantLookupTimeout bounds a lookup, as GetCertificate holds up the handshake.
+	tenantLookupTimeout = 10 * time.Second
+)
+
+// ErrCertificateNotFound is returned by a CertificateLookup without a certificate for a host.
+var ErrCertificateNotFound = errors.New("tlsutil: certificate not found")
+
+// CertificateLookup is a store of certificates by host, such as a SQL table or key value store.
+type CertificateLookup interface {
+	// LookupCertificate returns the PEM encoded certificate chain and private key of host, which may be a wildcard
+	// such as *.example.com, or ErrCertificateNotFound.
+	LookupCertificate(ctx context.Context, host string) (certPEM, keyPEM []byte, err error)
+}
+
+// CertificateLookupFunc adapts a function to a CertificateLookup.
+type CertificateLookupFunc func(ctx context.Context, host string) (certPEM, keyPEM []byte, err error)
+
+// LookupCertificate calls f.
+func (f CertificateLookupFunc) LookupCertificate(ctx context.Context, host string) ([]byte, []byte, error) {
+	return f(ctx, host)
+}
+
+// TenantOption configures a TenantCertificates.
+type TenantOption func(*TenantCertificates) error
+
+// WithTenantCacheSize sets the maximum number of hosts cached, including those without certificates, 1024 by
+// default.
+func WithTenantCacheSize(n int) TenantOption {
+	return func(c *TenantCertificates) error {
+		if n <= 0 {
+			return errors.New("tenant cache size must be positive")
+		}
+		c.size = n
+		return nil
+	}
+}
+
+// WithTenantCacheTTL sets how long found certificates are cached before being looked up again, an hour by default.
+func WithTenantCacheTTL(d time.Duration) TenantOption {
+	return func(c *TenantCertificates) error {
+		if d <= 0 {
+			return errors.New("tenant cache TTL must be positive")
+		}
+		c.ttl = d
+		return nil
+	}
+}
+
+// WithTenantNegativeTTL sets how long hosts without certificates are cached, a minute by default, so scanners
+// can't drive lookups. Zero disables negative caching.
+func WithTenantNegativeTTL(d time.Duration) TenantOption {
+	return func(c *TenantCertificates) error {
+		if d < 0 {
+			return errors.New("tenant negative cache TTL must not be negative")
+		}
+		c.negativeTTL = d
+		return nil
+	}
+}
+
+// WithTenantClock sets the clock cache entries are expired by.
+func WithTenantClock(clock Clock) TenantOption {
+	return func(c *TenantCertificates) error {
+		c.clock = clock
+		return nil
+	}
+}
+
+// tenantEntry is a cached lookup result, cert is nil for hosts without a certificate.
+type tenantEntry struct {
+	cert    *tls.Certificate
+	expires time.Time
+}
+
+// TenantCertificates serves certificates looked up by server name from a CertificateLookup, for platforms hosting
+// many customer domains. Results, including the absence of a certificate, are held in an LRU cache.
+type TenantCertificates struct {
+	lookup      CertificateLookup
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	clock       Clock
+
+	mu    sync.Mutex
+	cache *lruCache
+}
+
+// NewTenantCertificates returns a TenantCertificates looking up certificates with lookup.
+func NewTenantCertificates(lookup CertificateLookup, opts ...TenantOption) (*TenantCertificates, error) {
+	c := &TenantCertificates{
+		lookup:      lookup,
+		size:        defaultTenantCacheSize,
+		ttl:         defaultTenantCacheTTL,
+		negativeTTL: defaultTenantNegativeTTL,
+		clock:       systemClock{},
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	c.cache = newLRUCache(c.size)
+	return c, nil
+}
+
+// WithTenantCertificates configures TLS to serve certificates from c.
+func WithTenantCertificates(c *TenantCertificates) Option {
+	return func(cfg *tls.Config) error {
+		return setGetCertificate(cfg, c.GetCertificate)
+	}
+}
+
+// Invalidate removes any cached result for host, so its next handshake looks it up again.
+func (c *TenantCertificates) Invalidate(host string) {
+	c.mu.Lock()
+	c.cache.remove(normalizeServerName(host))
+	c.mu.Unlock()
+}
+
+// cached returns the unexpired cache entry of name.
+func (c *TenantCertificates) cached(name string, now time.Time) (tenantEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.cache.get(name)
+	if !ok {
+		return tenantEntry{}, false
+	}
+	e := v.(tenantEntry)
+	if !now.Before(e.expires) {
+		c.cache.remove(name)
+		return tenantEntry{}, false
+	}
+	return e, true
+}
+
+// GetCertificate implements tls.Config's GetCertificate, looking up the server name, then its wildcard.
+func (c *TenantCertificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := normalizeServerName(hello.ServerName)
+	if name == "" {
+		return nil, errors.New("tlsutil: missing server name")
+	}
+	now := c.clock.Now()
+	if e, ok := c.cached(name, now); ok {
+		if e.cert == nil {
+			return nil, errors.Wrapf(ErrCertificateNotFound, "%q", name)
+		}
+		return e.cert, nil
+	}
+
+	ctx, cancel := context.WithTimeout(helloContext(hello), tenantLookupTimeout)
+	defer cancel()
+	cert, err := c.load(ctx, name, now)
+	if err != nil && errors.Cause(err) != ErrCertificateNotFound {
+		// Store failures aren't cached, so the next handshake retries.
+		return nil, err
+	}
+	if cert != nil || c.negativeTTL > 0 {
+		e := tenantEntry{cert: cert, expires: now.Add(c.ttl)}
+		if cert == nil {
+			e.expires = now.Add(c.negativeTTL)
+		}
+		c.mu.Lock()
+		c.cache.add(name, e)
+		c.mu.Unlock()
+	}
+	if cert == nil {
+		return nil, errors.Wrapf(ErrCertificateNotFound, "%q", name)
+	}
+	return cert, nil
+}
+
+// load looks up name, then its wildcard, returning the parsed and validated certificate.
+func (c *TenantCertificates) load(ctx context.Context, name string, now time.Time) (*tls.Certificate, error) {
+	hosts := []string{name}
+	if w := wildcardOf(name); w != "" {
+		hosts = append(hosts, w)
+	}
+	for _, host := range hosts {
+		certPEM, keyPEM, err := c.lookup.LookupCertificate(ctx, host)
+		if errors.Cause(err) == ErrCertificateNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to look up certificate for %q", host)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load certificate for %q", host)
+		}
+		if err := ValidateCertificate(&cert, now); err != nil {
+			return nil, errors.Wrapf(err, "invalid certificate for %q", host)
+		}
+		if cert.Leaf = leafOf(&cert); cert.Leaf == nil {
+			return nil, errors.Errorf("failed to parse certificate for %q", host)
+		}
+		return &cert, nil
+	}
+	return nil, ErrCertificateNotFound
+}
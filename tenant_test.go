@@ -0,0 +1,99 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// encodeKeyPair returns cert PEM encoded.
+func encodeKeyPair(t *testing.T, cert *tls.Certificate) (certPEM, keyPEM []byte) {
+	t.Helper()
+	for _, der := range cert.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestTenantCertificates(t *testing.T) {
+	now := time.Now()
+	clock := newFakeClock(now)
+	pems := make(map[string][2][]byte)
+	for _, host := range []string{"shop.example", "*.tenant.example"} {
+		certPEM, keyPEM := encodeKeyPair(t, newTestCertificate(t, host, nil, now.Add(-time.Hour), now.Add(48*time.Hour)))
+		pems[host] = [2][]byte{certPEM, keyPEM}
+	}
+	lookups := make(map[string]int)
+	var fail bool
+	lookup := CertificateLookupFunc(func(ctx context.Context, host string) ([]byte, []byte, error) {
+		lookups[host]++
+		if fail {
+			return nil, nil, errors.New("database unavailable")
+		}
+		p, ok := pems[host]
+		if !ok {
+			return nil, nil, ErrCertificateNotFound
+		}
+		return p[0], p[1], nil
+	})
+	c, err := NewTenantCertificates(lookup, WithTenantClock(clock), WithTenantCacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(name string) (*tls.Certificate, error) {
+		return c.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+	}
+	cert, err := get("Shop.Example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := get("shop.example"); again != cert || lookups["shop.example"] != 1 {
+		t.Fatal("expected cached certificate")
+	}
+	if cert, err := get("a.tenant.example"); err != nil || cert.Leaf.Subject.CommonName != "*.tenant.example" {
+		t.Fatalf("expected wildcard certificate, got %v", err)
+	}
+
+	// Unknown hosts are negatively cached.
+	for i := 0; i < 2; i++ {
+		if _, err := get("unknown.example"); errors.Cause(err) != ErrCertificateNotFound {
+			t.Fatalf("expected ErrCertificateNotFound, got %v", err)
+		}
+	}
+	if lookups["unknown.example"] != 1 {
+		t.Fatalf("expected 1 lookup of unknown host, got %d", lookups["unknown.example"])
+	}
+	clock.Advance(defaultTenantNegativeTTL)
+	get("unknown.example")
+	if lookups["unknown.example"] != 2 {
+		t.Fatal("expected negative entry to expire")
+	}
+
+	// The cache holds 2 hosts, so shop.example has been evicted. Store errors aren't cached.
+	fail = true
+	if _, err := get("shop.example"); err == nil || errors.Cause(err) == ErrCertificateNotFound {
+		t.Fatalf("expected store error, got %v", err)
+	}
+	fail = false
+	if _, err := get("shop.example"); err != nil {
+		t.Fatal(err)
+	}
+	if lookups["shop.example"] != 3 {
+		t.Fatalf("expected 3 lookups, got %d", lookups["shop.example"])
+	}
+	c.Invalidate("shop.example")
+	get("shop.example")
+	if lookups["shop.example"] != 4 {
+		t.Fatal("expected lookup after invalidation")
+	}
+}
@@ -58,17 +58,25 @@ func ACMEWrap(opts ...ACMEOption) ACMEOption {
 // WithACME configures TLS to use ACME, configure by a ACMEOptions.
 func WithACME(opts ...ACMEOption) Option {
 	return func(cfg *tls.Config) error {
-		mgr := &autocert.Manager{
-			Prompt: autocert.AcceptTOS,
+		mgr, err := newACMEManager(opts...)
+		if err != nil {
+			return err
 		}
-		for _, opt := range opts {
-			if err := opt(mgr); err != nil {
-				return err
-			}
+		return setGetCertificate(cfg, mgr.GetCertificate)
+	}
+}
+
+// newACMEManager returns an autocert.Manager configured by opts.
+func newACMEManager(opts ...ACMEOption) (*autocert.Manager, error) {
+	mgr := &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+	}
+	for _, opt := range opts {
+		if err := opt(mgr); err != nil {
+			return nil, err
 		}
-		cfg.GetCertificate = mgr.GetCertificate
-		return nil
 	}
+	return mgr, nil
 }
 
 // WithACMEHosts adds hosts to the ACME host policy.
@@ -92,6 +100,75 @@ func WithACMEDirCache(dir string) ACMEOption {
 	}
 }
 
+// WithNextProtos sets the supported application level protocols (ALPN), in order of preference.
+func WithNextProtos(protos ...string) Option {
+	return func(cfg *tls.Config) error {
+		cfg.NextProtos = append([]string(nil), protos...)
+		return nil
+	}
+}
+
+// WithNextProtosOnly restricts the application level protocols already configured to those of protos, keeping their
+// order. With HostConfigs, to offer fewer protocols on some hosts, such as only http/1.1 on a legacy host.
+func WithNextProtosOnly(protos ...string) Option {
+	return func(cfg *tls.Config) error {
+		cfg.NextProtos = filterProtos(cfg.NextProtos, protos, true)
+		if len(cfg.NextProtos) == 0 {
+			return errors.Errorf("none of %v configured", protos)
+		}
+		return nil
+	}
+}
+
+// WithoutNextProtos removes protos from the application level protocols already configured, such as h2 for a host
+// whose backend can not speak it.
+func WithoutNextProtos(protos ...string) Option {
+	return func(cfg *tls.Config) error {
+		cfg.NextProtos = filterProtos(cfg.NextProtos, protos, false)
+		return nil
+	}
+}
+
+// filterProtos returns a new slice of those of next in, or not in, protos.
+func filterProtos(next, protos []string, in bool) []string {
+	var filtered []string
+	for _, p := range next {
+		found := false
+		for _, q := range protos {
+			if p == q {
+				found = true
+				break
+			}
+		}
+		if found == in {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+// WithMinVersion sets the minimum TLS version acceptable.
+func WithMinVersion(version uint16) Option {
+	return func(cfg *tls.Config) error {
+		if cfg.MaxVersion != 0 && version > cfg.MaxVersion {
+			return errors.Errorf("minimum TLS version %#04x exceeds maximum %#04x", version, cfg.MaxVersion)
+		}
+		cfg.MinVersion = version
+		return nil
+	}
+}
+
+// WithMaxVersion sets the maximum TLS version acceptable.
+func WithMaxVersion(version uint16) Option {
+	return func(cfg *tls.Config) error {
+		if version != 0 && version < cfg.MinVersion {
+			return errors.Errorf("maximum TLS version %#04x is below minimum %#04x", version, cfg.MinVersion)
+		}
+		cfg.MaxVersion = version
+		return nil
+	}
+}
+
 // WithTLS12 configures a tls.Config to the intersection of Mozilla's modern compatibility, and go's capability.
 // https://wiki.mozilla.org/Security/Server_Side_TLS#Modern_compatibility
 // https://blog.gopheracademy.com/advent-2016/exposing-go-on-the-internet/
@@ -112,13 +189,84 @@ func WithTLS12() Option {
 	}
 }
 
+// WithTLS13Only configures a tls.Config to Mozilla's current modern compatibility, TLS 1.3 only.
+// TLS 1.3 cipher suites are not configurable in go, so only curves are set.
+// https://wiki.mozilla.org/Security/Server_Side_TLS#Modern_compatibility
+func WithTLS13Only() Option {
+	return func(cfg *tls.Config) error {
+		if err := WithMinVersion(tls.VersionTLS13)(cfg); err != nil {
+			return err
+		}
+		cfg.CurvePreferences = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}
+		cfg.CipherSuites = nil
+		return nil
+	}
+}
+
+// WithLegacyCompat configures a tls.Config to Mozilla's old backward compatibility, TLS 1.0+ with CBC suites.
+// Only for serving clients that can not be upgraded, iUnderstandTheRisk must be true else an error is returned.
+// https://wiki.mozilla.org/Security/Server_Side_TLS#Old_backward_compatibility
+func WithLegacyCompat(iUnderstandTheRisk bool) Option {
+	return func(cfg *tls.Config) error {
+		if !iUnderstandTheRisk {
+			return errors.New("legacy compatibility requires explicit acceptance of the risk")
+		}
+		if err := WithMinVersion(tls.VersionTLS10)(cfg); err != nil {
+			return err
+		}
+		cfg.PreferServerCipherSuites = true
+		cfg.CurvePreferences = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384}
+		cfg.CipherSuites = []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+		}
+		return nil
+	}
+}
+
 // NewTLSConfig returns a new tls.Config with all options applied.
 func NewTLSConfig(opts ...Option) (*tls.Config, error) {
 	cfg := &tls.Config{}
+	if err := Apply(cfg, opts...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// NewTLSConfigFrom returns a clone of base with all options applied. base is left unmodified.
+func NewTLSConfigFrom(base *tls.Config, opts ...Option) (*tls.Config, error) {
+	if base == nil {
+		return NewTLSConfig(opts...)
+	}
+	cfg := base.Clone()
+	if err := Apply(cfg, opts...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Apply applies all options to cfg in place.
+func Apply(cfg *tls.Config, opts ...Option) error {
 	for _, opt := range opts {
 		if err := opt(cfg); err != nil {
-			return nil, err
+			return err
 		}
 	}
-	return cfg, nil
+	return nil
 }
@@ -0,0 +1,115 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestNextProtos(t *testing.T) {
+	protos := []string{"h2", "http/1.1"}
+	cfg, err := NewTLSConfig(WithNextProtos(protos...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.NextProtos, []string{"h2", "http/1.1"}) {
+		t.Fatalf("expected h2, http/1.1, got %v", cfg.NextProtos)
+	}
+	protos[0] = "spdy/3"
+	if cfg.NextProtos[0] != "h2" {
+		t.Fatal("expected NextProtos not to alias the caller's slice")
+	}
+}
+
+func TestMinMaxVersion(t *testing.T) {
+	cfg, err := NewTLSConfig(WithMinVersion(tls.VersionTLS12), WithMaxVersion(tls.VersionTLS13))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.MaxVersion != tls.VersionTLS13 {
+		t.Fatalf("expected TLS 1.2 to 1.3, got %#04x to %#04x", cfg.MinVersion, cfg.MaxVersion)
+	}
+	if _, err := NewTLSConfig(WithMaxVersion(tls.VersionTLS12), WithMinVersion(tls.VersionTLS13)); err == nil {
+		t.Fatal("expected error for minimum above maximum")
+	}
+	if _, err := NewTLSConfig(WithMinVersion(tls.VersionTLS13), WithMaxVersion(tls.VersionTLS12)); err == nil {
+		t.Fatal("expected error for maximum below minimum")
+	}
+	// Zero is the default maximum, whatever the minimum.
+	if _, err := NewTLSConfig(WithMinVersion(tls.VersionTLS13), WithMaxVersion(0)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLS13Only(t *testing.T) {
+	cfg, err := NewTLSConfig(WithTLS12(), WithTLS13Only())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 {
+		t.Fatalf("expected minimum TLS 1.3, got %#04x", cfg.MinVersion)
+	}
+	if cfg.CipherSuites != nil {
+		t.Fatal("expected TLS 1.2 cipher suites cleared")
+	}
+	if len(cfg.CurvePreferences) == 0 || cfg.CurvePreferences[0] != tls.X25519 {
+		t.Fatalf("expected X25519 preferred, got %v", cfg.CurvePreferences)
+	}
+	if _, err := NewTLSConfig(WithMaxVersion(tls.VersionTLS12), WithTLS13Only()); err == nil {
+		t.Fatal("expected error with maximum below TLS 1.3")
+	}
+}
+
+func TestLegacyCompat(t *testing.T) {
+	if _, err := NewTLSConfig(WithLegacyCompat(false)); err == nil {
+		t.Fatal("expected error without accepting the risk")
+	}
+	cfg, err := NewTLSConfig(WithLegacyCompat(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS10 {
+		t.Fatalf("expected minimum TLS 1.0, got %#04x", cfg.MinVersion)
+	}
+	cbc := false
+	for _, id := range cfg.CipherSuites {
+		cbc = cbc || id == tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA
+	}
+	if !cbc {
+		t.Fatal("expected CBC suites enabled")
+	}
+	// SSL 3.0, below the preset's minimum.
+	if _, err := NewTLSConfig(WithMaxVersion(0x0300), WithLegacyCompat(true)); err == nil {
+		t.Fatal("expected error with maximum below TLS 1.0")
+	}
+}
+
+func TestNewTLSConfigFrom(t *testing.T) {
+	base := &tls.Config{ServerName: "example.com", NextProtos: []string{"http/1.1"}}
+	cfg, err := NewTLSConfigFrom(base, WithNextProtos("h2"), WithMinVersion(tls.VersionTLS12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ServerName != "example.com" || cfg.MinVersion != tls.VersionTLS12 || cfg.NextProtos[0] != "h2" {
+		t.Fatalf("expected base with options applied, got %+v", cfg)
+	}
+	if base.MinVersion != 0 || base.NextProtos[0] != "http/1.1" {
+		t.Fatal("expected base unmodified")
+	}
+	if cfg, err = NewTLSConfigFrom(nil, WithMinVersion(tls.VersionTLS13)); err != nil || cfg.MinVersion != tls.VersionTLS13 {
+		t.Fatalf("expected new config from nil base, got %v", err)
+	}
+	fail := errors.New("option failed")
+	if _, err := NewTLSConfigFrom(base, WithError(fail)); err != fail {
+		t.Fatalf("expected option error, got %v", err)
+	}
+
+	if err := Apply(base, WithMinVersion(tls.VersionTLS12)); err != nil {
+		t.Fatal(err)
+	}
+	if base.MinVersion != tls.VersionTLS12 {
+		t.Fatal("expected Apply to modify in place")
+	}
+}
@@ -0,0 +1,47 @@
+package tlsutil
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultExpectContinueTimeout = time.Second
+	defaultMaxIdleConns          = 100
+)
+
+// NewHTTPTransport returns an http.Transport using a config of opts over the defaults of NewClientTLSConfig, with
+// HTTP/2 enabled, proxies from the environment, and http.DefaultTransport's timeouts.
+func NewHTTPTransport(opts ...ClientOption) (*http.Transport, error) {
+	cfg, err := NewClientTLSConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   defaultDialTimeout,
+			KeepAlive: defaultDialKeepAlive,
+		}).DialContext,
+		TLSClientConfig: cfg,
+		// Setting TLSClientConfig otherwise disables HTTP/2.
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          defaultMaxIdleConns,
+		IdleConnTimeout:       defaultIdleConnTimeout,
+		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
+		ExpectContinueTimeout: defaultExpectContinueTimeout,
+	}, nil
+}
+
+// NewHTTPClient returns an http.Client using a transport of NewHTTPTransport. It has no overall timeout, bound
+// requests with their contexts.
+func NewHTTPClient(opts ...ClientOption) (*http.Client, error) {
+	t, err := NewHTTPTransport(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t}, nil
+}
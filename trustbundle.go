@@ -0,0 +1,311 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// trustBundleInterval is how often a TrustBundle is refetched by default.
+	trustBundleInterval = time.Hour
+	// trustBundleTimeout bounds each fetch of a bundle.
+	trustBundleTimeout = 30 * time.Second
+	// trustBundleMaxSize bounds the size of a bundle, so a misbehaving server can't exhaust memory.
+	trustBundleMaxSize = 1 << 20
+)
+
+// TrustBundleOption configures a TrustBundle.
+type TrustBundleOption func(*TrustBundle) error
+
+// WithTrustBundleBootstrapCAs verifies the bundle's server against pool, rather than the system roots, such as the
+// private CA's current roots shipped with the application.
+func WithTrustBundleBootstrapCAs(pool *x509.CertPool) TrustBundleOption {
+	return func(b *TrustBundle) error {
+		b.bootstrap = pool
+		return nil
+	}
+}
+
+// WithTrustBundlePins only accepts a bundle server whose verified chain includes a certificate with one of the pinned
+// public keys, see WithPinnedPeerKeys.
+func WithTrustBundlePins(pins ...[sha256.Size]byte) TrustBundleOption {
+	return func(b *TrustBundle) error {
+		if len(pins) == 0 {
+			return errors.New("no pinned keys")
+		}
+		b.pins = append(b.pins, pins...)
+		return nil
+	}
+}
+
+// WithTrustBundleHTTPClient sets the HTTP client bundles are fetched with, in place of one built from the bootstrap
+// CAs and pins, which can't be combined with it.
+func WithTrustBundleHTTPClient(client *http.Client) TrustBundleOption {
+	return func(b *TrustBundle) error {
+		b.client = client
+		return nil
+	}
+}
+
+// WithTrustBundleInterval sets how often Run refetches the bundle, hourly by default.
+func WithTrustBundleInterval(d time.Duration) TrustBundleOption {
+	return func(b *TrustBundle) error {
+		if d <= 0 {
+			return errors.New("trust bundle interval must be positive")
+		}
+		b.interval = d
+		return nil
+	}
+}
+
+// WithTrustBundleClock sets the clock the bundle's certificates' validity, and Run's schedule, are judged by.
+func WithTrustBundleClock(clock Clock) TrustBundleOption {
+	return func(b *TrustBundle) error {
+		b.clock = clock
+		return nil
+	}
+}
+
+// WithTrustBundleErrorHandler sets a function called with every failed refresh by Run. The previous pool continues to
+// be used.
+func WithTrustBundleErrorHandler(fn func(error)) TrustBundleOption {
+	return func(b *TrustBundle) error {
+		b.onError = fn
+		return nil
+	}
+}
+
+// TrustBundle maintains a x509.CertPool of CA certificates published as PEM at an HTTPS URL, refetching it
+// periodically. So roots a private CA rotates are trusted without redeploying.
+type TrustBundle struct {
+	url       string
+	client    *http.Client
+	bootstrap *x509.CertPool
+	pins      [][sha256.Size]byte
+	interval  time.Duration
+	clock     Clock
+	onError   func(error)
+
+	mu   sync.RWMutex
+	pool *x509.CertPool
+	sum  [sha256.Size]byte
+}
+
+// NewTrustBundle returns a TrustBundle having fetched the bundle at rawURL. Call Run to refresh it periodically.
+func NewTrustBundle(ctx context.Context, rawURL string, opts ...TrustBundleOption) (*TrustBundle, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid trust bundle URL %q", rawURL)
+	}
+	if u.Scheme != "https" {
+		return nil, errors.Errorf("trust bundle URL %q is not HTTPS", rawURL)
+	}
+	b := &TrustBundle{url: rawURL, interval: trustBundleInterval, clock: systemClock{}}
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+	if b.client == nil {
+		if b.client, err = b.newHTTPClient(); err != nil {
+			return nil, err
+		}
+	} else if b.bootstrap != nil || len(b.pins) > 0 {
+		return nil, errors.New("trust bundle HTTP client can't be combined with bootstrap CAs or pins")
+	}
+	if err := b.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// newHTTPClient returns a client verifying bundle servers with the bootstrap CAs and pins.
+func (b *TrustBundle) newHTTPClient() (*http.Client, error) {
+	opts := []ClientOption{func(cfg *tls.Config) error {
+		cfg.RootCAs = b.bootstrap
+		return nil
+	}}
+	if len(b.pins) > 0 {
+		opts = append(opts, WithPinnedPeerKeys(b.pins...))
+	}
+	client, err := NewHTTPClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	client.Timeout = trustBundleTimeout
+	return client, nil
+}
+
+// WithRootCAsBundle configures TLS to verify server certificates with the current pool of b. As with
+// WithRootCAsReloader, crypto/tls's verification is replaced by the equivalent in VerifyConnection.
+func WithRootCAsBundle(b *TrustBundle) Option {
+	return func(cfg *tls.Config) error {
+		prependVerifyConnection(cfg, func(cs tls.ConnectionState) error {
+			return verifyServerWithRoots(cs, b.Pool())
+		})
+		cfg.InsecureSkipVerify = true
+		return nil
+	}
+}
+
+// WithClientCAsBundle configures TLS to verify client certificates with the current pool of b, via
+// GetConfigForClient.
+func WithClientCAsBundle(b *TrustBundle) Option {
+	return func(cfg *tls.Config) error {
+		return setClientCAsPool(cfg, b.Pool)
+	}
+}
+
+// WithRootCAsFromURL configures TLS to verify server certificates with the bundle at rawURL, refreshing it for the
+// life of the process from the first handshake. To stop refreshing, use WithRootCAsBundle with a TrustBundle whose
+// Run is owned by the caller, such as by WithRunBackground.
+func WithRootCAsFromURL(rawURL string, opts ...TrustBundleOption) Option {
+	return func(cfg *tls.Config) error {
+		b, err := NewTrustBundle(context.Background(), rawURL, opts...)
+		if err != nil {
+			return err
+		}
+		run := b.runOnFirstUse()
+		prependVerifyConnection(cfg, func(cs tls.ConnectionState) error {
+			run()
+			return verifyServerWithRoots(cs, b.Pool())
+		})
+		cfg.InsecureSkipVerify = true
+		return nil
+	}
+}
+
+// WithClientCAsFromURL configures TLS to verify client certificates with the bundle at rawURL, refreshing it for the
+// life of the process from the first handshake, as WithRootCAsFromURL does.
+func WithClientCAsFromURL(rawURL string, opts ...TrustBundleOption) Option {
+	return func(cfg *tls.Config) error {
+		b, err := NewTrustBundle(context.Background(), rawURL, opts...)
+		if err != nil {
+			return err
+		}
+		if err := WithClientCAsBundle(b)(cfg); err != nil {
+			return err
+		}
+		run, get := b.runOnFirstUse(), cfg.GetConfigForClient
+		cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			run()
+			return get(hello)
+		}
+		return nil
+	}
+}
+
+// runOnFirstUse returns a function starting Run in the background on its first call, so a config discarded as a
+// later option failed leaves nothing running.
+func (b *TrustBundle) runOnFirstUse() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			go b.Run(context.Background())
+		})
+	}
+}
+
+// Pool returns the current pool. It must not be modified.
+func (b *TrustBundle) Pool() *x509.CertPool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.pool
+}
+
+// Refresh fetches the bundle, replacing the current pool only if every certificate in it is a currently valid CA.
+func (b *TrustBundle) Refresh(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, b.url, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create trust bundle request")
+	}
+	resp, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch trust bundle")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("failed to fetch trust bundle: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, trustBundleMaxSize+1))
+	if err != nil {
+		return errors.Wrap(err, "failed to read trust bundle")
+	}
+	if len(body) > trustBundleMaxSize {
+		return errors.New("trust bundle too large")
+	}
+	sum := sha256.Sum256(body)
+	b.mu.RLock()
+	unchanged := b.pool != nil && sum == b.sum
+	b.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	pool, err := parseTrustBundle(body, b.clock.Now())
+	if err != nil {
+		return errors.Wrapf(err, "invalid trust bundle from %s", b.url)
+	}
+	b.mu.Lock()
+	b.pool, b.sum = pool, sum
+	b.mu.Unlock()
+	return nil
+}
+
+// parseTrustBundle returns the pool of the PEM encoded CA certificates in bundle, all of which must be valid at now.
+func parseTrustBundle(bundle []byte, now time.Time) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	n := 0
+	for {
+		var block *pem.Block
+		if block, bundle = pem.Decode(bundle); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.Errorf("unexpected PEM block %q", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse certificate")
+		}
+		if !cert.IsCA {
+			return nil, errors.Errorf("certificate %q is not a CA", cert.Subject)
+		}
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			return nil, errors.Errorf("certificate %q is not valid at %s", cert.Subject, now.Format(time.RFC3339))
+		}
+		pool.AddCert(cert)
+		n++
+	}
+	if n == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return pool, nil
+}
+
+// Run refreshes the bundle every interval until ctx is done, reporting failures to the error handler.
+func (b *TrustBundle) Run(ctx context.Context) error {
+	timer := b.clock.NewTimer(b.interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C():
+		}
+		if err := b.Refresh(ctx); err != nil && b.onError != nil {
+			b.onError(err)
+		}
+		timer.Reset(b.interval)
+	}
+}
@@ -0,0 +1,145 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBundleServer serves the PEM encoded leaves of the current CAs.
+type testBundleServer struct {
+	mu     sync.Mutex
+	bundle []byte
+}
+
+func (s *testBundleServer) set(cas ...*tls.Certificate) {
+	var bundle []byte
+	for _, ca := range cas {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw})...)
+	}
+	s.mu.Lock()
+	s.bundle = bundle
+	s.mu.Unlock()
+}
+
+func (s *testBundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Write(s.bundle)
+}
+
+func TestTrustBundle(t *testing.T) {
+	now := time.Now()
+	ca1 := newTestCertificate(t, "ca1", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	ca2 := newTestCertificate(t, "ca2", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	leaf := newTestCertificate(t, "example.com", ca2, now.Add(-time.Hour), now.Add(time.Hour))
+	bundles := &testBundleServer{}
+	bundles.set(ca1)
+	srv := httptest.NewTLSServer(bundles)
+	defer srv.Close()
+	bootstrap := x509.NewCertPool()
+	bootstrap.AddCert(srv.Certificate())
+
+	ctx := context.Background()
+	errs := make(chan error, 1)
+	clock := newFakeClock(now)
+	b, err := NewTrustBundle(ctx, srv.URL, WithTrustBundleBootstrapCAs(bootstrap), WithTrustBundleClock(clock),
+		WithTrustBundleInterval(time.Minute), WithTrustBundleErrorHandler(func(err error) { errs <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClientTLSConfig(WithRootCAsBundle(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewTLSConfig(WithClientCAsBundle(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := tls.ConnectionState{ServerName: "example.com", PeerCertificates: []*x509.Certificate{leaf.Leaf}}
+	if err := client.VerifyConnection(cs); err == nil {
+		t.Fatal("expected verification against the old bundle to fail")
+	}
+
+	bundles.set(ca1, ca2)
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		b.Run(runCtx)
+		close(done)
+	}()
+	<-clock.armed
+	clock.Advance(time.Minute)
+	<-clock.armed
+	if err := client.VerifyConnection(cs); err != nil {
+		t.Fatal(err)
+	}
+	got, err := server.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ClientCAs != b.Pool() {
+		t.Fatal("handshake not using refreshed client CAs")
+	}
+
+	// A bundle including a certificate that's not a CA is rejected, keeping the previous pool.
+	pool := b.Pool()
+	bundles.set(ca1, leaf)
+	clock.Advance(time.Minute)
+	if err := <-errs; err == nil {
+		t.Fatal("expected refresh to fail")
+	}
+	<-clock.armed
+	if b.Pool() != pool {
+		t.Fatal("failed refresh replaced pool")
+	}
+	cancel()
+	<-done
+
+	bundles.set(ca1)
+
+	if _, err := NewTrustBundle(ctx, srv.URL, WithTrustBundlePins(SPKIPin(ca1.Leaf)), WithTrustBundleBootstrapCAs(bootstrap)); err == nil {
+		t.Fatal("expected pin mismatch")
+	}
+	pinned, err := NewTrustBundle(ctx, srv.URL, WithTrustBundlePins(SPKIPin(srv.Certificate())),
+		WithTrustBundleBootstrapCAs(bootstrap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned.Pool() == nil {
+		t.Fatal("expected pool")
+	}
+	if _, err := NewTrustBundle(ctx, "http://example.com/roots.pem"); err == nil {
+		t.Fatal("expected error for plain HTTP URL")
+	}
+	if _, err := NewTrustBundle(ctx, srv.URL); err == nil {
+		t.Fatal("expected server unverifiable by system roots")
+	}
+}
+
+func TestParseTrustBundle(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	expired := newTestCertificate(t, "expired", nil, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw})
+	for _, tt := range []struct {
+		name   string
+		bundle []byte
+		ok     bool
+	}{
+		{"valid", caPEM, true},
+		{"empty", nil, false},
+		{"expired", append(caPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: expired.Leaf.Raw})...), false},
+		{"key", append(caPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{0}})...), false},
+	} {
+		if _, err := parseTrustBundle(tt.bundle, now); (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok %v, got %v", tt.name, tt.ok, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateCertificate checks that cert's private key matches the leaf, the leaf is valid at now, and that any
+// intermediates are ordered such that each certificate is issued by the one that follows it.
+func ValidateCertificate(cert *tls.Certificate, now time.Time) error {
+	if len(cert.Certificate) == 0 {
+		return errors.New("certificate has no chain")
+	}
+	chain := make([]*x509.Certificate, len(cert.Certificate))
+	for i, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse certificate %d in chain", i)
+		}
+		chain[i] = c
+	}
+	leaf := chain[0]
+
+	if cert.PrivateKey == nil {
+		return errors.Errorf("certificate %q has no private key", leaf.Subject)
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.Errorf("certificate %q private key is not a crypto.Signer", leaf.Subject)
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return errors.Errorf("certificate %q private key does not match public key", leaf.Subject)
+	}
+
+	if now.Before(leaf.NotBefore) {
+		return errors.Errorf("certificate %q is not valid until %s", leaf.Subject, leaf.NotBefore.Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return errors.Errorf("certificate %q expired at %s", leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	for i := 1; i < len(chain); i++ {
+		if !bytes.Equal(chain[i-1].RawIssuer, chain[i].RawSubject) {
+			return errors.Errorf("certificate %q is followed by %q, which is not its issuer", chain[i-1].Subject, chain[i].Subject)
+		}
+		if err := chain[i-1].CheckSignatureFrom(chain[i]); err != nil {
+			return errors.Wrapf(err, "certificate %q not signed by %q", chain[i-1].Subject, chain[i].Subject)
+		}
+	}
+	return nil
+}
+
+// WithValidation applies the loader options, then validates each certificate they appended to tls.Config's
+// Certificates with ValidateCertificate, so broken bundles are reported at start up rather than first handshake.
+// Certificates already present, eg inherited via NewTLSConfigFrom, are not revalidated.
+//
+//	tlsutil.WithValidation(tlsutil.WithKeyPair(certFile, keyFile))
+func WithValidation(loaders ...Option) Option {
+	return func(cfg *tls.Config) error {
+		n := len(cfg.Certificates)
+		for _, load := range loaders {
+			if err := load(cfg); err != nil {
+				return err
+			}
+		}
+		if len(cfg.Certificates) == n {
+			return errors.New("no certificates loaded to validate")
+		}
+		now := time.Now()
+		for i := n; i < len(cfg.Certificates); i++ {
+			if err := ValidateCertificate(&cfg.Certificates[i], now); err != nil {
+				return errors.Wrap(err, "invalid certificate")
+			}
+		}
+		return nil
+	}
+}
+
+// WithKeyPairValidated is WithKeyPair with the loaded certificate checked by ValidateCertificate.
+func WithKeyPairValidated(certFile, keyFile string) Option {
+	return WithValidation(WithKeyPair(certFile, keyFile))
+}
@@ -0,0 +1,117 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a certificate for cn issued by parent (self signed if nil), valid over [notBefore, notAfter).
+func newTestCertificate(t *testing.T, cn string, parent *tls.Certificate, notBefore, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+	return issueTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{cn},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, parent)
+}
+
+// issueTestCertificate returns a certificate of tmpl, with a new P-256 key and random serial, issued by parent
+// (self signed if nil).
+func issueTestCertificate(t *testing.T, tmpl *x509.Certificate, parent *tls.Certificate) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = serial
+	issuer, signer := tmpl, interface{}(key)
+	var chain [][]byte
+	if parent != nil {
+		issuer, signer, chain = parent.Leaf, parent.PrivateKey, parent.Certificate
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{
+		Certificate: append([][]byte{der}, chain...),
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+}
+
+func TestValidateCertificate(t *testing.T) {
+	now := time.Now()
+	ca := newTestCertificate(t, "ca", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	good := newTestCertificate(t, "good.example", ca, now.Add(-time.Hour), now.Add(time.Hour))
+
+	if err := ValidateCertificate(good, now); err != nil {
+		t.Fatalf("expected valid certificate: %v", err)
+	}
+
+	expired := newTestCertificate(t, "expired.example", ca, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if err := ValidateCertificate(expired, now); err == nil {
+		t.Error("expected expired certificate to fail")
+	}
+
+	notYet := newTestCertificate(t, "future.example", ca, now.Add(time.Hour), now.Add(2*time.Hour))
+	if err := ValidateCertificate(notYet, now); err == nil {
+		t.Error("expected not yet valid certificate to fail")
+	}
+
+	mismatch := *good
+	mismatch.PrivateKey = ca.PrivateKey
+	if err := ValidateCertificate(&mismatch, now); err == nil {
+		t.Error("expected mismatched key to fail")
+	}
+
+	other := newTestCertificate(t, "other", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	misordered := *good
+	misordered.Certificate = [][]byte{good.Certificate[0], other.Certificate[0]}
+	if err := ValidateCertificate(&misordered, now); err == nil {
+		t.Error("expected misordered chain to fail")
+	}
+}
+
+func TestWithValidation(t *testing.T) {
+	now := time.Now()
+	inherited := newTestCertificate(t, "expired.example", nil, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	good := newTestCertificate(t, "good.example", nil, now.Add(-time.Hour), now.Add(time.Hour))
+	add := func(c *tls.Certificate) Option {
+		return func(cfg *tls.Config) error {
+			cfg.Certificates = append(cfg.Certificates, *c)
+			return nil
+		}
+	}
+
+	base := &tls.Config{Certificates: []tls.Certificate{*inherited}}
+	if _, err := NewTLSConfigFrom(base, WithValidation(add(good))); err != nil {
+		t.Fatalf("expected inherited certificates to be ignored: %v", err)
+	}
+	if _, err := NewTLSConfig(WithValidation(add(inherited))); err == nil {
+		t.Fatal("expected expired certificate to fail validation")
+	}
+	if _, err := NewTLSConfig(WithValidation()); err == nil {
+		t.Fatal("expected error when nothing loaded")
+	}
+}
@@ -0,0 +1,59 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+)
+
+// Verifier verifies a peer once crypto/tls has completed, or skipped, its own chain verification. It's given the
+// handshake's connection state, so has the peer's certificates, any verified chains, the server name and stapled
+// responses. CRLChecker, OCSPChecker and PeerAllowlist are Verifiers.
+type Verifier interface {
+	VerifyConnection(tls.ConnectionState) error
+}
+
+// VerifierFunc adapts a function to a Verifier.
+type VerifierFunc func(tls.ConnectionState) error
+
+// VerifyConnection calls fn(cs).
+func (fn VerifierFunc) VerifyConnection(cs tls.ConnectionState) error {
+	return fn(cs)
+}
+
+// verifiers runs each Verifier in order.
+type verifiers []Verifier
+
+func (vs verifiers) VerifyConnection(cs tls.ConnectionState) error {
+	for _, v := range vs {
+		if err := v.VerifyConnection(cs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChainVerifiers returns a Verifier running each of vs in order, failing with the first error. Nil Verifiers are
+// skipped.
+func ChainVerifiers(vs ...Verifier) Verifier {
+	chain := make(verifiers, 0, len(vs))
+	for _, v := range vs {
+		switch v := v.(type) {
+		case nil:
+		case verifiers:
+			chain = append(chain, v...)
+		default:
+			chain = append(chain, v)
+		}
+	}
+	return chain
+}
+
+// WithVerifiers configures TLS to also verify peers with each of vs in order, after any verification already
+// configured. Verification options, such as WithSPIFFEVerification and WithCRLChecker, all add to the same
+// VerifyConnection this way, rather than replacing each other, which also runs on session resumption, unlike
+// VerifyPeerCertificate.
+func WithVerifiers(vs ...Verifier) Option {
+	return func(cfg *tls.Config) error {
+		appendVerifyConnection(cfg, ChainVerifiers(vs...).VerifyConnection)
+		return nil
+	}
+}
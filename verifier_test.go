@@ -0,0 +1,46 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestChainVerifiers(t *testing.T) {
+	var calls []string
+	record := func(name string, err error) Verifier {
+		return VerifierFunc(func(tls.ConnectionState) error {
+			calls = append(calls, name)
+			return err
+		})
+	}
+	allow, err := NewPeerAllowlist("cn:billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := NewTLSConfig(
+		WithVerifiers(record("first", nil), ChainVerifiers(record("second", nil), nil), allow),
+		WithVerifiers(record("last", nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "billing"}}}}
+	if err := cfg.VerifyConnection(cs); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 3 || calls[0] != "first" || calls[1] != "second" || calls[2] != "last" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+
+	calls = nil
+	cs.PeerCertificates[0].Subject.CommonName = "web"
+	if err := cfg.VerifyConnection(cs); errors.Cause(err) != ErrPeerNotAllowed {
+		t.Fatalf("expected ErrPeerNotAllowed, got %v", err)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("verification continued after failure: %v", calls)
+	}
+}
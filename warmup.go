@@ -0,0 +1,83 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// warmupHello returns a ClientHelloInfo for host as sent by a modern client, so managers choosing between key
+// types, such as autocert, obtain ECDSA certificates.
+func warmupHello(host string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:        host,
+		CipherSuites:      []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		SupportedCurves:   []tls.CurveID{tls.X25519, tls.CurveP256},
+		SupportedPoints:   []uint8{0},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256, tls.PKCS1WithSHA256},
+		SupportedVersions: []uint16{tls.VersionTLS13, tls.VersionTLS12},
+	}
+}
+
+// preIssue calls getCertificate for each host concurrently, returning the first error.
+func preIssue(ctx context.Context, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), hosts []string) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(hosts))
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			// GetCertificate has no context, so abandon, rather than cancel, the call if ctx ends first.
+			res := make(chan error, 1)
+			go func() {
+				_, err := getCertificate(warmupHello(host))
+				res <- err
+			}()
+			var err error
+			select {
+			case err = <-res:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			if err != nil {
+				errs[i] = errors.Wrapf(err, "failed to obtain certificate for %s", host)
+			}
+		}(i, host)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PreIssue obtains certificates for hosts from cfg's GetCertificate, eg WithACME or WithDNSManager, so the first
+// visitor to each host doesn't wait on issuance.
+func PreIssue(ctx context.Context, cfg *tls.Config, hosts ...string) error {
+	if cfg.GetCertificate == nil {
+		return errors.New("no GetCertificate configured to pre-issue from")
+	}
+	return preIssue(ctx, cfg.GetCertificate, hosts)
+}
+
+// PreIssue obtains certificates for hosts, so the first visitor to each host doesn't wait on issuance.
+func (m *DNSManager) PreIssue(ctx context.Context, hosts ...string) error {
+	return preIssue(ctx, m.GetCertificate, hosts)
+}
+
+// WithWarmup starts obtaining certificates for hosts in the background, from the GetCertificate configured by a
+// preceding option such as WithACME. Use PreIssue instead to wait for, and observe errors from, issuance.
+func WithWarmup(hosts ...string) Option {
+	return func(cfg *tls.Config) error {
+		getCertificate := cfg.GetCertificate
+		if getCertificate == nil {
+			return errors.New("no GetCertificate configured to warm up")
+		}
+		go preIssue(context.Background(), getCertificate, hosts)
+		return nil
+	}
+}
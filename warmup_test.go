@@ -0,0 +1,55 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestPreIssue(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	cfg := &tls.Config{GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[hello.ServerName] = true
+		if len(hello.SignatureSchemes) == 0 {
+			t.Error("expected modern client hello")
+		}
+		if hello.ServerName == "bad.example" {
+			return nil, errors.New("refused")
+		}
+		return &tls.Certificate{}, nil
+	}}
+
+	if err := PreIssue(context.Background(), cfg, "a.example", "b.example"); err != nil {
+		t.Fatal(err)
+	}
+	if !seen["a.example"] || !seen["b.example"] {
+		t.Fatalf("expected both hosts requested, got %v", seen)
+	}
+	if err := PreIssue(context.Background(), cfg, "bad.example"); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := PreIssue(context.Background(), &tls.Config{}, "a.example"); err == nil {
+		t.Fatal("expected error without GetCertificate")
+	}
+}
+
+func TestPreIssueContext(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	cfg := &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		<-block
+		return nil, nil
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := PreIssue(ctx, cfg, "slow.example"); err == nil {
+		t.Fatal("expected context deadline")
+	}
+}